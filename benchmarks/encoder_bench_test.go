@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package benchmarks
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// _encoders are the zap encoders compared by BenchmarkEncoders.
+var _encoders = []struct {
+	name string
+	new  func(zapcore.EncoderConfig) zapcore.Encoder
+}{
+	{"JSON", zapcore.NewJSONEncoder},
+	{"CBOR", zapcore.NewCBOREncoder},
+}
+
+// BenchmarkEncoders compares zap's encoders in the common logging scenarios.
+// Along with the usual measurements, it reports the size of each encoded
+// entry.
+func BenchmarkEncoders(b *testing.B) {
+	b.Logf("Logging with each of zap's encoders.")
+	for _, e := range _encoders {
+		e := e
+		b.Run(e.name, func(b *testing.B) {
+			b.Run("WithoutFields", func(b *testing.B) {
+				logger := newZapLoggerWithEncoder(e.new(newZapEncoderConfig()), zap.DebugLevel)
+				size := entrySize(b, e.new(newZapEncoderConfig()), nil)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						logger.Info(getMessage(0))
+					}
+				})
+				b.ReportMetric(size, "bytes/entry")
+			})
+			b.Run("AccumulatedContext", func(b *testing.B) {
+				logger := newZapLoggerWithEncoder(e.new(newZapEncoderConfig()), zap.DebugLevel).With(fakeFields()...)
+				enc := e.new(newZapEncoderConfig())
+				for _, f := range fakeFields() {
+					f.AddTo(enc)
+				}
+				size := entrySize(b, enc, nil)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						logger.Info(getMessage(0))
+					}
+				})
+				b.ReportMetric(size, "bytes/entry")
+			})
+			b.Run("AddingFields", func(b *testing.B) {
+				logger := newZapLoggerWithEncoder(e.new(newZapEncoderConfig()), zap.DebugLevel)
+				size := entrySize(b, e.new(newZapEncoderConfig()), fakeFields())
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						logger.Info(getMessage(0), fakeFields()...)
+					}
+				})
+				b.ReportMetric(size, "bytes/entry")
+			})
+		})
+	}
+}
+
+// entrySize returns the size of an entry encoded by enc with the given
+// fields.
+func entrySize(b *testing.B, enc zapcore.Encoder, fields []zap.Field) float64 {
+	ent := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Now(),
+		Message: getMessage(0),
+	}
+	buf, err := enc.EncodeEntry(ent, fields)
+	if err != nil {
+		b.Fatalf("Unexpected error encoding entry: %v", err)
+	}
+	defer buf.Free()
+	return float64(buf.Len())
+}
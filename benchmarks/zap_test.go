@@ -103,11 +103,18 @@ func (u *user) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	return nil
 }
 
-func newZapLogger(lvl zapcore.Level) *zap.Logger {
+func newZapEncoderConfig() zapcore.EncoderConfig {
 	ec := zap.NewProductionEncoderConfig()
 	ec.EncodeDuration = zapcore.NanosDurationEncoder
 	ec.EncodeTime = zapcore.EpochNanosTimeEncoder
-	enc := zapcore.NewJSONEncoder(ec)
+	return ec
+}
+
+func newZapLogger(lvl zapcore.Level) *zap.Logger {
+	return newZapLoggerWithEncoder(zapcore.NewJSONEncoder(newZapEncoderConfig()), lvl)
+}
+
+func newZapLoggerWithEncoder(enc zapcore.Encoder, lvl zapcore.Level) *zap.Logger {
 	return zap.New(zapcore.NewCore(
 		enc,
 		&ztest.Discarder{},
@@ -23,8 +23,10 @@ package zap
 import (
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
+	"go.uber.org/multierr"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -42,15 +44,64 @@ type SamplingConfig struct {
 	Hook       func(zapcore.Entry, zapcore.SamplingDecision) `json:"-" yaml:"-"`
 }
 
+// WriteErrorConfig sets a policy for handling failed writes to the logger's
+// outputs. Fallback paths are opened like OutputPaths. See
+// zapcore.WriteErrorPolicy for details.
+type WriteErrorConfig struct {
+	RetryShortWrites bool                       `json:"retryShortWrites" yaml:"retryShortWrites"`
+	Retries          int                        `json:"retries" yaml:"retries"`
+	Backoff          time.Duration              `json:"backoff" yaml:"backoff"`
+	MaxBackoff       time.Duration              `json:"maxBackoff" yaml:"maxBackoff"`
+	FallbackPaths    []string                   `json:"fallbackPaths" yaml:"fallbackPaths"`
+	ReportInterval   time.Duration              `json:"reportInterval" yaml:"reportInterval"`
+	Hook             func(zapcore.Entry, error) `json:"-" yaml:"-"`
+}
+
+// OutputConfig routes the entries in a range of levels to a set of outputs,
+// optionally with a different encoding from the rest of the Config.
+//
+// An OutputConfig can be loaded from JSON or YAML, like
+//
+//   {"paths": ["stderr"], "minLevel": "error"}
+type OutputConfig struct {
+	// Paths is a list of URLs or file paths to write to. See Open for
+	// details.
+	Paths []string `json:"paths" yaml:"paths"`
+	// MinLevel and MaxLevel are the lowest and highest levels written to
+	// these outputs, inclusive. Either may be omitted to leave that end of
+	// the range open. The Config's Level also applies.
+	MinLevel *zapcore.Level `json:"minLevel" yaml:"minLevel"`
+	MaxLevel *zapcore.Level `json:"maxLevel" yaml:"maxLevel"`
+	// Encoding and EncoderConfig, if specified, override the Config's
+	// Encoding and EncoderConfig for these outputs.
+	Encoding      string                 `json:"encoding" yaml:"encoding"`
+	EncoderConfig *zapcore.EncoderConfig `json:"encoderConfig" yaml:"encoderConfig"`
+}
+
+// levelEnabler combines the level range of the output with the Config's
+// level.
+func (o OutputConfig) levelEnabler(base zapcore.LevelEnabler) zapcore.LevelEnabler {
+	min, max := zapcore.DebugLevel, zapcore.FatalLevel
+	if o.MinLevel != nil {
+		min = *o.MinLevel
+	}
+	if o.MaxLevel != nil {
+		max = *o.MaxLevel
+	}
+	return LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl >= min && lvl <= max && base.Enabled(lvl)
+	})
+}
+
 // Config offers a declarative way to construct a logger. It doesn't do
 // anything that can't be done with New, Options, and the various
 // zapcore.WriteSyncer and zapcore.Core wrappers, but it's a simpler way to
 // toggle common options.
 //
 // Note that Config intentionally supports only the most common options. More
-// unusual logging setups (logging to network connections or message queues,
-// splitting output between multiple files, etc.) are possible, but require
-// direct use of the zapcore package. For sample code, see the package-level
+// unusual logging setups (logging to message queues, sampling each output
+// differently, etc.) are possible, but require direct use of the zapcore
+// package. For sample code, see the package-level
 // BasicConfiguration and AdvancedConfiguration examples.
 //
 // For an example showing runtime log level changes, see the documentation for
@@ -72,16 +123,23 @@ type Config struct {
 	DisableStacktrace bool `json:"disableStacktrace" yaml:"disableStacktrace"`
 	// Sampling sets a sampling policy. A nil SamplingConfig disables sampling.
 	Sampling *SamplingConfig `json:"sampling" yaml:"sampling"`
-	// Encoding sets the logger's encoding. Valid values are "json" and
-	// "console", as well as any third-party encodings registered via
+	// Encoding sets the logger's encoding. Valid values are "json",
+	// "console", "logfmt", "msgpack", "cbor", "syslog", "gelf", "ecs", "gcp",
+	// "cef", and "leef", as well as any third-party encodings registered via
 	// RegisterEncoder.
 	Encoding string `json:"encoding" yaml:"encoding"`
 	// EncoderConfig sets options for the chosen encoder. See
-	// zapcore.EncoderConfig for details.
+	// zapcore.EncoderConfig for details. Color is disabled for outputs that
+	// aren't terminals and when the NO_COLOR environment variable is set.
 	EncoderConfig zapcore.EncoderConfig `json:"encoderConfig" yaml:"encoderConfig"`
 	// OutputPaths is a list of URLs or file paths to write logging output to.
 	// See Open for details.
 	OutputPaths []string `json:"outputPaths" yaml:"outputPaths"`
+	// Outputs is a list of additional outputs, each of which receives a
+	// range of levels and may use its own encoding. For example, errors can
+	// be sent to standard error while all levels are written to a file.
+	// Outputs are written in addition to OutputPaths, if any.
+	Outputs []OutputConfig `json:"outputs" yaml:"outputs"`
 	// ErrorOutputPaths is a list of URLs to write internal logger errors to.
 	// The default is standard error.
 	//
@@ -91,6 +149,9 @@ type Config struct {
 	ErrorOutputPaths []string `json:"errorOutputPaths" yaml:"errorOutputPaths"`
 	// InitialFields is a collection of fields to add to the root logger.
 	InitialFields map[string]interface{} `json:"initialFields" yaml:"initialFields"`
+	// WriteErrors sets a policy for failed writes to the outputs. A nil
+	// WriteErrorConfig reports every failed write to the error outputs.
+	WriteErrors *WriteErrorConfig `json:"writeErrors" yaml:"writeErrors"`
 }
 
 // NewProductionEncoderConfig returns an opinionated EncoderConfig for
@@ -132,6 +193,35 @@ func NewProductionConfig() Config {
 	}
 }
 
+// NewECSEncoderConfig returns an EncoderConfig for the "ecs" encoder, whose
+// output conforms to the Elastic Common Schema. The keys name the ECS fields
+// they enable; the encoder doesn't use them otherwise.
+func NewECSEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "@timestamp",
+		LevelKey:       "log.level",
+		NameKey:        "log.logger",
+		CallerKey:      "log.origin.file",
+		FunctionKey:    "log.origin.function",
+		MessageKey:     "message",
+		StacktraceKey:  "error.stack_trace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.NanosDurationEncoder,
+		EncodeCaller:   zapcore.FullCallerEncoder,
+	}
+}
+
+// NewECSConfig is like NewProductionConfig, but uses the "ecs" encoder so
+// that logs can be shipped to Elasticsearch without further processing.
+func NewECSConfig() Config {
+	cfg := NewProductionConfig()
+	cfg.Encoding = "ecs"
+	cfg.EncoderConfig = NewECSEncoderConfig()
+	return cfg
+}
+
 // NewDevelopmentEncoderConfig returns an opinionated EncoderConfig for
 // development environments.
 func NewDevelopmentEncoderConfig() zapcore.EncoderConfig {
@@ -170,29 +260,64 @@ func NewDevelopmentConfig() Config {
 }
 
 // Build constructs a logger from the Config and Options.
+//
+// Files and other resources opened for the OutputPaths and ErrorOutputPaths
+// remain open for the life of the process. Use BuildWithClose to release
+// them.
 func (cfg Config) Build(opts ...Option) (*Logger, error) {
+	log, _, err := cfg.BuildWithClose(opts...)
+	return log, err
+}
+
+// BuildWithClose constructs a logger from the Config and Options, like Build,
+// and also returns a function that releases the resources opened for the
+// logger's outputs and error outputs.
+//
+// The returned function syncs the logger, then closes every output and error
+// output, returning any errors encountered. Only the first call has any
+// effect; later calls return the same result. The logger, and any loggers
+// derived from it, must not be used after it's closed.
+func (cfg Config) BuildWithClose(opts ...Option) (*Logger, func() error, error) {
 	enc, err := cfg.buildEncoder()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	sink, errSink, err := cfg.openSinks()
+	if cfg.Level == (AtomicLevel{}) {
+		return nil, nil, fmt.Errorf("missing Level")
+	}
+
+	// Sinks that keep time, like time-rotating files, share the logger's
+	// Clock.
+	sinks := &openedSinks{clock: NewNop().WithOptions(opts...).clock}
+	errSink, _, err := sinks.open(cfg.ErrorOutputPaths)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	sinks.errSink = errSink
 
-	if cfg.Level == (AtomicLevel{}) {
-		return nil, fmt.Errorf("missing Level")
+	core, err := cfg.buildCore(enc, sinks)
+	if err != nil {
+		sinks.close()
+		return nil, nil, err
 	}
 
-	log := New(
-		zapcore.NewCore(enc, sink, cfg.Level),
-		cfg.buildOptions(errSink)...,
-	)
+	log := New(core, cfg.buildOptions(errSink)...)
 	if len(opts) > 0 {
 		log = log.WithOptions(opts...)
 	}
-	return log, nil
+
+	var (
+		once     sync.Once
+		closeErr error
+	)
+	close := func() error {
+		once.Do(func() {
+			closeErr = multierr.Append(log.Sync(), sinks.close())
+		})
+		return closeErr
+	}
+	return log, close, nil
 }
 
 func (cfg Config) buildOptions(errSink zapcore.WriteSyncer) []Option {
@@ -246,19 +371,137 @@ func (cfg Config) buildOptions(errSink zapcore.WriteSyncer) []Option {
 	return opts
 }
 
-func (cfg Config) openSinks() (zapcore.WriteSyncer, zapcore.WriteSyncer, error) {
-	sink, closeOut, err := Open(cfg.OutputPaths...)
-	if err != nil {
-		return nil, nil, err
+// buildCore builds a Core for the OutputPaths and each of the Outputs,
+// opening their sinks.
+func (cfg Config) buildCore(enc zapcore.Encoder, sinks *openedSinks) (zapcore.Core, error) {
+	var policy *zapcore.WriteErrorPolicy
+	if wcfg := cfg.WriteErrors; wcfg != nil {
+		policy = &zapcore.WriteErrorPolicy{
+			RetryShortWrites: wcfg.RetryShortWrites,
+			Retries:          wcfg.Retries,
+			Backoff:          wcfg.Backoff,
+			MaxBackoff:       wcfg.MaxBackoff,
+			ReportInterval:   wcfg.ReportInterval,
+			Hook:             wcfg.Hook,
+		}
+		if len(wcfg.FallbackPaths) > 0 {
+			fallback, _, err := sinks.open(wcfg.FallbackPaths)
+			if err != nil {
+				return nil, err
+			}
+			policy.Fallback = fallback
+		}
+	}
+	newCore := func(enc zapcore.Encoder, ws zapcore.WriteSyncer, enab zapcore.LevelEnabler) zapcore.Core {
+		if policy == nil {
+			return zapcore.NewCore(enc, ws, enab)
+		}
+		return zapcore.NewCoreWithErrorPolicy(enc, ws, enab, *policy)
+	}
+
+	cores := make([]zapcore.Core, 0, len(cfg.Outputs)+1)
+	if len(cfg.OutputPaths) > 0 || len(cfg.Outputs) == 0 {
+		sink, color, err := sinks.open(cfg.OutputPaths)
+		if err != nil {
+			return nil, err
+		}
+		if !color {
+			if enc, err = newEncoderWithoutColor(cfg.Encoding, cfg.EncoderConfig); err != nil {
+				return nil, err
+			}
+		}
+		cores = append(cores, newCore(enc, sink, cfg.Level))
 	}
-	errSink, _, err := Open(cfg.ErrorOutputPaths...)
+
+	for i, out := range cfg.Outputs {
+		if len(out.Paths) == 0 {
+			return nil, fmt.Errorf("missing Paths in Outputs[%d]", i)
+		}
+		if out.MinLevel != nil && out.MaxLevel != nil && *out.MinLevel > *out.MaxLevel {
+			return nil, fmt.Errorf("MinLevel %v is above MaxLevel %v in Outputs[%d]", *out.MinLevel, *out.MaxLevel, i)
+		}
+
+		sink, color, err := sinks.open(out.Paths)
+		if err != nil {
+			return nil, err
+		}
+
+		outEnc := enc
+		if out.Encoding != "" || out.EncoderConfig != nil || !color {
+			encoding, encCfg := cfg.Encoding, cfg.EncoderConfig
+			if out.Encoding != "" {
+				encoding = out.Encoding
+			}
+			if out.EncoderConfig != nil {
+				encCfg = *out.EncoderConfig
+			}
+			newEnc := newEncoder
+			if !color {
+				newEnc = newEncoderWithoutColor
+			}
+			if outEnc, err = newEnc(encoding, encCfg); err != nil {
+				return nil, fmt.Errorf("Outputs[%d]: %v", i, err)
+			}
+		}
+		cores = append(cores, newCore(outEnc, sink, out.levelEnabler(cfg.Level)))
+	}
+	return zapcore.NewTee(cores...), nil
+}
+
+// openedSinks tracks the sinks opened while building a logger so that they
+// can be closed together.
+type openedSinks struct {
+	errSink zapcore.WriteSyncer // the logger's error output, once opened
+	clock   zapcore.Clock       // the logger's Clock
+	closers []func() error
+}
+
+// open opens the given paths like Open, directing any reports from the
+// resulting sinks to the logger's error output and rotating time-rotating
+// files by the logger's Clock. It also reports whether colored output should
+// be written to the sinks (see zapcore.ColorSupported).
+func (o *openedSinks) open(paths []string) (zapcore.WriteSyncer, bool, error) {
+	sinks, close, err := open(paths, o.clock)
 	if err != nil {
-		closeOut()
-		return nil, nil, err
+		return nil, false, err
+	}
+	o.closers = append(o.closers, close)
+
+	if o.errSink != nil {
+		for _, s := range sinks {
+			if es, ok := s.(errorOutputSink); ok {
+				es.setDefaultErrorOutput(o.errSink)
+			}
+		}
 	}
-	return sink, errSink, nil
+	color := len(sinks) > 0
+	for _, s := range sinks {
+		if ncs, ok := s.(nopCloserSink); ok {
+			s = ncs.WriteSyncer
+		}
+		color = color && zapcore.ColorSupported(s)
+	}
+	return CombineWriteSyncers(sinks...), color, nil
+}
+
+// close closes every opened sink, in the reverse of the order in which they
+// were opened.
+func (o *openedSinks) close() error {
+	var err error
+	for i := len(o.closers) - 1; i >= 0; i-- {
+		err = multierr.Append(err, o.closers[i]())
+	}
+	return err
 }
 
 func (cfg Config) buildEncoder() (zapcore.Encoder, error) {
 	return newEncoder(cfg.Encoding, cfg.EncoderConfig)
 }
+
+// newEncoderWithoutColor builds an encoder like newEncoder, but disables
+// color. The logger's outputs are opened after its encoder is built, so it's
+// used to replace the encoder for outputs that aren't terminals.
+func newEncoderWithoutColor(name string, encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+	encoderConfig.DisableColor = true
+	return newEncoder(name, encoderConfig)
+}
@@ -21,14 +21,21 @@
 package zap
 
 import (
+	"errors"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
+	"go.uber.org/zap/internal/ztest"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
 )
 
 func TestConfig(t *testing.T) {
@@ -211,3 +218,296 @@ func TestConfigWithSamplingHook(t *testing.T) {
 	assert.Equal(t, int64(expectDropped), dcount.Load())
 	assert.Equal(t, int64(expectSampled), scount.Load())
 }
+
+// closeRecorder is a Sink that records the calls made to it.
+type closeRecorder struct {
+	name  string
+	calls *[]string
+}
+
+func (r closeRecorder) Write(bs []byte) (int, error) {
+	*r.calls = append(*r.calls, "write "+r.name)
+	return len(bs), nil
+}
+
+func (r closeRecorder) Sync() error {
+	*r.calls = append(*r.calls, "sync "+r.name)
+	return nil
+}
+
+func (r closeRecorder) Close() error {
+	*r.calls = append(*r.calls, "close "+r.name)
+	if r.name == "broken" {
+		return errors.New("close failed")
+	}
+	return nil
+}
+
+func TestConfigBuildWithClose(t *testing.T) {
+	defer resetSinkRegistry()
+
+	var calls []string
+	require.NoError(t, RegisterSink("recorder", func(u *url.URL) (Sink, error) {
+		return closeRecorder{name: u.Host, calls: &calls}, nil
+	}), "Failed to register sink factory.")
+
+	cfg := NewProductionConfig()
+	cfg.OutputPaths = []string{"recorder://out"}
+	cfg.ErrorOutputPaths = []string{"recorder://errout"}
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+
+	logger.Info("hello")
+	assert.Equal(t, []string{"write out"}, calls, "Unexpected calls before close.")
+
+	require.NoError(t, close(), "Unexpected error closing logger.")
+	assert.Equal(t, []string{"write out", "sync out", "close out", "close errout"}, calls,
+		"Expected logger to be synced before its outputs are closed.")
+
+	require.NoError(t, close(), "Unexpected error closing logger twice.")
+	assert.Len(t, calls, 4, "Expected outputs to be closed only once.")
+
+	cfg.OutputPaths = []string{"recorder://broken"}
+	_, close, err = cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	assert.EqualError(t, close(), "close failed", "Expected error closing output.")
+	assert.EqualError(t, close(), "close failed", "Expected later calls to return the same error.")
+}
+
+func TestConfigBuildWithCloseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	cfg := NewProductionConfig()
+	cfg.OutputPaths = []string{path}
+	cfg.ErrorOutputPaths = []string{path}
+	cfg.EncoderConfig.TimeKey = ""
+
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	logger.Info("hello")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+
+	assert.Regexp(t, `^{"level":"info","caller":"[^"]+","msg":"hello"}\n$`, readFile(t, path), "Unexpected file contents.")
+	assert.Error(t, logger.Core().Sync(), "Expected output to be closed.")
+}
+
+func TestConfigBuildWithCloseErrors(t *testing.T) {
+	cfg := NewProductionConfig()
+	cfg.OutputPaths = []string{filepath.Join(t.TempDir(), "missing", "app.log")}
+	_, close, err := cfg.BuildWithClose()
+	assert.Error(t, err, "Expected an error opening a non-existent directory.")
+	assert.Nil(t, close, "Expected no close function on error.")
+
+	_, close, err = Config{Encoding: "json"}.BuildWithClose()
+	assert.EqualError(t, err, "missing Level", "Expected an error for missing level.")
+	assert.Nil(t, close, "Expected no close function on error.")
+}
+
+// failingSink is a Sink whose writes always fail without writing anything.
+type failingSink struct{ ztest.Syncer }
+
+func (failingSink) Write([]byte) (int, error) { return 0, errors.New("failed") }
+func (failingSink) Close() error              { return nil }
+
+func TestConfigWriteErrors(t *testing.T) {
+	defer resetSinkRegistry()
+	require.NoError(t, RegisterSink("failing", func(*url.URL) (Sink, error) {
+		return &failingSink{}, nil
+	}), "Failed to register sink factory.")
+
+	dir := t.TempDir()
+	fallback := filepath.Join(dir, "fallback.log")
+	errOut := filepath.Join(dir, "errors.log")
+
+	var failures int
+	cfg := NewProductionConfig()
+	cfg.OutputPaths = []string{"failing://"}
+	cfg.ErrorOutputPaths = []string{errOut}
+	cfg.EncoderConfig.TimeKey = ""
+	cfg.DisableCaller = true
+	cfg.WriteErrors = &WriteErrorConfig{
+		Retries:       1,
+		Backoff:       time.Millisecond,
+		FallbackPaths: []string{fallback},
+		Hook:          func(zapcore.Entry, error) { failures++ },
+	}
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+
+	logger.Info("saved")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+
+	assert.Equal(t, `{"level":"info","msg":"saved"}`+"\n", readFile(t, fallback), "Expected entry to be written to the fallback.")
+	assert.Empty(t, readFile(t, errOut), "Expected no errors to be reported.")
+	assert.Equal(t, 1, failures, "Expected hook to be called.")
+
+	cfg.WriteErrors = &WriteErrorConfig{ReportInterval: time.Hour}
+	logger, close, err = cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	logger.Info("lost")
+	logger.Info("lost")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+	assert.Equal(t, 1, strings.Count(readFile(t, errOut), "write error: failed"), "Expected repeated errors to be suppressed.")
+
+	cfg.WriteErrors = &WriteErrorConfig{FallbackPaths: []string{filepath.Join(dir, "missing", "fallback.log")}}
+	_, _, err = cfg.BuildWithClose()
+	assert.Error(t, err, "Expected an error opening a fallback in a non-existent directory.")
+}
+
+func TestConfigOutputs(t *testing.T) {
+	dir := t.TempDir()
+	errPath := filepath.Join(dir, "errors.log")
+	allPath := filepath.Join(dir, "all.log")
+	infoPath := filepath.Join(dir, "info.log")
+
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+level: debug
+encoding: json
+encoderConfig:
+  messageKey: msg
+  levelKey: level
+  levelEncoder: lowercase
+outputs:
+  - paths: [`+errPath+`]
+    minLevel: error
+  - paths: [`+infoPath+`]
+    minLevel: info
+    maxLevel: warn
+    encoding: console
+  - paths: [`+allPath+`]
+    encoderConfig:
+      messageKey: message
+`), &cfg), "Failed to unmarshal YAML config.")
+
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+
+	assert.Equal(t, `{"level":"error","msg":"error"}`+"\n", readFile(t, errPath), "Unexpected error output.")
+	assert.Equal(t, "info\tinfo\nwarn\twarn\n", readFile(t, infoPath), "Unexpected info output.")
+	assert.Equal(t,
+		`{"message":"debug"}`+"\n"+`{"message":"info"}`+"\n"+`{"message":"warn"}`+"\n"+`{"message":"error"}`+"\n",
+		readFile(t, allPath), "Unexpected output with all levels.")
+}
+
+func TestConfigDisablesColorForFiles(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.log")
+	outPath := filepath.Join(dir, "out.log")
+
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+level: debug
+encoding: console
+outputPaths: [`+mainPath+`]
+encoderConfig:
+  messageKey: msg
+  levelKey: level
+  nameKey: name
+  levelEncoder: capitalColor
+  theme:
+    info: blue
+    name: "208"
+    fieldKey: "#ff8800"
+outputs:
+  - paths: [`+outPath+`]
+`), &cfg), "Failed to unmarshal YAML config.")
+	require.NotNil(t, cfg.EncoderConfig.Theme, "Expected a theme.")
+	assert.Equal(t, zapcore.Color256(208), cfg.EncoderConfig.Theme.Name, "Unexpected name color.")
+
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	logger.Named("app").Info("info", String("k", "v"))
+	require.NoError(t, close(), "Unexpected error closing logger.")
+
+	want := "INFO\tapp\tinfo\t" + `{"k": "v"}` + "\n"
+	assert.Equal(t, want, readFile(t, mainPath), "Expected no color in the main output.")
+	assert.Equal(t, want, readFile(t, outPath), "Expected no color in Outputs.")
+}
+
+func TestConfigOutputsWithOutputPaths(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.log")
+	errPath := filepath.Join(dir, "errors.log")
+
+	errLevel := ErrorLevel
+	cfg := NewProductionConfig()
+	cfg.EncoderConfig.TimeKey = ""
+	cfg.DisableCaller = true
+	cfg.DisableStacktrace = true
+	cfg.OutputPaths = []string{mainPath}
+	cfg.Outputs = []OutputConfig{{Paths: []string{errPath}, MinLevel: &errLevel}}
+
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Error("error")
+	cfg.Level.SetLevel(FatalLevel)
+	logger.Error("disabled")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+
+	assert.Equal(t, `{"level":"info","msg":"info"}`+"\n"+`{"level":"error","msg":"error"}`+"\n",
+		readFile(t, mainPath), "Unexpected main output.")
+	assert.Equal(t, `{"level":"error","msg":"error"}`+"\n", readFile(t, errPath),
+		"Expected outputs to respect the Config's level.")
+}
+
+func TestConfigOutputsErrors(t *testing.T) {
+	warn, info := WarnLevel, InfoLevel
+	path := filepath.Join(t.TempDir(), "app.log")
+	tests := []struct {
+		desc    string
+		outputs []OutputConfig
+		want    string
+	}{
+		{
+			desc:    "missing paths",
+			outputs: []OutputConfig{{Paths: []string{path}}, {}},
+			want:    "missing Paths in Outputs[1]",
+		},
+		{
+			desc:    "inverted range",
+			outputs: []OutputConfig{{Paths: []string{path}, MinLevel: &warn, MaxLevel: &info}},
+			want:    "MinLevel warn is above MaxLevel info in Outputs[0]",
+		},
+		{
+			desc:    "unknown encoding",
+			outputs: []OutputConfig{{Paths: []string{path}, Encoding: "nope"}},
+			want:    `Outputs[0]: no encoder registered for name "nope"`,
+		},
+		{
+			desc:    "bad path",
+			outputs: []OutputConfig{{Paths: []string{filepath.Join(path, "missing", "app.log")}}},
+			want:    "couldn't open sink",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := NewProductionConfig()
+			cfg.Outputs = tt.outputs
+			_, err := cfg.Build()
+			require.Error(t, err, "Expected error building logger.")
+			assert.Contains(t, err.Error(), tt.want, "Unexpected error.")
+		})
+	}
+}
+
+func TestNewECSConfig(t *testing.T) {
+	cfg := NewECSConfig()
+	assert.Equal(t, "ecs", cfg.Encoding, "Unexpected encoding.")
+
+	enc, err := newEncoder(cfg.Encoding, cfg.EncoderConfig)
+	require.NoError(t, err, "Unexpected error building encoder.")
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "m"}, []Field{String("service.name", "api")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"service":{"name":"api"}}`+"\n",
+		buf.String(), "Unexpected ECS document.")
+	buf.Free()
+}
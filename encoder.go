@@ -32,19 +32,47 @@ var (
 	errNoEncoderNameSpecified = errors.New("no encoder name specified")
 
 	_encoderNameToConstructor = map[string]func(zapcore.EncoderConfig) (zapcore.Encoder, error){
+		"cbor": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewCBOREncoder(encoderConfig), nil
+		},
+		"cef": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewCEFEncoder(encoderConfig), nil
+		},
 		"console": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
 			return zapcore.NewConsoleEncoder(encoderConfig), nil
 		},
+		"ecs": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewECSEncoder(encoderConfig), nil
+		},
+		"gcp": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewGCPEncoder(encoderConfig), nil
+		},
+		"gelf": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewGELFEncoder(encoderConfig), nil
+		},
 		"json": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
 			return zapcore.NewJSONEncoder(encoderConfig), nil
 		},
+		"leef": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewLEEFEncoder(encoderConfig), nil
+		},
+		"logfmt": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewLogfmtEncoder(encoderConfig), nil
+		},
+		"msgpack": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewMsgpackEncoder(encoderConfig), nil
+		},
+		"syslog": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewSyslogEncoder(encoderConfig), nil
+		},
 	}
 	_encoderMutex sync.RWMutex
 )
 
 // RegisterEncoder registers an encoder constructor, which the Config struct
-// can then reference. By default, the "json" and "console" encoders are
-// registered.
+// can then reference. By default, the "json", "console", "logfmt",
+// "msgpack", "cbor", "syslog", "gelf", "ecs", "gcp", "cef", and "leef"
+// encoders are registered.
 //
 // Attempting to register an encoder whose name is already taken returns an
 // error.
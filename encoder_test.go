@@ -29,7 +29,7 @@ import (
 )
 
 func TestRegisterDefaultEncoders(t *testing.T) {
-	testEncodersRegistered(t, "console", "json")
+	testEncodersRegistered(t, "cbor", "cef", "console", "ecs", "gcp", "gelf", "json", "leef", "logfmt", "msgpack", "syslog")
 }
 
 func TestRegisterEncoder(t *testing.T) {
@@ -0,0 +1,250 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// A FlightRecorder keeps the most recent log entries in memory, at every
+// level, so that the lead-up to a crash can be inspected even if it was
+// logged below the configured level.
+//
+// Use Core to record entries, usually alongside the logger's regular Core:
+//
+//   recorder := zap.NewFlightRecorder(1000, zapcore.Lock(os.Stderr))
+//   logger := zap.New(zapcore.NewTee(core, recorder.Core()))
+//
+// Note that the recorded Core enables all levels, so every log statement is
+// encoded.
+//
+// When a DPanic, Panic, or Fatal entry is written, the FlightRecorder dumps
+// the recorded entries to its dump output. Cores are written before a
+// CheckedEntry runs its CheckWriteHook, so the dump completes before the
+// logger panics or exits. A Fatal entry that no Core writes, for example
+// because it was sampled out, doesn't reach the recorded Core, so install
+// the CheckWriteHook returned by Hook to dump on every Fatal entry:
+//
+//   logger := zap.New(core, zap.WithFatalHook(recorder.Hook(zapcore.WriteThenFatal)))
+//
+// Entries may also be dumped on demand with Dump, or fetched over HTTP with
+// ServeHTTP.
+//
+// Recording is lock-free: concurrent writers claim slots in a ring buffer
+// with an atomic counter.
+type FlightRecorder struct {
+	// next is the sequence number of the next entry. It's accessed
+	// atomically, so it must stay first to be 64-bit aligned on 32-bit
+	// platforms.
+	next uint64
+
+	enc   zapcore.Encoder
+	slots []atomic.Value // holds *recordedEntry
+
+	dumpMu     sync.Mutex
+	dump       zapcore.WriteSyncer
+	dumpedNext uint64 // value of next at the last automatic dump; guarded by dumpMu
+}
+
+type recordedEntry struct {
+	seq   uint64
+	level zapcore.Level
+	json  []byte // encoded entry, without a trailing newline
+}
+
+// NewFlightRecorder creates a FlightRecorder that keeps the last size entries
+// and dumps them to the given WriteSyncer when a DPanic, Panic, or Fatal
+// entry is written. If dump is nil, entries are only dumped on demand.
+//
+// Entries are recorded as JSON, using the production encoder configuration
+// with ISO8601 timestamps.
+func NewFlightRecorder(size int, dump zapcore.WriteSyncer) *FlightRecorder {
+	if size <= 0 {
+		size = 1
+	}
+	encCfg := NewProductionEncoderConfig()
+	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	return &FlightRecorder{
+		enc:   zapcore.NewJSONEncoder(encCfg),
+		slots: make([]atomic.Value, size),
+		dump:  dump,
+	}
+}
+
+// Core returns a Core that records every entry written to it.
+func (r *FlightRecorder) Core() zapcore.Core {
+	return &recorderCore{r: r, enc: r.enc.Clone()}
+}
+
+// Entries returns the recorded entries at or above the given level, oldest
+// first, each encoded as a JSON object.
+func (r *FlightRecorder) Entries(minLevel zapcore.Level) [][]byte {
+	recs := r.snapshot()
+	entries := make([][]byte, 0, len(recs))
+	for _, rec := range recs {
+		if rec.level >= minLevel {
+			entries = append(entries, rec.json)
+		}
+	}
+	return entries
+}
+
+// Dump writes the recorded entries to ws as newline-delimited JSON, oldest
+// first, and syncs it.
+func (r *FlightRecorder) Dump(ws zapcore.WriteSyncer) error {
+	r.dumpMu.Lock()
+	defer r.dumpMu.Unlock()
+	return r.writeEntries(ws)
+}
+
+// Hook returns a CheckWriteHook that dumps the recorded entries to the dump
+// output and then runs next, or WriteThenFatal if next is nil. It's meant to
+// be installed with WithFatalHook. Entries are dumped only if any were
+// recorded since the last automatic dump, so a Fatal entry that the recorded
+// Core already dumped isn't dumped twice.
+func (r *FlightRecorder) Hook(next zapcore.CheckWriteHook) zapcore.CheckWriteHook {
+	if next == nil {
+		next = zapcore.WriteThenFatal
+	}
+	return &recorderHook{r: r, next: next}
+}
+
+// autoDump dumps the recorded entries to the dump output, unless none were
+// recorded since the last time it was called.
+func (r *FlightRecorder) autoDump() error {
+	if r.dump == nil {
+		return nil
+	}
+	r.dumpMu.Lock()
+	defer r.dumpMu.Unlock()
+	next := atomic.LoadUint64(&r.next)
+	if next == r.dumpedNext {
+		return nil
+	}
+	r.dumpedNext = next
+	return r.writeEntries(r.dump)
+}
+
+// writeEntries writes the recorded entries to ws. r.dumpMu must be held.
+func (r *FlightRecorder) writeEntries(ws zapcore.WriteSyncer) error {
+	var buf bytes.Buffer
+	for _, entry := range r.Entries(zapcore.DebugLevel) {
+		buf.Write(entry)
+		buf.WriteByte('\n')
+	}
+	_, err := ws.Write(buf.Bytes())
+	return multierr.Append(err, ws.Sync())
+}
+
+func (r *FlightRecorder) record(lvl zapcore.Level, encoded []byte) {
+	seq := atomic.AddUint64(&r.next, 1) - 1
+	r.slots[seq%uint64(len(r.slots))].Store(&recordedEntry{
+		seq:   seq,
+		level: lvl,
+		json:  encoded,
+	})
+}
+
+// snapshot returns the entries currently in the ring buffer, oldest first.
+func (r *FlightRecorder) snapshot() []*recordedEntry {
+	next := atomic.LoadUint64(&r.next)
+	var oldest uint64
+	if size := uint64(len(r.slots)); next > size {
+		oldest = next - size
+	}
+
+	recs := make([]*recordedEntry, 0, len(r.slots))
+	for i := range r.slots {
+		rec, ok := r.slots[i].Load().(*recordedEntry)
+		// Skip empty slots, and slots overwritten since we loaded next.
+		if ok && rec.seq >= oldest && rec.seq < next {
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].seq < recs[j].seq })
+	return recs
+}
+
+type recorderCore struct {
+	r   *FlightRecorder
+	enc zapcore.Encoder
+}
+
+func (c *recorderCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *recorderCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &recorderCore{r: c.r, enc: c.enc.Clone()}
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (c *recorderCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *recorderCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	encoded := append([]byte(nil), bytes.TrimRight(buf.Bytes(), "\n")...)
+	buf.Free()
+	c.r.record(ent.Level, encoded)
+
+	if ent.Level >= zapcore.DPanicLevel {
+		return c.r.autoDump()
+	}
+	return nil
+}
+
+func (c *recorderCore) Sync() error {
+	return nil
+}
+
+type recorderHook struct {
+	r    *FlightRecorder
+	next zapcore.CheckWriteHook
+}
+
+func (h *recorderHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	if err := h.r.autoDump(); err != nil {
+		// Entries that no Core writes have no ErrorOutput.
+		errOut := ce.ErrorOutput
+		if errOut == nil {
+			errOut = zapcore.Lock(os.Stderr)
+		}
+		fmt.Fprintf(errOut, "%v flight recorder dump error: %v\n", ce.Time, err)
+		errOut.Sync()
+	}
+	h.next.OnWrite(ce, fields)
+}
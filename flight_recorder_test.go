@@ -0,0 +1,270 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+func decodeRecordedEntry(t testing.TB, entry []byte) map[string]interface{} {
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(entry, &fields), "Recorded entry is not valid JSON: %s", entry)
+	return fields
+}
+
+// recordedMessages extracts the messages of the recorded entries.
+func recordedMessages(t testing.TB, entries [][]byte) []string {
+	msgs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		fields := decodeRecordedEntry(t, e)
+		msgs = append(msgs, fields["msg"].(string))
+	}
+	return msgs
+}
+
+func TestFlightRecorderRing(t *testing.T) {
+	rec := NewFlightRecorder(3, nil)
+	logger := New(rec.Core())
+
+	assert.Empty(t, rec.Entries(DebugLevel), "Expected no entries before logging.")
+
+	logger.Debug("one")
+	logger.Info("two")
+	assert.Equal(t, []string{"one", "two"}, recordedMessages(t, rec.Entries(DebugLevel)), "Unexpected entries.")
+
+	logger.Warn("three")
+	logger.Debug("four")
+	logger.Error("five")
+	assert.Equal(t, []string{"three", "four", "five"}, recordedMessages(t, rec.Entries(DebugLevel)),
+		"Expected only the most recent entries to be kept.")
+	assert.Equal(t, []string{"three", "five"}, recordedMessages(t, rec.Entries(WarnLevel)),
+		"Unexpected entries at or above warn.")
+}
+
+func TestFlightRecorderFields(t *testing.T) {
+	rec := NewFlightRecorder(10, nil)
+	logger := New(rec.Core()).With(String("ctx", "a")).Named("svc")
+	logger.Debug("hello", Int("n", 1))
+
+	entries := rec.Entries(DebugLevel)
+	require.Len(t, entries, 1, "Unexpected number of entries.")
+	fields := decodeRecordedEntry(t, entries[0])
+	assert.Equal(t, "debug", fields["level"], "Unexpected level.")
+	assert.Equal(t, "svc", fields["logger"], "Unexpected logger name.")
+	assert.Equal(t, "a", fields["ctx"], "Expected context fields to be recorded.")
+	assert.Equal(t, float64(1), fields["n"], "Expected entry fields to be recorded.")
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T`, fields["ts"], "Expected ISO8601 timestamp.")
+}
+
+func TestFlightRecorderDumpsOnCrash(t *testing.T) {
+	tests := []struct {
+		desc string
+		log  func(*Logger)
+	}{
+		{"dpanic", func(l *Logger) { l.DPanic("crash") }},
+		{"panic", func(l *Logger) { assert.Panics(t, func() { l.Panic("crash") }) }},
+		{"fatal", func(l *Logger) {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l.WithOptions(WithFatalHook(zapcore.WriteThenGoexit)).Fatal("crash")
+			}()
+			wg.Wait()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dump := &ztest.Buffer{}
+			rec := NewFlightRecorder(10, dump)
+			core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}), &ztest.Buffer{}, ErrorLevel)
+			logger := New(zapcore.NewTee(core, rec.Core()))
+
+			logger.Debug("debug")
+			logger.Info("info")
+			assert.Empty(t, dump.Lines(), "Expected no dump before a crash.")
+
+			tt.log(logger)
+			lines := dump.Lines()
+			require.Len(t, lines, 3, "Expected recorded entries to be dumped.")
+			assert.Equal(t, []string{"debug", "info", "crash"}, recordedMessages(t, [][]byte{
+				[]byte(lines[0]), []byte(lines[1]), []byte(lines[2]),
+			}), "Unexpected dumped entries.")
+			assert.True(t, dump.Called(), "Expected dump output to be synced.")
+		})
+	}
+}
+
+func TestFlightRecorderHook(t *testing.T) {
+	fatal := func(l *Logger) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Fatal("crash")
+		}()
+		wg.Wait()
+	}
+
+	t.Run("entry not written", func(t *testing.T) {
+		dump := &ztest.Buffer{}
+		rec := NewFlightRecorder(10, dump)
+		New(rec.Core()).Debug("debug")
+
+		// No Core writes the Fatal entry, so only the hook can dump.
+		logger := New(zapcore.NewNopCore(), WithFatalHook(rec.Hook(zapcore.WriteThenGoexit)))
+		fatal(logger)
+		lines := dump.Lines()
+		require.Len(t, lines, 1, "Expected recorded entries to be dumped by the hook.")
+		assert.Equal(t, []string{"debug"}, recordedMessages(t, [][]byte{[]byte(lines[0])}), "Unexpected dumped entries.")
+	})
+
+	t.Run("entry written", func(t *testing.T) {
+		dump := &ztest.Buffer{}
+		rec := NewFlightRecorder(10, dump)
+		logger := New(rec.Core(), WithFatalHook(rec.Hook(zapcore.WriteThenGoexit)))
+		logger.Debug("debug")
+
+		fatal(logger)
+		assert.Len(t, dump.Lines(), 2, "Expected entries to be dumped once.")
+	})
+
+	t.Run("dump error", func(t *testing.T) {
+		rec := NewFlightRecorder(10, zapcore.AddSync(ztest.FailWriter{}))
+		New(rec.Core()).Debug("debug")
+
+		errOut := &ztest.Buffer{}
+		core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}), &ztest.Buffer{}, ErrorLevel)
+		logger := New(core, ErrorOutput(errOut), WithFatalHook(rec.Hook(zapcore.WriteThenGoexit)))
+		fatal(logger)
+		assert.Contains(t, errOut.String(), "flight recorder dump error: failed", "Expected dump errors to be reported.")
+	})
+}
+
+func TestFlightRecorderDumpError(t *testing.T) {
+	rec := NewFlightRecorder(10, zapcore.AddSync(ztest.FailWriter{}))
+	errOut := &ztest.Buffer{}
+	logger := New(rec.Core(), ErrorOutput(errOut))
+	logger.DPanic("crash")
+	assert.Contains(t, errOut.String(), "write error: failed", "Expected dump errors to be reported.")
+}
+
+func TestFlightRecorderConcurrent(t *testing.T) {
+	rec := NewFlightRecorder(100, nil)
+	logger := New(rec.Core())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Debug(fmt.Sprintf("%d-%d", i, j))
+				rec.Entries(DebugLevel)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	msgs := recordedMessages(t, rec.Entries(DebugLevel))
+	assert.Len(t, msgs, 100, "Expected the ring buffer to be full.")
+	seen := make(map[string]bool)
+	for _, msg := range msgs {
+		assert.False(t, seen[msg], "Unexpected duplicate entry %q.", msg)
+		seen[msg] = true
+		assert.True(t, strings.Contains(msg, "-"), "Unexpected entry %q.", msg)
+	}
+}
+
+func TestFlightRecorderNoDump(t *testing.T) {
+	rec := NewFlightRecorder(10, nil)
+	logger := New(rec.Core())
+	assert.NotPanics(t, func() { logger.DPanic("crash") }, "Unexpected panic.")
+	assert.Len(t, rec.Entries(DebugLevel), 1, "Expected entry to be recorded.")
+
+	dump := &ztest.Buffer{}
+	require.NoError(t, rec.Dump(dump), "Unexpected error dumping entries.")
+	assert.Equal(t, 1, len(dump.Lines()), "Expected on-demand dump to write the entry.")
+}
+
+func TestFlightRecorderServeHTTP(t *testing.T) {
+	rec := NewFlightRecorder(10, nil)
+	logger := New(rec.Core())
+	logger.Debug("debug")
+	logger.Warn("warn")
+
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	tests := []struct {
+		desc       string
+		method     string
+		query      string
+		wantStatus int
+		wantMsgs   []string
+		wantErr    string
+	}{
+		{desc: "all", method: http.MethodGet, wantStatus: http.StatusOK, wantMsgs: []string{"debug", "warn"}},
+		{desc: "level", method: http.MethodGet, query: "?level=warn", wantStatus: http.StatusOK, wantMsgs: []string{"warn"}},
+		{desc: "no matches", method: http.MethodGet, query: "?level=error", wantStatus: http.StatusOK, wantMsgs: []string{}},
+		{desc: "bad level", method: http.MethodGet, query: "?level=bad", wantStatus: http.StatusBadRequest, wantErr: "unrecognized level"},
+		{desc: "bad method", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantErr: "Only GET is supported."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.query, nil)
+			require.NoError(t, err, "Error constructing request.")
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "Error making request.")
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode, "Unexpected status code.")
+			assert.Equal(t, "application/json", res.Header.Get("Content-Type"), "Unexpected content type.")
+
+			var body struct {
+				Entries []json.RawMessage `json:"entries"`
+				Error   string            `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body), "Unable to decode response body.")
+			if tt.wantErr != "" {
+				assert.Contains(t, body.Error, tt.wantErr, "Unexpected error message.")
+				return
+			}
+			msgs := make([][]byte, len(body.Entries))
+			for i, e := range body.Entries {
+				msgs[i] = e
+			}
+			assert.Equal(t, tt.wantMsgs, recordedMessages(t, msgs), "Unexpected entries.")
+		})
+	}
+}
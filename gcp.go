@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Keys of the special fields that Google Cloud Logging's agents move from a
+// structured log entry's payload to the LogEntry itself.
+const (
+	GCPSourceLocationKey = "logging.googleapis.com/sourceLocation"
+	GCPTraceKey          = "logging.googleapis.com/trace"
+	GCPSpanIDKey         = "logging.googleapis.com/spanId"
+	GCPTraceSampledKey   = "logging.googleapis.com/trace_sampled"
+	GCPHTTPRequestKey    = "httpRequest"
+)
+
+// NewGCPEncoderConfig returns an EncoderConfig for Google Cloud Logging's
+// structured logging, as read by the logging agents of GKE, Cloud Run, and
+// other platforms. Levels are written to the severity field with the names of
+// Cloud Logging's severities, and callers are written as source location
+// objects. It's meant to be used with the "gcp" encoder.
+func NewGCPEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "severity",
+		NameKey:        "logger",
+		CallerKey:      GCPSourceLocationKey,
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "message",
+		StacktraceKey:  "stack_trace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.GCPSeverityLevelEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.GCPSourceLocationCallerEncoder,
+	}
+}
+
+// NewGCPConfig is like NewProductionConfig, but uses the "gcp" encoder and
+// writes to standard output, where Cloud Logging's agents expect structured
+// logs.
+func NewGCPConfig() Config {
+	cfg := NewProductionConfig()
+	cfg.Encoding = "gcp"
+	cfg.EncoderConfig = NewGCPEncoderConfig()
+	cfg.OutputPaths = []string{"stdout"}
+	return cfg
+}
+
+// GCPTrace constructs a field that associates an entry with a Cloud Trace
+// trace, given the ID of the Google Cloud project and the hex-encoded trace
+// ID (e.g., from the X-Cloud-Trace-Context header or a W3C traceparent).
+func GCPTrace(projectID, traceID string) Field {
+	return String(GCPTraceKey, "projects/"+projectID+"/traces/"+traceID)
+}
+
+// GCPSpanID constructs a field that associates an entry with a span of the
+// trace set by GCPTrace. Span IDs are 16-character hex strings.
+func GCPSpanID(spanID string) Field {
+	return String(GCPSpanIDKey, spanID)
+}
+
+// GCPTraceSampled constructs a field that records whether the trace set by
+// GCPTrace was sampled.
+func GCPTraceSampled(sampled bool) Field {
+	return Bool(GCPTraceSampledKey, sampled)
+}
+
+// GCPHTTPRequest constructs a field that describes an HTTP request in the
+// format of Cloud Logging's HttpRequest, which the Logs Explorer displays
+// with the entry. The request is usually a server's incoming request, while
+// status, responseSize, and latency describe the response; a non-positive
+// status, negative responseSize, or zero latency is omitted.
+func GCPHTTPRequest(r *http.Request, status int, responseSize int64, latency time.Duration) Field {
+	return Object(GCPHTTPRequestKey, gcpHTTPRequest{
+		r:            r,
+		status:       status,
+		responseSize: responseSize,
+		latency:      latency,
+	})
+}
+
+type gcpHTTPRequest struct {
+	r            *http.Request
+	status       int
+	responseSize int64
+	latency      time.Duration
+}
+
+func (req gcpHTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	r := req.r
+	enc.AddString("requestMethod", r.Method)
+	if r.URL != nil {
+		enc.AddString("requestUrl", requestURL(r))
+	}
+	if r.ContentLength > 0 {
+		enc.AddInt64("requestSize", r.ContentLength)
+	}
+	if req.status > 0 {
+		enc.AddInt("status", req.status)
+	}
+	if req.responseSize >= 0 {
+		enc.AddInt64("responseSize", req.responseSize)
+	}
+	if ua := r.UserAgent(); ua != "" {
+		enc.AddString("userAgent", ua)
+	}
+	if r.RemoteAddr != "" {
+		enc.AddString("remoteIp", r.RemoteAddr)
+	}
+	if ref := r.Referer(); ref != "" {
+		enc.AddString("referer", ref)
+	}
+	if req.latency != 0 {
+		// Cloud Logging expects a google.protobuf.Duration, like "1.5s".
+		enc.AddString("latency", strconv.FormatFloat(req.latency.Seconds(), 'f', -1, 64)+"s")
+	}
+	if r.Proto != "" {
+		enc.AddString("protocol", r.Proto)
+	}
+	return nil
+}
+
+// requestURL returns the absolute URL of a request. The URLs of incoming
+// requests usually lack a scheme and host, which are taken from the
+// connection and Host header.
+func requestURL(r *http.Request) string {
+	if r.URL.IsAbs() || r.Host == "" {
+		return r.URL.String()
+	}
+	u := *r.URL
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.Host = r.Host
+	return u.String()
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGCPTraceFields(t *testing.T) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range []Field{
+		GCPTrace("my-project", "06796866738c859f2f19b7cfb3214824"),
+		GCPSpanID("000000000000004a"),
+		GCPTraceSampled(true),
+	} {
+		f.AddTo(enc)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"logging.googleapis.com/trace":         "projects/my-project/traces/06796866738c859f2f19b7cfb3214824",
+		"logging.googleapis.com/spanId":        "000000000000004a",
+		"logging.googleapis.com/trace_sampled": true,
+	}, enc.Fields, "Unexpected trace fields.")
+}
+
+func TestGCPHTTPRequest(t *testing.T) {
+	tests := []struct {
+		desc    string
+		req     func() *http.Request
+		status  int
+		size    int64
+		latency time.Duration
+		want    map[string]interface{}
+	}{
+		{
+			desc: "incoming request",
+			req: func() *http.Request {
+				r := httptest.NewRequest("POST", "/api/items?page=2", strings.NewReader("body"))
+				r.Header.Set("User-Agent", "curl/7.79.1")
+				r.Header.Set("Referer", "https://example.com/")
+				return r
+			},
+			status:  201,
+			size:    512,
+			latency: 1500 * time.Millisecond,
+			want: map[string]interface{}{
+				"requestMethod": "POST",
+				"requestUrl":    "http://example.com/api/items?page=2",
+				"requestSize":   int64(4),
+				"status":        201,
+				"responseSize":  int64(512),
+				"userAgent":     "curl/7.79.1",
+				"remoteIp":      "192.0.2.1:1234",
+				"referer":       "https://example.com/",
+				"latency":       "1.5s",
+				"protocol":      "HTTP/1.1",
+			},
+		},
+		{
+			desc: "tls",
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.TLS = &tls.ConnectionState{}
+				r.Host = "secure.example.com"
+				return r
+			},
+			status: 0,
+			size:   -1,
+			want: map[string]interface{}{
+				"requestMethod": "GET",
+				"requestUrl":    "https://secure.example.com/",
+				"remoteIp":      "192.0.2.1:1234",
+				"protocol":      "HTTP/1.1",
+			},
+		},
+		{
+			desc: "outgoing request",
+			req: func() *http.Request {
+				r, err := http.NewRequest("GET", "https://api.example.com/v1", nil)
+				require.NoError(t, err, "Unexpected error creating request.")
+				return r
+			},
+			status:  404,
+			size:    0,
+			latency: time.Millisecond,
+			want: map[string]interface{}{
+				"requestMethod": "GET",
+				"requestUrl":    "https://api.example.com/v1",
+				"status":        404,
+				"responseSize":  int64(0),
+				"latency":       "0.001s",
+				"protocol":      "HTTP/1.1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewMapObjectEncoder()
+			GCPHTTPRequest(tt.req(), tt.status, tt.size, tt.latency).AddTo(enc)
+			assert.Equal(t, tt.want, enc.Fields["httpRequest"], "Unexpected httpRequest.")
+		})
+	}
+}
+
+func TestNewGCPConfig(t *testing.T) {
+	cfg := NewGCPConfig()
+	assert.Equal(t, "gcp", cfg.Encoding, "Unexpected encoding.")
+	assert.Equal(t, []string{"stdout"}, cfg.OutputPaths, "Unexpected output paths.")
+
+	enc, err := newEncoder(cfg.Encoding, cfg.EncoderConfig)
+	require.NoError(t, err, "Unexpected error building encoder.")
+	ent := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Message: "m",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "/src/f.go", Line: 3, Function: "main.f"},
+	}
+	buf, err := enc.EncodeEntry(ent, []Field{GCPTrace("p", "t")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"severity":"WARNING","time":"0001-01-01T00:00:00Z",`+
+			`"logging.googleapis.com/sourceLocation":{"file":"/src/f.go","line":3,"function":"main.f"},`+
+			`"message":"m","logging.googleapis.com/trace":"projects/p/traces/t"}`+"\n",
+		buf.String(), "Unexpected structured log entry.")
+	buf.Free()
+}
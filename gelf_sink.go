@@ -0,0 +1,260 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const schemeGELF = "gelf"
+
+// _defaultGELFPort is the port used by GELF URLs that don't specify one.
+const _defaultGELFPort = "12201"
+
+const (
+	// _defaultGELFChunkSize is the largest datagram Graylog recommends
+	// sending over a WAN.
+	_defaultGELFChunkSize = 1420
+
+	// Each chunk starts with two magic bytes, an 8-byte message ID, and
+	// one byte each for the chunk's sequence number and the chunk count.
+	_gelfChunkHeaderSize = 12
+	_gelfMaxChunks       = 128
+)
+
+// A GELFSink is a Sink that sends each message to a Graylog GELF UDP input
+// as a datagram. Messages too large for a single datagram are split into
+// GELF chunks, which Graylog reassembles. It's meant for use with the "gelf"
+// encoder; see zapcore.NewGELFEncoder.
+//
+// GELFSink dials lazily, on the first write, and dials again after a failed
+// write. Since UDP is unreliable, a successful write doesn't guarantee
+// delivery.
+//
+// GELFSink is safe for concurrent use.
+type GELFSink struct {
+	// Address is the host and port of the GELF UDP input.
+	//
+	// This field is required.
+	Address string
+
+	// ChunkSize is the size of the largest datagram to send, including the
+	// chunk header. Larger messages are split into at most 128 chunks;
+	// messages that would need more are rejected with an error.
+	//
+	// Defaults to 1420 bytes, which suits most networks. Graylog suggests
+	// 8192 bytes for local networks.
+	ChunkSize int
+
+	// Compress enables gzip compression of messages.
+	Compress bool
+
+	mu    sync.Mutex
+	conn  net.Conn
+	chunk []byte // scratch space for chunks
+}
+
+var _ Sink = (*GELFSink)(nil)
+
+// Write sends the supplied message, less any trailing newline, dialing first
+// if necessary.
+func (s *GELFSink) Write(bs []byte) (int, error) {
+	msg := bs
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	if s.Compress {
+		var err error
+		if msg, err = gzipBytes(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.Address)
+		if err != nil {
+			return 0, err
+		}
+		s.conn = conn
+	}
+	if err := s.send(msg); err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
+
+// send writes a message as a single datagram or as a series of chunks.
+// s.mu must be held.
+func (s *GELFSink) send(msg []byte) error {
+	size := s.ChunkSize
+	if size <= 0 {
+		size = _defaultGELFChunkSize
+	}
+	if len(msg) <= size {
+		return s.writeDatagram(msg)
+	}
+
+	if size <= _gelfChunkHeaderSize {
+		return fmt.Errorf("GELF chunk size %d is too small for the %d-byte chunk header", size, _gelfChunkHeaderSize)
+	}
+	dataSize := size - _gelfChunkHeaderSize
+	count := (len(msg) + dataSize - 1) / dataSize
+	if count > _gelfMaxChunks {
+		return fmt.Errorf("GELF message of %d bytes needs %d chunks, more than the maximum of %d", len(msg), count, _gelfMaxChunks)
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		data := msg[i*dataSize:]
+		if len(data) > dataSize {
+			data = data[:dataSize]
+		}
+		s.chunk = append(s.chunk[:0], 0x1e, 0x0f)
+		s.chunk = append(s.chunk, id[:]...)
+		s.chunk = append(s.chunk, byte(i), byte(count))
+		s.chunk = append(s.chunk, data...)
+		if err := s.writeDatagram(s.chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDatagram writes a single datagram, dropping the connection if that
+// fails. s.mu must be held.
+func (s *GELFSink) writeDatagram(bs []byte) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(_defaultNetWriteTimeout)); err != nil {
+		s.disconnect()
+		return err
+	}
+	if _, err := s.conn.Write(bs); err != nil {
+		s.disconnect()
+		return err
+	}
+	return nil
+}
+
+// disconnect drops a connection after a failed write, so that the next
+// write dials again. s.mu must be held.
+func (s *GELFSink) disconnect() {
+	s.conn.Close()
+	s.conn = nil
+}
+
+// Sync is a no-op, since messages are sent as they're written.
+func (s *GELFSink) Sync() error {
+	return nil
+}
+
+// Close closes the connection, if any. Writing to a closed GELFSink dials
+// again.
+func (s *GELFSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// newGELFSink builds a GELFSink from a URL like
+//
+//   gelf://graylog:12201?chunkSize=8192&compress=gzip
+//
+// The host and port determine the address of the GELF UDP input; the port
+// defaults to 12201. The query parameters chunkSize (e.g., "8KB") and
+// compress ("gzip" or "none") configure the sink.
+func newGELFSink(u *url.URL) (Sink, error) {
+	if u.User != nil {
+		return nil, fmt.Errorf("user and password not allowed with gelf URLs: got %v", u)
+	}
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with gelf URLs: got %v", u)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("gelf URLs must include a host: got %v", u)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("paths not allowed with gelf URLs: got %v", u)
+	}
+
+	s := &GELFSink{Address: u.Host}
+	if u.Port() == "" {
+		s.Address = net.JoinHostPort(u.Hostname(), _defaultGELFPort)
+	}
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "chunkSize":
+			var size int64
+			size, err = parseByteSize(val)
+			if err == nil && size <= _gelfChunkHeaderSize {
+				err = fmt.Errorf("must be larger than the %d-byte chunk header", _gelfChunkHeaderSize)
+			}
+			s.ChunkSize = int(size)
+		case "compress":
+			switch val {
+			case "gzip":
+				s.Compress = true
+			case "none":
+				s.Compress = false
+			default:
+				err = errors.New(`must be "gzip" or "none"`)
+			}
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in gelf URL: got %v", key, u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in gelf URL: %v", key, val, err)
+		}
+	}
+	return s, nil
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
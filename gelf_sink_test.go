@@ -0,0 +1,261 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readGELFMessage reads datagrams from conn until it has a complete GELF
+// message, reassembling chunks and decompressing the message if necessary.
+func readGELFMessage(t testing.TB, conn net.PacketConn) (msg []byte, datagrams int) {
+	var (
+		id     []byte
+		chunks [][]byte
+		seen   int
+	)
+	for {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 65536)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err, "Failed to read datagram.")
+		buf = buf[:n]
+		datagrams++
+
+		if len(buf) < 2 || buf[0] != 0x1e || buf[1] != 0x0f {
+			require.Nil(t, chunks, "Unexpected unchunked datagram while reassembling chunks.")
+			return gunzipIfNeeded(t, buf), datagrams
+		}
+
+		require.True(t, len(buf) > 12, "Chunk too short: %d bytes.", len(buf))
+		seq, count := int(buf[10]), int(buf[11])
+		if chunks == nil {
+			id = buf[2:10]
+			chunks = make([][]byte, count)
+		}
+		require.Equal(t, id, buf[2:10], "Chunks have different message IDs.")
+		require.Len(t, chunks, count, "Chunks have different counts.")
+		require.True(t, seq < count, "Sequence number %d out of range.", seq)
+		require.Nil(t, chunks[seq], "Duplicate chunk %d.", seq)
+		chunks[seq] = buf[12:]
+		if seen++; seen == count {
+			return gunzipIfNeeded(t, bytes.Join(chunks, nil)), datagrams
+		}
+	}
+}
+
+func gunzipIfNeeded(t testing.TB, bs []byte) []byte {
+	if len(bs) < 2 || bs[0] != 0x1f || bs[1] != 0x8b {
+		return bs
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(bs))
+	require.NoError(t, err, "Failed to read gzip header.")
+	out, err := ioutil.ReadAll(zr)
+	require.NoError(t, err, "Failed to decompress message.")
+	return out
+}
+
+func listenGELF(t testing.TB) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen on UDP.")
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGELFSink(t *testing.T) {
+	long := strings.Repeat("0123456789", 100)
+	tests := []struct {
+		desc          string
+		chunkSize     int
+		compress      bool
+		msg           string
+		wantDatagrams int
+	}{
+		{
+			desc:          "small message",
+			msg:           "hello\n",
+			wantDatagrams: 1,
+		},
+		{
+			desc:          "exactly one datagram",
+			chunkSize:     100,
+			msg:           long[:100],
+			wantDatagrams: 1,
+		},
+		{
+			desc:          "chunked",
+			chunkSize:     100,
+			msg:           long + "\n",
+			wantDatagrams: 12, // 1000 bytes in 88-byte chunks
+		},
+		{
+			desc:          "compressed",
+			compress:      true,
+			msg:           long,
+			wantDatagrams: 1,
+		},
+		{
+			desc:      "compressed and chunked",
+			chunkSize: 20,
+			compress:  true,
+			msg:       long,
+			// The number of chunks depends on the compressed size.
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			conn := listenGELF(t)
+			s := &GELFSink{
+				Address:   conn.LocalAddr().String(),
+				ChunkSize: tt.chunkSize,
+				Compress:  tt.compress,
+			}
+			defer s.Close()
+
+			n, err := s.Write([]byte(tt.msg))
+			require.NoError(t, err, "Unexpected error writing.")
+			assert.Equal(t, len(tt.msg), n, "Unexpected number of bytes written.")
+
+			msg, datagrams := readGELFMessage(t, conn)
+			assert.Equal(t, strings.TrimSuffix(tt.msg, "\n"), string(msg), "Unexpected message.")
+			if tt.wantDatagrams > 0 {
+				assert.Equal(t, tt.wantDatagrams, datagrams, "Unexpected number of datagrams.")
+			} else {
+				assert.True(t, datagrams > 1, "Expected message to be chunked.")
+			}
+		})
+	}
+}
+
+func TestGELFSinkTooManyChunks(t *testing.T) {
+	conn := listenGELF(t)
+	s := &GELFSink{Address: conn.LocalAddr().String(), ChunkSize: 13}
+	defer s.Close()
+
+	_, err := s.Write(bytes.Repeat([]byte("x"), 129))
+	if assert.Error(t, err, "Expected an error writing a message needing too many chunks.") {
+		assert.Contains(t, err.Error(), "needs 129 chunks", "Unexpected error.")
+	}
+
+	_, err = s.Write(bytes.Repeat([]byte("x"), 128))
+	require.NoError(t, err, "Unexpected error writing a message needing the maximum number of chunks.")
+	msg, datagrams := readGELFMessage(t, conn)
+	assert.Equal(t, 128, len(msg), "Unexpected message length.")
+	assert.Equal(t, 128, datagrams, "Unexpected number of datagrams.")
+
+	s.ChunkSize = 12
+	_, err = s.Write([]byte("too big for a single datagram"))
+	if assert.Error(t, err, "Expected an error with a chunk size too small for the header.") {
+		assert.Contains(t, err.Error(), "too small", "Unexpected error.")
+	}
+}
+
+func TestNewGELFSink(t *testing.T) {
+	tests := []struct {
+		url           string
+		wantAddress   string
+		wantChunkSize int
+		wantCompress  bool
+	}{
+		{"gelf://graylog", "graylog:12201", 0, false},
+		{"gelf://graylog:5555/", "graylog:5555", 0, false},
+		{"gelf://[::1]", "[::1]:12201", 0, false},
+		{"gelf://graylog?chunkSize=8KB&compress=gzip", "graylog:12201", 8192, true},
+		{"gelf://graylog?compress=none", "graylog:12201", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			require.NoError(t, err, "Failed to parse URL.")
+			sink, err := newGELFSink(u)
+			require.NoError(t, err, "Unexpected error building GELF sink.")
+			s := sink.(*GELFSink)
+			assert.Equal(t, tt.wantAddress, s.Address, "Unexpected address.")
+			assert.Equal(t, tt.wantChunkSize, s.ChunkSize, "Unexpected chunk size.")
+			assert.Equal(t, tt.wantCompress, s.Compress, "Unexpected compression.")
+		})
+	}
+}
+
+func TestNewGELFSinkErrors(t *testing.T) {
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"gelf://", "must include a host"},
+		{"gelf://:12201", "must include a host"},
+		{"gelf://graylog/foo", "paths not allowed"},
+		{"gelf://user@graylog", "user and password not allowed"},
+		{"gelf://graylog#foo", "fragments not allowed"},
+		{"gelf://graylog?foo=bar", `unknown query parameter "foo"`},
+		{"gelf://graylog?chunkSize=big", "invalid chunkSize"},
+		{"gelf://graylog?chunkSize=12", "invalid chunkSize"},
+		{"gelf://graylog?compress=zlib", "invalid compress"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, _, err := Open(tt.url)
+			if assert.Error(t, err, "Expected an error opening %q.", tt.url) {
+				assert.Contains(t, err.Error(), tt.err, "Unexpected error.")
+			}
+		})
+	}
+}
+
+func TestGELFConfig(t *testing.T) {
+	conn := listenGELF(t)
+
+	cfg := NewProductionConfig()
+	cfg.Encoding = "gelf"
+	cfg.OutputPaths = []string{"gelf://" + conn.LocalAddr().String() + "?chunkSize=200"}
+	cfg.Sampling = nil
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	defer close()
+
+	logger.Named("myapp").Warn("hello", String("user", "alice"), String("padding", strings.Repeat("x", 500)))
+	msg, datagrams := readGELFMessage(t, conn)
+	assert.True(t, datagrams > 1, "Expected message to be chunked.")
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(msg, &decoded), "Failed to decode GELF message %q.", msg)
+	assert.Equal(t, "1.1", decoded["version"], "Unexpected version.")
+	assert.Equal(t, "hello", decoded["short_message"], "Unexpected short message.")
+	assert.Equal(t, float64(4), decoded["level"], "Unexpected level.")
+	assert.Equal(t, "myapp", decoded["_logger"], "Unexpected logger name.")
+	assert.Equal(t, "alice", decoded["_user"], "Unexpected additional field.")
+	assert.Contains(t, decoded["_caller"], "gelf_sink_test.go", "Unexpected caller.")
+	assert.IsType(t, float64(0), decoded["timestamp"], "Expected a numeric timestamp.")
+}
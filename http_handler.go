@@ -97,6 +97,51 @@ func (lvl AtomicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ServeHTTP is a JSON endpoint that reports the entries held by the
+// FlightRecorder, oldest first. Only GET requests are supported.
+//
+// The response looks like:
+//
+//    {"entries":[{"level":"debug","ts":"2022-01-02T15:04:05.000Z","msg":"..."}]}
+//
+// The optional level query parameter limits the response to entries at or
+// above a level. For example,
+//
+//    curl localhost:8080/log/recent?level=warn
+func (r *FlightRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	type errorResponse struct {
+		Error string `json:"error"`
+	}
+	type payload struct {
+		Entries []json.RawMessage `json:"entries"`
+	}
+
+	enc := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		enc.Encode(errorResponse{Error: "Only GET is supported."})
+		return
+	}
+
+	minLevel := zapcore.DebugLevel
+	if lvl := req.URL.Query().Get("level"); lvl != "" {
+		if err := minLevel.UnmarshalText([]byte(lvl)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			enc.Encode(errorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	entries := r.Entries(minLevel)
+	resp := payload{Entries: make([]json.RawMessage, len(entries))}
+	for i, e := range entries {
+		resp.Entries[i] = e
+	}
+	enc.Encode(resp)
+}
+
 // Decodes incoming PUT requests and returns the requested logging level.
 func decodePutRequest(contentType string, r *http.Request) (zapcore.Level, error) {
 	if contentType == "application/x-www-form-urlencoded" {
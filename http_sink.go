@@ -0,0 +1,349 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/internal/httpbatch"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+)
+
+const (
+	_defaultHTTPBatchEntries  = 1000
+	_defaultHTTPBatchBytes    = 1024 * 1024 // 1 MB
+	_defaultHTTPFlushInterval = time.Second
+	_defaultHTTPMaxRetries    = 5
+	_defaultHTTPMinBackoff    = 100 * time.Millisecond
+	_defaultHTTPMaxBackoff    = 30 * time.Second
+	_defaultHTTPMaxPending    = 16
+	_defaultHTTPTimeout       = 30 * time.Second
+)
+
+var (
+	_defaultHTTPClient = &http.Client{Timeout: _defaultHTTPTimeout}
+
+	errHTTPSinkClosed = errors.New("http sink is closed")
+)
+
+// HTTPSinkStats reports the activity of an HTTPSink.
+type HTTPSinkStats struct {
+	// Batches and Entries count the batches and entries accepted by the
+	// server.
+	Batches int64
+	Entries int64
+	// Bytes is the size of the accepted batches before compression.
+	Bytes int64
+	// Retries is the number of requests repeated after a failure.
+	Retries int64
+	// FailedBatches and FailedEntries count the batches and entries that
+	// were abandoned after exhausting their retries or being rejected by the
+	// server.
+	FailedBatches int64
+	FailedEntries int64
+	// DroppedEntries is the number of entries discarded because too many
+	// batches were already awaiting delivery.
+	DroppedEntries int64
+}
+
+// An HTTPSink is a Sink that collects messages into batches and POSTs each
+// batch to an HTTP endpoint as newline-delimited JSON (NDJSON). It's meant to
+// be used with the JSON encoder, which writes one object per line.
+//
+// A batch is sent once it holds BatchEntries messages or BatchBytes bytes, or
+// once FlushInterval has passed, whichever comes first. Batches are delivered
+// by a background goroutine, so writes don't wait on the network. Requests
+// that fail with a network error, a 5xx status, or 429 Too Many Requests are
+// retried with exponential backoff, waiting as long as the server asks in a
+// Retry-After header, up to MaxBackoff. Other responses outside the 2xx range
+// abandon the batch.
+//
+// Errors encountered during delivery are reported by the next call to Sync.
+// They name the endpoint without its user info and query string, which may
+// hold credentials.
+//
+// HTTPSink is safe for concurrent use. It must be closed when finished to
+// deliver any remaining messages.
+type HTTPSink struct {
+	// URL is the endpoint to which batches are POSTed.
+	//
+	// This field is required.
+	URL string
+
+	// Client sends the requests.
+	//
+	// Defaults to a client with a 30 second timeout.
+	Client *http.Client
+
+	// Header holds additional headers to send with each request, such as
+	// authorization.
+	Header http.Header
+
+	// Compress enables gzip compression of request bodies.
+	Compress bool
+
+	// BatchEntries and BatchBytes bound the number of messages and the
+	// number of bytes in each batch.
+	//
+	// Default to 1000 messages and 1 MB if unspecified.
+	BatchEntries int
+	BatchBytes   int
+
+	// FlushInterval is the longest a message waits for its batch to fill
+	// before the batch is sent anyway.
+	//
+	// Defaults to 1 second if unspecified.
+	FlushInterval time.Duration
+
+	// MaxRetries is the number of times a failed request is repeated
+	// before the batch is abandoned.
+	//
+	// Defaults to 5 if unspecified. Negative values disable retries.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the delay between retries. A longer
+	// delay asked for in a Retry-After header is capped at MaxBackoff.
+	//
+	// Default to 100 milliseconds and 30 seconds if unspecified.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxPending is the number of full batches that may await delivery.
+	// Once that many are waiting, further batches are dropped.
+	//
+	// Defaults to 16 if unspecified.
+	MaxPending int
+
+	// Clock, if specified, provides control of the source of time for the
+	// flush interval and the delay between retries.
+	//
+	// Defaults to the system clock.
+	Clock zapcore.Clock
+
+	// unexported fields for state
+	mu     sync.Mutex
+	closed bool
+	sender *httpbatch.Sender // started by the first Write
+}
+
+var _ Sink = (*HTTPSink)(nil)
+
+// start creates the Sender that delivers the sink's batches. s.mu must be
+// held.
+func (s *HTTPSink) start() *httpbatch.Sender {
+	if s.sender != nil {
+		return s.sender
+	}
+	client := s.Client
+	if client == nil {
+		client = _defaultHTTPClient
+	}
+	clock := s.Clock
+	if clock == nil {
+		clock = zapcore.DefaultClock
+	}
+	maxRetries := s.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = _defaultHTTPMaxRetries
+	}
+	s.sender = httpbatch.New(httpbatch.Config{
+		URL:           s.URL,
+		Client:        client,
+		Header:        s.Header,
+		ContentType:   "application/x-ndjson",
+		Compress:      s.Compress,
+		Terminator:    '\n',
+		BatchEntries:  intOr(s.BatchEntries, _defaultHTTPBatchEntries),
+		BatchBytes:    intOr(s.BatchBytes, _defaultHTTPBatchBytes),
+		FlushInterval: durationOr(s.FlushInterval, _defaultHTTPFlushInterval),
+		MaxRetries:    maxRetries,
+		MinBackoff:    durationOr(s.MinBackoff, _defaultHTTPMinBackoff),
+		MaxBackoff:    durationOr(s.MaxBackoff, _defaultHTTPMaxBackoff),
+		MaxPending:    intOr(s.MaxPending, _defaultHTTPMaxPending),
+		Retryable: func(code int) bool {
+			return code == http.StatusTooManyRequests || code >= 500
+		},
+		Clock: clock,
+	})
+	return s.sender
+}
+
+// Write adds the message to the current batch, adding a trailing newline if
+// necessary.
+func (s *HTTPSink) Write(bs []byte) (int, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, errHTTPSinkClosed
+	}
+	sender := s.start()
+	s.mu.Unlock()
+
+	if err := sender.Add(bs); err != nil {
+		// The sink was closed concurrently.
+		return 0, errHTTPSinkClosed
+	}
+	return len(bs), nil
+}
+
+// Sync sends the current batch and waits until every batch written so far
+// has been delivered or abandoned. It returns the first delivery error
+// encountered since the previous call to Sync.
+func (s *HTTPSink) Sync() error {
+	s.mu.Lock()
+	sender := s.sender
+	s.mu.Unlock()
+
+	if sender == nil {
+		return nil
+	}
+	return sender.Sync()
+}
+
+// Close delivers any remaining messages and stops the background
+// goroutines. Once it's called, failed requests are no longer retried, so
+// that Close doesn't wait out the backoff. It returns the first delivery
+// error encountered since the previous call to Sync.
+func (s *HTTPSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	sender := s.sender
+	s.mu.Unlock()
+
+	if sender == nil {
+		return nil
+	}
+	return sender.Close()
+}
+
+// Stats reports the delivery statistics of the sink so far.
+func (s *HTTPSink) Stats() HTTPSinkStats {
+	s.mu.Lock()
+	sender := s.sender
+	s.mu.Unlock()
+
+	if sender == nil {
+		return HTTPSinkStats{}
+	}
+	return HTTPSinkStats(sender.Stats())
+}
+
+func intOr(n, fallback int) int {
+	if n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// _httpSinkParams lists the query parameters that configure an HTTPSink
+// rather than being sent to the server.
+var _httpSinkParams = []string{
+	"batchEntries", "batchBytes", "flushInterval", "compress",
+	"maxRetries", "minBackoff", "maxBackoff", "maxPending",
+}
+
+// newHTTPSink builds an HTTPSink from a URL like
+//
+//   https://collector/ingest?compress=gzip&batchEntries=500
+//
+// The query parameters listed in _httpSinkParams configure the sink and are
+// removed from the URL; any others are sent to the server.
+func newHTTPSink(u *url.URL) (Sink, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s URLs must include a host: got %v", u.Scheme, u)
+	}
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with %s URLs: got %v", u.Scheme, u)
+	}
+
+	s := &HTTPSink{URL: u.String()}
+	q := u.Query()
+	removed := false
+	for _, key := range _httpSinkParams {
+		vals, ok := q[key]
+		if !ok {
+			continue
+		}
+		q.Del(key)
+		removed = true
+
+		val := vals[len(vals)-1]
+		var (
+			err  error
+			size int64
+		)
+		switch key {
+		case "batchEntries":
+			s.BatchEntries, err = parsePositiveInt(val)
+		case "batchBytes":
+			size, err = parseByteSize(val)
+			s.BatchBytes = int(size)
+		case "flushInterval":
+			s.FlushInterval, err = parsePositiveDuration(val)
+		case "compress":
+			switch val {
+			case "gzip":
+				s.Compress = true
+			case "none":
+				s.Compress = false
+			default:
+				err = errors.New(`must be "gzip" or "none"`)
+			}
+		case "maxRetries":
+			s.MaxRetries, err = strconv.Atoi(val)
+		case "minBackoff":
+			s.MinBackoff, err = parsePositiveDuration(val)
+		case "maxBackoff":
+			s.MaxBackoff, err = parsePositiveDuration(val)
+		case "maxPending":
+			s.MaxPending, err = parsePositiveInt(val)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in %s URL: %v", key, val, u.Scheme, err)
+		}
+	}
+
+	if removed {
+		target := *u
+		target.RawQuery = q.Encode()
+		s.URL = target.String()
+	}
+	return s, nil
+}
+
+func parsePositiveInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err == nil && n <= 0 {
+		err = errors.New("must be positive")
+	}
+	return n, err
+}
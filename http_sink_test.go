@@ -0,0 +1,436 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+)
+
+// collector is an HTTP server that records the bodies of the requests it
+// receives and responds with the statuses it's given, then with 200 OK.
+type collector struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	bodies   []string
+	requests []*http.Request
+	statuses []int
+	headers  []http.Header // extra response headers, parallel to statuses
+	received chan struct{}
+}
+
+func newCollector(t testing.TB, statuses ...int) *collector {
+	c := &collector{statuses: statuses, received: make(chan struct{}, 100)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err, "Failed to decompress request.") {
+				return
+			}
+			body = zr
+		}
+		bs, err := ioutil.ReadAll(body)
+		assert.NoError(t, err, "Failed to read request.")
+
+		c.mu.Lock()
+		c.bodies = append(c.bodies, string(bs))
+		c.requests = append(c.requests, r)
+		status := http.StatusOK
+		if len(c.statuses) > 0 {
+			status = c.statuses[0]
+			c.statuses = c.statuses[1:]
+			if len(c.headers) > 0 {
+				for k, vs := range c.headers[0] {
+					w.Header()[k] = vs
+				}
+				c.headers = c.headers[1:]
+			}
+		}
+		c.mu.Unlock()
+
+		w.WriteHeader(status)
+		c.received <- struct{}{}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) Bodies() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+func (c *collector) Requests() []*http.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*http.Request(nil), c.requests...)
+}
+
+func writeLines(t testing.TB, s Sink, lines ...string) {
+	for _, line := range lines {
+		n, err := s.Write([]byte(line))
+		require.NoError(t, err, "Unexpected error writing to sink.")
+		assert.Equal(t, len(line), n, "Unexpected number of bytes written.")
+	}
+}
+
+func TestHTTPSinkBatches(t *testing.T) {
+	tests := []struct {
+		desc string
+		sink *HTTPSink
+		want []string
+	}{
+		{
+			desc: "by count",
+			sink: &HTTPSink{BatchEntries: 2},
+			want: []string{"{\"a\":1}\n{\"a\":2}\n", "{\"a\":3}\n{\"a\":4}\n", "{\"a\":5}\n"},
+		},
+		{
+			desc: "by bytes",
+			sink: &HTTPSink{BatchBytes: 20},
+			want: []string{"{\"a\":1}\n{\"a\":2}\n", "{\"a\":3}\n{\"a\":4}\n", "{\"a\":5}\n"},
+		},
+		{
+			desc: "compressed",
+			sink: &HTTPSink{Compress: true},
+			want: []string{"{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n{\"a\":4}\n{\"a\":5}\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := newCollector(t)
+			s := tt.sink
+			s.URL = c.URL + "/ingest"
+			s.Header = http.Header{"Authorization": {"Bearer token"}}
+			defer s.Close()
+
+			// Lines without a trailing newline get one.
+			writeLines(t, s, "{\"a\":1}\n", "{\"a\":2}", "{\"a\":3}\n", "{\"a\":4}\n", "{\"a\":5}\n")
+			require.NoError(t, s.Sync(), "Unexpected error syncing sink.")
+
+			assert.Equal(t, tt.want, c.Bodies(), "Unexpected request bodies.")
+			for _, r := range c.Requests() {
+				assert.Equal(t, http.MethodPost, r.Method, "Unexpected method.")
+				assert.Equal(t, "/ingest", r.URL.Path, "Unexpected path.")
+				assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"), "Unexpected content type.")
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"), "Expected extra headers to be sent.")
+			}
+			assert.Equal(t, HTTPSinkStats{
+				Batches: int64(len(tt.want)),
+				Entries: 5,
+				Bytes:   40,
+			}, s.Stats(), "Unexpected stats.")
+		})
+	}
+}
+
+func TestHTTPSinkFlushInterval(t *testing.T) {
+	c := newCollector(t)
+	clock := ztest.NewMockClock()
+	s := &HTTPSink{URL: c.URL, FlushInterval: time.Minute, Clock: clock}
+	defer s.Close()
+
+	writeLines(t, s, "{}\n")
+	assert.Empty(t, c.Bodies(), "Expected partial batch to wait for the flush interval.")
+
+	clock.Add(time.Minute)
+	select {
+	case <-c.received:
+	case <-time.After(time.Second):
+		t.Fatal("Expected partial batch to be sent after the flush interval.")
+	}
+	assert.Equal(t, []string{"{}\n"}, c.Bodies(), "Unexpected request bodies.")
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	c := newCollector(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	c.headers = []http.Header{{}, {"Retry-After": {"1"}}}
+	s := &HTTPSink{URL: c.URL, MinBackoff: time.Millisecond}
+	defer s.Close()
+
+	start := time.Now()
+	writeLines(t, s, "{}\n")
+	require.NoError(t, s.Sync(), "Expected batch to be delivered after retries.")
+
+	assert.True(t, time.Since(start) >= time.Second, "Expected Retry-After to be honored.")
+	assert.Len(t, c.Bodies(), 3, "Unexpected number of requests.")
+	assert.Equal(t, HTTPSinkStats{Batches: 1, Entries: 1, Bytes: 3, Retries: 2}, s.Stats(), "Unexpected stats.")
+}
+
+func TestHTTPSinkCapsRetryAfter(t *testing.T) {
+	c := newCollector(t, http.StatusServiceUnavailable, http.StatusOK)
+	c.headers = []http.Header{{"Retry-After": {"3600"}}}
+	clock := ztest.NewMockClock()
+	s := &HTTPSink{URL: c.URL, MaxBackoff: time.Minute, Clock: clock}
+	defer s.Close()
+
+	writeLines(t, s, "{}\n")
+	synced := make(chan error, 1)
+	go func() { synced <- s.Sync() }()
+	<-c.received
+
+	// Advancing the clock by MaxBackoff, rather than the hour the server
+	// asked for, releases the retry. The backoff may not have started yet,
+	// so advance a minute at a time, well short of the hour.
+	for elapsed := time.Duration(0); elapsed < 30*time.Minute; elapsed += time.Minute {
+		clock.Add(time.Minute)
+		select {
+		case err := <-synced:
+			require.NoError(t, err, "Expected batch to be delivered after a retry.")
+			assert.Len(t, c.Bodies(), 2, "Unexpected number of requests.")
+			return
+		case <-time.After(ztest.Timeout(10 * time.Millisecond)):
+		}
+	}
+	t.Fatal("Expected Retry-After to be capped at MaxBackoff.")
+}
+
+func TestHTTPSinkFailures(t *testing.T) {
+	tests := []struct {
+		desc     string
+		statuses []int
+		retries  int
+		want     string
+		requests int
+	}{
+		{
+			desc:     "not retryable",
+			statuses: []int{http.StatusBadRequest},
+			want:     "400 Bad Request",
+			requests: 1,
+		},
+		{
+			desc:     "retries exhausted",
+			statuses: []int{500, 500, 500},
+			retries:  2,
+			want:     "500 Internal Server Error",
+			requests: 3,
+		},
+		{
+			desc:     "retries disabled",
+			statuses: []int{502},
+			retries:  -1,
+			want:     "502 Bad Gateway",
+			requests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := newCollector(t, tt.statuses...)
+			s := &HTTPSink{URL: c.URL, MaxRetries: tt.retries, MinBackoff: time.Millisecond}
+			defer s.Close()
+
+			writeLines(t, s, "{}\n", "{}\n")
+			err := s.Sync()
+			require.Error(t, err, "Expected delivery to fail.")
+			assert.Contains(t, err.Error(), tt.want, "Unexpected error.")
+			assert.NoError(t, s.Sync(), "Expected error to be reported only once.")
+
+			assert.Len(t, c.Bodies(), tt.requests, "Unexpected number of requests.")
+			assert.Equal(t, HTTPSinkStats{
+				Retries:       int64(tt.requests - 1),
+				FailedBatches: 1,
+				FailedEntries: 2,
+			}, s.Stats(), "Unexpected stats.")
+		})
+	}
+}
+
+func TestHTTPSinkRedactsURL(t *testing.T) {
+	c := newCollector(t, http.StatusBadRequest)
+	u, err := url.Parse(c.URL)
+	require.NoError(t, err, "Unexpected error parsing collector URL.")
+	u.User = url.UserPassword("user", "secret-password")
+	u.Path = "/ingest"
+	u.RawQuery = "token=secret-token"
+
+	s := &HTTPSink{URL: u.String(), MaxRetries: -1}
+	defer s.Close()
+	writeLines(t, s, "{}\n")
+	err = s.Sync()
+	require.Error(t, err, "Expected delivery to fail.")
+	assert.Contains(t, err.Error(), "/ingest: 400 Bad Request", "Unexpected error.")
+	assert.NotContains(t, err.Error(), "secret", "Expected credentials to be redacted.")
+
+	// Client errors, like failing to connect, also name the URL.
+	c.Close()
+	writeLines(t, s, "{}\n")
+	err = s.Sync()
+	require.Error(t, err, "Expected delivery to a closed server to fail.")
+	assert.Contains(t, err.Error(), "/ingest", "Expected the error to name the endpoint.")
+	assert.NotContains(t, err.Error(), "secret", "Expected credentials to be redacted.")
+}
+
+func TestHTTPSinkCloseStopsRetries(t *testing.T) {
+	c := newCollector(t, 500, 500)
+	s := &HTTPSink{URL: c.URL, MinBackoff: time.Hour}
+
+	writeLines(t, s, "{}\n")
+	s.sender.Flush()
+	// Wait for the first request to fail, so that the sink is backing off.
+	for len(c.Bodies()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Close() }()
+	select {
+	case err := <-done:
+		require.Error(t, err, "Expected Close to report the failed delivery.")
+		assert.Contains(t, err.Error(), "500 Internal Server Error", "Unexpected error.")
+	case <-time.After(ztest.Timeout(time.Second)):
+		t.Fatal("Expected Close to interrupt the retry backoff.")
+	}
+	assert.Len(t, c.Bodies(), 1, "Expected no retries after Close.")
+}
+
+func TestHTTPSinkDropsWhenBacklogged(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer srv.Close()
+
+	s := &HTTPSink{URL: srv.URL, BatchEntries: 1, MaxPending: 1}
+	writeLines(t, s, "{}\n")
+	<-arrived // first batch is in flight
+
+	writeLines(t, s, "{}\n", "{}\n")
+	close(release)
+	require.NoError(t, s.Close(), "Unexpected error closing sink.")
+
+	assert.Equal(t, HTTPSinkStats{
+		Batches:        2,
+		Entries:        2,
+		Bytes:          6,
+		DroppedEntries: 1,
+	}, s.Stats(), "Unexpected stats.")
+}
+
+func TestHTTPSinkClose(t *testing.T) {
+	c := newCollector(t)
+	s := &HTTPSink{URL: c.URL}
+
+	writeLines(t, s, "{}\n")
+	require.NoError(t, s.Close(), "Unexpected error closing sink.")
+	assert.Equal(t, []string{"{}\n"}, c.Bodies(), "Expected Close to deliver remaining messages.")
+
+	_, err := s.Write([]byte("{}\n"))
+	assert.Equal(t, errHTTPSinkClosed, err, "Expected writes after Close to fail.")
+	assert.NoError(t, s.Close(), "Expected closing twice to succeed.")
+	assert.NoError(t, (&HTTPSink{}).Close(), "Expected closing an unused sink to succeed.")
+}
+
+func TestOpenHTTPSink(t *testing.T) {
+	c := newCollector(t)
+	u := c.URL + "/ingest?token=abc&compress=gzip&batchEntries=2&flushInterval=1m"
+
+	ws, close, err := Open(u)
+	require.NoError(t, err, "Failed to open HTTP sink.")
+	defer close()
+
+	_, err = ws.Write([]byte("{}\n"))
+	require.NoError(t, err, "Unexpected error writing to sink.")
+	_, err = ws.Write([]byte("{}\n"))
+	require.NoError(t, err, "Unexpected error writing to sink.")
+	select {
+	case <-c.received:
+	case <-time.After(time.Second):
+		t.Fatal("Expected full batch to be sent.")
+	}
+
+	reqs := c.Requests()
+	require.Len(t, reqs, 1, "Unexpected number of requests.")
+	assert.Equal(t, "token=abc", reqs[0].URL.RawQuery, "Expected sink parameters to be removed from the URL.")
+	assert.Equal(t, "gzip", reqs[0].Header.Get("Content-Encoding"), "Expected compressed request.")
+	assert.Equal(t, []string{"{}\n{}\n"}, c.Bodies(), "Unexpected request bodies.")
+}
+
+func TestNewHTTPSink(t *testing.T) {
+	tests := []struct {
+		url  string
+		want *HTTPSink
+	}{
+		{
+			url:  "https://collector/ingest?a=1&b=2",
+			want: &HTTPSink{URL: "https://collector/ingest?a=1&b=2"},
+		},
+		{
+			url: "http://collector:8080/?batchBytes=64KB&maxRetries=-1&minBackoff=1s&maxBackoff=1m&maxPending=4&compress=none",
+			want: &HTTPSink{
+				URL:        "http://collector:8080/",
+				BatchBytes: 64 * 1024,
+				MaxRetries: -1,
+				MinBackoff: time.Second,
+				MaxBackoff: time.Minute,
+				MaxPending: 4,
+			},
+		},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		require.NoError(t, err, "Failed to parse URL.")
+		sink, err := newHTTPSink(u)
+		require.NoError(t, err, "Unexpected error building sink from %q.", tt.url)
+		assert.Equal(t, tt.want, sink, "Unexpected sink built from %q.", tt.url)
+	}
+}
+
+func TestNewHTTPSinkErrors(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http:///ingest", "http URLs must include a host"},
+		{"https://collector/#frag", "fragments not allowed"},
+		{"http://collector/?batchEntries=0", `invalid batchEntries "0"`},
+		{"http://collector/?batchBytes=lots", `invalid batchBytes "lots"`},
+		{"http://collector/?flushInterval=-1s", `invalid flushInterval "-1s"`},
+		{"http://collector/?compress=zstd", `invalid compress "zstd"`},
+		{"http://collector/?maxRetries=many", `invalid maxRetries "many"`},
+		{"http://collector/?maxPending=0", `invalid maxPending "0"`},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		require.NoError(t, err, "Failed to parse URL.")
+		_, err = newHTTPSink(u)
+		require.Error(t, err, "Expected error building sink from %q.", tt.url)
+		assert.Contains(t, err.Error(), tt.want, "Unexpected error building sink from %q.", tt.url)
+	}
+}
@@ -0,0 +1,467 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package httpbatch implements the batching HTTP client shared by zap's
+// HTTPSink and zapotlp's Exporter. Entries are collected into batches, which
+// a background goroutine POSTs, retrying failed requests with exponential
+// backoff.
+package httpbatch // import "go.uber.org/zap/internal/httpbatch"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrClosed is returned when adding entries to a closed Sender.
+var ErrClosed = errors.New("sender is closed")
+
+// Config configures a Sender. Unlike the options of the types built on it,
+// it has no defaults: every field that bounds the Sender must be set.
+type Config struct {
+	// URL is the endpoint to which batches are POSTed.
+	URL string
+
+	// Client sends the requests.
+	Client *http.Client
+
+	// Header holds additional headers to send with each request.
+	Header http.Header
+
+	// ContentType is the Content-Type of the requests.
+	ContentType string
+
+	// Compress enables gzip compression of request bodies.
+	Compress bool
+
+	// Prefix and Suffix surround the entries of a batch in the request
+	// body, and Separator is written between them. Unless it's zero,
+	// Terminator is appended to every entry that doesn't already end with
+	// it.
+	Prefix     []byte
+	Suffix     []byte
+	Separator  []byte
+	Terminator byte
+
+	// BatchEntries bounds the number of entries in each batch, and
+	// BatchBytes, if positive, bounds the size of their encoding.
+	BatchEntries int
+	BatchBytes   int
+
+	// FlushInterval is the longest an entry waits for its batch to fill
+	// before the batch is sent anyway.
+	FlushInterval time.Duration
+
+	// MaxRetries is the number of times a failed request is repeated before
+	// the batch is abandoned. Zero or negative values disable retries.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the delay between retries. A delay
+	// asked for in a Retry-After header is also capped at MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxPending is the number of full batches that may await delivery.
+	// Once that many are waiting, further batches are dropped.
+	MaxPending int
+
+	// Retryable reports whether a request that failed with the given status
+	// should be retried. Requests that fail with a network error are always
+	// retried.
+	Retryable func(status int) bool
+
+	// Clock is the source of time for the flush interval, the delay between
+	// retries, and Retry-After dates.
+	Clock zapcore.Clock
+}
+
+// Stats reports the activity of a Sender.
+type Stats struct {
+	// Batches and Entries count the batches and entries accepted by the
+	// server.
+	Batches int64
+	Entries int64
+	// Bytes is the size of the entries of the accepted batches, before
+	// compression.
+	Bytes int64
+	// Retries is the number of requests repeated after a failure.
+	Retries int64
+	// FailedBatches and FailedEntries count the batches and entries that
+	// were abandoned after exhausting their retries or being rejected by the
+	// server.
+	FailedBatches int64
+	FailedEntries int64
+	// DroppedEntries is the number of entries discarded because too many
+	// batches were already awaiting delivery.
+	DroppedEntries int64
+}
+
+// A Sender collects entries into batches and POSTs each batch to an HTTP
+// endpoint. A batch is sent once it's full or once the flush interval has
+// passed, whichever comes first. Requests that fail with a network error or
+// a retryable status are retried with exponential backoff, waiting as long
+// as the server asks in a Retry-After header, up to the maximum backoff.
+// Other responses outside the 2xx range abandon the batch.
+//
+// Errors encountered during delivery are reported by the next call to Sync
+// or Close. They name the endpoint without its user info and query string,
+// which may hold credentials.
+//
+// A Sender is safe for concurrent use.
+type Sender struct {
+	cfg Config
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signaled when the queue or closed changes
+	closed   bool
+	batch    batch   // batch being filled
+	queue    []batch // batches awaiting delivery
+	queued   int64   // batches ever added to queue
+	finished int64   // batches ever taken from queue and processed
+	err      error   // first delivery error since the last Sync
+	stats    Stats
+	ticker   *time.Ticker
+	stop     chan struct{} // closed when flushLoop and retries should stop
+	done     chan struct{} // closed when sendLoop has stopped
+}
+
+type batch struct {
+	body    []byte
+	entries int
+}
+
+// New creates a Sender and starts the goroutines that deliver its batches.
+func New(cfg Config) *Sender {
+	s := &Sender{
+		cfg:    cfg,
+		ticker: cfg.Clock.NewTicker(cfg.FlushInterval),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.flushLoop()
+	go s.sendLoop()
+	return s
+}
+
+// Add adds an entry to the current batch, sending the batch if it's full.
+func (s *Sender) Add(entry []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	terminate := s.cfg.Terminator != 0 && (len(entry) == 0 || entry[len(entry)-1] != s.cfg.Terminator)
+	size := len(s.cfg.Separator) + len(entry)
+	if terminate {
+		size++
+	}
+	if s.batch.entries > 0 && s.cfg.BatchBytes > 0 && len(s.batch.body)+size > s.cfg.BatchBytes {
+		s.flush()
+	}
+
+	if s.batch.entries > 0 {
+		s.batch.body = append(s.batch.body, s.cfg.Separator...)
+	}
+	s.batch.body = append(s.batch.body, entry...)
+	if terminate {
+		s.batch.body = append(s.batch.body, s.cfg.Terminator)
+	}
+	s.batch.entries++
+	if s.batch.entries >= s.cfg.BatchEntries || (s.cfg.BatchBytes > 0 && len(s.batch.body) >= s.cfg.BatchBytes) {
+		s.flush()
+	}
+	return nil
+}
+
+// Flush queues the current batch for delivery without waiting for it.
+func (s *Sender) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
+
+// Sync sends the current batch and waits until every batch added so far has
+// been delivered or abandoned. It returns the first delivery error
+// encountered since the previous call to Sync.
+func (s *Sender) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush()
+	for target := s.queued; s.finished < target; {
+		s.cond.Wait()
+	}
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Close delivers any remaining entries and stops the background goroutines.
+// Once it's called, failed requests are no longer retried, so that Close
+// doesn't wait out the backoff. It returns the first delivery error
+// encountered since the previous call to Sync. Entries added after Close
+// are rejected with ErrClosed.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.flush()
+	s.closed = true
+	s.ticker.Stop()
+	close(s.stop)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Stats reports the delivery statistics of the Sender so far.
+func (s *Sender) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// flush moves the current batch to the delivery queue, dropping it if the
+// queue is full. s.mu must be held.
+func (s *Sender) flush() {
+	if s.batch.entries == 0 {
+		return
+	}
+	if len(s.queue) >= s.cfg.MaxPending {
+		s.stats.DroppedEntries += int64(s.batch.entries)
+	} else {
+		s.queue = append(s.queue, s.batch)
+		s.queued++
+		s.cond.Broadcast()
+	}
+	s.batch = batch{}
+}
+
+// flushLoop sends partially-filled batches at the flush interval until Close
+// is called.
+func (s *Sender) flushLoop() {
+	for {
+		select {
+		case <-s.ticker.C:
+			s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sendLoop delivers queued batches until Close is called and the queue is
+// empty.
+func (s *Sender) sendLoop() {
+	defer close(s.done)
+
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		b := s.queue[0]
+		s.queue[0] = batch{}
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		retries, err := s.deliver(b.body)
+
+		s.mu.Lock()
+		s.stats.Retries += int64(retries)
+		if err != nil {
+			s.stats.FailedBatches++
+			s.stats.FailedEntries += int64(b.entries)
+			if s.err == nil {
+				s.err = err
+			}
+		} else {
+			s.stats.Batches++
+			s.stats.Entries += int64(b.entries)
+			s.stats.Bytes += int64(len(b.body))
+		}
+		s.finished++
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// deliver POSTs a batch, retrying as configured. It returns the number of
+// retries and the final error, if any.
+func (s *Sender) deliver(entries []byte) (int, error) {
+	body := entries
+	if len(s.cfg.Prefix) > 0 || len(s.cfg.Suffix) > 0 {
+		body = make([]byte, 0, len(s.cfg.Prefix)+len(entries)+len(s.cfg.Suffix))
+		body = append(body, s.cfg.Prefix...)
+		body = append(body, entries...)
+		body = append(body, s.cfg.Suffix...)
+	}
+	if s.cfg.Compress {
+		var err error
+		if body, err = gzipBytes(body); err != nil {
+			return 0, err
+		}
+	}
+
+	backoff := s.cfg.MinBackoff
+	for retries := 0; ; retries++ {
+		retryAfter, err := s.post(body)
+		if err == nil || retryAfter < 0 || retries >= s.cfg.MaxRetries {
+			return retries, err
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > s.cfg.MaxBackoff {
+			// Don't let the server hold up Sync for longer than configured.
+			wait = s.cfg.MaxBackoff
+		}
+		ticker := s.cfg.Clock.NewTicker(wait)
+		select {
+		case <-ticker.C:
+			ticker.Stop()
+		case <-s.stop:
+			// Close was called, so give up rather than delay it.
+			ticker.Stop()
+			return retries, err
+		}
+
+		if backoff *= 2; backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+	}
+}
+
+// post sends a single request. If it fails, post also reports how long to
+// wait before retrying, or a negative duration if the request shouldn't be
+// retried.
+func (s *Sender) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, redactURLError(err)
+	}
+	for k, vs := range s.cfg.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", s.cfg.ContentType)
+	if s.cfg.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if code := resp.StatusCode; code >= 200 && code < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("POST %s: %s", redactURL(s.cfg.URL), resp.Status)
+	if !s.cfg.Retryable(resp.StatusCode) {
+		return -1, err
+	}
+	return retryAfter(resp.Header.Get("Retry-After"), s.cfg.Clock.Now()), err
+}
+
+// redactURL removes the user info, query string and fragment from a URL,
+// since they may hold credentials.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// redactURLError redacts the URL of the *url.Error returned by the HTTP
+// client, which otherwise includes it in full.
+func redactURLError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		uerr.URL = redactURL(uerr.URL)
+	}
+	return err
+}
+
+// retryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date. It returns zero if the header is absent
+// or invalid.
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,495 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	schemeTCP      = "tcp"
+	schemeUDP      = "udp"
+	schemeUnix     = "unix"
+	schemeUnixgram = "unixgram"
+	schemeSyslog   = "syslog"
+)
+
+// _defaultSyslogPort is the port used by syslog URLs that don't specify one.
+const _defaultSyslogPort = "514"
+
+const (
+	_defaultNetDialTimeout  = 5 * time.Second
+	_defaultNetWriteTimeout = 5 * time.Second
+	_defaultNetMinBackoff   = 100 * time.Millisecond
+	_defaultNetMaxBackoff   = 30 * time.Second
+)
+
+// Framing determines how a NetSink delimits messages on the wire.
+type Framing uint8
+
+const (
+	// NewlineFraming terminates each message with a newline, adding one if
+	// the message doesn't already end with one.
+	NewlineFraming Framing = iota
+	// OctetCountingFraming prefixes each message with its length in bytes
+	// and a space, as described in RFC 6587. Any trailing newline is
+	// removed from the message.
+	OctetCountingFraming
+	// NoFraming sends each message as is, less any trailing newline. It's
+	// only suitable for datagram networks, where each message is delivered
+	// separately.
+	NoFraming
+)
+
+// String returns a lower-case ASCII representation of the framing.
+func (f Framing) String() string {
+	switch f {
+	case NewlineFraming:
+		return "newline"
+	case OctetCountingFraming:
+		return "octet-counting"
+	case NoFraming:
+		return "none"
+	default:
+		return fmt.Sprintf("Framing(%d)", f)
+	}
+}
+
+// appendFrame appends msg to dst, framed as specified.
+func (f Framing) appendFrame(dst, msg []byte) []byte {
+	switch f {
+	case OctetCountingFraming:
+		if n := len(msg); n > 0 && msg[n-1] == '\n' {
+			msg = msg[:n-1]
+		}
+		dst = strconv.AppendInt(dst, int64(len(msg)), 10)
+		dst = append(dst, ' ')
+		return append(dst, msg...)
+	case NoFraming:
+		if n := len(msg); n > 0 && msg[n-1] == '\n' {
+			msg = msg[:n-1]
+		}
+		return append(dst, msg...)
+	default:
+		dst = append(dst, msg...)
+		if n := len(msg); n == 0 || msg[n-1] != '\n' {
+			dst = append(dst, '\n')
+		}
+		return dst
+	}
+}
+
+// A NetSink is a Sink that writes each message to a network connection.
+//
+// NetSink dials lazily, on the first write. If the connection can't be
+// established or breaks, it waits before dialing again, doubling the delay
+// after each consecutive failure. While disconnected, messages are either
+// held in a bounded in-memory spool and delivered once the connection is
+// re-established, or rejected with an error if spooling is disabled.
+//
+// A message is never resent after part of it reached the connection, which
+// would corrupt the framing. If a write times out partway through a message,
+// the rest of it is written before anything else on the same connection. If
+// a write fails otherwise, the connection is closed, so that the receiver
+// sees the partial message end with the connection.
+//
+// NetSink is safe for concurrent use.
+type NetSink struct {
+	// Network is the kind of connection to dial: "tcp", "udp", "unix"
+	// (stream), or "unixgram" (datagram). See net.Dial for details.
+	//
+	// This field is required.
+	Network string
+
+	// Address is the address to dial. See net.Dial for details.
+	//
+	// This field is required.
+	Address string
+
+	// Framing determines how messages are delimited.
+	//
+	// Defaults to NewlineFraming.
+	Framing Framing
+
+	// DialTimeout bounds the time spent establishing a connection.
+	//
+	// Defaults to 5 seconds if unspecified.
+	DialTimeout time.Duration
+
+	// WriteTimeout bounds the time spent writing a single message.
+	//
+	// Defaults to 5 seconds if unspecified.
+	WriteTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay between attempts to dial.
+	//
+	// Default to 100 milliseconds and 30 seconds if unspecified.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// SpoolSize is the maximum number of bytes of messages to hold while
+	// disconnected. Once the spool is full, the oldest messages are
+	// discarded to make room.
+	//
+	// Defaults to zero, which disables spooling.
+	SpoolSize int
+
+	// Clock, if specified, provides control of the source of time for the
+	// reconnection backoff.
+	//
+	// Defaults to the system clock.
+	Clock zapcore.Clock
+
+	mu        sync.Mutex
+	conn      net.Conn
+	backoff   time.Duration // delay before the next dial after a failure
+	nextDial  time.Time     // no dials are attempted before this time
+	lastErr   error         // most recent dial or write error
+	frame     []byte        // scratch space for framing
+	partial   []byte        // unwritten tail of a frame that timed out partway through
+	spool     [][]byte      // framed messages awaiting delivery
+	spoolSize int           // total bytes in spool
+	dropped   int           // messages discarded from a full spool
+}
+
+var _ Sink = (*NetSink)(nil)
+
+// Write frames the supplied message and writes it to the connection,
+// dialing first if necessary.
+func (s *NetSink) Write(bs []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frame = s.Framing.appendFrame(s.frame[:0], bs)
+	err := s.connect()
+	if err == nil {
+		if err = s.writeFrame(s.frame); err == nil || len(s.partial) > 0 {
+			// Part of the message was written, so the rest will follow on
+			// the next write or Sync.
+			return len(bs), nil
+		}
+	}
+
+	if s.SpoolSize <= 0 {
+		return 0, err
+	}
+	s.enqueue(s.frame)
+	return len(bs), nil
+}
+
+// Sync attempts to deliver any spooled or partially written messages. It
+// returns an error if the connection couldn't be established to do so.
+func (s *NetSink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.spool) == 0 && len(s.partial) == 0 {
+		return nil
+	}
+	return s.connect()
+}
+
+// Close closes the connection, if any. Spooled messages are discarded.
+// Writing to a closed NetSink dials again.
+func (s *NetSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spool = nil
+	s.spoolSize = 0
+	s.partial = nil
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Dropped reports the number of messages discarded because the spool was
+// full.
+func (s *NetSink) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// connect ensures that there's a connection and that any partially written
+// and spooled messages have been delivered on it. s.mu must be held.
+func (s *NetSink) connect() error {
+	if s.conn == nil {
+		now := s.now()
+		if now.Before(s.nextDial) {
+			return fmt.Errorf("waiting to reconnect to %s %s: %v", s.Network, s.Address, s.lastErr)
+		}
+
+		conn, err := net.DialTimeout(s.Network, s.Address, durationOr(s.DialTimeout, _defaultNetDialTimeout))
+		if err != nil {
+			s.fail(now, err)
+			return err
+		}
+		s.conn = conn
+		s.backoff = 0
+	}
+
+	if len(s.partial) > 0 {
+		tail := s.partial
+		s.partial = nil
+		if err := s.writeFrame(tail); err != nil {
+			return err
+		}
+	}
+
+	for len(s.spool) > 0 {
+		err := s.writeFrame(s.spool[0])
+		if err == nil || len(s.partial) > 0 {
+			// The message was written, at least in part, so it mustn't be
+			// sent again.
+			s.spoolSize -= len(s.spool[0])
+			s.spool[0] = nil
+			s.spool = s.spool[1:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes a framed message to the connection. If the write times
+// out after writing part of the frame, the rest is kept in s.partial and the
+// connection is kept for it. Otherwise, the connection is dropped if the
+// write fails. s.mu must be held.
+func (s *NetSink) writeFrame(frame []byte) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(durationOr(s.WriteTimeout, _defaultNetWriteTimeout))); err != nil {
+		s.disconnect(err)
+		return err
+	}
+	n, err := s.conn.Write(frame)
+	if err == nil {
+		return nil
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && n > 0 {
+		s.partial = append([]byte(nil), frame[n:]...)
+		s.lastErr = err
+		return err
+	}
+	s.disconnect(err)
+	return err
+}
+
+// disconnect drops a broken connection, along with the rest of any partially
+// written frame. The next write re-dials immediately; backoff only applies
+// once dialing fails. s.mu must be held.
+func (s *NetSink) disconnect(err error) {
+	s.conn.Close()
+	s.conn = nil
+	s.partial = nil
+	s.lastErr = err
+}
+
+// fail records a failure to dial and schedules the next attempt. s.mu must be
+// held.
+func (s *NetSink) fail(now time.Time, err error) {
+	minBackoff := durationOr(s.MinBackoff, _defaultNetMinBackoff)
+	maxBackoff := durationOr(s.MaxBackoff, _defaultNetMaxBackoff)
+
+	if s.backoff == 0 {
+		s.backoff = minBackoff
+	} else {
+		s.backoff *= 2
+	}
+	if s.backoff > maxBackoff {
+		s.backoff = maxBackoff
+	}
+	s.nextDial = now.Add(s.backoff)
+	s.lastErr = err
+}
+
+// enqueue adds a copy of a framed message to the spool, discarding the oldest
+// messages if necessary. s.mu must be held.
+func (s *NetSink) enqueue(frame []byte) {
+	if len(frame) > s.SpoolSize {
+		s.dropped++
+		return
+	}
+	for s.spoolSize+len(frame) > s.SpoolSize {
+		s.spoolSize -= len(s.spool[0])
+		s.spool[0] = nil
+		s.spool = s.spool[1:]
+		s.dropped++
+	}
+	s.spool = append(s.spool, append([]byte(nil), frame...))
+	s.spoolSize += len(frame)
+}
+
+func (s *NetSink) now() time.Time {
+	if s.Clock == nil {
+		return zapcore.DefaultClock.Now()
+	}
+	return s.Clock.Now()
+}
+
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// newNetSink builds a NetSink from a URL like
+//
+//   tcp://collector:5170?framing=octet-counting&spool=1MB
+//   unix:///run/collector.sock
+//
+// The scheme determines the network; the host and port (for "tcp" and "udp")
+// or path (for "unix" and "unixgram") determine the address.
+func newNetSink(u *url.URL) (Sink, error) {
+	if u.User != nil {
+		return nil, fmt.Errorf("user and password not allowed with %s URLs: got %v", u.Scheme, u)
+	}
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with %s URLs: got %v", u.Scheme, u)
+	}
+
+	s := &NetSink{Network: u.Scheme}
+	switch u.Scheme {
+	case schemeUnix, schemeUnixgram:
+		if u.Host != "" {
+			return nil, fmt.Errorf("%s URLs must leave host empty: got %v", u.Scheme, u)
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("%s URLs must include a socket path: got %v", u.Scheme, u)
+		}
+		s.Address = u.Path
+	default:
+		if u.Hostname() == "" || u.Port() == "" {
+			return nil, fmt.Errorf("%s URLs must include a host and port: got %v", u.Scheme, u)
+		}
+		if u.Path != "" && u.Path != "/" {
+			return nil, fmt.Errorf("paths not allowed with %s URLs: got %v", u.Scheme, u)
+		}
+		s.Address = u.Host
+	}
+
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "framing":
+			switch val {
+			case "newline":
+				s.Framing = NewlineFraming
+			case "octet-counting":
+				s.Framing = OctetCountingFraming
+			case "none":
+				s.Framing = NoFraming
+			default:
+				err = errors.New(`must be "newline", "octet-counting", or "none"`)
+			}
+		case "dialTimeout":
+			s.DialTimeout, err = parsePositiveDuration(val)
+		case "writeTimeout":
+			s.WriteTimeout, err = parsePositiveDuration(val)
+		case "minBackoff":
+			s.MinBackoff, err = parsePositiveDuration(val)
+		case "maxBackoff":
+			s.MaxBackoff, err = parsePositiveDuration(val)
+		case "spool":
+			var size int64
+			size, err = parseByteSize(val)
+			s.SpoolSize = int(size)
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in %s URL: got %v", key, u.Scheme, u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in %s URL: %v", key, val, u.Scheme, err)
+		}
+	}
+	return s, nil
+}
+
+// newSyslogSink builds a NetSink for delivering messages to a syslog daemon
+// from a URL like
+//
+//   syslog:///dev/log
+//   syslog://collector?network=tcp
+//
+// URLs with a path dial a Unix datagram socket, and URLs with a host dial UDP
+// port 514, unless the network and port are specified. Messages are framed
+// with octet counting on stream networks, as described in RFC 6587, and
+// unframed on datagram networks. The NetSink query parameters are also
+// accepted.
+func newSyslogSink(u *url.URL) (Sink, error) {
+	q := u.Query()
+	network := q.Get("network")
+	q.Del("network")
+	if network == "" {
+		network = schemeUDP
+		if u.Host == "" {
+			network = schemeUnixgram
+		}
+	}
+
+	nu := *u
+	nu.Scheme = network
+	nu.RawQuery = q.Encode()
+	switch network {
+	case schemeTCP, schemeUDP:
+		if nu.Hostname() != "" && nu.Port() == "" {
+			nu.Host = net.JoinHostPort(nu.Hostname(), _defaultSyslogPort)
+		}
+	case schemeUnix, schemeUnixgram:
+	default:
+		return nil, fmt.Errorf(`invalid network %q in syslog URL: must be "tcp", "udp", "unix", or "unixgram"`, network)
+	}
+
+	sink, err := newNetSink(&nu)
+	if err != nil {
+		return nil, err
+	}
+	s := sink.(*NetSink)
+	if _, ok := q["framing"]; !ok {
+		switch network {
+		case schemeTCP, schemeUnix:
+			s.Framing = OctetCountingFraming
+		default:
+			s.Framing = NoFraming
+		}
+	}
+	return s, nil
+}
+
+func parsePositiveDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	return d, err
+}
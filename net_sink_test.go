@@ -0,0 +1,390 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+)
+
+// acceptOne accepts a single connection on the listener, sending everything
+// read from it to the returned channel once the peer closes it.
+func acceptOne(t testing.TB, ln net.Listener) <-chan string {
+	received := make(chan string, 1)
+	go func() {
+		defer close(received)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bs, err := ioutil.ReadAll(conn)
+		assert.NoError(t, err, "Failed to read from connection.")
+		received <- string(bs)
+	}()
+	return received
+}
+
+func readPacket(t testing.TB, conn net.PacketConn) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err, "Failed to read packet.")
+	return string(buf[:n])
+}
+
+func TestNetSinkStream(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen on TCP.")
+	defer tcp.Close()
+
+	sock := filepath.Join(t.TempDir(), "sink.sock")
+	unix, err := net.Listen("unix", sock)
+	require.NoError(t, err, "Failed to listen on Unix socket.")
+	defer unix.Close()
+
+	tests := []struct {
+		url  string
+		ln   net.Listener
+		want string
+	}{
+		{"tcp://" + tcp.Addr().String(), tcp, "foo\nbar\n"},
+		{"tcp://" + tcp.Addr().String() + "?framing=octet-counting", tcp, "3 foo3 bar"},
+		{"unix://" + sock, unix, "foo\nbar\n"},
+		{"unix://" + sock + "?framing=octet-counting&writeTimeout=1s", unix, "3 foo3 bar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			received := acceptOne(t, tt.ln)
+
+			ws, close, err := Open(tt.url)
+			require.NoError(t, err, "Unexpected error opening %q.", tt.url)
+
+			for _, msg := range []string{"foo\n", "bar"} {
+				_, err := ws.Write([]byte(msg))
+				require.NoError(t, err, "Unexpected error writing.")
+			}
+			require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+			close()
+
+			assert.Equal(t, tt.want, <-received, "Unexpected data received.")
+		})
+	}
+}
+
+func TestNetSinkDatagram(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen on UDP.")
+	defer udp.Close()
+
+	sock := filepath.Join(t.TempDir(), "sink.sock")
+	unixgram, err := net.ListenPacket("unixgram", sock)
+	require.NoError(t, err, "Failed to listen on Unix datagram socket.")
+	defer unixgram.Close()
+
+	tests := []struct {
+		url  string
+		conn net.PacketConn
+	}{
+		{"udp://" + udp.LocalAddr().String(), udp},
+		{"unixgram://" + sock, unixgram},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			ws, close, err := Open(tt.url)
+			require.NoError(t, err, "Unexpected error opening %q.", tt.url)
+			defer close()
+
+			for _, msg := range []string{"foo\n", "bar"} {
+				_, err := ws.Write([]byte(msg))
+				require.NoError(t, err, "Unexpected error writing.")
+			}
+			assert.Equal(t, "foo\n", readPacket(t, tt.conn), "Unexpected first datagram.")
+			assert.Equal(t, "bar\n", readPacket(t, tt.conn), "Unexpected second datagram.")
+		})
+	}
+}
+
+func TestNetSinkBackoff(t *testing.T) {
+	clock := ztest.NewMockClock()
+	sock := filepath.Join(t.TempDir(), "missing.sock")
+	s := &NetSink{
+		Network:    "unix",
+		Address:    sock,
+		MinBackoff: time.Second,
+		MaxBackoff: 3 * time.Second,
+		Clock:      clock,
+	}
+	defer s.Close()
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		_, err := s.Write([]byte("foo"))
+		require.Error(t, err, "Expected dial to fail.")
+		assert.Equal(t, want, s.backoff, "Unexpected backoff.")
+
+		// Before the backoff expires, we don't even try to dial.
+		clock.Add(want - time.Millisecond)
+		_, err = s.Write([]byte("foo"))
+		require.Error(t, err, "Expected write to fail while waiting to reconnect.")
+		assert.Contains(t, err.Error(), "waiting to reconnect", "Unexpected error.")
+		clock.Add(time.Millisecond)
+	}
+
+	// Once the address is reachable, the next attempt succeeds and resets
+	// the backoff.
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err, "Failed to listen on Unix socket.")
+	defer ln.Close()
+	received := acceptOne(t, ln)
+
+	_, err = s.Write([]byte("foo"))
+	require.NoError(t, err, "Unexpected error writing once reachable.")
+	assert.Zero(t, s.backoff, "Expected backoff to reset after a successful dial.")
+	require.NoError(t, s.Close(), "Unexpected error closing.")
+	assert.Equal(t, "foo\n", <-received, "Unexpected data received.")
+}
+
+func TestNetSinkSpool(t *testing.T) {
+	clock := ztest.NewMockClock()
+	sock := filepath.Join(t.TempDir(), "missing.sock")
+	s := &NetSink{
+		Network:   "unix",
+		Address:   sock,
+		SpoolSize: 8, // room for two framed messages
+		Clock:     clock,
+	}
+	defer s.Close()
+
+	for _, msg := range []string{"aa", "bbb", "ccc", "toolongtospool"} {
+		n, err := s.Write([]byte(msg))
+		require.NoError(t, err, "Expected writes to be spooled while disconnected.")
+		assert.Equal(t, len(msg), n, "Unexpected number of bytes written.")
+	}
+	assert.Equal(t, 2, s.Dropped(), "Expected the oldest and oversized messages to be dropped.")
+	assert.Error(t, s.Sync(), "Expected Sync to fail while disconnected.")
+
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err, "Failed to listen on Unix socket.")
+	defer ln.Close()
+	received := acceptOne(t, ln)
+
+	clock.Add(time.Minute)
+	require.NoError(t, s.Sync(), "Expected Sync to deliver spooled messages.")
+	_, err = s.Write([]byte("ddd"))
+	require.NoError(t, err, "Unexpected error writing.")
+	require.NoError(t, s.Close(), "Unexpected error closing.")
+	assert.Equal(t, "bbb\nccc\nddd\n", <-received, "Unexpected data received.")
+}
+
+// shortConn is a net.Conn that accepts at most limit bytes per write,
+// failing any write it cuts short with err.
+type shortConn struct {
+	net.Conn
+
+	limit   int
+	err     error
+	written []byte
+	closed  bool
+}
+
+func (c *shortConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *shortConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *shortConn) Write(bs []byte) (int, error) {
+	if len(bs) <= c.limit {
+		c.written = append(c.written, bs...)
+		return len(bs), nil
+	}
+	c.written = append(c.written, bs[:c.limit]...)
+	return c.limit, c.err
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNetSinkPartialWrite(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		conn := &shortConn{limit: 3, err: timeoutError{}}
+		s := &NetSink{Network: "tcp", Address: "localhost:0", SpoolSize: 1024}
+		s.conn = conn
+
+		n, err := s.Write([]byte("hello"))
+		require.NoError(t, err, "Expected the rest of a partial write to be kept.")
+		assert.Equal(t, 5, n, "Unexpected number of bytes written.")
+		assert.Equal(t, "hel", string(conn.written), "Unexpected data written.")
+		assert.False(t, conn.closed, "Expected the connection to be kept.")
+		assert.Empty(t, s.spool, "Expected partially written message not to be spooled.")
+
+		conn.limit = 1024
+		_, err = s.Write([]byte("world"))
+		require.NoError(t, err, "Unexpected error writing.")
+		assert.Equal(t, "hello\nworld\n", string(conn.written), "Expected each message to be written once.")
+	})
+
+	t.Run("broken connection", func(t *testing.T) {
+		conn := &shortConn{limit: 3, err: errors.New("broken pipe")}
+		s := &NetSink{
+			Network:   "unix",
+			Address:   filepath.Join(t.TempDir(), "missing.sock"),
+			SpoolSize: 1024,
+			Clock:     ztest.NewMockClock(),
+		}
+		s.conn = conn
+
+		_, err := s.Write([]byte("hello"))
+		require.NoError(t, err, "Expected the message to be spooled.")
+		assert.True(t, conn.closed, "Expected the connection to be closed after a partial write.")
+		assert.Nil(t, s.partial, "Expected nothing to be resumed on a new connection.")
+	})
+}
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		framing Framing
+		give    string
+		want    string
+	}{
+		{NewlineFraming, "", "\n"},
+		{NewlineFraming, "foo", "foo\n"},
+		{NewlineFraming, "foo\n", "foo\n"},
+		{OctetCountingFraming, "", "0 "},
+		{OctetCountingFraming, "foo", "3 foo"},
+		{OctetCountingFraming, "foo\n", "3 foo"},
+		{OctetCountingFraming, "foo\nbar", "7 foo\nbar"},
+		{NoFraming, "", ""},
+		{NoFraming, "foo", "foo"},
+		{NoFraming, "foo\n", "foo"},
+	}
+
+	for _, tt := range tests {
+		got := tt.framing.appendFrame([]byte("x"), []byte(tt.give))
+		assert.Equal(t, "x"+tt.want, string(got), "Unexpected %v framing of %q.", tt.framing, tt.give)
+	}
+
+	assert.Equal(t, "newline", NewlineFraming.String())
+	assert.Equal(t, "octet-counting", OctetCountingFraming.String())
+	assert.Equal(t, "none", NoFraming.String())
+	assert.Equal(t, "Framing(42)", Framing(42).String())
+}
+
+func TestNewNetSinkErrors(t *testing.T) {
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"tcp://localhost", "must include a host and port"},
+		{"udp://:514", "must include a host and port"},
+		{"tcp://localhost:514/foo", "paths not allowed"},
+		{"tcp://user@localhost:514", "user and password not allowed"},
+		{"tcp://localhost:514#foo", "fragments not allowed"},
+		{"unix://localhost/run/sock", "must leave host empty"},
+		{"unixgram://", "must include a socket path"},
+		{"tcp://localhost:514?foo=bar", `unknown query parameter "foo"`},
+		{"tcp://localhost:514?framing=json", "invalid framing"},
+		{"tcp://localhost:514?dialTimeout=soon", "invalid dialTimeout"},
+		{"tcp://localhost:514?writeTimeout=-1s", "invalid writeTimeout"},
+		{"tcp://localhost:514?minBackoff=0s", "invalid minBackoff"},
+		{"tcp://localhost:514?maxBackoff=1", "invalid maxBackoff"},
+		{"tcp://localhost:514?spool=lots", "invalid spool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, _, err := Open(tt.url)
+			if assert.Error(t, err, "Expected an error opening %q.", tt.url) {
+				assert.Contains(t, err.Error(), tt.err, "Unexpected error.")
+			}
+		})
+	}
+}
+
+func TestSyslogSinkDefaults(t *testing.T) {
+	tests := []struct {
+		url         string
+		wantNetwork string
+		wantAddress string
+		wantFraming Framing
+	}{
+		{"syslog:///dev/log", "unixgram", "/dev/log", NoFraming},
+		{"syslog:///dev/log?network=unix", "unix", "/dev/log", OctetCountingFraming},
+		{"syslog://collector", "udp", "collector:514", NoFraming},
+		{"syslog://collector:1514?network=tcp", "tcp", "collector:1514", OctetCountingFraming},
+		{"syslog://collector?network=tcp&framing=newline", "tcp", "collector:514", NewlineFraming},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			require.NoError(t, err, "Failed to parse URL.")
+			sink, err := newSyslogSink(u)
+			require.NoError(t, err, "Unexpected error building syslog sink.")
+			s := sink.(*NetSink)
+			assert.Equal(t, tt.wantNetwork, s.Network, "Unexpected network.")
+			assert.Equal(t, tt.wantAddress, s.Address, "Unexpected address.")
+			assert.Equal(t, tt.wantFraming, s.Framing, "Unexpected framing.")
+		})
+	}
+
+	_, _, err := Open("syslog://collector?network=sctp")
+	if assert.Error(t, err, "Expected an error with an unsupported network.") {
+		assert.Contains(t, err.Error(), "invalid network")
+	}
+}
+
+func TestSyslogConfig(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenPacket("unixgram", sock)
+	require.NoError(t, err, "Failed to listen on Unix datagram socket.")
+	defer conn.Close()
+
+	cfg := NewProductionConfig()
+	cfg.Encoding = "syslog"
+	cfg.OutputPaths = []string{"syslog://" + sock}
+	cfg.Sampling = nil
+	logger, err := cfg.Build()
+	require.NoError(t, err, "Unexpected error building logger.")
+
+	logger.Named("myapp").Warn("hello", String("user", "alice"))
+	assert.Regexp(
+		t,
+		`^<12>1 \S+ \S+ myapp \d+ - \[zap@32473 caller="[^"]*/net_sink_test.go:\d+" user="alice"\] hello$`,
+		readPacket(t, conn),
+		"Unexpected syslog message.",
+	)
+}
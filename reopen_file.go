@@ -0,0 +1,211 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// A ReopenableFile is a Sink that writes to a file on the local filesystem
+// and can reopen it by name, typically after an external tool like logrotate
+// has renamed the file. Once reopened, writes go to a new file at Filename,
+// rather than to the renamed one.
+//
+// Reopen reopens the file on demand, and ReopenOnSignal reopens it whenever
+// the process receives a signal, such as SIGHUP.
+//
+// ReopenableFile is safe for concurrent use; no writes are lost or split
+// between files while reopening.
+type ReopenableFile struct {
+	// Filename is the file to write logs to.
+	//
+	// This field is required.
+	Filename string
+
+	// Mode is the permissions of newly created files, before the umask.
+	//
+	// Defaults to 0666 if unspecified.
+	Mode os.FileMode
+
+	// ErrorOutput receives a message each time the file is reopened in
+	// response to a signal, reporting whether that succeeded.
+	//
+	// Defaults to standard error. Loggers built from a Config use the
+	// Config's error output.
+	ErrorOutput zapcore.WriteSyncer
+
+	mu      sync.Mutex
+	file    *os.File
+	signals chan os.Signal // nil unless ReopenOnSignal has been called
+	done    chan struct{}  // closed when the signal goroutine has stopped
+}
+
+var _ Sink = (*ReopenableFile)(nil)
+
+// Write writes the provided bytes to the current file, opening it first if
+// necessary.
+func (r *ReopenableFile) Write(bs []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(os.O_WRONLY | os.O_APPEND | os.O_CREATE); err != nil {
+			return 0, err
+		}
+	}
+	return r.file.Write(bs)
+}
+
+// Sync flushes the current file to disk.
+func (r *ReopenableFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Reopen opens Filename, creating it if necessary, and directs further writes
+// to it. The previous file is closed. If Filename can't be opened, writes
+// continue to go to the previous file.
+func (r *ReopenableFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := r.file
+	if err := r.open(os.O_WRONLY | os.O_APPEND | os.O_CREATE); err != nil {
+		return err
+	}
+	if prev != nil {
+		return prev.Close()
+	}
+	return nil
+}
+
+// ReopenOnSignal starts reopening the file whenever the process receives
+// one of the given signals, or SIGHUP if none are given. The result of each
+// reopen is reported to ErrorOutput.
+//
+// Signals stop being handled once the ReopenableFile is closed.
+func (r *ReopenableFile) ReopenOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.signals == nil {
+		r.signals = make(chan os.Signal, 1)
+		r.done = make(chan struct{})
+		go r.handleSignals(r.signals, r.done)
+	}
+	signal.Notify(r.signals, sigs...)
+}
+
+// Close stops handling signals and closes the current file.
+//
+// Writing to a closed ReopenableFile opens it again.
+func (r *ReopenableFile) Close() error {
+	r.mu.Lock()
+	signals, done := r.signals, r.done
+	r.signals, r.done = nil, nil
+	r.mu.Unlock()
+
+	if signals != nil {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *ReopenableFile) handleSignals(signals <-chan os.Signal, done chan<- struct{}) {
+	defer close(done)
+
+	for sig := range signals {
+		err := r.Reopen()
+
+		r.mu.Lock()
+		out := r.ErrorOutput
+		r.mu.Unlock()
+		if out == nil {
+			out = zapcore.Lock(os.Stderr)
+		}
+
+		now := zapcore.DefaultClock.Now().UTC()
+		if err != nil {
+			fmt.Fprintf(out, "%v ReopenableFile: failed to reopen %s on %v: %v\n", now, r.Filename, sig, err)
+		} else {
+			fmt.Fprintf(out, "%v ReopenableFile: reopened %s on %v\n", now, r.Filename, sig)
+		}
+		out.Sync()
+	}
+}
+
+// setDefaultErrorOutput sets ErrorOutput if it hasn't been specified.
+func (r *ReopenableFile) setDefaultErrorOutput(ws zapcore.WriteSyncer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ErrorOutput == nil {
+		r.ErrorOutput = ws
+	}
+}
+
+// open opens Filename with the given flags, replacing the current file
+// without closing it. r.mu must be held.
+func (r *ReopenableFile) open(flag int) error {
+	mode := r.Mode
+	if mode == 0 {
+		mode = 0666
+	}
+	f, err := os.OpenFile(r.Filename, flag, mode)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	return nil
+}
+
+// errorOutputSink is implemented by sinks that report problems outside of
+// their Write and Sync methods, like ReopenableFile. Loggers built from a
+// Config direct those reports to the Config's error output.
+type errorOutputSink interface {
+	setDefaultErrorOutput(zapcore.WriteSyncer)
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package zap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanSyncer is a WriteSyncer that sends each write to a channel.
+type chanSyncer chan string
+
+func (c chanSyncer) Write(bs []byte) (int, error) {
+	c <- string(bs)
+	return len(bs), nil
+}
+
+func (c chanSyncer) Sync() error { return nil }
+
+func receive(t testing.TB, c <-chan string) string {
+	select {
+	case s := <-c:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for message.")
+		return ""
+	}
+}
+
+func TestReopenableFileSignal(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	events := make(chanSyncer, 1)
+	r := &ReopenableFile{Filename: name, ErrorOutput: events}
+	r.ReopenOnSignal()
+	r.ReopenOnSignal(syscall.SIGUSR1)
+
+	writeString(t, r, "a\n")
+	require.NoError(t, os.Rename(name, name+".1"), "Failed to rename file.")
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP), "Failed to send SIGHUP.")
+	assert.Regexp(t, `ReopenableFile: reopened .*app.log on hangup\n$`, receive(t, events), "Unexpected report.")
+	writeString(t, r, "b\n")
+
+	require.NoError(t, os.Remove(name), "Failed to remove file.")
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1), "Failed to send SIGUSR1.")
+	assert.Regexp(t, `ReopenableFile: reopened .*app.log on user defined signal 1\n$`, receive(t, events), "Unexpected report.")
+	writeString(t, r, "c\n")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	assert.Equal(t, "a\n", readFile(t, name+".1"), "Unexpected contents of renamed file.")
+	assert.Equal(t, "c\n", readFile(t, name), "Unexpected contents of reopened file.")
+
+	// Writing after Close reopens the file.
+	writeString(t, r, "d\n")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+	assert.Equal(t, "c\nd\n", readFile(t, name), "Unexpected contents after reopening a closed file.")
+}
+
+func TestReopenableFileSignalFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	require.NoError(t, os.Mkdir(dir, 0777))
+	events := make(chanSyncer, 1)
+	r := &ReopenableFile{Filename: filepath.Join(dir, "app.log"), ErrorOutput: events}
+	defer r.Close()
+	r.ReopenOnSignal(syscall.SIGUSR2)
+
+	writeString(t, r, "a\n")
+	require.NoError(t, os.Rename(dir, dir+".old"), "Failed to rename directory.")
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2), "Failed to send SIGUSR2.")
+	assert.Regexp(t, `ReopenableFile: failed to reopen .*app.log on user defined signal 2: .*no such file or directory\n$`,
+		receive(t, events), "Unexpected report.")
+}
+
+func TestReopenableFileConfig(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	errName := filepath.Join(dir, "errors.log")
+
+	cfg := NewProductionConfig()
+	cfg.OutputPaths = []string{"file://" + name + "?reopen=true&sync=always&truncate=true"}
+	cfg.ErrorOutputPaths = []string{errName}
+	cfg.EncoderConfig.TimeKey = ""
+	cfg.DisableCaller = true
+	logger, close, err := cfg.BuildWithClose()
+	require.NoError(t, err, "Unexpected error building logger.")
+	defer close()
+
+	logger.Info("before")
+	require.NoError(t, os.Rename(name, name+".1"), "Failed to rename file.")
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP), "Failed to send SIGHUP.")
+
+	ok := assert.Eventually(t, func() bool {
+		bs, err := os.ReadFile(errName)
+		return err == nil && strings.Contains(string(bs), "ReopenableFile: reopened")
+	}, 5*time.Second, 10*time.Millisecond, "Expected reopen to be reported to the error output.")
+	require.True(t, ok)
+
+	logger.Info("after")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+	assert.Equal(t, `{"level":"info","msg":"before"}`+"\n", readFile(t, name+".1"), "Unexpected contents of renamed file.")
+	assert.Equal(t, `{"level":"info","msg":"after"}`+"\n", readFile(t, name), "Unexpected contents of reopened file.")
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeString(t testing.TB, s Sink, str string) {
+	_, err := s.Write([]byte(str))
+	require.NoError(t, err, "Unexpected error writing %q.", str)
+}
+
+func TestReopenableFileReopen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	r := &ReopenableFile{Filename: name, Mode: 0600}
+	defer r.Close()
+
+	writeString(t, r, "a\n")
+	require.NoError(t, os.Rename(name, name+".1"), "Failed to rename file.")
+	writeString(t, r, "b\n")
+	require.NoError(t, r.Reopen(), "Unexpected error reopening.")
+	writeString(t, r, "c\n")
+	require.NoError(t, r.Sync(), "Unexpected error syncing.")
+
+	assert.Equal(t, "a\nb\n", readFile(t, name+".1"), "Expected writes before reopening to go to the renamed file.")
+	assert.Equal(t, "c\n", readFile(t, name), "Expected writes after reopening to go to a new file.")
+
+	info, err := os.Stat(name)
+	require.NoError(t, err, "Failed to stat file.")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Unexpected permissions of reopened file.")
+}
+
+func TestReopenableFileReopenFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	require.NoError(t, os.Mkdir(dir, 0777))
+	name := filepath.Join(dir, "app.log")
+	r := &ReopenableFile{Filename: name}
+	defer r.Close()
+
+	writeString(t, r, "a\n")
+	require.NoError(t, os.Rename(dir, dir+".old"), "Failed to rename directory.")
+	assert.Error(t, r.Reopen(), "Expected error reopening in a missing directory.")
+	writeString(t, r, "b\n")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	assert.Equal(t, "a\nb\n", readFile(t, filepath.Join(dir+".old", "app.log")),
+		"Expected writes to continue to the previous file.")
+}
+
+func TestReopenableFileConcurrentReopen(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	r := &ReopenableFile{Filename: name}
+
+	const writers, lines = 4, 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				writeString(t, r, fmt.Sprintf("writer %d line %d\n", i, j))
+			}
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		if err := os.Rename(name, fmt.Sprintf("%s.%d", name, i)); err != nil {
+			assert.True(t, os.IsNotExist(err), "Unexpected error renaming: %v", err)
+		}
+		require.NoError(t, r.Reopen(), "Unexpected error reopening.")
+	}
+	wg.Wait()
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	var got []string
+	for _, file := range listDir(t, dir) {
+		if contents := readFile(t, filepath.Join(dir, file)); contents != "" {
+			got = append(got, strings.Split(strings.TrimSuffix(contents, "\n"), "\n")...)
+		}
+	}
+	assert.Len(t, got, writers*lines, "Expected no writes to be lost.")
+	for _, line := range got {
+		assert.Regexp(t, `^writer \d line \d+$`, line, "Expected no writes to be split.")
+	}
+}
+
+func TestOpenReopenableFileErrors(t *testing.T) {
+	_, err := newSink("file://"+filepath.Join(t.TempDir(), "app.log")+"?reopen=maybe", nil)
+	require.Error(t, err, "Expected error for invalid reopen parameter.")
+	assert.Contains(t, err.Error(), `invalid reopen "maybe"`, "Unexpected error for invalid reopen parameter.")
+
+	_, err = newSink("file://"+filepath.Join(t.TempDir(), "missing", "app.log")+"?reopen=true", nil)
+	assert.Error(t, err, "Expected error opening file in a missing directory.")
+
+	r := &ReopenableFile{Filename: filepath.Join(t.TempDir(), "app.log")}
+	assert.NoError(t, r.Sync(), "Expected syncing an unopened file to succeed.")
+	assert.NoError(t, r.Close(), "Expected closing an unopened file to succeed.")
+}
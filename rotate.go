@@ -0,0 +1,487 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// _defaultRotateMaxSize is the size at which a RotatingFile rolls over
+	// if MaxSize is unspecified.
+	_defaultRotateMaxSize = 100 * 1024 * 1024 // 100 MB
+
+	// _rotateTimeFormat is the layout of the timestamp embedded in the names
+	// of rotated files. It sorts lexically and avoids characters that are
+	// awkward in file names.
+	_rotateTimeFormat = "2006-01-02T15-04-05.000"
+
+	_gzipSuffix = ".gz"
+)
+
+// A RotatingFile is a Sink that writes to a file on the local filesystem and
+// rolls it over once it grows past a size limit. Rotated files are renamed
+// to include the time of rotation (e.g., "app.log" becomes
+// "app-2022-01-02T15-04-05.000.log") and are pruned by count and age in the
+// background. Rotated files may optionally be compressed with gzip.
+//
+// RotatingFile is safe for concurrent use. Close waits for any pending
+// background pruning and compression to finish.
+type RotatingFile struct {
+	// Filename is the file to write logs to. Rotated files are kept in the
+	// same directory.
+	//
+	// This field is required.
+	Filename string
+
+	// MaxSize is the size in bytes at which the file is rotated. A single
+	// write is never split across files, so a file may exceed MaxSize if a
+	// single write is larger than it.
+	//
+	// Defaults to 100 MB if unspecified.
+	MaxSize int64
+
+	// MaxBackups is the maximum number of rotated files to retain.
+	//
+	// Defaults to retaining all rotated files if unspecified.
+	MaxBackups int
+
+	// MaxAge is the maximum age of rotated files to retain, based on the
+	// time of rotation.
+	//
+	// Defaults to retaining all rotated files if unspecified.
+	MaxAge time.Duration
+
+	// Compress specifies whether rotated files should be compressed with
+	// gzip.
+	Compress bool
+
+	// Clock, if specified, provides the time used to name rotated files and
+	// to determine their age.
+	//
+	// Defaults to the system clock.
+	Clock zapcore.Clock
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	millMu  sync.Mutex     // serializes pruning and compression
+	millErr error          // errors from background work; guarded by millMu
+	millWG  sync.WaitGroup // tracks pending background work
+}
+
+var _ Sink = (*RotatingFile)(nil)
+
+// Write writes the provided bytes to the current file, rotating it first if
+// the write would take it past MaxSize.
+func (r *RotatingFile) Write(bs []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.openExisting(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.size > 0 && r.size+int64(len(bs)) > r.maxSize() {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(bs)
+	r.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the current file to disk.
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Rotate closes the current file, renames it, and starts writing to a new
+// file, regardless of its size.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.openExisting(); err != nil {
+			return err
+		}
+	}
+	return r.rotate()
+}
+
+// Close closes the current file and waits for any background pruning or
+// compression of rotated files to finish. It returns any errors encountered
+// by that background work.
+//
+// Writing to a closed RotatingFile re-opens it.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+		r.size = 0
+	}
+
+	// Holding r.mu ensures that no new background work is scheduled while
+	// we wait.
+	r.millWG.Wait()
+
+	r.millMu.Lock()
+	err = multierr.Append(err, r.millErr)
+	r.millErr = nil
+	r.millMu.Unlock()
+
+	return err
+}
+
+func (r *RotatingFile) maxSize() int64 {
+	if r.MaxSize > 0 {
+		return r.MaxSize
+	}
+	return _defaultRotateMaxSize
+}
+
+func (r *RotatingFile) now() time.Time {
+	if r.Clock == nil {
+		return zapcore.DefaultClock.Now()
+	}
+	return r.Clock.Now()
+}
+
+// openExisting opens the current file for appending, creating it if
+// necessary. r.mu must be held.
+func (r *RotatingFile) openExisting() error {
+	if r.Filename == "" {
+		return errors.New("no file name specified for rotating file")
+	}
+	f, err := os.OpenFile(r.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one in its place. r.mu
+// must be held.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	r.size = 0
+
+	if err := os.Rename(r.Filename, r.backupName()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(r.Filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	r.file = f
+
+	r.millWG.Add(1)
+	go r.mill()
+	return nil
+}
+
+// backupName returns an unused name for the current file once rotated.
+func (r *RotatingFile) backupName() string {
+	dir, prefix, ext := r.nameParts()
+	t := r.now().UTC()
+	for {
+		name := filepath.Join(dir, prefix+t.Format(_rotateTimeFormat)+ext)
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + _gzipSuffix); os.IsNotExist(err) {
+				return name
+			}
+		}
+		// Two rotations within the same millisecond; nudge the timestamp
+		// rather than clobber an existing backup.
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// nameParts splits Filename into the directory holding it and the prefix and
+// extension shared by all of its backups.
+func (r *RotatingFile) nameParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(r.Filename)
+	base := filepath.Base(r.Filename)
+	ext = filepath.Ext(base)
+	prefix = strings.TrimSuffix(base, ext) + "-"
+	return dir, prefix, ext
+}
+
+// mill prunes and compresses rotated files.
+func (r *RotatingFile) mill() {
+	defer r.millWG.Done()
+
+	r.millMu.Lock()
+	defer r.millMu.Unlock()
+
+	r.millErr = multierr.Append(r.millErr, r.millRunOnce())
+}
+
+func (r *RotatingFile) millRunOnce() error {
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+
+	var remove []rotatedFile
+	if r.MaxBackups > 0 && len(backups) > r.MaxBackups {
+		remove = append(remove, backups[r.MaxBackups:]...)
+		backups = backups[:r.MaxBackups]
+	}
+	if r.MaxAge > 0 {
+		cutoff := r.now().Add(-r.MaxAge)
+		keep := backups[:0]
+		for _, b := range backups {
+			if b.rotatedAt.Before(cutoff) {
+				remove = append(remove, b)
+			} else {
+				keep = append(keep, b)
+			}
+		}
+		backups = keep
+	}
+
+	for _, b := range remove {
+		if rmErr := os.Remove(b.path); rmErr != nil && !os.IsNotExist(rmErr) {
+			err = multierr.Append(err, rmErr)
+		}
+	}
+	if r.Compress {
+		for _, b := range backups {
+			if !strings.HasSuffix(b.path, _gzipSuffix) {
+				err = multierr.Append(err, gzipFile(b.path))
+			}
+		}
+	}
+	return err
+}
+
+type rotatedFile struct {
+	path      string
+	rotatedAt time.Time
+}
+
+// backups lists the rotated versions of Filename, newest first.
+func (r *RotatingFile) backups() ([]rotatedFile, error) {
+	dir, prefix, ext := r.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []rotatedFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), _gzipSuffix)
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		ts := name[len(prefix) : len(name)-len(ext)]
+		t, err := time.Parse(_rotateTimeFormat, ts)
+		if err != nil {
+			// Not one of ours.
+			continue
+		}
+		backups = append(backups, rotatedFile{
+			path:      filepath.Join(dir, e.Name()),
+			rotatedAt: t,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotatedAt.After(backups[j].rotatedAt)
+	})
+	return backups, nil
+}
+
+// gzipFile compresses the file at path, replacing it with a file of the same
+// name plus a ".gz" suffix.
+func gzipFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dstPath := path + _gzipSuffix
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dstPath)
+		}
+	}()
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// newRotatingFileSink builds a RotatingFile from a URL like
+//
+//   rotate:///var/log/app.log?maxSize=100MB&maxBackups=7&maxAge=72h&compress=gzip
+func newRotatingFileSink(u *url.URL) (Sink, error) {
+	if err := checkFileURL(u); err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("rotate URLs must include a file path: got %v", u)
+	}
+
+	r := &RotatingFile{Filename: u.Path}
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "maxSize":
+			r.MaxSize, err = parseByteSize(val)
+		case "maxBackups":
+			r.MaxBackups, err = strconv.Atoi(val)
+			if err == nil && r.MaxBackups < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "maxAge":
+			r.MaxAge, err = time.ParseDuration(val)
+			if err == nil && r.MaxAge < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "compress":
+			switch val {
+			case "gzip":
+				r.Compress = true
+			case "", "none":
+				r.Compress = false
+			default:
+				err = errors.New(`must be "gzip" or "none"`)
+			}
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in rotate URL: got %v", key, u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in rotate URL: %v", key, val, err)
+		}
+	}
+
+	// Open the file eagerly so that misconfigurations surface from Open
+	// rather than from the first write.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.openExisting(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseByteSize parses a human-readable size like "512", "10KB", or "1.5GB".
+// Units are powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		// Longer suffixes first so that "MB" isn't mistaken for "B".
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"TB", 1 << 40},
+		{"K", 1 << 10},
+		{"M", 1 << 20},
+		{"G", 1 << 30},
+		{"T", 1 << 40},
+		{"B", 1},
+	}
+
+	num, scale := strings.TrimSpace(s), float64(1)
+	upper := strings.ToUpper(num)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			num, scale = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.scale
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f <= 0 {
+		return 0, fmt.Errorf("can't parse %q as a positive size", s)
+	}
+	return int64(f * scale), nil
+}
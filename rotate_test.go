@@ -0,0 +1,328 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+)
+
+func listDir(t testing.TB, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err, "Failed to list directory.")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t testing.TB, path string) string {
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "Failed to read %v.", path)
+	return string(bs)
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	clock := ztest.NewMockClock()
+	r := &RotatingFile{
+		Filename: filepath.Join(dir, "app.log"),
+		MaxSize:  10,
+		Clock:    clock,
+	}
+
+	for _, s := range []string{"aaaaaa", "bbbb", "cccccc", "dddddd"} {
+		_, err := r.Write([]byte(s))
+		require.NoError(t, err, "Unexpected error writing.")
+		clock.Add(time.Second)
+	}
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	assert.Equal(t, []string{
+		"app-1970-01-01T00-00-02.000.log",
+		"app-1970-01-01T00-00-03.000.log",
+		"app.log",
+	}, listDir(t, dir), "Unexpected files after rotation.")
+	assert.Equal(t, "aaaaaabbbb", readFile(t, filepath.Join(dir, "app-1970-01-01T00-00-02.000.log")))
+	assert.Equal(t, "cccccc", readFile(t, filepath.Join(dir, "app-1970-01-01T00-00-03.000.log")))
+	assert.Equal(t, "dddddd", readFile(t, filepath.Join(dir, "app.log")))
+}
+
+func TestRotatingFileAppendsToExisting(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	require.NoError(t, ioutil.WriteFile(name, []byte("12345678"), 0666))
+
+	r := &RotatingFile{Filename: name, MaxSize: 10, Clock: ztest.NewMockClock()}
+	_, err := r.Write([]byte("abc"))
+	require.NoError(t, err, "Unexpected error writing.")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	assert.Equal(t, []string{"app-1970-01-01T00-00-00.000.log", "app.log"}, listDir(t, dir))
+	assert.Equal(t, "12345678", readFile(t, filepath.Join(dir, "app-1970-01-01T00-00-00.000.log")))
+	assert.Equal(t, "abc", readFile(t, name))
+}
+
+func TestRotatingFileSameInstant(t *testing.T) {
+	dir := t.TempDir()
+	r := &RotatingFile{Filename: filepath.Join(dir, "app.log"), Clock: ztest.NewMockClock()}
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := r.Write([]byte("x"))
+		require.NoError(t, err, "Unexpected error writing.")
+		require.NoError(t, r.Rotate(), "Unexpected error rotating.")
+	}
+	assert.Equal(t, []string{
+		"app-1970-01-01T00-00-00.000.log",
+		"app-1970-01-01T00-00-00.001.log",
+		"app-1970-01-01T00-00-00.002.log",
+		"app.log",
+	}, listDir(t, dir), "Expected rotations in the same instant not to overwrite each other.")
+}
+
+func TestRotatingFilePrunes(t *testing.T) {
+	tests := []struct {
+		desc       string
+		maxBackups int
+		maxAge     time.Duration
+		want       []string
+	}{
+		{
+			desc: "keep everything",
+			want: []string{
+				"app-1970-01-01T00-00-00.000.log",
+				"app-1970-01-01T01-00-00.000.log",
+				"app-1970-01-01T02-00-00.000.log",
+				"app-1970-01-01T03-00-00.000.log",
+				"app.log",
+			},
+		},
+		{
+			desc:       "by count",
+			maxBackups: 2,
+			want: []string{
+				"app-1970-01-01T02-00-00.000.log",
+				"app-1970-01-01T03-00-00.000.log",
+				"app.log",
+			},
+		},
+		{
+			desc:   "by age",
+			maxAge: 90 * time.Minute,
+			want: []string{
+				"app-1970-01-01T02-00-00.000.log",
+				"app-1970-01-01T03-00-00.000.log",
+				"app.log",
+			},
+		},
+		{
+			desc:       "by count and age",
+			maxBackups: 1,
+			maxAge:     150 * time.Minute,
+			want: []string{
+				"app-1970-01-01T03-00-00.000.log",
+				"app.log",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			// Files that merely look similar must be left alone.
+			unrelated := []string{"app-old.log", "other-1970-01-01T00-00-00.000.log"}
+			for _, name := range unrelated {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0666))
+			}
+
+			clock := ztest.NewMockClock()
+			r := &RotatingFile{
+				Filename:   filepath.Join(dir, "app.log"),
+				MaxBackups: tt.maxBackups,
+				MaxAge:     tt.maxAge,
+				Clock:      clock,
+			}
+			for i := 0; i < 4; i++ {
+				if i > 0 {
+					clock.Add(time.Hour)
+				}
+				_, err := r.Write([]byte("x"))
+				require.NoError(t, err, "Unexpected error writing.")
+				require.NoError(t, r.Rotate(), "Unexpected error rotating.")
+			}
+			require.NoError(t, r.Close(), "Unexpected error closing.")
+
+			want := append(tt.want, unrelated...)
+			sort.Strings(want)
+			assert.Equal(t, want, listDir(t, dir), "Unexpected files after pruning.")
+		})
+	}
+}
+
+func TestRotatingFileCompresses(t *testing.T) {
+	dir := t.TempDir()
+	r := &RotatingFile{
+		Filename: filepath.Join(dir, "app.log"),
+		MaxSize:  5,
+		Compress: true,
+		Clock:    ztest.NewMockClock(),
+	}
+	for _, s := range []string{"hello", "world"} {
+		_, err := r.Write([]byte(s))
+		require.NoError(t, err, "Unexpected error writing.")
+	}
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	compressed := "app-1970-01-01T00-00-00.000.log.gz"
+	assert.Equal(t, []string{compressed, "app.log"}, listDir(t, dir))
+
+	f, err := os.Open(filepath.Join(dir, compressed))
+	require.NoError(t, err, "Failed to open compressed backup.")
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err, "Failed to read gzip header.")
+	bs, err := ioutil.ReadAll(gz)
+	require.NoError(t, err, "Failed to decompress backup.")
+	assert.Equal(t, "hello", string(bs), "Unexpected contents of compressed backup.")
+}
+
+func TestRotatingFileWriteAfterClose(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	r := &RotatingFile{Filename: name}
+	_, err := r.Write([]byte("foo"))
+	require.NoError(t, err, "Unexpected error writing.")
+	require.NoError(t, r.Sync(), "Unexpected error syncing.")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	_, err = r.Write([]byte("bar"))
+	require.NoError(t, err, "Unexpected error writing after close.")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+	assert.Equal(t, "foobar", readFile(t, name))
+}
+
+func TestRotatingFileNoFilename(t *testing.T) {
+	r := &RotatingFile{}
+	_, err := r.Write([]byte("foo"))
+	assert.Error(t, err, "Expected an error writing without a file name.")
+	assert.NoError(t, r.Sync(), "Unexpected error syncing an unopened file.")
+	assert.NoError(t, r.Close(), "Unexpected error closing an unopened file.")
+}
+
+func TestOpenRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+
+	ws, close, err := Open("rotate://" + name + "?maxSize=1KB&maxBackups=2&maxAge=72h&compress=gzip")
+	require.NoError(t, err, "Unexpected error opening rotate URL.")
+	assert.True(t, fileExists(name), "Expected file to be created eagerly.")
+
+	_, err = ws.Write(make([]byte, 1000))
+	require.NoError(t, err, "Unexpected error writing.")
+	_, err = ws.Write(make([]byte, 100))
+	require.NoError(t, err, "Unexpected error writing.")
+	require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+	close()
+
+	names := listDir(t, dir)
+	require.Len(t, names, 2, "Expected one backup and the current file.")
+	assert.Regexp(t, `^app-.*\.log\.gz$`, names[0], "Expected backup to be compressed.")
+	assert.Equal(t, "app.log", names[1])
+}
+
+func TestNewRotatingFileSinkErrors(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"rotate://", "must include a file path"},
+		{"rotate://host01.test.com" + name, "empty or use localhost"},
+		{"rotate://localhost:8080" + name, "ports not allowed"},
+		{"rotate://rms@localhost" + name, "user and password not allowed"},
+		{"rotate://" + name + "#foo", "fragments not allowed"},
+		{"rotate://" + name + "?foo=bar", `unknown query parameter "foo"`},
+		{"rotate://" + name + "?maxSize=lots", "invalid maxSize"},
+		{"rotate://" + name + "?maxSize=-1MB", "invalid maxSize"},
+		{"rotate://" + name + "?maxBackups=x", "invalid maxBackups"},
+		{"rotate://" + name + "?maxBackups=-1", "invalid maxBackups"},
+		{"rotate://" + name + "?maxAge=3", "invalid maxAge"},
+		{"rotate://" + name + "?maxAge=-3h", "invalid maxAge"},
+		{"rotate://" + name + "?compress=zstd", "invalid compress"},
+		{"rotate:///non-existent-dir/app.log", "no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, _, err := Open(tt.url)
+			if assert.Error(t, err, "Expected an error opening %q.", tt.url) {
+				assert.Contains(t, err.Error(), tt.err, "Unexpected error.")
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		give    string
+		want    int64
+		wantErr bool
+	}{
+		{give: "512", want: 512},
+		{give: "512B", want: 512},
+		{give: "10KB", want: 10 << 10},
+		{give: "10k", want: 10 << 10},
+		{give: "100MB", want: 100 << 20},
+		{give: "1.5GB", want: 3 << 29},
+		{give: "2 TB", want: 2 << 40},
+		{give: "", wantErr: true},
+		{give: "MB", wantErr: true},
+		{give: "0", wantErr: true},
+		{give: "ten", wantErr: true},
+		{give: "NaN", wantErr: true},
+		{give: "NaNMB", wantErr: true},
+		{give: "Inf", wantErr: true},
+		{give: "+InfKB", wantErr: true},
+		{give: "-Inf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.give)
+		if tt.wantErr {
+			assert.Error(t, err, "Expected an error parsing %q.", tt.give)
+			continue
+		}
+		if assert.NoError(t, err, "Unexpected error parsing %q.", tt.give) {
+			assert.Equal(t, tt.want, got, "Unexpected size for %q.", tt.give)
+		}
+	}
+}
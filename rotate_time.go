@@ -0,0 +1,365 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// A RotationPeriod is the wall-clock interval on which a TimeRotatingFile
+// starts a new file.
+type RotationPeriod uint8
+
+const (
+	// RotateHourly starts a new file at the top of every hour.
+	RotateHourly RotationPeriod = iota
+	// RotateDaily starts a new file at midnight.
+	RotateDaily
+)
+
+// String returns a lower-case ASCII representation of the period.
+func (p RotationPeriod) String() string {
+	switch p {
+	case RotateHourly:
+		return "hourly"
+	case RotateDaily:
+		return "daily"
+	default:
+		return fmt.Sprintf("RotationPeriod(%d)", p)
+	}
+}
+
+// start returns the beginning of the period containing t.
+func (p RotationPeriod) start(t time.Time) time.Time {
+	switch p {
+	case RotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+}
+
+// next returns the beginning of the period following the one that starts at
+// start.
+func (p RotationPeriod) next(start time.Time) time.Time {
+	switch p {
+	case RotateDaily:
+		// AddDate rather than Add, so that days with a daylight saving
+		// transition still end at midnight.
+		return start.AddDate(0, 0, 1)
+	default:
+		return start.Add(time.Hour)
+	}
+}
+
+// A TimeRotatingFile is a Sink that starts a new file on the local filesystem
+// at wall-clock boundaries. File names are derived from a strftime-style
+// pattern, so that logs written between 13:00 and 14:00 on 2022-01-02 with
+// the pattern "/var/log/app-%Y-%m-%dT%H.log" land in
+// "/var/log/app-2022-01-02T13.log".
+//
+// Rotation is driven entirely by the configured Clock: the file is switched on
+// the first write at or after a boundary. This makes TimeRotatingFile
+// deterministic when used with a mock clock.
+//
+// TimeRotatingFile is safe for concurrent use.
+type TimeRotatingFile struct {
+	// Pattern is the strftime-style pattern for file names. The following
+	// conversions are supported:
+	//
+	//   %Y  four-digit year        %y  two-digit year
+	//   %m  month (01-12)          %b  abbreviated month name
+	//   %d  day of month (01-31)   %a  abbreviated weekday name
+	//   %H  hour (00-23)           %M  minute (00-59)
+	//   %S  second (00-59)         %z  UTC offset (-0700)
+	//   %Z  time zone name         %%  a literal '%'
+	//
+	// This field is required.
+	Pattern string
+
+	// Period is how often to start a new file.
+	//
+	// Defaults to RotateHourly.
+	Period RotationPeriod
+
+	// Symlink, if specified, is kept pointing at the current file so that
+	// there is a stable path at which to find the newest logs. Failing to
+	// update it doesn't stop logging: the write that switched files still
+	// succeeds, but returns the error.
+	Symlink string
+
+	// Location is the time zone in which to compute period boundaries and
+	// format file names.
+	//
+	// Defaults to the local time zone.
+	Location *time.Location
+
+	// Clock, if specified, provides control of the source of time for the
+	// writer. To rotate in step with a Logger's timestamps, use the same
+	// Clock as supplied to WithClock. Config.Build does this for files
+	// opened from timerotate URLs.
+	//
+	// Defaults to the system clock.
+	Clock zapcore.Clock
+
+	mu   sync.Mutex
+	file *os.File
+	name string    // name of the currently open file
+	next time.Time // when the current file should be rotated
+}
+
+var _ Sink = (*TimeRotatingFile)(nil)
+
+// Write writes the provided bytes to the file for the current period,
+// switching files first if a boundary has passed. If the switch fails, the
+// bytes are still written to the previous file, if there is one, and the
+// error is returned along with any error writing them.
+func (r *TimeRotatingFile) Write(bs []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rotateErr := r.maybeRotate()
+	if r.file == nil {
+		return 0, rotateErr
+	}
+	n, err := r.file.Write(bs)
+	return n, multierr.Append(rotateErr, err)
+}
+
+// Sync flushes the current file to disk.
+func (r *TimeRotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close closes the current file. Writing to a closed TimeRotatingFile
+// re-opens it.
+func (r *TimeRotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.name = ""
+	return err
+}
+
+// maybeRotate ensures that r.file is the file for the current period. r.mu
+// must be held.
+func (r *TimeRotatingFile) maybeRotate() error {
+	var clock zapcore.Clock = zapcore.DefaultClock
+	if r.Clock != nil {
+		clock = r.Clock
+	}
+	loc := time.Local
+	if r.Location != nil {
+		loc = r.Location
+	}
+
+	now := clock.Now().In(loc)
+	if r.file != nil && now.Before(r.next) {
+		return nil
+	}
+
+	if r.Pattern == "" {
+		return errors.New("no file name pattern specified for time-rotating file")
+	}
+	start := r.Period.start(now)
+	name := strftime(r.Pattern, start)
+
+	// Patterns coarser than the period map consecutive periods to the same
+	// file; there's no need to re-open it.
+	if r.file == nil || name != r.name {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		if r.file != nil {
+			// The old file is finished; a failure to close it shouldn't
+			// prevent us from writing to the new one.
+			_ = r.file.Close()
+		}
+		r.file = f
+		r.name = name
+		r.next = r.Period.next(start)
+
+		// The new file is usable even if the symlink can't be updated, so
+		// report the error without retrying on every write.
+		if r.Symlink != "" {
+			return r.updateSymlink()
+		}
+		return nil
+	}
+	r.next = r.Period.next(start)
+	return nil
+}
+
+// updateSymlink atomically points Symlink at the current file.
+func (r *TimeRotatingFile) updateSymlink() error {
+	target := r.name
+	if filepath.Dir(target) == filepath.Dir(r.Symlink) {
+		// Prefer relative links, which survive moving the whole directory.
+		target = filepath.Base(target)
+	}
+
+	tmp := r.Symlink + ".tmp" + strconv.Itoa(os.Getpid())
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.Symlink); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// strftime formats t according to a strftime-style pattern. See
+// TimeRotatingFile.Pattern for the supported conversions; unsupported ones
+// are copied to the output verbatim.
+func strftime(pattern string, t time.Time) string {
+	buf := make([]byte, 0, len(pattern)+16)
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i == len(pattern)-1 {
+			buf = append(buf, c)
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'Y':
+			buf = appendInt(buf, t.Year(), 4)
+		case 'y':
+			buf = appendInt(buf, t.Year()%100, 2)
+		case 'm':
+			buf = appendInt(buf, int(t.Month()), 2)
+		case 'b':
+			buf = append(buf, t.Month().String()[:3]...)
+		case 'd':
+			buf = appendInt(buf, t.Day(), 2)
+		case 'a':
+			buf = append(buf, t.Weekday().String()[:3]...)
+		case 'H':
+			buf = appendInt(buf, t.Hour(), 2)
+		case 'M':
+			buf = appendInt(buf, t.Minute(), 2)
+		case 'S':
+			buf = appendInt(buf, t.Second(), 2)
+		case 'z':
+			buf = t.AppendFormat(buf, "-0700")
+		case 'Z':
+			buf = t.AppendFormat(buf, "MST")
+		case '%':
+			buf = append(buf, '%')
+		default:
+			buf = append(buf, '%', pattern[i])
+		}
+	}
+	return string(buf)
+}
+
+// appendInt appends n, zero-padded to at least width digits.
+func appendInt(buf []byte, n, width int) []byte {
+	s := strconv.Itoa(n)
+	for i := len(s); i < width; i++ {
+		buf = append(buf, '0')
+	}
+	return append(buf, s...)
+}
+
+// newTimeRotatingFileSink builds a TimeRotatingFile from a URL like
+//
+//   timerotate:///var/log/app-%25Y-%25m-%25dT%25H.log?period=hourly&symlink=/var/log/app.log
+//
+// Note that the '%' of each conversion must be escaped as "%25" to form a
+// valid URL.
+func newTimeRotatingFileSink(u *url.URL) (Sink, error) {
+	return newTimeRotatingFileSinkWithClock(u, nil)
+}
+
+// newTimeRotatingFileSinkWithClock is like newTimeRotatingFileSink, but
+// rotates the file by the given Clock.
+func newTimeRotatingFileSinkWithClock(u *url.URL, clock zapcore.Clock) (Sink, error) {
+	if err := checkFileURL(u); err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("timerotate URLs must include a file name pattern: got %v", u)
+	}
+
+	r := &TimeRotatingFile{Pattern: u.Path, Clock: clock}
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		switch key {
+		case "period":
+			switch val {
+			case "hourly":
+				r.Period = RotateHourly
+			case "daily":
+				r.Period = RotateDaily
+			default:
+				return nil, fmt.Errorf(`invalid period %q in timerotate URL: must be "hourly" or "daily"`, val)
+			}
+		case "symlink":
+			r.Symlink = val
+		case "utc":
+			utc, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid utc %q in timerotate URL: %v", val, err)
+			}
+			if utc {
+				r.Location = time.UTC
+			}
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in timerotate URL: got %v", key, u)
+		}
+	}
+
+	// Open the file eagerly so that misconfigurations surface from Open
+	// rather than from the first write.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.maybeRotate(); err != nil {
+		if r.file != nil {
+			r.file.Close()
+		}
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestTimeRotatingFileHourly(t *testing.T) {
+	dir := t.TempDir()
+	clock := ztest.NewMockClock()
+	clock.Add(13*time.Hour + 59*time.Minute)
+	r := &TimeRotatingFile{
+		Pattern:  filepath.Join(dir, "app-%Y-%m-%dT%H.log"),
+		Symlink:  filepath.Join(dir, "app.log"),
+		Location: time.UTC,
+		Clock:    clock,
+	}
+	defer r.Close()
+
+	write := func(s string) {
+		_, err := r.Write([]byte(s))
+		require.NoError(t, err, "Unexpected error writing.")
+	}
+
+	write("a")
+	clock.Add(59 * time.Second)
+	write("b")
+	assertSymlink(t, r.Symlink, "app-1970-01-01T13.log")
+
+	clock.Add(time.Second) // 14:00:00
+	write("c")
+	assertSymlink(t, r.Symlink, "app-1970-01-01T14.log")
+
+	clock.Add(3 * time.Hour) // 17:00:00, skipping some periods entirely
+	write("d")
+	require.NoError(t, r.Sync(), "Unexpected error syncing.")
+
+	assert.Equal(t, []string{
+		"app-1970-01-01T13.log",
+		"app-1970-01-01T14.log",
+		"app-1970-01-01T17.log",
+		"app.log",
+	}, listDir(t, dir), "Unexpected files after rotation.")
+	assert.Equal(t, "ab", readFile(t, filepath.Join(dir, "app-1970-01-01T13.log")))
+	assert.Equal(t, "c", readFile(t, filepath.Join(dir, "app-1970-01-01T14.log")))
+	assert.Equal(t, "d", readFile(t, filepath.Join(dir, "app-1970-01-01T17.log")))
+	assert.Equal(t, "d", readFile(t, r.Symlink), "Expected symlink to resolve to current file.")
+}
+
+func TestTimeRotatingFileDaily(t *testing.T) {
+	dir := t.TempDir()
+	clock := ztest.NewMockClock()
+	r := &TimeRotatingFile{
+		// The pattern includes the hour to prove that we don't rotate hourly.
+		Pattern:  filepath.Join(dir, "app-%Y%m%d-%H.log"),
+		Period:   RotateDaily,
+		Location: time.UTC,
+		Clock:    clock,
+	}
+	defer r.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := r.Write([]byte("x"))
+		require.NoError(t, err, "Unexpected error writing.")
+		clock.Add(10 * time.Hour)
+	}
+	assert.Equal(t, []string{"app-19700101-00.log", "app-19700102-00.log"}, listDir(t, dir))
+	assert.Equal(t, "xxx", readFile(t, filepath.Join(dir, "app-19700101-00.log")))
+}
+
+func TestTimeRotatingFileCoarsePattern(t *testing.T) {
+	dir := t.TempDir()
+	clock := ztest.NewMockClock()
+	r := &TimeRotatingFile{
+		Pattern:  filepath.Join(dir, "app-%Y-%m-%d.log"),
+		Location: time.UTC,
+		Clock:    clock,
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := r.Write([]byte("x"))
+		require.NoError(t, err, "Unexpected error writing.")
+		clock.Add(time.Hour)
+	}
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	// Re-opening after Close appends to the existing file.
+	_, err := r.Write([]byte("y"))
+	require.NoError(t, err, "Unexpected error writing after close.")
+	require.NoError(t, r.Close(), "Unexpected error closing.")
+
+	assert.Equal(t, []string{"app-1970-01-01.log"}, listDir(t, dir))
+	assert.Equal(t, "xxxy", readFile(t, filepath.Join(dir, "app-1970-01-01.log")))
+}
+
+func TestTimeRotatingFileErrors(t *testing.T) {
+	r := &TimeRotatingFile{}
+	_, err := r.Write([]byte("foo"))
+	assert.Error(t, err, "Expected an error writing without a pattern.")
+	assert.NoError(t, r.Sync(), "Unexpected error syncing an unopened file.")
+	assert.NoError(t, r.Close(), "Unexpected error closing an unopened file.")
+
+	r = &TimeRotatingFile{
+		Pattern: filepath.Join(t.TempDir(), "app.log"),
+		Symlink: "/non-existent-dir/app.log",
+	}
+	defer r.Close()
+	n, err := r.Write([]byte("foo"))
+	assert.Error(t, err, "Expected an error updating symlink in a missing directory.")
+	assert.Equal(t, 3, n, "Expected the write to succeed despite the symlink error.")
+	n, err = r.Write([]byte("bar"))
+	assert.NoError(t, err, "Expected the symlink error to be reported once per rotation.")
+	assert.Equal(t, 3, n, "Unexpected number of bytes written.")
+	assert.Equal(t, "foobar", readFile(t, r.Pattern), "Expected both writes to reach the file.")
+}
+
+func TestStrftime(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 0, time.FixedZone("EST", -5*60*60))
+	tests := []struct {
+		give string
+		want string
+	}{
+		{"app2.log", "app2.log"},
+		{"%Y-%m-%dT%H:%M:%S", "2022-03-04T05:06:07"},
+		{"%y%b%d-%a", "22Mar04-Fri"},
+		{"%z %Z", "-0500 EST"},
+		{"100%%", "100%"},
+		{"%q", "%q"},
+		{"trailing%", "trailing%"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, strftime(tt.give, ts), "Unexpected output for pattern %q.", tt.give)
+	}
+}
+
+func TestRotationPeriodString(t *testing.T) {
+	assert.Equal(t, "hourly", RotateHourly.String())
+	assert.Equal(t, "daily", RotateDaily.String())
+	assert.Equal(t, "RotationPeriod(42)", RotationPeriod(42).String())
+}
+
+func TestOpenTimeRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "app.log")
+
+	ws, close, err := Open("timerotate://" + dir + "/app-%25Y.log?period=daily&utc=true&symlink=" + link)
+	require.NoError(t, err, "Unexpected error opening timerotate URL.")
+	defer close()
+
+	name := "app-" + time.Now().UTC().Format("2006") + ".log"
+	assertSymlink(t, link, name)
+
+	_, err = ws.Write([]byte("foo"))
+	require.NoError(t, err, "Unexpected error writing.")
+	assert.Equal(t, "foo", readFile(t, filepath.Join(dir, name)))
+}
+
+func TestConfigTimeRotatingFileClock(t *testing.T) {
+	dir := t.TempDir()
+	clock := ztest.NewMockClock()
+	clock.Add(13*time.Hour + 59*time.Minute)
+
+	cfg := NewProductionConfig()
+	cfg.Encoding = "console"
+	cfg.EncoderConfig = zapcore.EncoderConfig{MessageKey: "M"}
+	cfg.OutputPaths = []string{"timerotate://" + dir + "/app-%25H.log?period=hourly&utc=true"}
+	logger, close, err := cfg.BuildWithClose(WithClock(clock))
+	require.NoError(t, err, "Unexpected error building logger.")
+
+	logger.Info("foo")
+	clock.Add(time.Minute) // 14:00:00
+	logger.Info("bar")
+	require.NoError(t, close(), "Unexpected error closing logger.")
+
+	assert.Equal(t, []string{"app-13.log", "app-14.log"}, listDir(t, dir), "Unexpected files after rotation.")
+	assert.Equal(t, "foo\n", readFile(t, filepath.Join(dir, "app-13.log")))
+	assert.Equal(t, "bar\n", readFile(t, filepath.Join(dir, "app-14.log")))
+}
+
+func TestNewTimeRotatingFileSinkErrors(t *testing.T) {
+	pattern := filepath.Join(t.TempDir(), "app-%25Y.log")
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"timerotate://", "must include a file name pattern"},
+		{"timerotate://host01.test.com" + pattern, "empty or use localhost"},
+		{"timerotate://" + pattern + "?foo=bar", `unknown query parameter "foo"`},
+		{"timerotate://" + pattern + "?period=weekly", "invalid period"},
+		{"timerotate://" + pattern + "?utc=maybe", "invalid utc"},
+		{"timerotate:///non-existent-dir/app.log", "no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, _, err := Open(tt.url)
+			if assert.Error(t, err, "Expected an error opening %q.", tt.url) {
+				assert.Contains(t, err.Error(), tt.err, "Unexpected error.")
+			}
+		})
+	}
+}
+
+func assertSymlink(t testing.TB, link, want string) {
+	got, err := os.Readlink(link)
+	if assert.NoError(t, err, "Failed to read symlink.") {
+		assert.Equal(t, want, got, "Unexpected symlink target.")
+	}
+}
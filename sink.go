@@ -26,13 +26,19 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
 	"go.uber.org/zap/zapcore"
 )
 
-const schemeFile = "file"
+const (
+	schemeFile       = "file"
+	schemeRotate     = "rotate"
+	schemeTimeRotate = "timerotate"
+)
 
 var (
 	_sinkMutex     sync.RWMutex
@@ -48,7 +54,17 @@ func resetSinkRegistry() {
 	defer _sinkMutex.Unlock()
 
 	_sinkFactories = map[string]func(*url.URL) (Sink, error){
-		schemeFile: newFileSink,
+		schemeFile:       newFileSink,
+		schemeRotate:     newRotatingFileSink,
+		schemeTimeRotate: newTimeRotatingFileSink,
+		schemeTCP:        newNetSink,
+		schemeUDP:        newNetSink,
+		schemeUnix:       newNetSink,
+		schemeUnixgram:   newNetSink,
+		schemeSyslog:     newSyslogSink,
+		schemeHTTP:       newHTTPSink,
+		schemeHTTPS:      newHTTPSink,
+		schemeGELF:       newGELFSink,
 	}
 }
 
@@ -75,8 +91,9 @@ func (e *errSinkNotFound) Error() string {
 //
 // All schemes must be ASCII, valid under section 3.1 of RFC 3986
 // (https://tools.ietf.org/html/rfc3986#section-3.1), and must not already
-// have a factory registered. Zap automatically registers a factory for the
-// "file" scheme.
+// have a factory registered. Zap automatically registers factories for the
+// "file", "rotate", "timerotate", "tcp", "udp", "unix", "unixgram", "syslog",
+// "http", "https", and "gelf" schemes.
 func RegisterSink(scheme string, factory func(*url.URL) (Sink, error)) error {
 	_sinkMutex.Lock()
 	defer _sinkMutex.Unlock()
@@ -95,7 +112,9 @@ func RegisterSink(scheme string, factory func(*url.URL) (Sink, error)) error {
 	return nil
 }
 
-func newSink(rawURL string) (Sink, error) {
+// newSink opens a sink for the URL. If clock is non-nil, sinks opened from
+// timerotate URLs rotate by it rather than by the system clock.
+func newSink(rawURL string, clock zapcore.Clock) (Sink, error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("can't parse %q as a URL: %v", rawURL, err)
@@ -110,33 +129,141 @@ func newSink(rawURL string) (Sink, error) {
 	if !ok {
 		return nil, &errSinkNotFound{u.Scheme}
 	}
+	if u.Scheme == schemeTimeRotate && clock != nil {
+		return newTimeRotatingFileSinkWithClock(u, clock)
+	}
 	return factory(u)
 }
 
+// newFileSink opens a file from a URL like
+//
+//   file:///var/log/app.log?mode=0640&mkdir=true&truncate=false&sync=always
+//
+// The query parameters control the permissions of a newly created file
+// (subject to the umask), whether missing parent directories are created,
+// whether an existing file is truncated, whether each write is followed by an
+// fsync, and whether the file is reopened on SIGHUP.
 func newFileSink(u *url.URL) (Sink, error) {
+	if err := checkFileURL(u); err != nil {
+		return nil, err
+	}
+	switch u.Path {
+	case "stdout", "stderr":
+		if u.RawQuery != "" {
+			return nil, fmt.Errorf("query parameters not allowed with %s: got %v", u.Path, u)
+		}
+		if u.Path == "stdout" {
+			return nopCloserSink{os.Stdout}, nil
+		}
+		return nopCloserSink{os.Stderr}, nil
+	}
+
+	var (
+		mode       os.FileMode = 0666
+		mkdir      bool
+		flag       = os.O_WRONLY | os.O_APPEND | os.O_CREATE
+		syncWrites bool
+		reopen     bool
+	)
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "mode":
+			var perm uint64
+			perm, err = strconv.ParseUint(val, 8, 32)
+			if err == nil && perm > 0777 {
+				err = errors.New("must be an octal permission mode, like 0640")
+			}
+			mode = os.FileMode(perm)
+		case "mkdir":
+			mkdir, err = strconv.ParseBool(val)
+		case "truncate":
+			var truncate bool
+			truncate, err = strconv.ParseBool(val)
+			if truncate {
+				flag |= os.O_TRUNC
+			}
+		case "sync":
+			switch val {
+			case "always":
+				syncWrites = true
+			case "never":
+				syncWrites = false
+			default:
+				err = errors.New(`must be "always" or "never"`)
+			}
+		case "reopen":
+			reopen, err = strconv.ParseBool(val)
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in %s URL: got %v", key, u.Scheme, u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in %s URL: %v", key, val, u.Scheme, err)
+		}
+	}
+
+	if mkdir {
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	var sink Sink
+	if reopen {
+		r := &ReopenableFile{Filename: u.Path, Mode: mode}
+		if err := r.open(flag); err != nil {
+			return nil, err
+		}
+		r.ReopenOnSignal()
+		sink = r
+	} else {
+		f, err := os.OpenFile(u.Path, flag, mode)
+		if err != nil {
+			return nil, err
+		}
+		sink = f
+	}
+	if syncWrites {
+		return syncedSink{sink}, nil
+	}
+	return sink, nil
+}
+
+// syncedSink is a Sink that's synced to stable storage after every write.
+type syncedSink struct{ Sink }
+
+func (s syncedSink) Write(bs []byte) (int, error) {
+	n, err := s.Sink.Write(bs)
+	if err != nil {
+		return n, err
+	}
+	return n, s.Sink.Sync()
+}
+
+func (s syncedSink) setDefaultErrorOutput(ws zapcore.WriteSyncer) {
+	if es, ok := s.Sink.(errorOutputSink); ok {
+		es.setDefaultErrorOutput(ws)
+	}
+}
+
+// checkFileURL verifies that a URL for a sink backed by the local filesystem
+// doesn't carry any components that we'd silently ignore.
+func checkFileURL(u *url.URL) error {
 	if u.User != nil {
-		return nil, fmt.Errorf("user and password not allowed with file URLs: got %v", u)
+		return fmt.Errorf("user and password not allowed with %s URLs: got %v", u.Scheme, u)
 	}
 	if u.Fragment != "" {
-		return nil, fmt.Errorf("fragments not allowed with file URLs: got %v", u)
-	}
-	if u.RawQuery != "" {
-		return nil, fmt.Errorf("query parameters not allowed with file URLs: got %v", u)
+		return fmt.Errorf("fragments not allowed with %s URLs: got %v", u.Scheme, u)
 	}
 	// Error messages are better if we check hostname and port separately.
 	if u.Port() != "" {
-		return nil, fmt.Errorf("ports not allowed with file URLs: got %v", u)
+		return fmt.Errorf("ports not allowed with %s URLs: got %v", u.Scheme, u)
 	}
 	if hn := u.Hostname(); hn != "" && hn != "localhost" {
-		return nil, fmt.Errorf("file URLs must leave host empty or use localhost: got %v", u)
+		return fmt.Errorf("%s URLs must leave host empty or use localhost: got %v", u.Scheme, u)
 	}
-	switch u.Path {
-	case "stdout":
-		return nopCloserSink{os.Stdout}, nil
-	case "stderr":
-		return nopCloserSink{os.Stderr}, nil
-	}
-	return os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	return nil
 }
 
 func normalizeScheme(s string) (string, error) {
@@ -24,6 +24,8 @@ import (
 	"bytes"
 	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -98,3 +100,79 @@ func TestRegisterSinkErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestFileSinkOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("mode and mkdir", func(t *testing.T) {
+		path := filepath.Join(dir, "nested", "dir", "app.log")
+		sink, err := newSink("file://"+path+"?mode=0640&mkdir=true", nil)
+		require.NoError(t, err, "Failed to open file sink.")
+		defer sink.Close()
+
+		info, err := os.Stat(path)
+		require.NoError(t, err, "Expected file to be created.")
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm(), "Unexpected file permissions.")
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		path := filepath.Join(dir, "truncate.log")
+		require.NoError(t, ioutil.WriteFile(path, []byte("old\n"), 0666))
+
+		sink, err := newSink("file://"+path+"?truncate=false", nil)
+		require.NoError(t, err, "Failed to open file sink.")
+		_, err = sink.Write([]byte("appended\n"))
+		require.NoError(t, err, "Failed to write to file sink.")
+		require.NoError(t, sink.Close())
+		assert.Equal(t, "old\nappended\n", readFile(t, path), "Expected writes to be appended.")
+
+		sink, err = newSink("file://"+path+"?truncate=true", nil)
+		require.NoError(t, err, "Failed to open file sink.")
+		_, err = sink.Write([]byte("new\n"))
+		require.NoError(t, err, "Failed to write to file sink.")
+		require.NoError(t, sink.Close())
+		assert.Equal(t, "new\n", readFile(t, path), "Expected file to be truncated.")
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		path := filepath.Join(dir, "sync.log")
+		sink, err := newSink("file://"+path+"?sync=always", nil)
+		require.NoError(t, err, "Failed to open file sink.")
+		require.IsType(t, syncedSink{}, sink, "Expected file to be synced on every write.")
+
+		n, err := sink.Write([]byte("synced\n"))
+		require.NoError(t, err, "Failed to write to file sink.")
+		assert.Equal(t, 7, n, "Unexpected number of bytes written.")
+		require.NoError(t, sink.Close())
+		assert.Equal(t, "synced\n", readFile(t, path), "Unexpected file contents.")
+
+		sink, err = newSink("file://"+path+"?sync=never", nil)
+		require.NoError(t, err, "Failed to open file sink.")
+		defer sink.Close()
+		assert.IsType(t, &os.File{}, sink, "Expected plain file without sync=always.")
+	})
+}
+
+func TestFileSinkOptionErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "app.log")
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"file://" + path, "no such file or directory"},
+		{"file://" + path + "?mkdir=false", "no such file or directory"},
+		{"file://" + path + "?mode=rw", `invalid mode "rw"`},
+		{"file://" + path + "?mode=01777", `invalid mode "01777"`},
+		{"file://" + path + "?mkdir=sure", `invalid mkdir "sure"`},
+		{"file://" + path + "?truncate=sure", `invalid truncate "sure"`},
+		{"file://" + path + "?sync=sometimes", `invalid sync "sometimes"`},
+		{"file://" + path + "?foo=bar", `unknown query parameter "foo"`},
+		{"stdout?sync=always", "query parameters not allowed with stdout"},
+		{"stderr?mode=0600", "query parameters not allowed with stderr"},
+	}
+	for _, tt := range tests {
+		_, err := newSink(tt.url, nil)
+		require.Error(t, err, "Expected error opening %q.", tt.url)
+		assert.Contains(t, err.Error(), tt.want, "Unexpected error opening %q.", tt.url)
+	}
+}
@@ -36,12 +36,72 @@ import (
 // any opened files.
 //
 // Passing no URLs returns a no-op WriteSyncer. Zap handles URLs without a
-// scheme and URLs with the "file" scheme. Third-party code may register
-// factories for other schemes using RegisterSink.
+// scheme and URLs with the "file", "rotate", "timerotate", "tcp", "udp",
+// "unix", "unixgram", "syslog", "http", "https", and "gelf" schemes.
+// Third-party code may register factories for other schemes using
+// RegisterSink.
 //
 // URLs with the "file" scheme must use absolute paths on the local
-// filesystem. No user, password, port, fragments, or query parameters are
-// allowed, and the hostname must be empty or "localhost".
+// filesystem. No user, password, port, or fragments are allowed, and the
+// hostname must be empty or "localhost". They accept the query parameters
+// mode (the octal permissions of a newly created file, subject to the umask),
+// mkdir ("true" to create missing parent directories), truncate ("true" to
+// truncate an existing file), sync ("always" to sync the file after every
+// write, or "never"), and reopen ("true" to open a ReopenableFile that
+// reopens the path on SIGHUP, for use with logrotate's create mode). For
+// example,
+//
+//   file:///var/log/app.log?mode=0640&mkdir=true&sync=always
+//   file:///var/log/app.log?reopen=true
+//
+// URLs with the "rotate" scheme follow the same rules, but open a
+// RotatingFile configured by the query parameters maxSize (e.g., "100MB"),
+// maxBackups, maxAge (e.g., "72h"), and compress ("gzip" or "none"). For
+// example,
+//
+//   rotate:///var/log/app.log?maxSize=100MB&maxBackups=7&compress=gzip
+//
+// URLs with the "timerotate" scheme open a TimeRotatingFile whose path is a
+// strftime-style pattern, with each '%' escaped as "%25". They accept the
+// query parameters period ("hourly" or "daily"), symlink (a path to keep
+// pointing at the current file), and utc ("true" to use UTC rather than local
+// time). They rotate by the system clock, or, when opened by Config.Build,
+// by the Clock given to WithClock. For example,
+//
+//   timerotate:///var/log/app-%25Y-%25m-%25d.log?period=daily&symlink=/var/log/app.log
+//
+// URLs with the "tcp", "udp", "unix", and "unixgram" schemes open a NetSink,
+// which dials lazily. The host and port (for "tcp" and "udp") or path (for
+// "unix" and "unixgram") specify the address. They accept the query
+// parameters framing ("newline", "octet-counting", or "none"), dialTimeout,
+// writeTimeout, minBackoff, maxBackoff (e.g., "30s"), and spool (e.g., "1MB").
+// For example,
+//
+//   tcp://collector:5170?framing=octet-counting&spool=1MB
+//
+// URLs with the "syslog" scheme also open a NetSink, with defaults suited to
+// syslog daemons: a path dials a Unix datagram socket, a host dials UDP port
+// 514, and messages sent over stream networks use octet-counting framing. The
+// network query parameter overrides the default network. For example,
+//
+//   syslog:///dev/log
+//   syslog://collector?network=tcp
+//
+// URLs with the "http" and "https" schemes open an HTTPSink, which POSTs
+// batches of messages to the URL as newline-delimited JSON. The query
+// parameters batchEntries, batchBytes (e.g., "1MB"), flushInterval, compress
+// ("gzip" or "none"), maxRetries, minBackoff, maxBackoff, and maxPending
+// configure the sink and are removed from the URL; any other query parameters
+// are sent to the server. For example,
+//
+//   https://collector/ingest?token=abc&compress=gzip&batchEntries=500
+//
+// URLs with the "gelf" scheme open a GELFSink, which sends messages to a
+// Graylog GELF UDP input, splitting large messages into chunks. The port
+// defaults to 12201. They accept the query parameters chunkSize (e.g., "8KB")
+// and compress ("gzip" or "none"). For example,
+//
+//   gelf://graylog:12201?chunkSize=8KB&compress=gzip
 //
 // Since it's common to write logs to the local filesystem, URLs without a
 // scheme (e.g., "/var/log/foo.log") are treated as local file paths. Without
@@ -49,27 +109,29 @@ import (
 // os.Stdout and os.Stderr. When specified without a scheme, relative file
 // paths also work.
 func Open(paths ...string) (zapcore.WriteSyncer, func(), error) {
-	writers, close, err := open(paths)
+	writers, close, err := open(paths, nil)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	writer := CombineWriteSyncers(writers...)
-	return writer, close, nil
+	return writer, func() { close() }, nil
 }
 
-func open(paths []string) ([]zapcore.WriteSyncer, func(), error) {
+func open(paths []string, clock zapcore.Clock) ([]zapcore.WriteSyncer, func() error, error) {
 	writers := make([]zapcore.WriteSyncer, 0, len(paths))
 	closers := make([]io.Closer, 0, len(paths))
-	close := func() {
+	close := func() error {
+		var err error
 		for _, c := range closers {
-			c.Close()
+			err = multierr.Append(err, c.Close())
 		}
+		return err
 	}
 
 	var openErr error
 	for _, path := range paths {
-		sink, err := newSink(path)
+		sink, err := newSink(path, clock)
 		if err != nil {
 			openErr = multierr.Append(openErr, fmt.Errorf("couldn't open sink %q: %v", path, err))
 			continue
@@ -77,7 +77,7 @@ func TestOpen(t *testing.T) {
 		{[]string{"file://host01.test.com" + tempName}, []string{"empty or use localhost"}},
 		{[]string{"file://rms@localhost" + tempName}, []string{"user and password not allowed"}},
 		{[]string{"file://localhost" + tempName + "#foo"}, []string{"fragments not allowed"}},
-		{[]string{"file://localhost" + tempName + "?foo=bar"}, []string{"query parameters not allowed"}},
+		{[]string{"file://localhost" + tempName + "?foo=bar"}, []string{`unknown query parameter "foo"`}},
 		{[]string{"file://localhost:8080" + tempName}, []string{"ports not allowed"}},
 	}
 
@@ -0,0 +1,376 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package zapcbor decodes the output of zap's "cbor" encoder.
+package zapcbor // import "go.uber.org/zap/zapcbor"
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Major types of CBOR data items. See RFC 8949, section 3.1.
+const (
+	majorUint   = 0
+	majorNegint = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Tags interpreted by the Decoder.
+const (
+	tagDateTime  = 0
+	tagEpoch     = 1
+	tagBase64URL = 21
+	tagBase64    = 22
+	tagBase16    = 23
+)
+
+// _break terminates indefinite-length items.
+const _break = 0xff
+
+// errBreak is returned by decodeItem when it reads a break.
+var errBreak = errors.New("unexpected CBOR break")
+
+// A Tag is a tagged data item whose tag the Decoder doesn't interpret.
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// A Decoder reads log entries encoded by the "cbor" encoder from an input
+// stream.
+//
+// Entries are decoded as maps. Their values are decoded as nil, bool,
+// int64 (or uint64, for integers too large for an int64), float32, float64,
+// string, []byte, time.Time (for tags 0 and 1), []interface{},
+// map[string]interface{}, or Tag. Byte strings with the tags that suggest an
+// encoding for conversion to JSON (21, 22, and 23) are decoded as []byte.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder returns a Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next entry from the input. At the end of the input, it
+// returns io.EOF.
+func (d *Decoder) Decode() (map[string]interface{}, error) {
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+	v, err := d.decodeItem()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	entry, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a CBOR map, got %T", v)
+	}
+	return entry, nil
+}
+
+// DecodeAll decodes all the entries in data.
+func DecodeAll(data []byte) ([]map[string]interface{}, error) {
+	d := NewDecoder(bytes.NewReader(data))
+	var entries []map[string]interface{}
+	for {
+		entry, err := d.Decode()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (d *Decoder) decodeItem() (interface{}, error) {
+	initial, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if initial == _break {
+		return nil, errBreak
+	}
+
+	major, info := initial>>5, initial&0x1f
+	if major == majorSimple {
+		return d.decodeSimple(info)
+	}
+
+	// Indefinite-length items, which have no argument.
+	if info == 31 {
+		switch major {
+		case majorBytes, majorText:
+			return d.decodeChunks(major)
+		case majorArray:
+			return d.decodeArray(-1)
+		case majorMap:
+			return d.decodeMap(-1)
+		default:
+			return nil, fmt.Errorf("invalid indefinite-length CBOR major type %d", major)
+		}
+	}
+
+	arg, err := d.readArgument(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUint:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case majorNegint:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR negative integer -1-%d overflows int64", arg)
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		return d.read(arg)
+	case majorText:
+		bs, err := d.read(arg)
+		return string(bs), err
+	case majorArray:
+		return d.decodeArray(int64(arg))
+	case majorMap:
+		return d.decodeMap(int64(arg))
+	default: // majorTag
+		return d.decodeTag(arg)
+	}
+}
+
+// readArgument reads the argument of a data item, given the low five bits
+// of its initial byte.
+func (d *Decoder) readArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return d.readUint(1 << (info - 24))
+	default:
+		return 0, fmt.Errorf("invalid CBOR additional information %d", info)
+	}
+}
+
+func (d *Decoder) decodeSimple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null and undefined
+		return nil, nil
+	case 25:
+		v, err := d.readUint(2)
+		return float16ToFloat32(uint16(v)), err
+	case 26:
+		v, err := d.readUint(4)
+		return math.Float32frombits(uint32(v)), err
+	case 27:
+		v, err := d.readUint(8)
+		return math.Float64frombits(v), err
+	default:
+		return nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+	}
+}
+
+// decodeChunks decodes an indefinite-length byte or text string.
+func (d *Decoder) decodeChunks(major byte) (interface{}, error) {
+	var buf []byte
+	for {
+		v, err := d.decodeItem()
+		if err == errBreak {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch chunk := v.(type) {
+		case []byte:
+			if major != majorBytes {
+				return nil, errors.New("invalid chunk in indefinite-length CBOR text string")
+			}
+			buf = append(buf, chunk...)
+		case string:
+			if major != majorText {
+				return nil, errors.New("invalid chunk in indefinite-length CBOR byte string")
+			}
+			buf = append(buf, chunk...)
+		default:
+			return nil, fmt.Errorf("invalid CBOR string chunk of type %T", v)
+		}
+	}
+	if major == majorText {
+		return string(buf), nil
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, nil
+}
+
+// decodeArray decodes an array with n elements, or an indefinite-length
+// array if n is negative.
+func (d *Decoder) decodeArray(n int64) (interface{}, error) {
+	arr := []interface{}{}
+	for i := int64(0); n < 0 || i < n; i++ {
+		v, err := d.decodeItem()
+		if n < 0 && err == errBreak {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+// decodeMap decodes a map with n entries, or an indefinite-length map if n
+// is negative.
+func (d *Decoder) decodeMap(n int64) (interface{}, error) {
+	m := make(map[string]interface{})
+	for i := int64(0); n < 0 || i < n; i++ {
+		k, err := d.decodeItem()
+		if n < 0 && err == errBreak {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a CBOR text map key, got %T", k)
+		}
+		v, err := d.decodeItem()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (d *Decoder) decodeTag(num uint64) (interface{}, error) {
+	content, err := d.decodeItem()
+	if err != nil {
+		return nil, err
+	}
+	switch num {
+	case tagDateTime:
+		s, ok := content.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected text for CBOR tag 0, got %T", content)
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case tagEpoch:
+		switch sec := content.(type) {
+		case int64:
+			return time.Unix(sec, 0).UTC(), nil
+		case float64:
+			whole, frac := math.Modf(sec)
+			return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+		case float32:
+			whole, frac := math.Modf(float64(sec))
+			return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+		default:
+			return nil, fmt.Errorf("expected a number for CBOR tag 1, got %T", content)
+		}
+	case tagBase64URL, tagBase64, tagBase16:
+		if _, ok := content.([]byte); ok {
+			return content, nil
+		}
+	}
+	return Tag{Number: num, Content: content}, nil
+}
+
+func (d *Decoder) readUint(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func (d *Decoder) read(n uint64) ([]byte, error) {
+	// Read in bounded steps, so that a corrupt length can't make us
+	// allocate a huge buffer up front.
+	const step = 64 << 10
+	var bs []byte
+	for n > 0 {
+		m := n
+		if m > step {
+			m = step
+		}
+		chunk := make([]byte, m)
+		if _, err := io.ReadFull(d.r, chunk); err != nil {
+			return nil, err
+		}
+		bs = append(bs, chunk...)
+		n -= m
+	}
+	if bs == nil {
+		bs = []byte{}
+	}
+	return bs, nil
+}
+
+// float16ToFloat32 converts an IEEE 754 half-precision float.
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		// Zero or subnormal.
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		// Infinity or NaN.
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	default:
+		return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+	}
+}
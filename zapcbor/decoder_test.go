@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeHex decodes a map with a single key, "v", whose value is the given
+// hex-encoded CBOR data item, and returns that value.
+func decodeHex(t testing.TB, item string) (interface{}, error) {
+	data, err := hex.DecodeString("a16176" + item)
+	require.NoError(t, err, "Invalid hex.")
+	entries, err := DecodeAll(data)
+	if err != nil {
+		return nil, err
+	}
+	require.Len(t, entries, 1, "Expected a single entry.")
+	return entries[0]["v"], nil
+}
+
+func TestDecoderValues(t *testing.T) {
+	// Most of these examples are from RFC 8949, Appendix A.
+	tests := []struct {
+		item string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"3b7fffffffffffffff", int64(math.MinInt64)},
+		{"f90000", float32(0)},
+		{"f93c00", float32(1)},
+		{"f97bff", float32(65504)},
+		{"f90001", float32(5.960464477539063e-8)},
+		{"f9c400", float32(-4)},
+		{"f97c00", float32(math.Inf(1))},
+		{"fa47c35000", float32(100000)},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, time.March, 21, 20, 4, 0, 0, time.UTC)},
+		{"c11a514b67b0", time.Date(2013, time.March, 21, 20, 4, 0, 0, time.UTC)},
+		{"c1fb41d452d9ec200000", time.Date(2013, time.March, 21, 20, 4, 0, 500000000, time.UTC)},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+		{"d818456449455446", Tag{Number: 24, Content: []byte("dIETF")}},
+		{"40", []byte{}},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6449455446", "IETF"},
+		{"62c3bc", "ü"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a0", map[string]interface{}{}},
+		{"a26161016162820203", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9fff", []interface{}{}},
+		{"9f018202039f0405ffff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.item, func(t *testing.T) {
+			got, err := decodeHex(t, tt.item)
+			require.NoError(t, err, "Unexpected error decoding.")
+			assert.Equal(t, tt.want, got, "Unexpected value.")
+		})
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		item string
+		want string
+	}{
+		{"", "unexpected EOF"},
+		{"1a0000", "unexpected EOF"},
+		{"6449", "unexpected EOF"},
+		{"9f01", "unexpected EOF"},
+		{"ff", "unexpected CBOR break"},
+		{"82ff", "unexpected CBOR break"},
+		{"1c", "invalid CBOR additional information 28"},
+		{"3bffffffffffffffff", "CBOR negative integer -1-18446744073709551615 overflows int64"},
+		{"3f", "invalid indefinite-length CBOR major type 1"},
+		{"f0", "unsupported CBOR simple value 16"},
+		{"5f6161ff", "invalid chunk in indefinite-length CBOR byte string"},
+		{"7f4161ff", "invalid chunk in indefinite-length CBOR text string"},
+		{"5f01ff", "invalid CBOR string chunk of type int64"},
+		{"a10101", "expected a CBOR text map key, got int64"},
+		{"c001", "expected text for CBOR tag 0, got int64"},
+		{"c06161", `parsing time "a" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "a" as "2006"`},
+		{"c16161", "expected a number for CBOR tag 1, got string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.item, func(t *testing.T) {
+			_, err := decodeHex(t, tt.item)
+			assert.EqualError(t, err, tt.want, "Unexpected error.")
+		})
+	}
+}
+
+func TestDecoderStream(t *testing.T) {
+	// {"a": 1} {"b": 2}, followed by a non-map.
+	data, err := hex.DecodeString("bf616101ff" + "a1616202" + "01")
+	require.NoError(t, err, "Invalid hex.")
+
+	dec := NewDecoder(bytes.NewReader(data))
+	entry, err := dec.Decode()
+	require.NoError(t, err, "Unexpected error decoding first entry.")
+	assert.Equal(t, map[string]interface{}{"a": int64(1)}, entry, "Unexpected first entry.")
+
+	entry, err = dec.Decode()
+	require.NoError(t, err, "Unexpected error decoding second entry.")
+	assert.Equal(t, map[string]interface{}{"b": int64(2)}, entry, "Unexpected second entry.")
+
+	_, err = dec.Decode()
+	assert.EqualError(t, err, "expected a CBOR map, got int64", "Unexpected error decoding a non-map.")
+
+	_, err = dec.Decode()
+	assert.Equal(t, io.EOF, err, "Expected EOF at the end of the input.")
+
+	entries, err := DecodeAll(nil)
+	assert.NoError(t, err, "Unexpected error decoding empty input.")
+	assert.Empty(t, entries, "Expected no entries in empty input.")
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// _defaultQueueSize specifies the default number of writes an
+// AsyncWriteSyncer will queue.
+const _defaultQueueSize = 1024
+
+// errAsyncStopped is returned by writes to an AsyncWriteSyncer that has been
+// stopped.
+var errAsyncStopped = errors.New("write to stopped AsyncWriteSyncer")
+
+// An OverflowPolicy determines what an AsyncWriteSyncer does with a write when
+// its queue is full.
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock blocks the caller until there's room in the queue. No
+	// writes are lost, but a slow destination will slow down the caller.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the write that didn't fit in the queue.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest queued write to make room for
+	// the new one.
+	OverflowDropOldest
+	// OverflowDropBelowLevel discards the write that didn't fit if it was
+	// logged below the AsyncWriteSyncer's DropLevel, and blocks otherwise.
+	OverflowDropBelowLevel
+)
+
+// String returns a lower-case ASCII representation of the policy.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropBelowLevel:
+		return "drop-below-level"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", p)
+	}
+}
+
+// AsyncStats reports the activity of an AsyncWriteSyncer.
+type AsyncStats struct {
+	// Written is the number of writes delivered to the wrapped WriteSyncer.
+	Written int64
+	// DroppedEntries and DroppedBytes report writes that were discarded
+	// because of the OverflowPolicy, or because Stop gave up draining the
+	// queue.
+	DroppedEntries int64
+	DroppedBytes   int64
+}
+
+// An AsyncWriteSyncer is a WriteSyncer that hands writes to a background
+// goroutine through a bounded queue, so that callers aren't held up by a
+// slow destination. What happens when the queue is full is controlled by the
+// Overflow policy.
+//
+// Errors returned by the wrapped WriteSyncer can't be reported to the
+// caller of Write; the first such error is returned by the next call to Sync
+// or Stop instead.
+//
+// AsyncWriteSyncer is safe for concurrent use. You don't need to use
+// zapcore.Lock for WriteSyncers with AsyncWriteSyncer. Call Stop to release
+// its goroutine.
+type AsyncWriteSyncer struct {
+	// WS is the WriteSyncer to which AsyncWriteSyncer will deliver writes.
+	//
+	// This field is required.
+	WS WriteSyncer
+
+	// QueueSize is the maximum number of writes held in the queue.
+	//
+	// Defaults to 1024 if unspecified.
+	QueueSize int
+
+	// Overflow is the policy applied to writes that don't fit in the queue.
+	//
+	// Defaults to OverflowBlock.
+	Overflow OverflowPolicy
+
+	// DropLevel is the minimum level of writes that are never dropped under
+	// OverflowDropBelowLevel. It has no effect with other policies.
+	//
+	// The level of a write is only known when the AsyncWriteSyncer is passed
+	// directly to NewCore; writes made through any other path are treated as
+	// InfoLevel.
+	DropLevel Level
+
+	// unexported fields for state
+	mu          sync.Mutex
+	cond        *sync.Cond // broadcast on every change to the fields below
+	initialized bool       // whether initialize() has run
+	stopping    bool       // whether Stop() has been called
+	abandon     bool       // whether Stop() has given up on draining the queue
+	done        chan struct{}
+	queue       []asyncWrite // ring buffer
+	head, count int
+	enqueued    int64 // total writes accepted into the queue
+	finished    int64 // total writes taken out of the queue and handled
+	err         error // first error from WS, cleared when reported
+	stats       AsyncStats
+}
+
+type asyncWrite struct {
+	buf *buffer.Buffer
+	lvl Level
+}
+
+var _ WriteSyncer = (*AsyncWriteSyncer)(nil)
+
+func (s *AsyncWriteSyncer) initialize() {
+	size := s.QueueSize
+	if size <= 0 {
+		size = _defaultQueueSize
+	}
+
+	s.cond = sync.NewCond(&s.mu)
+	s.queue = make([]asyncWrite, size)
+	s.done = make(chan struct{})
+	s.initialized = true
+	go s.writeLoop()
+}
+
+// Write copies the supplied bytes into the queue for delivery to the wrapped
+// WriteSyncer. Writes discarded by the overflow policy are reported as
+// successful.
+func (s *AsyncWriteSyncer) Write(bs []byte) (int, error) {
+	return s.writeLevel(InfoLevel, bs)
+}
+
+func (s *AsyncWriteSyncer) writeLevel(lvl Level, bs []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopping {
+		return 0, errAsyncStopped
+	}
+	if !s.initialized {
+		s.initialize()
+	}
+
+	for {
+		if s.stopping {
+			return 0, errAsyncStopped
+		}
+		if s.count < len(s.queue) {
+			break
+		}
+
+		switch s.Overflow {
+		case OverflowDropNewest:
+			s.drop(len(bs))
+			return len(bs), nil
+		case OverflowDropOldest:
+			old := s.pop()
+			s.drop(old.buf.Len())
+			old.buf.Free()
+			s.finished++
+			continue
+		case OverflowDropBelowLevel:
+			if lvl < s.DropLevel {
+				s.drop(len(bs))
+				return len(bs), nil
+			}
+		}
+		s.cond.Wait()
+	}
+
+	// The caller may re-use bs as soon as we return.
+	buf := bufferpool.Get()
+	buf.Write(bs)
+	s.queue[(s.head+s.count)%len(s.queue)] = asyncWrite{buf: buf, lvl: lvl}
+	s.count++
+	s.enqueued++
+	s.cond.Broadcast()
+	return len(bs), nil
+}
+
+// pop removes the oldest write from the queue. s.mu must be held.
+func (s *AsyncWriteSyncer) pop() asyncWrite {
+	w := s.queue[s.head]
+	s.queue[s.head] = asyncWrite{}
+	s.head = (s.head + 1) % len(s.queue)
+	s.count--
+	return w
+}
+
+// drop records a discarded write. s.mu must be held.
+func (s *AsyncWriteSyncer) drop(n int) {
+	s.stats.DroppedEntries++
+	s.stats.DroppedBytes += int64(n)
+}
+
+// writeLoop delivers queued writes to the wrapped WriteSyncer until Stop is
+// called and the queue is drained.
+func (s *AsyncWriteSyncer) writeLoop() {
+	defer close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		for s.count == 0 && !s.stopping {
+			s.cond.Wait()
+		}
+		if s.abandon {
+			for s.count > 0 {
+				w := s.pop()
+				s.drop(w.buf.Len())
+				w.buf.Free()
+				s.finished++
+			}
+		}
+		if s.count == 0 {
+			// Stopping, and nothing left to do.
+			s.cond.Broadcast()
+			return
+		}
+
+		w := s.pop()
+		s.cond.Broadcast() // there's room in the queue
+
+		s.mu.Unlock()
+		_, err := s.WS.Write(w.buf.Bytes())
+		w.buf.Free()
+		s.mu.Lock()
+
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		s.stats.Written++
+		s.finished++
+		s.cond.Broadcast()
+	}
+}
+
+// Sync waits until all writes queued before the call have been delivered to
+// the wrapped WriteSyncer, then syncs it. It returns the first error
+// encountered delivering writes since the last call to Sync or Stop, if any.
+func (s *AsyncWriteSyncer) Sync() error {
+	s.mu.Lock()
+	var err error
+	if s.initialized {
+		target := s.enqueued
+		for s.finished < target && !s.abandon {
+			s.cond.Wait()
+		}
+		err, s.err = s.err, nil
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return s.WS.Sync()
+}
+
+// Stats reports the number of writes delivered and dropped so far.
+func (s *AsyncWriteSyncer) Stats() AsyncStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Stop stops accepting writes and waits for the queue to drain, then syncs
+// the wrapped WriteSyncer. If ctx expires before the queue is drained, the
+// remaining writes are dropped and ctx's error is returned.
+//
+// Writes made after Stop return an error.
+func (s *AsyncWriteSyncer) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.initialized || s.stopping {
+		s.stopping = true
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopping = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.abandon = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		// We don't wait for the write in progress, if any: the wrapped
+		// WriteSyncer may be hung.
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	err := s.err
+	s.err = nil
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return s.WS.Sync()
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+)
+
+// gatedWriter is a WriteSyncer whose writes block until the gate is opened.
+type gatedWriter struct {
+	ztest.Syncer
+
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	gate    chan struct{}
+	started chan struct{} // receives a value as each write starts
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{
+		gate:    make(chan struct{}),
+		started: make(chan struct{}, 100),
+	}
+}
+
+func (w *gatedWriter) Write(bs []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(bs)
+}
+
+func (w *gatedWriter) open() { close(w.gate) }
+
+func (w *gatedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncWriteSyncer(t *testing.T) {
+	buf := &ztest.Buffer{}
+	ws := &AsyncWriteSyncer{WS: buf}
+
+	for _, s := range []string{"foo\n", "bar\n"} {
+		n, err := ws.Write([]byte(s))
+		require.NoError(t, err, "Unexpected error writing.")
+		assert.Equal(t, len(s), n, "Unexpected number of bytes written.")
+	}
+	require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+	assert.Equal(t, []string{"foo", "bar"}, buf.Lines(), "Expected Sync to wait for queued writes.")
+	assert.True(t, buf.Called(), "Expected Sync to sync the wrapped WriteSyncer.")
+	assert.Equal(t, AsyncStats{Written: 2}, ws.Stats(), "Unexpected stats.")
+
+	require.NoError(t, ws.Stop(context.Background()), "Unexpected error stopping.")
+	_, err := ws.Write([]byte("baz"))
+	assert.Error(t, err, "Expected an error writing after Stop.")
+	assert.NoError(t, ws.Stop(context.Background()), "Expected second Stop to be a no-op.")
+}
+
+func TestAsyncWriteSyncerWithoutStart(t *testing.T) {
+	buf := &ztest.Buffer{}
+	ws := &AsyncWriteSyncer{WS: buf}
+	assert.NoError(t, ws.Sync(), "Unexpected error syncing.")
+	assert.NoError(t, ws.Stop(context.Background()), "Unexpected error stopping.")
+	_, err := ws.Write([]byte("foo"))
+	assert.Error(t, err, "Expected an error writing after Stop.")
+}
+
+func TestAsyncWriteSyncerCopiesInput(t *testing.T) {
+	buf := &ztest.Buffer{}
+	ws := &AsyncWriteSyncer{WS: buf}
+	defer ws.Stop(context.Background())
+
+	bs := []byte("foo")
+	_, err := ws.Write(bs)
+	require.NoError(t, err, "Unexpected error writing.")
+	copy(bs, "bar")
+	require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+	assert.Equal(t, "foo", buf.String(), "Expected write to be unaffected by re-use of the input.")
+}
+
+func TestAsyncWriteSyncerOverflow(t *testing.T) {
+	tests := []struct {
+		policy      OverflowPolicy
+		want        string
+		wantDropped int64
+	}{
+		// "a" is picked up by the write loop immediately, leaving room for
+		// two more writes in the queue.
+		{OverflowDropNewest, "abc", 2},
+		{OverflowDropOldest, "ade", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			w := newGatedWriter()
+			ws := &AsyncWriteSyncer{WS: w, QueueSize: 2, Overflow: tt.policy}
+
+			_, err := ws.Write([]byte("a"))
+			require.NoError(t, err, "Unexpected error writing.")
+			<-w.started
+
+			for _, s := range []string{"b", "c", "d", "e"} {
+				n, err := ws.Write([]byte(s))
+				require.NoError(t, err, "Unexpected error writing.")
+				assert.Equal(t, 1, n, "Expected dropped writes to be reported as complete.")
+			}
+
+			w.open()
+			require.NoError(t, ws.Stop(context.Background()), "Unexpected error stopping.")
+			assert.Equal(t, tt.want, w.String(), "Unexpected output.")
+			assert.Equal(t, AsyncStats{
+				Written:        3,
+				DroppedEntries: tt.wantDropped,
+				DroppedBytes:   tt.wantDropped,
+			}, ws.Stats(), "Unexpected stats.")
+		})
+	}
+}
+
+func TestAsyncWriteSyncerBlocks(t *testing.T) {
+	w := newGatedWriter()
+	ws := &AsyncWriteSyncer{WS: w, QueueSize: 1}
+
+	_, err := ws.Write([]byte("a"))
+	require.NoError(t, err, "Unexpected error writing.")
+	<-w.started
+	_, err = ws.Write([]byte("b"))
+	require.NoError(t, err, "Unexpected error writing.")
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		ws.Write([]byte("c"))
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("Expected write to block while the queue is full.")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	w.open()
+	<-written
+	require.NoError(t, ws.Stop(context.Background()), "Unexpected error stopping.")
+	assert.Equal(t, "abc", w.String(), "Unexpected output.")
+	assert.Equal(t, AsyncStats{Written: 3}, ws.Stats(), "Unexpected stats.")
+}
+
+func TestAsyncWriteSyncerDropBelowLevel(t *testing.T) {
+	w := newGatedWriter()
+	ws := &AsyncWriteSyncer{
+		WS:        w,
+		QueueSize: 1,
+		Overflow:  OverflowDropBelowLevel,
+		DropLevel: WarnLevel,
+	}
+	core := NewCore(NewConsoleEncoder(EncoderConfig{MessageKey: "M"}), ws, DebugLevel)
+
+	require.NoError(t, core.Write(Entry{Level: InfoLevel, Message: "a"}, nil))
+	<-w.started
+	require.NoError(t, core.Write(Entry{Level: InfoLevel, Message: "b"}, nil))
+
+	// The queue is full: lower-level entries are dropped...
+	require.NoError(t, core.Write(Entry{Level: DebugLevel, Message: "c"}, nil))
+	require.NoError(t, core.Write(Entry{Level: InfoLevel, Message: "d"}, nil))
+
+	// ...but more important ones wait for room.
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		core.Write(Entry{Level: ErrorLevel, Message: "e"}, nil)
+	}()
+
+	w.open()
+	<-written
+	require.NoError(t, ws.Stop(context.Background()), "Unexpected error stopping.")
+	assert.Equal(t, "a\nb\ne\n", w.String(), "Unexpected output.")
+	assert.Equal(t, AsyncStats{Written: 3, DroppedEntries: 2, DroppedBytes: 4}, ws.Stats(), "Unexpected stats.")
+}
+
+func TestAsyncWriteSyncerStopDeadline(t *testing.T) {
+	w := newGatedWriter()
+	ws := &AsyncWriteSyncer{WS: w}
+
+	for _, s := range []string{"a", "b", "c"} {
+		_, err := ws.Write([]byte(s))
+		require.NoError(t, err, "Unexpected error writing.")
+	}
+	<-w.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ws.Stop(ctx), "Expected Stop to give up at the deadline.")
+
+	// Let the write in progress finish, so that the write loop can discard
+	// the rest of the queue and exit.
+	w.open()
+	<-ws.done
+	assert.Equal(t, "a", w.String(), "Unexpected output.")
+	assert.Equal(t, AsyncStats{Written: 1, DroppedEntries: 2, DroppedBytes: 2}, ws.Stats(), "Unexpected stats.")
+}
+
+func TestAsyncWriteSyncerErrors(t *testing.T) {
+	t.Run("write", func(t *testing.T) {
+		ws := &AsyncWriteSyncer{WS: &ztest.FailWriter{}}
+		_, err := ws.Write([]byte("foo"))
+		require.NoError(t, err, "Expected write errors to be deferred.")
+		assert.Error(t, ws.Sync(), "Expected Sync to report the write error.")
+		assert.NoError(t, ws.Sync(), "Expected the write error to be reported only once.")
+
+		_, err = ws.Write([]byte("foo"))
+		require.NoError(t, err, "Expected write errors to be deferred.")
+		assert.Error(t, ws.Stop(context.Background()), "Expected Stop to report the write error.")
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		failer := &ztest.Buffer{}
+		failer.SetError(errors.New("sync failed"))
+		ws := &AsyncWriteSyncer{WS: failer}
+		_, err := ws.Write([]byte("foo"))
+		require.NoError(t, err, "Unexpected error writing.")
+		assert.EqualError(t, ws.Stop(context.Background()), "sync failed")
+	})
+}
+
+func TestOverflowPolicyString(t *testing.T) {
+	tests := map[OverflowPolicy]string{
+		OverflowBlock:          "block",
+		OverflowDropNewest:     "drop-newest",
+		OverflowDropOldest:     "drop-oldest",
+		OverflowDropBelowLevel: "drop-below-level",
+		OverflowPolicy(42):     "OverflowPolicy(42)",
+	}
+	for policy, want := range tests {
+		assert.Equal(t, want, policy.String(), "Unexpected string for policy.")
+	}
+}
@@ -0,0 +1,573 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// CBOR major types, shifted into the high bits of the initial byte. See RFC
+// 8949, section 3.
+const (
+	_cborUint   = 0 << 5
+	_cborNegint = 1 << 5
+	_cborBytes  = 2 << 5
+	_cborText   = 3 << 5
+	_cborArray  = 4 << 5
+	_cborMap    = 5 << 5
+	_cborTag    = 6 << 5
+)
+
+// Initial bytes of CBOR simple values and indefinite-length items.
+const (
+	_cborFalse      = 0xf4
+	_cborTrue       = 0xf5
+	_cborNull       = 0xf6
+	_cborFloat32    = 0xfa
+	_cborFloat64    = 0xfb
+	_cborIndefArray = 0x9f
+	_cborIndefMap   = 0xbf
+	_cborBreak      = 0xff
+)
+
+// CBOR tags used by the encoder.
+const (
+	// _cborTagDateTime marks an RFC 3339 date/time string.
+	_cborTagDateTime = 0
+	// _cborTagEpoch marks a number of seconds since the Unix epoch.
+	_cborTagEpoch = 1
+	// _cborTagBase64 marks a byte string that's expected to be converted to
+	// base64 when converted to JSON.
+	_cborTagBase64 = 22
+)
+
+var _cborPool = sync.Pool{New: func() interface{} {
+	return &cborEncoder{}
+}}
+
+func getCBOREncoder() *cborEncoder {
+	return _cborPool.Get().(*cborEncoder)
+}
+
+func putCBOREncoder(enc *cborEncoder) {
+	if enc.reflectBuf != nil {
+		enc.reflectBuf.Free()
+	}
+	enc.EncoderConfig = nil
+	enc.buf = nil
+	enc.openNamespaces = 0
+	enc.reflectBuf = nil
+	enc.reflectEnc = nil
+	_cborPool.Put(enc)
+}
+
+type cborEncoder struct {
+	*EncoderConfig
+	buf            *buffer.Buffer
+	openNamespaces int
+
+	// for encoding generic values by reflection
+	reflectBuf *buffer.Buffer
+	reflectEnc ReflectedEncoder
+}
+
+// NewCBOREncoder creates a fast, low-allocation encoder whose output is a
+// sequence of CBOR (RFC 8949) maps, one per entry.
+//
+// Entry metadata is encoded under the keys configured in the EncoderConfig,
+// and fields use the CBOR types that best describe them. Times are tagged:
+// times with whole seconds use tag 1 with an integer number of seconds
+// since the epoch, while other times use tag 0 with an RFC 3339 string to
+// keep their full precision. Binary fields are byte strings with tag 22,
+// which asks converters to JSON to use base64, as the JSON encoder does.
+// Durations are integer nanoseconds, complex numbers are arrays of their
+// real and imaginary parts, and nested objects and namespaces are nested
+// maps. Reflected values are encoded with the EncoderConfig's
+// NewReflectedEncoder and converted from JSON to the equivalent CBOR types.
+//
+// Strings and byte strings are text strings, which CBOR requires to be
+// valid UTF-8, so invalid sequences are replaced with U+FFFD, as in the JSON
+// encoder.
+//
+// Maps and arrays use the indefinite-length encoding, so that they can be
+// written without knowing their size in advance.
+//
+// The EncodeLevel, EncodeName and EncodeCaller functions of the
+// EncoderConfig are honored. EncodeTime, EncodeDuration and LineEnding are
+// ignored.
+func NewCBOREncoder(cfg EncoderConfig) Encoder {
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+	return &cborEncoder{
+		EncoderConfig: &cfg,
+		buf:           bufferpool.Get(),
+	}
+}
+
+func (enc *cborEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.appendText(key)
+	return enc.AppendArray(arr)
+}
+
+func (enc *cborEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.appendText(key)
+	return enc.AppendObject(obj)
+}
+
+func (enc *cborEncoder) AddBinary(key string, val []byte) {
+	enc.appendText(key)
+	enc.appendHead(_cborTag, _cborTagBase64)
+	enc.appendHead(_cborBytes, uint64(len(val)))
+	enc.buf.Write(val)
+}
+
+func (enc *cborEncoder) AddByteString(key string, val []byte) {
+	enc.appendText(key)
+	enc.AppendByteString(val)
+}
+
+func (enc *cborEncoder) AddBool(key string, val bool) {
+	enc.appendText(key)
+	enc.AppendBool(val)
+}
+
+func (enc *cborEncoder) AddComplex128(key string, val complex128) {
+	enc.appendText(key)
+	enc.AppendComplex128(val)
+}
+
+func (enc *cborEncoder) AddDuration(key string, val time.Duration) {
+	enc.appendText(key)
+	enc.AppendDuration(val)
+}
+
+func (enc *cborEncoder) AddFloat64(key string, val float64) {
+	enc.appendText(key)
+	enc.AppendFloat64(val)
+}
+
+func (enc *cborEncoder) AddFloat32(key string, val float32) {
+	enc.appendText(key)
+	enc.AppendFloat32(val)
+}
+
+func (enc *cborEncoder) AddInt64(key string, val int64) {
+	enc.appendText(key)
+	enc.AppendInt64(val)
+}
+
+func (enc *cborEncoder) AddReflected(key string, obj interface{}) error {
+	valueBytes, err := enc.encodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.appendText(key)
+	return enc.appendJSON(valueBytes)
+}
+
+func (enc *cborEncoder) OpenNamespace(key string) {
+	enc.appendText(key)
+	enc.buf.AppendByte(_cborIndefMap)
+	enc.openNamespaces++
+}
+
+func (enc *cborEncoder) AddString(key, val string) {
+	enc.appendText(key)
+	enc.appendText(val)
+}
+
+func (enc *cborEncoder) AddTime(key string, val time.Time) {
+	enc.appendText(key)
+	enc.AppendTime(val)
+}
+
+func (enc *cborEncoder) AddUint64(key string, val uint64) {
+	enc.appendText(key)
+	enc.AppendUint64(val)
+}
+
+func (enc *cborEncoder) AppendArray(arr ArrayMarshaler) error {
+	enc.buf.AppendByte(_cborIndefArray)
+	err := arr.MarshalLogArray(enc)
+	enc.buf.AppendByte(_cborBreak)
+	return err
+}
+
+func (enc *cborEncoder) AppendObject(obj ObjectMarshaler) error {
+	// Close ONLY new openNamespaces that are created during
+	// AppendObject().
+	old := enc.openNamespaces
+	enc.openNamespaces = 0
+	enc.buf.AppendByte(_cborIndefMap)
+	err := obj.MarshalLogObject(enc)
+	enc.closeOpenNamespaces()
+	enc.buf.AppendByte(_cborBreak)
+	enc.openNamespaces = old
+	return err
+}
+
+func (enc *cborEncoder) AppendBool(val bool) {
+	if val {
+		enc.buf.AppendByte(_cborTrue)
+	} else {
+		enc.buf.AppendByte(_cborFalse)
+	}
+}
+
+func (enc *cborEncoder) AppendByteString(val []byte) {
+	if utf8.Valid(val) {
+		enc.appendHead(_cborText, uint64(len(val)))
+		enc.buf.Write(val)
+		return
+	}
+
+	// Invalid bytes are replaced with U+FFFD, which takes three bytes.
+	n := 0
+	for i := 0; i < len(val); {
+		r, size := utf8.DecodeRune(val[i:])
+		if r == utf8.RuneError && size == 1 {
+			n += utf8.RuneLen(utf8.RuneError)
+		} else {
+			n += size
+		}
+		i += size
+	}
+	enc.appendHead(_cborText, uint64(n))
+	for i := 0; i < len(val); {
+		r, size := utf8.DecodeRune(val[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+		} else {
+			enc.buf.Write(val[i : i+size])
+		}
+		i += size
+	}
+}
+
+func (enc *cborEncoder) AppendComplex128(val complex128) {
+	enc.appendHead(_cborArray, 2)
+	enc.AppendFloat64(real(val))
+	enc.AppendFloat64(imag(val))
+}
+
+func (enc *cborEncoder) AppendDuration(val time.Duration) {
+	enc.AppendInt64(int64(val))
+}
+
+func (enc *cborEncoder) AppendInt64(val int64) {
+	if val < 0 {
+		// Negative integers are encoded as -1 - n.
+		enc.appendHead(_cborNegint, uint64(-1-val))
+		return
+	}
+	enc.appendHead(_cborUint, uint64(val))
+}
+
+func (enc *cborEncoder) AppendFloat32(val float32) {
+	enc.buf.AppendByte(_cborFloat32)
+	enc.appendUint32(math.Float32bits(val))
+}
+
+func (enc *cborEncoder) AppendFloat64(val float64) {
+	enc.buf.AppendByte(_cborFloat64)
+	enc.appendUint64(math.Float64bits(val))
+}
+
+func (enc *cborEncoder) AppendReflected(val interface{}) error {
+	valueBytes, err := enc.encodeReflected(val)
+	if err != nil {
+		return err
+	}
+	return enc.appendJSON(valueBytes)
+}
+
+func (enc *cborEncoder) AppendString(val string) {
+	enc.appendText(val)
+}
+
+func (enc *cborEncoder) AppendTimeLayout(val time.Time, layout string) {
+	tmp := bufferpool.Get()
+	tmp.AppendTime(val, layout)
+	enc.AppendByteString(tmp.Bytes())
+	tmp.Free()
+}
+
+func (enc *cborEncoder) AppendTime(val time.Time) {
+	if val.Nanosecond() == 0 {
+		enc.appendHead(_cborTag, _cborTagEpoch)
+		enc.AppendInt64(val.Unix())
+		return
+	}
+	enc.appendHead(_cborTag, _cborTagDateTime)
+	tmp := bufferpool.Get()
+	tmp.AppendTime(val, time.RFC3339Nano)
+	enc.AppendByteString(tmp.Bytes())
+	tmp.Free()
+}
+
+func (enc *cborEncoder) AppendUint64(val uint64) {
+	enc.appendHead(_cborUint, val)
+}
+
+func (enc *cborEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *cborEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *cborEncoder) AppendInt(v int)                    { enc.AppendInt64(int64(v)) }
+func (enc *cborEncoder) AppendInt32(v int32)                { enc.AppendInt64(int64(v)) }
+func (enc *cborEncoder) AppendInt16(v int16)                { enc.AppendInt64(int64(v)) }
+func (enc *cborEncoder) AppendInt8(v int8)                  { enc.AppendInt64(int64(v)) }
+func (enc *cborEncoder) AppendUint(v uint)                  { enc.AppendUint64(uint64(v)) }
+func (enc *cborEncoder) AppendUint32(v uint32)              { enc.AppendUint64(uint64(v)) }
+func (enc *cborEncoder) AppendUint16(v uint16)              { enc.AppendUint64(uint64(v)) }
+func (enc *cborEncoder) AppendUint8(v uint8)                { enc.AppendUint64(uint64(v)) }
+func (enc *cborEncoder) AppendUintptr(v uintptr)            { enc.AppendUint64(uint64(v)) }
+
+func (enc *cborEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+func (enc *cborEncoder) clone() *cborEncoder {
+	clone := getCBOREncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.openNamespaces = enc.openNamespaces
+	clone.buf = bufferpool.Get()
+	return clone
+}
+
+func (enc *cborEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+	final.buf.AppendByte(_cborIndefMap)
+
+	if final.LevelKey != "" && final.EncodeLevel != nil {
+		final.appendText(final.LevelKey)
+		cur := final.buf.Len()
+		final.EncodeLevel(ent.Level, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to
+			// keep output valid.
+			final.appendText(ent.Level.String())
+		}
+	}
+	if final.TimeKey != "" {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.appendText(final.NameKey)
+		cur := final.buf.Len()
+		nameEncoder := final.EncodeName
+
+		// if no name encoder provided, fall back to FullNameEncoder for backwards
+		// compatibility
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+
+		nameEncoder(ent.LoggerName, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeName was a no-op. Fall back to strings to
+			// keep output valid.
+			final.appendText(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" && final.EncodeCaller != nil {
+			final.appendText(final.CallerKey)
+			cur := final.buf.Len()
+			final.EncodeCaller(ent.Caller, final)
+			if cur == final.buf.Len() {
+				// User-supplied EncodeCaller was a no-op. Fall back to strings to
+				// keep output valid.
+				final.appendText(ent.Caller.String())
+			}
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	if final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+	final.buf.Write(enc.buf.Bytes())
+	addFields(final, fields)
+	final.closeOpenNamespaces()
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	final.buf.AppendByte(_cborBreak)
+
+	ret := final.buf
+	putCBOREncoder(final)
+	return ret, nil
+}
+
+func (enc *cborEncoder) closeOpenNamespaces() {
+	for i := 0; i < enc.openNamespaces; i++ {
+		enc.buf.AppendByte(_cborBreak)
+	}
+	enc.openNamespaces = 0
+}
+
+// appendHead writes the head of a data item: its major type, and an
+// argument using the shortest encoding that fits.
+func (enc *cborEncoder) appendHead(major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		enc.buf.AppendByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		enc.buf.AppendByte(major | 24)
+		enc.buf.AppendByte(byte(arg))
+	case arg <= math.MaxUint16:
+		enc.buf.AppendByte(major | 25)
+		enc.buf.AppendByte(byte(arg >> 8))
+		enc.buf.AppendByte(byte(arg))
+	case arg <= math.MaxUint32:
+		enc.buf.AppendByte(major | 26)
+		enc.appendUint32(uint32(arg))
+	default:
+		enc.buf.AppendByte(major | 27)
+		enc.appendUint64(arg)
+	}
+}
+
+// appendText writes a text string, replacing invalid UTF-8 with U+FFFD.
+func (enc *cborEncoder) appendText(s string) {
+	if !utf8.ValidString(s) {
+		// Rare, so the conversion's allocation doesn't matter.
+		enc.AppendByteString([]byte(s))
+		return
+	}
+	enc.appendHead(_cborText, uint64(len(s)))
+	enc.buf.AppendString(s)
+}
+
+func (enc *cborEncoder) appendUint32(v uint32) {
+	enc.buf.AppendByte(byte(v >> 24))
+	enc.buf.AppendByte(byte(v >> 16))
+	enc.buf.AppendByte(byte(v >> 8))
+	enc.buf.AppendByte(byte(v))
+}
+
+func (enc *cborEncoder) appendUint64(v uint64) {
+	enc.appendUint32(uint32(v >> 32))
+	enc.appendUint32(uint32(v))
+}
+
+// Only invoke the standard JSON encoder if there is actually something to
+// encode; otherwise write JSON null literal directly.
+func (enc *cborEncoder) encodeReflected(obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nullLiteralBytes, nil
+	}
+	if enc.reflectBuf == nil {
+		enc.reflectBuf = bufferpool.Get()
+		enc.reflectEnc = enc.NewReflectedEncoder(enc.reflectBuf)
+	} else {
+		enc.reflectBuf.Reset()
+	}
+	if err := enc.reflectEnc.Encode(obj); err != nil {
+		return nil, err
+	}
+	enc.reflectBuf.TrimNewline()
+	return enc.reflectBuf.Bytes(), nil
+}
+
+// appendJSON converts a JSON value to CBOR. Values that aren't valid JSON,
+// which a custom ReflectedEncoder may produce, are written as text.
+func (enc *cborEncoder) appendJSON(data []byte) error {
+	if !json.Valid(data) {
+		enc.AppendByteString(data)
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return enc.appendJSONValue(dec)
+}
+
+func (enc *cborEncoder) appendJSONValue(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			enc.buf.AppendByte(_cborIndefMap)
+		} else {
+			enc.buf.AppendByte(_cborIndefArray)
+		}
+		for dec.More() {
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				enc.appendText(key.(string))
+			}
+			if err := enc.appendJSONValue(dec); err != nil {
+				return err
+			}
+		}
+		enc.buf.AppendByte(_cborBreak)
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		return err
+	case string:
+		enc.appendText(tok)
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			enc.AppendInt64(i)
+		} else if u, err := strconv.ParseUint(tok.String(), 10, 64); err == nil {
+			enc.AppendUint64(u)
+		} else if f, err := tok.Float64(); err == nil {
+			enc.AppendFloat64(f)
+		} else {
+			enc.appendText(tok.String())
+		}
+	case bool:
+		enc.AppendBool(tok)
+	case nil:
+		enc.buf.AppendByte(_cborNull)
+	default:
+		return fmt.Errorf("unexpected JSON token %v", tok)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcbor"
+	"go.uber.org/zap/zapcore"
+)
+
+func decodeCBOREntry(t testing.TB, enc zapcore.Encoder, ent zapcore.Entry, fields ...zapcore.Field) map[string]interface{} {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+
+	entries, err := zapcbor.DecodeAll(buf.Bytes())
+	require.NoError(t, err, "Unexpected error decoding entry.")
+	require.Len(t, entries, 1, "Expected a single entry.")
+	return entries[0]
+}
+
+func TestCBOREncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891000000, time.UTC)
+	enc := zapcore.NewCBOREncoder(zap.NewProductionEncoderConfig())
+	ent := zapcore.Entry{
+		Level:      zapcore.ErrorLevel,
+		Time:       ts,
+		LoggerName: "svc",
+		Message:    "hello",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+		Stack:      "goroutine 1",
+	}
+
+	got := decodeCBOREntry(t, enc, ent, zap.String("k", "v"))
+	assert.Equal(t, map[string]interface{}{
+		"level":      "error",
+		"ts":         ts,
+		"logger":     "svc",
+		"caller":     "app/main.go:42",
+		"msg":        "hello",
+		"k":          "v",
+		"stacktrace": "goroutine 1",
+	}, got, "Unexpected entry.")
+}
+
+func TestCBOREncoderWireFormat(t *testing.T) {
+	enc := zapcore.NewCBOREncoder(zapcore.EncoderConfig{MessageKey: "m"})
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "hi"}, []zapcore.Field{
+		zap.Time("t", time.Unix(1363896240, 0)),
+		zap.Time("tn", time.Date(2013, time.March, 21, 20, 4, 0, 500000000, time.UTC)),
+		zap.Binary("b", []byte{1, 2}),
+		zap.Int("n", -500),
+	})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+
+	want := "bf" + // indefinite-length map
+		"616d" + "626869" + // "m": "hi"
+		"6174" + "c11a514b67b0" + // "t": 1(1363896240)
+		"62746e" + "c0" + "76" + hex.EncodeToString([]byte("2013-03-21T20:04:00.5Z")) + // "tn": 0("...")
+		"6162" + "d6" + "420102" + // "b": 22(h'0102')
+		"616e" + "3901f3" + // "n": -500
+		"ff"
+	assert.Equal(t, want, hex.EncodeToString(buf.Bytes()), "Unexpected encoding.")
+}
+
+func TestCBOREncoderInvalidUTF8(t *testing.T) {
+	enc := zapcore.NewCBOREncoder(zapcore.EncoderConfig{})
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, []zapcore.Field{
+		zap.String("s", "a\xffb"),
+		zap.ByteString("b", []byte("\xc3")),
+		zap.String("\xfe", "héllo"),
+	})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+
+	want := "bf" + // indefinite-length map
+		"6173" + "65" + hex.EncodeToString([]byte("a\ufffdb")) + // "s": "a\ufffdb"
+		"6162" + "63" + hex.EncodeToString([]byte("\ufffd")) + // "b": "\ufffd"
+		"63" + hex.EncodeToString([]byte("\ufffd")) + "66" + hex.EncodeToString([]byte("héllo")) + // "\ufffd": "héllo"
+		"ff"
+	assert.Equal(t, want, hex.EncodeToString(buf.Bytes()), "Unexpected encoding.")
+}
+
+func TestCBOREncoderFields(t *testing.T) {
+	enc := zapcore.NewCBOREncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	got := decodeCBOREntry(t, enc, zapcore.Entry{Message: "hello"},
+		zap.Bool("bool", true),
+		zap.Int("small", 7),
+		zap.Int("negative", -25),
+		zap.Int64("int64", math.MinInt64),
+		zap.Uint8("uint8", math.MaxUint8),
+		zap.Uint16("uint16", math.MaxUint16),
+		zap.Uint32("uint32", math.MaxUint32),
+		zap.Uint64("uint64", math.MaxUint64),
+		zap.Float32("float32", 1.5),
+		zap.Float64("float64", 2.5),
+		zap.Complex128("complex", 1-2i),
+		zap.Duration("dur", time.Second),
+		zap.Time("zone", time.Date(2022, time.March, 4, 5, 6, 7, 8, time.FixedZone("", 3600))),
+		zap.Binary("binary", []byte{0, 1, 2}),
+		zap.ByteString("bytes", []byte("foo")),
+		zap.String("long", string(make([]byte, 300))),
+		zap.Error(errors.New("oh no")),
+		zap.Reflect("reflect", map[string]interface{}{
+			"list": []interface{}{1, -1, 1.5, "a", true, nil},
+			"big":  uint64(math.MaxUint64),
+		}),
+		zap.Reflect("nil", nil),
+	)
+
+	assert.Equal(t, map[string]interface{}{
+		"msg":      "hello",
+		"bool":     true,
+		"small":    int64(7),
+		"negative": int64(-25),
+		"int64":    int64(math.MinInt64),
+		"uint8":    int64(math.MaxUint8),
+		"uint16":   int64(math.MaxUint16),
+		"uint32":   int64(math.MaxUint32),
+		"uint64":   uint64(math.MaxUint64),
+		"float32":  float32(1.5),
+		"float64":  2.5,
+		"complex":  []interface{}{1.0, -2.0},
+		"dur":      int64(time.Second),
+		"zone":     time.Date(2022, time.March, 4, 5, 6, 7, 8, time.FixedZone("", 3600)),
+		"binary":   []byte{0, 1, 2},
+		"bytes":    "foo",
+		"long":     string(make([]byte, 300)),
+		"error":    "oh no",
+		"reflect": map[string]interface{}{
+			"list": []interface{}{int64(1), int64(-1), 1.5, "a", true, nil},
+			"big":  uint64(math.MaxUint64),
+		},
+		"nil": nil,
+	}, got, "Unexpected fields.")
+}
+
+func TestCBOREncoderNesting(t *testing.T) {
+	enc := zapcore.NewCBOREncoder(zapcore.EncoderConfig{MessageKey: "msg", StacktraceKey: "stack"})
+	enc.AddString("ctx", "a")
+	enc.OpenNamespace("ns")
+	enc.AddString("inner", "b")
+
+	clone := enc.Clone()
+	clone.AddString("clone", "c")
+
+	got := decodeCBOREntry(t, enc, zapcore.Entry{Message: "hello", Stack: "trace"},
+		zap.Object("obj", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddInt("n", 1)
+			enc.OpenNamespace("deeper")
+			enc.AddInt("m", 2)
+			return nil
+		})),
+		zap.Array("arr", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			enc.AppendString("x")
+			enc.AppendDuration(time.Millisecond)
+			if err := enc.AppendArray(zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+				enc.AppendBool(false)
+				return nil
+			})); err != nil {
+				return err
+			}
+			return enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("k", "v")
+				return nil
+			}))
+		})),
+		zap.Ints("empty", nil),
+		zap.String("after", "d"),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"msg": "hello",
+		"ctx": "a",
+		"ns": map[string]interface{}{
+			"inner": "b",
+			"obj": map[string]interface{}{
+				"n":      int64(1),
+				"deeper": map[string]interface{}{"m": int64(2)},
+			},
+			"arr": []interface{}{
+				"x",
+				int64(time.Millisecond),
+				[]interface{}{false},
+				map[string]interface{}{"k": "v"},
+			},
+			"empty": []interface{}{},
+			"after": "d",
+		},
+		"stack": "trace",
+	}, got, "Unexpected entry from original encoder.")
+
+	got = decodeCBOREntry(t, clone, zapcore.Entry{Message: "hello"})
+	assert.Equal(t, map[string]interface{}{
+		"msg": "hello",
+		"ctx": "a",
+		"ns":  map[string]interface{}{"inner": "b", "clone": "c"},
+	}, got, "Unexpected entry from clone.")
+}
+
+func TestCBOREncoderUserEncoders(t *testing.T) {
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		LoggerName: "svc",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+	}
+	tests := []struct {
+		desc string
+		cfg  zapcore.EncoderConfig
+		want map[string]interface{}
+	}{
+		{
+			desc: "custom",
+			cfg: zapcore.EncoderConfig{
+				LevelKey:     "L",
+				NameKey:      "N",
+				CallerKey:    "C",
+				FunctionKey:  "F",
+				EncodeLevel:  zapcore.CapitalLevelEncoder,
+				EncodeCaller: zapcore.FullCallerEncoder,
+				EncodeName: func(name string, enc zapcore.PrimitiveArrayEncoder) {
+					enc.AppendString("name:" + name)
+				},
+			},
+			want: map[string]interface{}{
+				"L": "WARN",
+				"N": "name:svc",
+				"C": "/src/app/main.go:42",
+				"F": "",
+			},
+		},
+		{
+			desc: "no-op",
+			cfg: zapcore.EncoderConfig{
+				LevelKey:     "L",
+				NameKey:      "N",
+				CallerKey:    "C",
+				EncodeLevel:  func(zapcore.Level, zapcore.PrimitiveArrayEncoder) {},
+				EncodeName:   func(string, zapcore.PrimitiveArrayEncoder) {},
+				EncodeCaller: func(zapcore.EntryCaller, zapcore.PrimitiveArrayEncoder) {},
+			},
+			want: map[string]interface{}{
+				"L": "warn",
+				"N": "svc",
+				"C": "/src/app/main.go:42",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewCBOREncoder(tt.cfg)
+			assert.Equal(t, tt.want, decodeCBOREntry(t, enc, ent), "Unexpected entry.")
+		})
+	}
+}
+
+func TestCBOREncoderReflectedError(t *testing.T) {
+	enc := zapcore.NewCBOREncoder(zapcore.EncoderConfig{})
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error encoding a channel.")
+}
+
+func TestCBOREncoderAllocs(t *testing.T) {
+	if ztest.RaceEnabled {
+		t.Skip("Skipping allocation test under the race detector.")
+	}
+
+	enc := zapcore.NewCBOREncoder(zap.NewProductionEncoderConfig())
+	enc.AddString("service", "api")
+	enc.OpenNamespace("ns")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "hello world"}
+	fields := []zapcore.Field{
+		zap.String("str", "foo bar"),
+		zap.Int("int", 42),
+		zap.Ints("ints", []int{1, 2, 3}),
+		zap.Time("time", time.Now()),
+		zap.Bool("bool", true),
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	})
+	assert.Zero(t, allocs, "Expected encoding to be allocation-free.")
+}
@@ -0,0 +1,714 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"encoding/base64"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// _leefTimeFormat is the default format of LEEF's devTime attribute, which
+// QRadar parses without a devTimeFormat.
+const _leefTimeFormat = "Jan 02 2006 15:04:05.000 MST"
+
+var _cefPool = sync.Pool{New: func() interface{} {
+	return &cefEncoder{}
+}}
+
+func getCEFEncoder() *cefEncoder {
+	return _cefPool.Get().(*cefEncoder)
+}
+
+func putCEFEncoder(enc *cefEncoder) {
+	if enc.reflectBuf != nil {
+		enc.reflectBuf.Free()
+	}
+	enc.EncoderConfig = nil
+	enc.leef = false
+	enc.buf = nil
+	enc.extStart = 0
+	enc.prefix = enc.prefix[:0]
+	enc.arrIndex = -1
+	enc.reflectBuf = nil
+	enc.reflectEnc = nil
+	_cefPool.Put(enc)
+}
+
+// cefSeverity maps a Level to a CEF severity or LEEF sev, from 0 (lowest) to
+// 10 (highest).
+func cefSeverity(l Level) int {
+	switch l {
+	case DebugLevel:
+		return 1
+	case InfoLevel:
+		return 3
+	case WarnLevel:
+		return 5
+	case ErrorLevel:
+		return 7
+	case DPanicLevel:
+		return 8
+	case PanicLevel:
+		return 9
+	case FatalLevel:
+		return 10
+	default:
+		return 5
+	}
+}
+
+// cefEncoder writes CEF events or, if leef is set, LEEF events. The formats
+// differ only in their headers and in the separator between extension
+// key-value pairs.
+type cefEncoder struct {
+	*EncoderConfig
+	leef bool
+	buf  *buffer.Buffer
+	// extStart is the offset in buf at which the extension starts, after the
+	// header.
+	extStart int
+
+	// prefix is prepended to keys added in namespaces, nested objects and
+	// arrays. Each element of the prefix is followed by a '.'.
+	prefix []byte
+	// arrIndex is the index of the next element when encoding an array.
+	// It's -1 when the Append methods write the value of a single key, as
+	// they do for the user-supplied time, duration and caller encoders.
+	arrIndex int
+
+	// for encoding generic values by reflection
+	reflectBuf *buffer.Buffer
+	reflectEnc ReflectedEncoder
+}
+
+// NewCEFEncoder creates an encoder whose output is an ArcSight Common Event
+// Format (CEF) event, such as
+//
+//   CEF:0|Acme|auth-service|1.4|auth.login|login failed|5|rt=1646370367891 suser=bob src=10.0.0.1
+//
+// The header identifies the application with the DeviceVendor,
+// DeviceProduct, and DeviceVersion of the EncoderConfig. The logger's name is
+// the Device Event Class ID (or the message, for unnamed loggers), the
+// message is the Name, and the level is mapped to a severity from 1 (debug)
+// to 10 (fatal). Pipes and backslashes in the header are escaped with
+// backslashes.
+//
+// The extension holds the entry's time as the rt key, in milliseconds since
+// the epoch, followed by the logger name, caller, function, fields, and stack
+// trace as space-separated key=value pairs, using the keys and encoders from
+// the EncoderConfig. Equals signs and backslashes in values are escaped with
+// backslashes, and newlines are written as \n and \r. Keys may only contain
+// letters, digits, '_', '.', and '-', so other characters are replaced with
+// '_'. As in the logfmt encoder, nested objects, namespaces, and arrays are
+// flattened into dotted keys, and reflected values are encoded as JSON.
+func NewCEFEncoder(cfg EncoderConfig) Encoder {
+	return newCEFEncoder(cfg, false)
+}
+
+// NewLEEFEncoder creates an encoder whose output is an IBM QRadar Log Event
+// Extended Format (LEEF) 1.0 event, such as
+//
+//   LEEF:1.0|Acme|auth-service|1.4|auth.login|devTime=Mar 04 2022 05:06:07.891 UTC	sev=5	msg=login failed	usrName=bob
+//
+// The header is the same as a CEF header, without the Name and severity.
+// Attributes are separated by tabs, which are escaped in values as \t, and
+// are otherwise encoded as in NewCEFEncoder, except that the entry's time is
+// written to devTime in LEEF's default time format, its severity to sev, and
+// its message to the EncoderConfig's MessageKey.
+func NewLEEFEncoder(cfg EncoderConfig) Encoder {
+	return newCEFEncoder(cfg, true)
+}
+
+func newCEFEncoder(cfg EncoderConfig, leef bool) *cefEncoder {
+	if cfg.SkipLineEnding {
+		cfg.LineEnding = ""
+	} else if cfg.LineEnding == "" {
+		cfg.LineEnding = DefaultLineEnding
+	}
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+	if cfg.DeviceProduct == "" {
+		cfg.DeviceProduct = filepath.Base(os.Args[0])
+	}
+
+	return &cefEncoder{
+		EncoderConfig: &cfg,
+		leef:          leef,
+		buf:           bufferpool.Get(),
+		arrIndex:      -1,
+	}
+}
+
+func (enc *cefEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushKey(key)
+	err := enc.marshalArray(arr)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *cefEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushKey(key)
+	err := enc.marshalObject(obj)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *cefEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *cefEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.appendByteString(val)
+}
+
+func (enc *cefEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.buf.AppendBool(val)
+}
+
+func (enc *cefEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.appendComplex128(val)
+}
+
+func (enc *cefEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.appendDuration(val)
+}
+
+func (enc *cefEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.appendFloat(val, 64)
+}
+
+func (enc *cefEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.appendFloat(float64(val), 32)
+}
+
+func (enc *cefEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.buf.AppendInt(val)
+}
+
+func (enc *cefEncoder) AddReflected(key string, obj interface{}) error {
+	valueBytes, err := enc.encodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	enc.appendByteString(valueBytes)
+	return nil
+}
+
+func (enc *cefEncoder) OpenNamespace(key string) {
+	enc.pushKey(key)
+}
+
+func (enc *cefEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.appendString(val)
+}
+
+func (enc *cefEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.appendTime(val)
+}
+
+func (enc *cefEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.buf.AppendUint(val)
+}
+
+func (enc *cefEncoder) AppendArray(arr ArrayMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushElement()
+	err := enc.marshalArray(arr)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *cefEncoder) AppendObject(obj ObjectMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushElement()
+	err := enc.marshalObject(obj)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *cefEncoder) AppendBool(val bool) {
+	enc.addElementKey()
+	enc.buf.AppendBool(val)
+}
+
+func (enc *cefEncoder) AppendByteString(val []byte) {
+	enc.addElementKey()
+	enc.appendByteString(val)
+}
+
+func (enc *cefEncoder) AppendComplex128(val complex128) {
+	enc.addElementKey()
+	enc.appendComplex128(val)
+}
+
+func (enc *cefEncoder) AppendDuration(val time.Duration) {
+	enc.addElementKey()
+	enc.appendDuration(val)
+}
+
+func (enc *cefEncoder) AppendInt64(val int64) {
+	enc.addElementKey()
+	enc.buf.AppendInt(val)
+}
+
+func (enc *cefEncoder) AppendReflected(val interface{}) error {
+	valueBytes, err := enc.encodeReflected(val)
+	if err != nil {
+		return err
+	}
+	enc.addElementKey()
+	enc.appendByteString(valueBytes)
+	return nil
+}
+
+func (enc *cefEncoder) AppendString(val string) {
+	enc.addElementKey()
+	enc.appendString(val)
+}
+
+func (enc *cefEncoder) AppendTimeLayout(val time.Time, layout string) {
+	enc.addElementKey()
+	tmp := bufferpool.Get()
+	tmp.AppendTime(val, layout)
+	enc.appendByteString(tmp.Bytes())
+	tmp.Free()
+}
+
+func (enc *cefEncoder) AppendTime(val time.Time) {
+	enc.addElementKey()
+	enc.appendTime(val)
+}
+
+func (enc *cefEncoder) AppendUint64(val uint64) {
+	enc.addElementKey()
+	enc.buf.AppendUint(val)
+}
+
+func (enc *cefEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *cefEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *cefEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *cefEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *cefEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *cefEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *cefEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *cefEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *cefEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *cefEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *cefEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *cefEncoder) AppendFloat64(v float64)            { enc.addElementKey(); enc.appendFloat(v, 64) }
+func (enc *cefEncoder) AppendFloat32(v float32) {
+	enc.addElementKey()
+	enc.appendFloat(float64(v), 32)
+}
+func (enc *cefEncoder) AppendInt(v int)         { enc.AppendInt64(int64(v)) }
+func (enc *cefEncoder) AppendInt32(v int32)     { enc.AppendInt64(int64(v)) }
+func (enc *cefEncoder) AppendInt16(v int16)     { enc.AppendInt64(int64(v)) }
+func (enc *cefEncoder) AppendInt8(v int8)       { enc.AppendInt64(int64(v)) }
+func (enc *cefEncoder) AppendUint(v uint)       { enc.AppendUint64(uint64(v)) }
+func (enc *cefEncoder) AppendUint32(v uint32)   { enc.AppendUint64(uint64(v)) }
+func (enc *cefEncoder) AppendUint16(v uint16)   { enc.AppendUint64(uint64(v)) }
+func (enc *cefEncoder) AppendUint8(v uint8)     { enc.AppendUint64(uint64(v)) }
+func (enc *cefEncoder) AppendUintptr(v uintptr) { enc.AppendUint64(uint64(v)) }
+
+func (enc *cefEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+func (enc *cefEncoder) clone() *cefEncoder {
+	clone := getCEFEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.leef = enc.leef
+	clone.prefix = append(clone.prefix[:0], enc.prefix...)
+	clone.arrIndex = -1
+	clone.buf = bufferpool.Get()
+	return clone
+}
+
+func (enc *cefEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+
+	eventID := ent.LoggerName
+	if eventID == "" {
+		eventID = ent.Message
+	}
+	if final.leef {
+		final.buf.AppendString("LEEF:1.0|")
+	} else {
+		final.buf.AppendString("CEF:0|")
+	}
+	final.appendHeader(final.DeviceVendor)
+	final.appendHeader(final.DeviceProduct)
+	final.appendHeader(final.DeviceVersion)
+	final.appendHeader(eventID)
+	if !final.leef {
+		final.appendHeader(ent.Message)
+		final.buf.AppendInt(int64(cefSeverity(ent.Level)))
+		final.buf.AppendByte('|')
+	}
+	final.extStart = final.buf.Len()
+
+	// Entry metadata goes first, outside of any namespaces.
+	n := len(final.prefix)
+	final.prefix = final.prefix[:0]
+	if final.TimeKey != "" && !ent.Time.IsZero() {
+		if final.leef {
+			final.addKey("devTime")
+			final.AppendTimeLayout(ent.Time, _leefTimeFormat)
+		} else {
+			final.AddInt64("rt", ent.Time.UnixNano()/int64(time.Millisecond))
+		}
+	}
+	if final.leef {
+		final.AddInt("sev", cefSeverity(ent.Level))
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		cur := final.buf.Len()
+		nameEncoder := final.EncodeName
+
+		// if no name encoder provided, fall back to FullNameEncoder for backwards
+		// compatibility
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+
+		nameEncoder(ent.LoggerName, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeName was a no-op. Fall back to strings to
+			// keep output valid.
+			final.appendString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" && final.EncodeCaller != nil {
+			final.addKey(final.CallerKey)
+			cur := final.buf.Len()
+			final.EncodeCaller(ent.Caller, final)
+			if cur == final.buf.Len() {
+				// User-supplied EncodeCaller was a no-op. Fall back to strings to
+				// keep output valid.
+				final.appendString(ent.Caller.String())
+			}
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	if final.leef && final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+	if enc.buf.Len() > 0 {
+		final.addSeparator()
+		final.buf.Write(enc.buf.Bytes())
+	}
+	final.prefix = final.prefix[:n]
+	addFields(final, fields)
+	final.prefix = final.prefix[:0]
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	final.buf.AppendString(final.LineEnding)
+
+	ret := final.buf
+	putCEFEncoder(final)
+	return ret, nil
+}
+
+// appendHeader writes a header field and the following '|', escaping pipes
+// and backslashes. Header fields can't span lines, so line breaks are
+// replaced with spaces.
+func (enc *cefEncoder) appendHeader(s string) {
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			switch b {
+			case '|', '\\':
+				enc.buf.AppendByte('\\')
+				enc.buf.AppendByte(b)
+			case '\n', '\r':
+				enc.buf.AppendByte(' ')
+			default:
+				enc.buf.AppendByte(b)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+		} else {
+			enc.buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+	enc.buf.AppendByte('|')
+}
+
+// addSeparator separates a key-value pair from the previous one, if any.
+func (enc *cefEncoder) addSeparator() {
+	if enc.buf.Len() <= enc.extStart {
+		return
+	}
+	if enc.leef {
+		enc.buf.AppendByte('\t')
+	} else {
+		enc.buf.AppendByte(' ')
+	}
+}
+
+// addKey starts a key=value pair with the given key, qualified by the
+// current prefix. The value must be written next.
+func (enc *cefEncoder) addKey(key string) {
+	enc.addSeparator()
+	enc.buf.Write(enc.prefix)
+	for i := 0; i < len(key); i++ {
+		enc.buf.AppendByte(cefKeyByte(key[i]))
+	}
+	if len(enc.prefix) == 0 && len(key) == 0 {
+		enc.buf.AppendByte('_')
+	}
+	enc.buf.AppendByte('=')
+}
+
+// addElementKey starts a key=value pair for the next array element. It does
+// nothing when the Append methods are writing the value of a single key.
+func (enc *cefEncoder) addElementKey() {
+	if enc.arrIndex < 0 {
+		return
+	}
+	enc.addSeparator()
+	enc.buf.Write(enc.prefix)
+	enc.buf.AppendInt(int64(enc.arrIndex))
+	enc.buf.AppendByte('=')
+	enc.arrIndex++
+}
+
+// pushKey adds a key to the prefix.
+func (enc *cefEncoder) pushKey(key string) {
+	for i := 0; i < len(key); i++ {
+		enc.prefix = append(enc.prefix, cefKeyByte(key[i]))
+	}
+	enc.prefix = append(enc.prefix, '.')
+}
+
+// pushElement adds the index of the next array element to the prefix.
+func (enc *cefEncoder) pushElement() {
+	if enc.arrIndex < 0 {
+		return
+	}
+	enc.prefix = strconv.AppendInt(enc.prefix, int64(enc.arrIndex), 10)
+	enc.prefix = append(enc.prefix, '.')
+	enc.arrIndex++
+}
+
+func (enc *cefEncoder) marshalArray(arr ArrayMarshaler) error {
+	old := enc.arrIndex
+	enc.arrIndex = 0
+	err := arr.MarshalLogArray(enc)
+	enc.arrIndex = old
+	return err
+}
+
+func (enc *cefEncoder) marshalObject(obj ObjectMarshaler) error {
+	old := enc.arrIndex
+	enc.arrIndex = -1
+	err := obj.MarshalLogObject(enc)
+	enc.arrIndex = old
+	return err
+}
+
+// appendTime writes a time with the user-supplied EncodeTime. The Append
+// methods called by EncodeTime write the value itself rather than array
+// elements.
+func (enc *cefEncoder) appendTime(val time.Time) {
+	old := enc.arrIndex
+	enc.arrIndex = -1
+	cur := enc.buf.Len()
+	if e := enc.EncodeTime; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		// User-supplied EncodeTime is a no-op. Fall back to nanos since epoch
+		// to keep output valid.
+		enc.buf.AppendInt(val.UnixNano())
+	}
+	enc.arrIndex = old
+}
+
+// appendDuration is the equivalent of appendTime for durations.
+func (enc *cefEncoder) appendDuration(val time.Duration) {
+	old := enc.arrIndex
+	enc.arrIndex = -1
+	cur := enc.buf.Len()
+	if e := enc.EncodeDuration; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		// User-supplied EncodeDuration is a no-op. Fall back to nanoseconds
+		// to keep output valid.
+		enc.buf.AppendInt(int64(val))
+	}
+	enc.arrIndex = old
+}
+
+func (enc *cefEncoder) appendComplex128(val complex128) {
+	// Cast to a platform-independent, fixed-size type.
+	r, i := float64(real(val)), float64(imag(val))
+	enc.buf.AppendFloat(r, 64)
+	// If imaginary part is less than 0, minus (-) sign is added by default
+	// by AppendFloat.
+	if i >= 0 {
+		enc.buf.AppendByte('+')
+	}
+	enc.buf.AppendFloat(i, 64)
+	enc.buf.AppendByte('i')
+}
+
+func (enc *cefEncoder) appendFloat(val float64, bitSize int) {
+	switch {
+	case math.IsNaN(val):
+		enc.buf.AppendString("NaN")
+	case math.IsInf(val, 1):
+		enc.buf.AppendString("+Inf")
+	case math.IsInf(val, -1):
+		enc.buf.AppendString("-Inf")
+	default:
+		enc.buf.AppendFloat(val, bitSize)
+	}
+}
+
+// Only invoke the standard JSON encoder if there is actually something to
+// encode; otherwise write JSON null literal directly.
+func (enc *cefEncoder) encodeReflected(obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nullLiteralBytes, nil
+	}
+	if enc.reflectBuf == nil {
+		enc.reflectBuf = bufferpool.Get()
+		enc.reflectEnc = enc.NewReflectedEncoder(enc.reflectBuf)
+	} else {
+		enc.reflectBuf.Reset()
+	}
+	if err := enc.reflectEnc.Encode(obj); err != nil {
+		return nil, err
+	}
+	enc.reflectBuf.TrimNewline()
+	return enc.reflectBuf.Bytes(), nil
+}
+
+// appendString writes an extension value, escaping it as necessary.
+func (enc *cefEncoder) appendString(s string) {
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+			i++
+			continue
+		}
+		enc.buf.AppendString(s[i : i+size])
+		i += size
+	}
+}
+
+// appendByteString is no-alloc equivalent of appendString(string(s)) for
+// s []byte.
+func (enc *cefEncoder) appendByteString(s []byte) {
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+			i++
+			continue
+		}
+		enc.buf.Write(s[i : i+size])
+		i += size
+	}
+}
+
+// tryAddRuneSelf appends b to an extension value, escaping it if necessary,
+// if it's a single-byte rune.
+func (enc *cefEncoder) tryAddRuneSelf(b byte) bool {
+	if b >= utf8.RuneSelf {
+		return false
+	}
+	switch b {
+	case '\\', '=':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte(b)
+	case '\n':
+		enc.buf.AppendString(`\n`)
+	case '\r':
+		enc.buf.AppendString(`\r`)
+	case '\t':
+		if enc.leef {
+			// Tabs separate LEEF attributes.
+			enc.buf.AppendString(`\t`)
+		} else {
+			enc.buf.AppendByte(b)
+		}
+	default:
+		enc.buf.AppendByte(b)
+	}
+	return true
+}
+
+// cefKeyByte replaces bytes that aren't allowed in keys with '_'.
+func cefKeyByte(b byte) byte {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', b == '_', b == '.', b == '-':
+		return b
+	default:
+		return '_'
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func testCEFEncoderConfig() zapcore.EncoderConfig {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeDuration = zapcore.StringDurationEncoder
+	cfg.DeviceVendor = "Acme"
+	cfg.DeviceProduct = "auth-service"
+	cfg.DeviceVersion = "1.4"
+	return cfg
+}
+
+func TestCEFEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891000000, time.UTC)
+	tests := []struct {
+		desc   string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc: "minimal",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			want: "CEF:0|Acme|auth-service|1.4|hello|hello|3|rt=1646370367891\n",
+		},
+		{
+			desc: "metadata",
+			ent: zapcore.Entry{
+				Level:      zapcore.ErrorLevel,
+				Time:       ts,
+				LoggerName: "auth.login",
+				Message:    "login failed",
+				Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+				Stack:      "goroutine 1\n\tmain.go:42",
+			},
+			fields: []zapcore.Field{zap.String("suser", "bob"), zap.String("src", "10.0.0.1")},
+			want: `CEF:0|Acme|auth-service|1.4|auth.login|login failed|7|rt=1646370367891 logger=auth.login ` +
+				`caller=app/main.go:42 suser=bob src=10.0.0.1 stacktrace=goroutine 1\n` + "\tmain.go:42\n",
+		},
+		{
+			desc: "header escaping",
+			ent:  zapcore.Entry{Level: zapcore.WarnLevel, Time: ts, LoggerName: `a|b\c`, Message: "line1\nline2|x\xff"},
+			want: `CEF:0|Acme|auth-service|1.4|a\|b\\c|line1 line2\|x` + "�" + `|5|rt=1646370367891 logger=a|b\\c` + "\n",
+		},
+		{
+			desc: "value escaping",
+			ent:  zapcore.Entry{Level: zapcore.DebugLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.String("equals", "a=b"),
+				zap.String("backslash", `a\b`),
+				zap.String("pipe", "a|b"),
+				zap.String("newlines", "a\r\nb"),
+				zap.String("tab", "a\tb"),
+				zap.String("unicode", "héllo"),
+				zap.String("invalid", "a\xffb"),
+				zap.ByteString("bytes", []byte("c=d\\")),
+				zap.String("bad key=", "v"),
+				zap.String("", "v"),
+			},
+			want: `CEF:0|Acme|auth-service|1.4|hello|hello|1|rt=1646370367891 equals=a\=b backslash=a\\b ` +
+				`pipe=a|b newlines=a\r\nb tab=a` + "\tb unicode=héllo invalid=a�b " +
+				`bytes=c\=d\\ bad_key_=v _=v` + "\n",
+		},
+		{
+			desc: "primitives",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Bool("bool", true),
+				zap.Int("int", -42),
+				zap.Uint64("uint", 42),
+				zap.Float64("float", 1.5),
+				zap.Float32("float32", 0.1),
+				zap.Float64("nan", math.NaN()),
+				zap.Float64("inf", math.Inf(-1)),
+				zap.Complex128("complex", 1-2i),
+				zap.Duration("dur", 1500*time.Millisecond),
+				zap.Time("time", ts),
+				zap.Binary("binary", []byte("foo")),
+				zap.Error(errors.New("oh no")),
+			},
+			want: "CEF:0|Acme|auth-service|1.4|hello|hello|3|rt=1646370367891 bool=true int=-42 uint=42 " +
+				"float=1.5 float32=0.1 nan=NaN inf=-Inf complex=1-2i dur=1.5s time=1646370367.891 " +
+				"binary=Zm9v error=oh no\n",
+		},
+		{
+			desc: "nested",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("name", "bob")
+					return enc.AddArray("roles", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+						enc.AppendString("admin")
+						return enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+							enc.AddString("k", "v")
+							return nil
+						}))
+					}))
+				})),
+				zap.Durations("durs", []time.Duration{time.Second}),
+				zap.Reflect("reflect", map[string]string{"a": "b"}),
+				zap.Reflect("nil", nil),
+				zap.Namespace("ns"),
+				zap.Int("inner", 1),
+			},
+			want: "CEF:0|Acme|auth-service|1.4|hello|hello|3|rt=1646370367891 user.name=bob user.roles.0=admin " +
+				`user.roles.1.k=v durs.0=1s reflect={"a":"b"} nil=null ns.inner=1` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewCEFEncoder(testCEFEncoderConfig())
+			buf, err := enc.EncodeEntry(tt.ent, tt.fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected output.")
+			buf.Free()
+		})
+	}
+}
+
+func TestLEEFEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891000000, time.UTC)
+	tests := []struct {
+		desc   string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc: "minimal",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			want: "LEEF:1.0|Acme|auth-service|1.4|hello|devTime=Mar 04 2022 05:06:07.891 UTC\tsev=3\tmsg=hello\n",
+		},
+		{
+			desc: "metadata",
+			ent: zapcore.Entry{
+				Level:      zapcore.FatalLevel,
+				Time:       ts,
+				LoggerName: "auth|login",
+				Message:    "login failed",
+				Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+			},
+			fields: []zapcore.Field{zap.String("usrName", "bob")},
+			want: `LEEF:1.0|Acme|auth-service|1.4|auth\|login|devTime=Mar 04 2022 05:06:07.891 UTC` +
+				"\tsev=10\tlogger=auth|login\tcaller=app/main.go:42\tmsg=login failed\tusrName=bob\n",
+		},
+		{
+			desc:   "escaping",
+			ent:    zapcore.Entry{Level: zapcore.WarnLevel, Time: ts, Message: "a=b"},
+			fields: []zapcore.Field{zap.String("tab", "a\tb\nc\\")},
+			want: "LEEF:1.0|Acme|auth-service|1.4|a=b|devTime=Mar 04 2022 05:06:07.891 UTC\tsev=5\t" +
+				`msg=a\=b` + "\t" + `tab=a\tb\nc\\` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewLEEFEncoder(testCEFEncoderConfig())
+			buf, err := enc.EncodeEntry(tt.ent, tt.fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected output.")
+			buf.Free()
+		})
+	}
+}
+
+func TestCEFEncoderSeverity(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{zapcore.DebugLevel, "1"},
+		{zapcore.InfoLevel, "3"},
+		{zapcore.WarnLevel, "5"},
+		{zapcore.ErrorLevel, "7"},
+		{zapcore.DPanicLevel, "8"},
+		{zapcore.PanicLevel, "9"},
+		{zapcore.FatalLevel, "10"},
+		{zapcore.Level(42), "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			cfg := zapcore.EncoderConfig{DeviceProduct: "p"}
+			buf, err := zapcore.NewCEFEncoder(cfg).EncodeEntry(zapcore.Entry{Level: tt.level, Message: "m"}, nil)
+			require.NoError(t, err, "Unexpected error encoding CEF entry.")
+			assert.Equal(t, "CEF:0||p||m|m|"+tt.want+"|\n", buf.String(), "Unexpected CEF severity.")
+
+			buf, err = zapcore.NewLEEFEncoder(cfg).EncodeEntry(zapcore.Entry{Level: tt.level, Message: "m"}, nil)
+			require.NoError(t, err, "Unexpected error encoding LEEF entry.")
+			assert.Equal(t, "LEEF:1.0||p||m|sev="+tt.want+"\n", buf.String(), "Unexpected LEEF severity.")
+		})
+	}
+}
+
+func TestCEFEncoderClone(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 0, time.UTC)
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"}
+
+	enc := zapcore.NewCEFEncoder(testCEFEncoderConfig())
+	enc.AddString("a", "1")
+	enc.OpenNamespace("ns")
+	enc.AddString("b", "2")
+
+	clone := enc.Clone()
+	clone.AddString("c", "3")
+
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.String("d", "4")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "CEF:0|Acme|auth-service|1.4|hello|hello|3|rt=1646370367000 a=1 ns.b=2 ns.d=4\n", buf.String(),
+		"Unexpected output from original encoder.")
+	buf.Free()
+
+	buf, err = clone.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "CEF:0|Acme|auth-service|1.4|hello|hello|3|rt=1646370367000 a=1 ns.b=2 ns.c=3\n", buf.String(),
+		"Unexpected output from clone.")
+	buf.Free()
+}
+
+func TestCEFEncoderConfig(t *testing.T) {
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "hello"}
+
+	tests := []struct {
+		desc string
+		enc  zapcore.Encoder
+		want string
+	}{
+		{
+			desc: "default product",
+			enc:  zapcore.NewCEFEncoder(zapcore.EncoderConfig{}),
+			want: "CEF:0||" + filepath.Base(os.Args[0]) + "||hello|hello|3|\n",
+		},
+		{
+			desc: "skip line ending",
+			enc:  zapcore.NewLEEFEncoder(zapcore.EncoderConfig{DeviceProduct: "p", MessageKey: "m", SkipLineEnding: true}),
+			want: "LEEF:1.0||p||hello|sev=3\tm=hello",
+		},
+		{
+			desc: "custom line ending",
+			enc:  zapcore.NewCEFEncoder(zapcore.EncoderConfig{DeviceProduct: "p", LineEnding: "\r\n"}),
+			want: "CEF:0||p||hello|hello|3|\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			buf, err := tt.enc.EncodeEntry(ent, nil)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected output.")
+		})
+	}
+}
+
+func TestCEFEncoderReflectedError(t *testing.T) {
+	enc := zapcore.NewCEFEncoder(testCEFEncoderConfig())
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error encoding a channel.")
+}
@@ -22,25 +22,36 @@ package zapcore
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"sync"
+	"time"
 
 	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/internal/bufferpool"
 )
 
-var _sliceEncoderPool = sync.Pool{
+var _consoleArrayEncoderPool = sync.Pool{
 	New: func() interface{} {
-		return &sliceArrayEncoder{elems: make([]interface{}, 0, 2)}
+		return &consoleArrayEncoder{}
 	},
 }
 
-func getSliceEncoder() *sliceArrayEncoder {
-	return _sliceEncoderPool.Get().(*sliceArrayEncoder)
+func getConsoleArrayEncoder(line *buffer.Buffer, sep string, noColor bool) *consoleArrayEncoder {
+	arr := _consoleArrayEncoderPool.Get().(*consoleArrayEncoder)
+	arr.line = line
+	arr.sep = sep
+	arr.noColor = noColor
+	return arr
 }
 
-func putSliceEncoder(e *sliceArrayEncoder) {
-	e.elems = e.elems[:0]
-	_sliceEncoderPool.Put(e)
+func putConsoleArrayEncoder(arr *consoleArrayEncoder) {
+	arr.line = nil
+	arr.sep = ""
+	arr.n = 0
+	arr.color = NoColor
+	arr.noColor = false
+	_consoleArrayEncoderPool.Put(arr)
 }
 
 type consoleEncoder struct {
@@ -50,7 +61,8 @@ type consoleEncoder struct {
 // NewConsoleEncoder creates an encoder whose output is designed for human -
 // rather than machine - consumption. It serializes the core log entry data
 // (message, level, timestamp, etc.) in a plain-text format and leaves the
-// structured context as JSON.
+// structured context as JSON. If the configuration includes a Theme, the
+// level, logger name, caller, and context are colored to match.
 //
 // Note that although the console encoder doesn't use the keys specified in the
 // encoder configuration, it will omit any element whose key is set to the empty
@@ -71,17 +83,20 @@ func (c consoleEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer,
 	line := bufferpool.Get()
 
 	// We don't want the entry's metadata to be quoted and escaped (if it's
-	// encoded as strings), which means that we can't use the JSON encoder. The
-	// simplest option is to use the memory encoder and fmt.Fprint.
-	//
-	// If this ever becomes a performance bottleneck, we can implement
-	// ArrayEncoder for our plain-text format.
-	arr := getSliceEncoder()
+	// encoded as strings), so we can't use the JSON encoder. Instead, a
+	// plain-text ArrayEncoder writes each element straight into the line,
+	// formatted as fmt.Print would.
+	theme := c.theme()
+	arr := getConsoleArrayEncoder(line, c.ConsoleSeparator, c.DisableColor)
 	if c.TimeKey != "" && c.EncodeTime != nil {
 		c.EncodeTime(ent.Time, arr)
 	}
 	if c.LevelKey != "" && c.EncodeLevel != nil {
+		if theme != nil {
+			arr.color = theme.levelColor(ent.Level)
+		}
 		c.EncodeLevel(ent.Level, arr)
+		arr.color = NoColor
 	}
 	if ent.LoggerName != "" && c.NameKey != "" {
 		nameEncoder := c.EncodeName
@@ -91,23 +106,25 @@ func (c consoleEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer,
 			nameEncoder = FullNameEncoder
 		}
 
+		if theme != nil {
+			arr.color = theme.Name
+		}
 		nameEncoder(ent.LoggerName, arr)
+		arr.color = NoColor
 	}
 	if ent.Caller.Defined {
+		if theme != nil {
+			arr.color = theme.Caller
+		}
 		if c.CallerKey != "" && c.EncodeCaller != nil {
 			c.EncodeCaller(ent.Caller, arr)
 		}
 		if c.FunctionKey != "" {
 			arr.AppendString(ent.Caller.Function)
 		}
+		arr.color = NoColor
 	}
-	for i := range arr.elems {
-		if i > 0 {
-			line.AppendString(c.ConsoleSeparator)
-		}
-		fmt.Fprint(line, arr.elems[i])
-	}
-	putSliceEncoder(arr)
+	putConsoleArrayEncoder(arr)
 
 	// Add the message itself.
 	if c.MessageKey != "" {
@@ -116,7 +133,7 @@ func (c consoleEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer,
 	}
 
 	// Add any structured context.
-	c.writeContext(line, fields)
+	c.writeContext(line, fields, theme)
 
 	// If there's no stacktrace key, honor that; this allows users to force
 	// single-line output.
@@ -129,7 +146,16 @@ func (c consoleEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer,
 	return line, nil
 }
 
-func (c consoleEncoder) writeContext(line *buffer.Buffer, extra []Field) {
+// theme returns the Theme to color output with, or nil if output shouldn't be
+// colored.
+func (c consoleEncoder) theme() *Theme {
+	if c.DisableColor {
+		return nil
+	}
+	return c.Theme
+}
+
+func (c consoleEncoder) writeContext(line *buffer.Buffer, extra []Field, theme *Theme) {
 	context := c.jsonEncoder.Clone().(*jsonEncoder)
 	defer func() {
 		// putJSONEncoder assumes the buffer is still used, but we write out the buffer so
@@ -146,12 +172,250 @@ func (c consoleEncoder) writeContext(line *buffer.Buffer, extra []Field) {
 
 	c.addSeparatorIfNecessary(line)
 	line.AppendByte('{')
-	line.Write(context.buf.Bytes())
+	if theme != nil {
+		writeColoredJSON(line, context.buf.Bytes(), theme)
+	} else {
+		line.Write(context.buf.Bytes())
+	}
 	line.AppendByte('}')
 }
 
+// writeColoredJSON copies the JSON-encoded context in bs to the line,
+// coloring object keys with the theme's FieldKey color and strings, numbers,
+// and literals elsewhere with its FieldValue color. Since the JSON encoder
+// escapes control characters, bs contains no escape sequences of its own.
+func writeColoredJSON(line *buffer.Buffer, bs []byte, theme *Theme) {
+	for i := 0; i < len(bs); {
+		switch b := bs[i]; b {
+		case '{', '}', '[', ']', ',', ':', ' ':
+			line.AppendByte(b)
+			i++
+		case '"':
+			end := i + 1
+			for end < len(bs) && bs[end] != '"' {
+				if bs[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(bs) {
+				end++ // include the closing quote
+			}
+			color := theme.FieldValue
+			if isJSONKey(bs[end:]) {
+				color = theme.FieldKey
+			}
+			writeColored(line, bs[i:end], color)
+			i = end
+		default:
+			end := i + 1
+			for end < len(bs) && !isJSONDelimiter(bs[end]) {
+				end++
+			}
+			writeColored(line, bs[i:end], theme.FieldValue)
+			i = end
+		}
+	}
+}
+
+// isJSONKey reports whether the JSON following a string starts with a colon,
+// making the string an object key.
+func isJSONKey(rest []byte) bool {
+	for _, b := range rest {
+		if b != ' ' {
+			return b == ':'
+		}
+	}
+	return false
+}
+
+func isJSONDelimiter(b byte) bool {
+	switch b {
+	case '{', '}', '[', ']', ',', ':', ' ', '"':
+		return true
+	}
+	return false
+}
+
+func writeColored(line *buffer.Buffer, bs []byte, color Color) {
+	if color == NoColor {
+		line.Write(bs)
+		return
+	}
+	color.appendStart(line)
+	line.Write(bs)
+	line.AppendString(_colorReset)
+}
+
 func (c consoleEncoder) addSeparatorIfNecessary(line *buffer.Buffer) {
 	if line.Len() > 0 {
 		line.AppendString(c.ConsoleSeparator)
 	}
 }
+
+// consoleArrayEncoder is an ArrayEncoder that writes each element to a line
+// of plain text, separating elements with the console separator. Elements are
+// formatted exactly as fmt.Print would format them, but primitives are
+// written without boxing them in interfaces.
+type consoleArrayEncoder struct {
+	line *buffer.Buffer
+	sep  string
+	n    int // number of elements written so far
+
+	color   Color // color for the elements being written
+	noColor bool  // whether color-aware encoding functions should skip color
+}
+
+// Ensure consoleArrayEncoder supports AppendTimeLayout so that layout-based
+// time encoders format straight into the line.
+var _ interface {
+	AppendTimeLayout(time.Time, string)
+} = (*consoleArrayEncoder)(nil)
+
+func (a *consoleArrayEncoder) colorDisabled() bool {
+	return a.noColor
+}
+
+// begin starts a new element, writing the separator and switching to the
+// element's color. Every call must be paired with a call to end.
+func (a *consoleArrayEncoder) begin() {
+	if a.n > 0 {
+		a.line.AppendString(a.sep)
+	}
+	a.n++
+	a.color.appendStart(a.line)
+}
+
+func (a *consoleArrayEncoder) end() {
+	if a.color != NoColor {
+		a.line.AppendString(_colorReset)
+	}
+}
+
+func (a *consoleArrayEncoder) AppendArray(v ArrayMarshaler) error {
+	// Nested arrays are rare in entry metadata, so reuse the memory encoder
+	// and fmt rather than reimplementing fmt's formatting of slices.
+	enc := &sliceArrayEncoder{}
+	err := enc.AppendArray(v)
+	a.appendFmt(enc.elems[0])
+	return err
+}
+
+func (a *consoleArrayEncoder) AppendObject(v ObjectMarshaler) error {
+	enc := &sliceArrayEncoder{}
+	err := enc.AppendObject(v)
+	a.appendFmt(enc.elems[0])
+	return err
+}
+
+func (a *consoleArrayEncoder) AppendReflected(v interface{}) error {
+	a.appendFmt(v)
+	return nil
+}
+
+func (a *consoleArrayEncoder) appendFmt(v interface{}) {
+	a.begin()
+	fmt.Fprint(a.line, v)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendBool(v bool) {
+	a.begin()
+	a.line.AppendBool(v)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendByteString(v []byte) {
+	a.begin()
+	a.line.Write(v)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendComplex128(v complex128) {
+	a.begin()
+	a.appendComplex(real(v), imag(v), 64)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendComplex64(v complex64) {
+	a.begin()
+	a.appendComplex(float64(real(v)), float64(imag(v)), 32)
+	a.end()
+}
+
+// appendComplex matches fmt's "(r+ii)" formatting of complex numbers.
+func (a *consoleArrayEncoder) appendComplex(r, i float64, bitSize int) {
+	a.line.AppendByte('(')
+	a.appendFloat(r, bitSize)
+	if !math.Signbit(i) && !math.IsInf(i, 1) {
+		// fmt always signs the imaginary part, but strconv only signs
+		// negative numbers and +Inf.
+		a.line.AppendByte('+')
+	}
+	a.appendFloat(i, bitSize)
+	a.line.AppendString("i)")
+}
+
+func (a *consoleArrayEncoder) appendFloat(v float64, bitSize int) {
+	// Buffer.AppendFloat uses the 'f' format, but fmt prints floats with 'g'.
+	var scratch [32]byte
+	a.line.Write(strconv.AppendFloat(scratch[:0], v, 'g', -1, bitSize))
+}
+
+func (a *consoleArrayEncoder) AppendDuration(v time.Duration) {
+	a.begin()
+	a.line.AppendString(v.String())
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendFloat64(v float64) {
+	a.begin()
+	a.appendFloat(v, 64)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendFloat32(v float32) {
+	a.begin()
+	a.appendFloat(float64(v), 32)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendInt64(v int64) {
+	a.begin()
+	a.line.AppendInt(v)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendString(v string) {
+	a.begin()
+	a.line.AppendString(v)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendTime(v time.Time) {
+	a.begin()
+	a.line.AppendString(v.String())
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendTimeLayout(v time.Time, layout string) {
+	a.begin()
+	a.line.AppendTime(v, layout)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendUint64(v uint64) {
+	a.begin()
+	a.line.AppendUint(v)
+	a.end()
+}
+
+func (a *consoleArrayEncoder) AppendInt(v int)         { a.AppendInt64(int64(v)) }
+func (a *consoleArrayEncoder) AppendInt32(v int32)     { a.AppendInt64(int64(v)) }
+func (a *consoleArrayEncoder) AppendInt16(v int16)     { a.AppendInt64(int64(v)) }
+func (a *consoleArrayEncoder) AppendInt8(v int8)       { a.AppendInt64(int64(v)) }
+func (a *consoleArrayEncoder) AppendUint(v uint)       { a.AppendUint64(uint64(v)) }
+func (a *consoleArrayEncoder) AppendUint32(v uint32)   { a.AppendUint64(uint64(v)) }
+func (a *consoleArrayEncoder) AppendUint16(v uint16)   { a.AppendUint64(uint64(v)) }
+func (a *consoleArrayEncoder) AppendUint8(v uint8)     { a.AppendUint64(uint64(v)) }
+func (a *consoleArrayEncoder) AppendUintptr(v uintptr) { a.AppendUint64(uint64(v)) }
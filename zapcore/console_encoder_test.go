@@ -20,9 +20,13 @@
 package zapcore_test
 
 import (
+	"fmt"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/internal/ztest"
 	. "go.uber.org/zap/zapcore"
 )
 
@@ -89,3 +93,122 @@ func encoderTestEncoderConfig(separator string) EncoderConfig {
 	testEncoder.ConsoleSeparator = separator
 	return testEncoder
 }
+
+func TestConsoleEncodeEntryMetadata(t *testing.T) {
+	ts := time.Date(2022, 3, 4, 5, 6, 7, 8000, time.UTC)
+	tests := []struct {
+		desc   string
+		append func(ArrayEncoder)
+		want   interface{}
+	}{
+		{"bool", func(e ArrayEncoder) { e.AppendBool(true) }, true},
+		{"byte string", func(e ArrayEncoder) { e.AppendByteString([]byte("bytes")) }, "bytes"},
+		{"complex128", func(e ArrayEncoder) { e.AppendComplex128(1.5 - 2i) }, complex128(1.5 - 2i)},
+		{"complex128 positive", func(e ArrayEncoder) { e.AppendComplex128(-1e21 + 0.25i) }, complex128(-1e21 + 0.25i)},
+		{"complex128 NaN", func(e ArrayEncoder) { e.AppendComplex128(complex(math.NaN(), math.NaN())) }, complex(math.NaN(), math.NaN())},
+		{"complex128 +Inf", func(e ArrayEncoder) { e.AppendComplex128(complex(math.Inf(1), math.Inf(1))) }, complex(math.Inf(1), math.Inf(1))},
+		{"complex128 -Inf", func(e ArrayEncoder) { e.AppendComplex128(complex(math.Inf(-1), math.Inf(-1))) }, complex(math.Inf(-1), math.Inf(-1))},
+		{"complex128 negative zero", func(e ArrayEncoder) { e.AppendComplex128(complex(0, math.Copysign(0, -1))) }, complex(0, math.Copysign(0, -1))},
+		{"complex64", func(e ArrayEncoder) { e.AppendComplex64(0.1 + 0.2i) }, complex64(0.1 + 0.2i)},
+		{"duration", func(e ArrayEncoder) { e.AppendDuration(1500 * time.Millisecond) }, 1500 * time.Millisecond},
+		{"float64", func(e ArrayEncoder) { e.AppendFloat64(1646370367.891) }, 1646370367.891},
+		{"float64 large", func(e ArrayEncoder) { e.AppendFloat64(1e21) }, 1e21},
+		{"float64 small", func(e ArrayEncoder) { e.AppendFloat64(1e-7) }, 1e-7},
+		{"float64 NaN", func(e ArrayEncoder) { e.AppendFloat64(math.NaN()) }, math.NaN()},
+		{"float64 +Inf", func(e ArrayEncoder) { e.AppendFloat64(math.Inf(1)) }, math.Inf(1)},
+		{"float64 -Inf", func(e ArrayEncoder) { e.AppendFloat64(math.Inf(-1)) }, math.Inf(-1)},
+		{"float32", func(e ArrayEncoder) { e.AppendFloat32(0.1) }, float32(0.1)},
+		{"int", func(e ArrayEncoder) { e.AppendInt(-42) }, -42},
+		{"int64", func(e ArrayEncoder) { e.AppendInt64(math.MinInt64) }, int64(math.MinInt64)},
+		{"int32", func(e ArrayEncoder) { e.AppendInt32(-32) }, int32(-32)},
+		{"int16", func(e ArrayEncoder) { e.AppendInt16(-16) }, int16(-16)},
+		{"int8", func(e ArrayEncoder) { e.AppendInt8(-8) }, int8(-8)},
+		{"string", func(e ArrayEncoder) { e.AppendString("a \"string\"\n") }, "a \"string\"\n"},
+		{"time", func(e ArrayEncoder) { e.AppendTime(ts) }, ts},
+		{"uint", func(e ArrayEncoder) { e.AppendUint(42) }, uint(42)},
+		{"uint64", func(e ArrayEncoder) { e.AppendUint64(math.MaxUint64) }, uint64(math.MaxUint64)},
+		{"uint32", func(e ArrayEncoder) { e.AppendUint32(32) }, uint32(32)},
+		{"uint16", func(e ArrayEncoder) { e.AppendUint16(16) }, uint16(16)},
+		{"uint8", func(e ArrayEncoder) { e.AppendUint8(8) }, uint8(8)},
+		{"uintptr", func(e ArrayEncoder) { e.AppendUintptr(0xdeadbeef) }, uintptr(0xdeadbeef)},
+		{"reflected", func(e ArrayEncoder) { e.AppendReflected(struct{ A int }{1}) }, struct{ A int }{1}},
+		{
+			"array",
+			func(e ArrayEncoder) {
+				e.AppendArray(ArrayMarshalerFunc(func(inner ArrayEncoder) error {
+					inner.AppendString("a")
+					inner.AppendInt(1)
+					return nil
+				}))
+			},
+			[]interface{}{"a", 1},
+		},
+		{
+			"object",
+			func(e ArrayEncoder) {
+				e.AppendObject(ObjectMarshalerFunc(func(inner ObjectEncoder) error {
+					inner.AddString("k", "v")
+					return nil
+				}))
+			},
+			map[string]interface{}{"k": "v"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := encoderTestEncoderConfig(" ")
+			cfg.EncodeTime = func(_ time.Time, enc PrimitiveArrayEncoder) {
+				tt.append(enc.(ArrayEncoder))
+			}
+			cfg.EncodeLevel = func(_ Level, enc PrimitiveArrayEncoder) {
+				tt.append(enc.(ArrayEncoder))
+			}
+			buf, err := NewConsoleEncoder(cfg).EncodeEntry(Entry{Message: "msg"}, nil)
+			if !assert.NoError(t, err, "Unexpected error encoding entry.") {
+				return
+			}
+			defer buf.Free()
+
+			want := fmt.Sprint(tt.want)
+			assert.Equal(t, want+" "+want+" msg\n", buf.String(), "Unexpected console output.")
+		})
+	}
+}
+
+func TestConsoleEncodeEntryEmptyElements(t *testing.T) {
+	cfg := encoderTestEncoderConfig(",")
+	cfg.EncodeTime = func(time.Time, PrimitiveArrayEncoder) {}
+	cfg.EncodeLevel = func(_ Level, enc PrimitiveArrayEncoder) { enc.AppendString("") }
+	cfg.EncodeName = func(_ string, enc PrimitiveArrayEncoder) { enc.AppendString("name") }
+
+	buf, err := NewConsoleEncoder(cfg).EncodeEntry(Entry{LoggerName: "main", Message: "msg"}, nil)
+	if assert.NoError(t, err, "Unexpected error encoding entry.") {
+		assert.Equal(t, ",name,msg\n", buf.String(), "Expected a separator after every element, even empty ones.")
+		buf.Free()
+	}
+}
+
+func TestConsoleEncodeEntryAllocs(t *testing.T) {
+	if ztest.RaceEnabled {
+		t.Skip("Skipping allocation test under the race detector.")
+	}
+
+	ent := testEntry
+	ent.Time = time.Now()
+	// Caller encoders build their own strings, so leave the caller out.
+	ent.Caller = EntryCaller{}
+	fields := []Field{{Key: "k", Type: StringType, String: "v"}}
+
+	for _, theme := range []*Theme{nil, DefaultTheme()} {
+		cfg := humanEncoderConfig()
+		cfg.Theme = theme
+		enc := NewConsoleEncoder(cfg)
+
+		allocs := testing.AllocsPerRun(100, func() {
+			buf, _ := enc.EncodeEntry(ent, fields)
+			buf.Free()
+		})
+		assert.Zero(t, allocs, "Expected encoding entry metadata to be allocation-free (theme %v).", theme)
+	}
+}
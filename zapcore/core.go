@@ -63,10 +63,22 @@ func NewCore(enc Encoder, ws WriteSyncer, enab LevelEnabler) Core {
 	}
 }
 
+// NewCoreWithErrorPolicy creates a Core that writes logs to a WriteSyncer,
+// handling failed writes as specified by the WriteErrorPolicy.
+func NewCoreWithErrorPolicy(enc Encoder, ws WriteSyncer, enab LevelEnabler, policy WriteErrorPolicy) Core {
+	return &ioCore{
+		LevelEnabler: enab,
+		enc:          enc,
+		out:          ws,
+		onErr:        newWriteErrorHandler(policy),
+	}
+}
+
 type ioCore struct {
 	LevelEnabler
-	enc Encoder
-	out WriteSyncer
+	enc   Encoder
+	out   WriteSyncer
+	onErr *writeErrorHandler // nil unless built with a WriteErrorPolicy
 }
 
 func (c *ioCore) With(fields []Field) Core {
@@ -87,7 +99,13 @@ func (c *ioCore) Write(ent Entry, fields []Field) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.out.Write(buf.Bytes())
+	if c.onErr != nil {
+		err = c.onErr.write(c.out, ent, buf.Bytes())
+	} else if lw, ok := c.out.(levelWriter); ok {
+		_, err = lw.writeLevel(ent.Level, buf.Bytes())
+	} else {
+		_, err = c.out.Write(buf.Bytes())
+	}
 	buf.Free()
 	if err != nil {
 		return err
@@ -109,5 +127,6 @@ func (c *ioCore) clone() *ioCore {
 		LevelEnabler: c.LevelEnabler,
 		enc:          c.enc.Clone(),
 		out:          c.out,
+		onErr:        c.onErr,
 	}
 }
@@ -0,0 +1,481 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// ECSVersion is the version of the Elastic Common Schema that the ECS
+// encoder's output conforms to. It's written to each entry's ecs.version
+// field.
+const ECSVersion = "1.6.0"
+
+// _ecsTimeFormat is the layout of @timestamp: RFC 3339 in UTC, with
+// millisecond precision.
+const _ecsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// _ecsMetadataKeys are the fields the ECS encoder writes for each entry, in
+// the order in which they're written.
+var _ecsMetadataKeys = []string{
+	"@timestamp",
+	"log.level",
+	"log.logger",
+	"log.origin.file.name",
+	"log.origin.file.line",
+	"log.origin.function",
+	"message",
+	"ecs.version",
+}
+
+var _ecsPool = sync.Pool{New: func() interface{} {
+	return &ecsEncoder{}
+}}
+
+func getECSEncoder() *ecsEncoder {
+	return _ecsPool.Get().(*ecsEncoder)
+}
+
+func putECSEncoder(enc *ecsEncoder) {
+	putJSONEncoder(enc.json)
+	enc.EncoderConfig = nil
+	enc.nodes = enc.nodes[:0]
+	enc.cur = 0
+	enc.values = nil
+	enc.json = nil
+	_ecsPool.Put(enc)
+}
+
+// An ecsNode is a member of the tree of objects that the ECS encoder builds
+// from dotted keys. Nodes refer to each other by their indexes in the
+// encoder's nodes.
+type ecsNode struct {
+	key string
+
+	// If leaf is set, the node's value is values[start:end]. Otherwise, the
+	// node is an object, whose members are first, first.next, and so on.
+	leaf        bool
+	start, end  int
+	first, last int // -1 if there are no members
+	next        int // next member of the parent object, or -1
+}
+
+type ecsEncoder struct {
+	*EncoderConfig
+
+	// nodes[0] is the root object.
+	nodes []ecsNode
+	// cur is the object that fields are added to: the root, an open
+	// namespace, or a nested object.
+	cur int
+	// values holds the JSON-encoded values of the leaf nodes. Each value is
+	// preceded by a space, which keeps json from adding element separators.
+	values *buffer.Buffer
+	// json writes to values.
+	json *jsonEncoder
+}
+
+// NewECSEncoder creates an encoder whose output is JSON conforming to the
+// Elastic Common Schema (ECS), as enforced by many Elasticsearch indices. The
+// entry's time, level, logger name, caller, function, and message are written
+// to the @timestamp, log.level, log.logger, log.origin.file.name,
+// log.origin.file.line, log.origin.function, and message fields, and each
+// entry includes the ecs.version field.
+//
+// Field keys containing dots are nested into objects, as are fields added to
+// namespaces and nested objects, so that
+//
+//   logger.Info("request", zap.String("http.request.method", "GET"), zap.Int("http.response.status_code", 200))
+//
+// is encoded as
+//
+//   {..., "http": {"request": {"method": "GET"}, "response": {"status_code": 200}}}
+//
+// If keys conflict, later fields replace earlier ones; the entry's metadata
+// replaces any field with the same key. Errors added with zap.Error (or
+// zap.NamedError) are encoded as error.message, error.type and, for errors
+// that format a verbose message with "%+v" like those from
+// github.com/pkg/errors, error.stack_trace. The entry's stack trace is written
+// to error.stack_trace if an error didn't provide one.
+//
+// The keys in the EncoderConfig are ignored, since ECS determines the field
+// names, except that an empty key omits that part of the entry. EncodeLevel
+// formats log.level, and defaults to LowercaseLevelEncoder. The EncodeTime
+// and EncodeDuration functions are honored for field values; @timestamp is
+// always encoded in the format required by ECS.
+func NewECSEncoder(cfg EncoderConfig) Encoder {
+	if cfg.SkipLineEnding {
+		cfg.LineEnding = ""
+	} else if cfg.LineEnding == "" {
+		cfg.LineEnding = DefaultLineEnding
+	}
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+
+	enc := &ecsEncoder{
+		EncoderConfig: &cfg,
+		nodes:         []ecsNode{{first: -1, last: -1, next: -1}},
+		values:        bufferpool.Get(),
+	}
+	enc.json = enc.newJSONEncoder(enc.values)
+	// Add empty nodes for the metadata, so that it comes first in each entry.
+	for _, key := range _ecsMetadataKeys {
+		enc.path(0, key)
+	}
+	return enc
+}
+
+// newJSONEncoder returns a pooled JSON encoder that writes to buf.
+func (enc *ecsEncoder) newJSONEncoder(buf *buffer.Buffer) *jsonEncoder {
+	j := getJSONEncoder()
+	j.EncoderConfig = enc.EncoderConfig
+	j.buf = buf
+	return j
+}
+
+// path returns the node for a dotted key, relative to the given object,
+// creating it and any missing parent objects.
+func (enc *ecsEncoder) path(parent int, key string) int {
+	for {
+		i := strings.IndexByte(key, '.')
+		if i < 0 {
+			return enc.member(parent, key)
+		}
+		parent = enc.member(parent, key[:i])
+		key = key[i+1:]
+	}
+}
+
+// member returns the member of an object with the given key, creating it if
+// necessary.
+func (enc *ecsEncoder) member(parent int, key string) int {
+	enc.object(parent)
+	for n := enc.nodes[parent].first; n >= 0; n = enc.nodes[n].next {
+		if enc.nodes[n].key == key {
+			return n
+		}
+	}
+
+	n := len(enc.nodes)
+	enc.nodes = append(enc.nodes, ecsNode{key: key, first: -1, last: -1, next: -1})
+	if last := enc.nodes[parent].last; last >= 0 {
+		enc.nodes[last].next = n
+	} else {
+		enc.nodes[parent].first = n
+	}
+	enc.nodes[parent].last = n
+	return n
+}
+
+// object turns a leaf node into an empty object, discarding its value.
+func (enc *ecsEncoder) object(n int) {
+	if node := &enc.nodes[n]; node.leaf {
+		node.leaf = false
+		node.first, node.last = -1, -1
+	}
+}
+
+// beginValue starts the value of the field with the given key, replacing any
+// existing value or object. The value must be written with json and
+// terminated with endValue.
+func (enc *ecsEncoder) beginValue(key string) int {
+	return enc.beginValueAt(enc.path(enc.cur, key))
+}
+
+func (enc *ecsEncoder) beginValueAt(n int) int {
+	enc.values.AppendByte(' ')
+	node := &enc.nodes[n]
+	node.leaf = true
+	node.first, node.last = -1, -1
+	node.start = enc.values.Len()
+	return n
+}
+
+func (enc *ecsEncoder) endValue(n int) {
+	node := &enc.nodes[n]
+	node.end = enc.values.Len()
+	if node.end == node.start {
+		// Nothing was written, probably because of an error.
+		node.leaf = false
+	}
+}
+
+// hasContent reports whether a node is a leaf or an object containing at
+// least one leaf. Empty objects are omitted from the output.
+func (enc *ecsEncoder) hasContent(n int) bool {
+	if enc.nodes[n].leaf {
+		return true
+	}
+	for c := enc.nodes[n].first; c >= 0; c = enc.nodes[c].next {
+		if enc.hasContent(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (enc *ecsEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	n := enc.beginValue(key)
+	err := enc.json.AppendArray(arr)
+	enc.endValue(n)
+	if err != nil {
+		// Omit the partially-written value.
+		enc.nodes[n].leaf = false
+	}
+	return err
+}
+
+func (enc *ecsEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	old := enc.cur
+	enc.cur = enc.path(old, key)
+	enc.object(enc.cur)
+	err := obj.MarshalLogObject(enc)
+	enc.cur = old
+	return err
+}
+
+func (enc *ecsEncoder) AddReflected(key string, obj interface{}) error {
+	n := enc.beginValue(key)
+	err := enc.json.AppendReflected(obj)
+	enc.endValue(n)
+	if err != nil {
+		// Omit the partially-written value.
+		enc.nodes[n].leaf = false
+	}
+	return err
+}
+
+func (enc *ecsEncoder) OpenNamespace(key string) {
+	enc.cur = enc.path(enc.cur, key)
+	enc.object(enc.cur)
+}
+
+// addError adds an error as the message, type and stack_trace members of an
+// object, as ECS requires. It's used by encodeError in place of the usual
+// error fields.
+func (enc *ecsEncoder) addError(key string, err error) {
+	old := enc.cur
+	enc.cur = enc.path(old, key)
+	enc.object(enc.cur)
+	defer func() { enc.cur = old }()
+
+	basic := err.Error()
+	enc.AddString("message", basic)
+	enc.AddString("type", reflect.TypeOf(err).String())
+	if f, ok := err.(fmt.Formatter); ok {
+		if verbose := fmt.Sprintf("%+v", f); verbose != basic {
+			enc.AddString("stack_trace", verbose)
+		}
+	}
+}
+
+func (enc *ecsEncoder) AddBinary(key string, val []byte) {
+	n := enc.beginValue(key)
+	enc.json.AppendString(base64.StdEncoding.EncodeToString(val))
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddByteString(key string, val []byte) {
+	n := enc.beginValue(key)
+	enc.json.AppendByteString(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddBool(key string, val bool) {
+	n := enc.beginValue(key)
+	enc.json.AppendBool(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddComplex128(key string, val complex128) {
+	n := enc.beginValue(key)
+	enc.json.AppendComplex128(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddComplex64(key string, val complex64) {
+	n := enc.beginValue(key)
+	enc.json.AppendComplex64(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddDuration(key string, val time.Duration) {
+	n := enc.beginValue(key)
+	enc.json.AppendDuration(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddFloat64(key string, val float64) {
+	n := enc.beginValue(key)
+	enc.json.AppendFloat64(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddFloat32(key string, val float32) {
+	n := enc.beginValue(key)
+	enc.json.AppendFloat32(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddInt64(key string, val int64) {
+	n := enc.beginValue(key)
+	enc.json.AppendInt64(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddString(key, val string) {
+	n := enc.beginValue(key)
+	enc.json.AppendString(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddTime(key string, val time.Time) {
+	n := enc.beginValue(key)
+	enc.json.AppendTime(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddUint64(key string, val uint64) {
+	n := enc.beginValue(key)
+	enc.json.AppendUint64(val)
+	enc.endValue(n)
+}
+
+func (enc *ecsEncoder) AddInt(k string, v int)         { enc.AddInt64(k, int64(v)) }
+func (enc *ecsEncoder) AddInt32(k string, v int32)     { enc.AddInt64(k, int64(v)) }
+func (enc *ecsEncoder) AddInt16(k string, v int16)     { enc.AddInt64(k, int64(v)) }
+func (enc *ecsEncoder) AddInt8(k string, v int8)       { enc.AddInt64(k, int64(v)) }
+func (enc *ecsEncoder) AddUint(k string, v uint)       { enc.AddUint64(k, uint64(v)) }
+func (enc *ecsEncoder) AddUint32(k string, v uint32)   { enc.AddUint64(k, uint64(v)) }
+func (enc *ecsEncoder) AddUint16(k string, v uint16)   { enc.AddUint64(k, uint64(v)) }
+func (enc *ecsEncoder) AddUint8(k string, v uint8)     { enc.AddUint64(k, uint64(v)) }
+func (enc *ecsEncoder) AddUintptr(k string, v uintptr) { enc.AddUint64(k, uint64(v)) }
+
+func (enc *ecsEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.nodes = append(clone.nodes, enc.nodes...)
+	clone.values.Write(enc.values.Bytes())
+	return clone
+}
+
+func (enc *ecsEncoder) clone() *ecsEncoder {
+	clone := getECSEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.cur = enc.cur
+	clone.values = bufferpool.Get()
+	clone.json = clone.newJSONEncoder(clone.values)
+	return clone
+}
+
+func (enc *ecsEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+	final.nodes = append(final.nodes, enc.nodes...)
+	final.values.Write(enc.values.Bytes())
+	addFields(final, fields)
+
+	// The entry's metadata goes at the root, and replaces any fields with the
+	// same keys.
+	final.cur = 0
+	if final.TimeKey != "" && !ent.Time.IsZero() {
+		n := final.beginValue("@timestamp")
+		final.json.AppendTimeLayout(ent.Time.UTC(), _ecsTimeFormat)
+		final.endValue(n)
+	}
+	if final.LevelKey != "" {
+		n := final.beginValue("log.level")
+		if e := final.EncodeLevel; e != nil {
+			e(ent.Level, final.json)
+		}
+		if final.nodes[n].start == final.values.Len() {
+			final.json.AppendString(ent.Level.String())
+		}
+		final.endValue(n)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.AddString("log.logger", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" {
+			final.AddString("log.origin.file.name", ent.Caller.File)
+			final.AddInt("log.origin.file.line", ent.Caller.Line)
+		}
+		if final.FunctionKey != "" && ent.Caller.Function != "" {
+			final.AddString("log.origin.function", ent.Caller.Function)
+		}
+	}
+	if final.MessageKey != "" {
+		final.AddString("message", ent.Message)
+	}
+	final.AddString("ecs.version", ECSVersion)
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		if n := final.path(0, "error.stack_trace"); !final.nodes[n].leaf {
+			final.beginValueAt(n)
+			final.json.AppendString(ent.Stack)
+			final.endValue(n)
+		}
+	}
+
+	line := bufferpool.Get()
+	keys := final.newJSONEncoder(line)
+	final.writeObject(line, keys, 0)
+	line.AppendString(final.LineEnding)
+	putJSONEncoder(keys)
+
+	final.values.Free()
+	putECSEncoder(final)
+	return line, nil
+}
+
+// writeObject writes an object node and its members to line, using keys to
+// escape their keys.
+func (enc *ecsEncoder) writeObject(line *buffer.Buffer, keys *jsonEncoder, n int) {
+	line.AppendByte('{')
+	first := true
+	for c := enc.nodes[n].first; c >= 0; c = enc.nodes[c].next {
+		if !enc.hasContent(c) {
+			continue
+		}
+		if !first {
+			line.AppendByte(',')
+		}
+		first = false
+
+		node := &enc.nodes[c]
+		line.AppendByte('"')
+		keys.safeAddString(node.key)
+		line.AppendString(`":`)
+		if node.leaf {
+			line.Write(enc.values.Bytes()[node.start:node.end])
+		} else {
+			enc.writeObject(line, keys, c)
+		}
+	}
+	line.AppendByte('}')
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+func testECSEncoder() zapcore.Encoder {
+	return zapcore.NewECSEncoder(zap.NewECSEncoderConfig())
+}
+
+type ecsTestError struct{}
+
+func (ecsTestError) Error() string { return "test error" }
+
+func TestECSEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891011000, time.FixedZone("EST", -5*60*60))
+	caller := zapcore.EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42, Function: "main.run"}
+	tests := []struct {
+		desc   string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc: "minimal",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			want: `{"@timestamp":"2022-03-04T10:06:07.891Z","log":{"level":"info"},"message":"hello","ecs":{"version":"1.6.0"}}`,
+		},
+		{
+			desc: "metadata",
+			ent: zapcore.Entry{
+				Level:      zapcore.WarnLevel,
+				LoggerName: "app.db",
+				Message:    "slow query",
+				Caller:     caller,
+			},
+			want: `{"log":{"level":"warn","logger":"app.db","origin":{"file":{"name":"/src/app/main.go","line":42},"function":"main.run"}},"message":"slow query","ecs":{"version":"1.6.0"}}`,
+		},
+		{
+			desc: "dotted keys",
+			ent:  zapcore.Entry{Message: "request"},
+			fields: []zapcore.Field{
+				zap.String("http.request.method", "GET"),
+				zap.String("service.name", "api"),
+				zap.Int("http.response.status_code", 200),
+				zap.String("plain", "value"),
+			},
+			want: `{"log":{"level":"info"},"message":"request","ecs":{"version":"1.6.0"},"http":{"request":{"method":"GET"},"response":{"status_code":200}},"service":{"name":"api"},"plain":"value"}`,
+		},
+		{
+			desc: "nested objects and namespaces",
+			ent:  zapcore.Entry{Message: "m"},
+			fields: []zapcore.Field{
+				zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("id", "u1")
+					enc.AddString("geo.city", "Paris")
+					return nil
+				})),
+				zap.String("user.name", "alice"),
+				zap.Namespace("labels"),
+				zap.String("env", "prod"),
+			},
+			want: `{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"user":{"id":"u1","geo":{"city":"Paris"},"name":"alice"},"labels":{"env":"prod"}}`,
+		},
+		{
+			desc: "conflicts",
+			ent:  zapcore.Entry{Message: "m"},
+			fields: []zapcore.Field{
+				zap.String("a", "leaf"),
+				zap.String("a.b", "object"),
+				zap.String("c.d", "object"),
+				zap.String("c", "leaf"),
+				zap.String("message", "ignored"),
+				zap.String("log.level", "ignored"),
+			},
+			want: `{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"a":{"b":"object"},"c":"leaf"}`,
+		},
+		{
+			desc: "arrays and reflected values",
+			ent:  zapcore.Entry{Message: "m"},
+			fields: []zapcore.Field{
+				zap.Strings("tags", []string{"a", "b"}),
+				zap.Reflect("data.raw", map[string]int{"n": 1}),
+				zap.Duration("event.duration", time.Millisecond),
+			},
+			want: `{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"tags":["a","b"],"data":{"raw":{"n":1}},"event":{"duration":1000000}}`,
+		},
+		{
+			desc: "binary",
+			ent:  zapcore.Entry{Message: "m"},
+			fields: []zapcore.Field{
+				zap.Binary("bin", []byte{0, 1}),
+				zap.Binary("event.original", []byte("raw")),
+			},
+			want: `{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"bin":"AAE=","event":{"original":"cmF3"}}`,
+		},
+		{
+			desc:   "error",
+			ent:    zapcore.Entry{Level: zapcore.ErrorLevel, Message: "failed"},
+			fields: []zapcore.Field{zap.Error(ecsTestError{})},
+			want:   `{"log":{"level":"error"},"message":"failed","ecs":{"version":"1.6.0"},"error":{"message":"test error","type":"zapcore_test.ecsTestError"}}`,
+		},
+		{
+			desc:   "named error",
+			ent:    zapcore.Entry{Message: "m"},
+			fields: []zapcore.Field{zap.NamedError("upstream.error", errors.New("eof"))},
+			want:   `{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"upstream":{"error":{"message":"eof","type":"*errors.errorString"}}}`,
+		},
+		{
+			desc: "entry stack",
+			ent:  zapcore.Entry{Level: zapcore.ErrorLevel, Message: "m", Stack: "goroutine 1"},
+			want: `{"log":{"level":"error"},"message":"m","ecs":{"version":"1.6.0"},"error":{"stack_trace":"goroutine 1"}}`,
+		},
+		{
+			desc: "escaped keys",
+			ent:  zapcore.Entry{Message: "m"},
+			fields: []zapcore.Field{
+				zap.String(`quo"te`, "v"),
+			},
+			want: `{"log":{"level":"info"},"message":"m","ecs":{"version":"1.6.0"},"quo\"te":"v"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			buf, err := testECSEncoder().EncodeEntry(tt.ent, tt.fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want+"\n", buf.String(), "Unexpected ECS document.")
+			assert.True(t, json.Valid(buf.Bytes()), "Expected valid JSON.")
+			buf.Free()
+		})
+	}
+}
+
+func TestECSEncoderErrorStack(t *testing.T) {
+	err := pkgerrors.New("boom")
+	ent := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "m", Stack: "entry stack"}
+	buf, encErr := testECSEncoder().EncodeEntry(ent, []zapcore.Field{zap.Error(err)})
+	require.NoError(t, encErr, "Unexpected error encoding entry.")
+
+	var doc struct {
+		Error struct {
+			Message    string `json:"message"`
+			Type       string `json:"type"`
+			StackTrace string `json:"stack_trace"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc), "Unexpected error decoding ECS document.")
+	assert.Equal(t, "boom", doc.Error.Message, "Unexpected error message.")
+	assert.Equal(t, "*errors.fundamental", doc.Error.Type, "Unexpected error type.")
+	assert.Contains(t, doc.Error.StackTrace, "TestECSEncoderErrorStack", "Expected the error's stack trace.")
+	assert.NotContains(t, doc.Error.StackTrace, "entry stack", "Expected the error's stack trace to take precedence.")
+	buf.Free()
+}
+
+func TestECSEncoderConfig(t *testing.T) {
+	cfg := zap.NewECSEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.FunctionKey = ""
+	cfg.StacktraceKey = ""
+	cfg.EncodeLevel = zapcore.CapitalLevelEncoder
+	cfg.SkipLineEnding = true
+	enc := zapcore.NewECSEncoder(cfg)
+
+	ent := zapcore.Entry{
+		Time:       time.Now(),
+		LoggerName: "name",
+		Message:    "m",
+		Caller:     zapcore.EntryCaller{Defined: true, File: "f.go", Line: 1, Function: "f"},
+		Stack:      "stack",
+	}
+	buf, err := enc.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `{"log":{"level":"INFO"},"message":"m","ecs":{"version":"1.6.0"}}`, buf.String(), "Expected empty keys to omit elements.")
+	buf.Free()
+}
+
+func TestECSEncoderClone(t *testing.T) {
+	enc := testECSEncoder()
+	enc.AddString("service.name", "api")
+
+	child := enc.Clone()
+	child.OpenNamespace("ns")
+	child.AddString("child", "c")
+
+	ent := zapcore.Entry{Message: "hi"}
+	buf, err := child.EncodeEntry(ent, []zapcore.Field{zap.Int("n", 1), zap.String("service.name", "shadowed")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"log":{"level":"info"},"message":"hi","ecs":{"version":"1.6.0"},"service":{"name":"api"},"ns":{"child":"c","n":1,"service":{"name":"shadowed"}}}`+"\n",
+		buf.String(), "Unexpected ECS document.")
+	buf.Free()
+
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("service.name", "web")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"log":{"level":"info"},"message":"hi","ecs":{"version":"1.6.0"},"service":{"name":"web"}}`+"\n",
+		buf.String(), "Expected parent to be unaffected by child.")
+	buf.Free()
+}
+
+func TestECSEncoderMarshalErrors(t *testing.T) {
+	enc := testECSEncoder()
+	failing := zapcore.ArrayMarshalerFunc(func(zapcore.ArrayEncoder) error {
+		return errors.New("fail")
+	})
+	assert.Error(t, enc.AddArray("arr", failing), "Expected array marshaling error to propagate.")
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected reflection error to propagate.")
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `{"log":{"level":"info"},"message":"","ecs":{"version":"1.6.0"}}`+"\n", buf.String(), "Expected failed fields to be omitted.")
+	buf.Free()
+}
+
+func TestECSEncoderAllocs(t *testing.T) {
+	if ztest.RaceEnabled {
+		t.Skip("Skipping allocation test under the race detector.")
+	}
+
+	enc := testECSEncoder()
+	enc.AddString("service.name", "api")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "hello world"}
+	fields := []zapcore.Field{
+		zap.String("http.request.method", "GET"),
+		zap.Int("http.response.status_code", 200),
+		zap.Bool("bool", true),
+		zap.Float64("float", 1.5),
+	}
+
+	// Warm up the pools, whose encoders keep their node slices.
+	for i := 0; i < 10; i++ {
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	})
+	assert.Zero(t, allocs, "Expected encoding to be allocation-free.")
+}
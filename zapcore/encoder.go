@@ -46,8 +46,13 @@ func LowercaseLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 }
 
 // LowercaseColorLevelEncoder serializes a Level to a lowercase string and adds coloring.
-// For example, InfoLevel is serialized to "info" and colored blue.
+// For example, InfoLevel is serialized to "info" and colored blue. If the
+// encoder's DisableColor is set, the color is omitted.
 func LowercaseColorLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
+	if !colorEnabled(enc) {
+		LowercaseLevelEncoder(l, enc)
+		return
+	}
 	s, ok := _levelToLowercaseColorString[l]
 	if !ok {
 		s = _unknownLevelColor.Add(l.String())
@@ -62,8 +67,13 @@ func CapitalLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 }
 
 // CapitalColorLevelEncoder serializes a Level to an all-caps string and adds color.
-// For example, InfoLevel is serialized to "INFO" and colored blue.
+// For example, InfoLevel is serialized to "INFO" and colored blue. If the
+// encoder's DisableColor is set, the color is omitted.
 func CapitalColorLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
+	if !colorEnabled(enc) {
+		CapitalLevelEncoder(l, enc)
+		return
+	}
 	s, ok := _levelToCapitalColorString[l]
 	if !ok {
 		s = _unknownLevelColor.Add(l.CapitalString())
@@ -73,8 +83,9 @@ func CapitalColorLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 
 // UnmarshalText unmarshals text to a LevelEncoder. "capital" is unmarshaled to
 // CapitalLevelEncoder, "coloredCapital" is unmarshaled to CapitalColorLevelEncoder,
-// "colored" is unmarshaled to LowercaseColorLevelEncoder, and anything else
-// is unmarshaled to LowercaseLevelEncoder.
+// "colored" is unmarshaled to LowercaseColorLevelEncoder, "gcp" is unmarshaled
+// to GCPSeverityLevelEncoder, and anything else is unmarshaled to
+// LowercaseLevelEncoder.
 func (e *LevelEncoder) UnmarshalText(text []byte) error {
 	switch string(text) {
 	case "capital":
@@ -83,6 +94,8 @@ func (e *LevelEncoder) UnmarshalText(text []byte) error {
 		*e = CapitalColorLevelEncoder
 	case "color":
 		*e = LowercaseColorLevelEncoder
+	case "gcp":
+		*e = GCPSeverityLevelEncoder
 	default:
 		*e = LowercaseLevelEncoder
 	}
@@ -276,11 +289,14 @@ func ShortCallerEncoder(caller EntryCaller, enc PrimitiveArrayEncoder) {
 }
 
 // UnmarshalText unmarshals text to a CallerEncoder. "full" is unmarshaled to
-// FullCallerEncoder and anything else is unmarshaled to ShortCallerEncoder.
+// FullCallerEncoder, "gcp" is unmarshaled to GCPSourceLocationCallerEncoder,
+// and anything else is unmarshaled to ShortCallerEncoder.
 func (e *CallerEncoder) UnmarshalText(text []byte) error {
 	switch string(text) {
 	case "full":
 		*e = FullCallerEncoder
+	case "gcp":
+		*e = GCPSourceLocationCallerEncoder
 	default:
 		*e = ShortCallerEncoder
 	}
@@ -338,6 +354,20 @@ type EncoderConfig struct {
 	// Configures the field separator used by the console encoder. Defaults
 	// to tab.
 	ConsoleSeparator string `json:"consoleSeparator" yaml:"consoleSeparator"`
+	// Theme colors the console encoder's output. If nil, the output isn't
+	// colored (other than by color-aware encoders like
+	// CapitalColorLevelEncoder).
+	Theme *Theme `json:"theme" yaml:"theme"`
+	// DisableColor suppresses the Theme and makes color-aware encoders like
+	// CapitalColorLevelEncoder write plain text. zap.Config sets it for
+	// outputs that aren't terminals or when NO_COLOR is set; see
+	// ColorSupported.
+	DisableColor bool `json:"disableColor" yaml:"disableColor"`
+	// Identify the application in the headers of the CEF and LEEF encoders.
+	// DeviceProduct defaults to the name of the program.
+	DeviceVendor  string `json:"deviceVendor" yaml:"deviceVendor"`
+	DeviceProduct string `json:"deviceProduct" yaml:"deviceProduct"`
+	DeviceVersion string `json:"deviceVersion" yaml:"deviceVersion"`
 }
 
 // ObjectEncoder is a strongly-typed, encoding-agnostic interface for adding a
@@ -565,6 +565,7 @@ func TestLevelEncoders(t *testing.T) {
 	}{
 		{"capital", "INFO"},
 		{"lower", "info"},
+		{"gcp", "INFO"},
 		{"", "info"},
 		{"something-random", "info"},
 	}
@@ -682,6 +683,7 @@ func TestCallerEncoders(t *testing.T) {
 		{"something-random", "foo/foo.go:42"},
 		{"short", "foo/foo.go:42"},
 		{"full", "/home/jack/src/github.com/foo/foo.go:42"},
+		{"gcp", map[string]interface{}{"file": "/home/jack/src/github.com/foo/foo.go", "line": 42}},
 	}
 
 	for _, tt := range tests {
@@ -60,6 +60,11 @@ func encodeError(key string, err error, enc ObjectEncoder) (retErr error) {
 		}
 	}()
 
+	if e, ok := enc.(errorEncoder); ok {
+		e.addError(key, err)
+		return nil
+	}
+
 	basic := err.Error()
 	enc.AddString(key, basic)
 
@@ -77,6 +82,12 @@ func encodeError(key string, err error, enc ObjectEncoder) (retErr error) {
 	return nil
 }
 
+// errorEncoder is implemented by ObjectEncoders that encode errors in their
+// own format, like the ECS encoder.
+type errorEncoder interface {
+	addError(key string, err error)
+}
+
 type errorGroup interface {
 	// Provides read-only access to the underlying list of errors, preferably
 	// without causing any allocs.
@@ -0,0 +1,200 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultFailoverMaxFailures   = 3
+	_defaultFailoverRetryInterval = 30 * time.Second
+)
+
+// A FailoverWriteSyncer is a WriteSyncer that writes to a primary
+// WriteSyncer and switches to a secondary one, such as a local file, once
+// the primary has failed too many times in a row.
+//
+// While failed over, the FailoverWriteSyncer periodically checks whether
+// the primary has recovered, either by calling Probe or, if Probe is nil,
+// by writing the next entry to the primary and falling back to the secondary
+// if that fails. Once the primary recovers, writes go to it again.
+//
+// Each switch is reported to ErrorOutput.
+//
+// FailoverWriteSyncer is safe for concurrent use.
+type FailoverWriteSyncer struct {
+	// Primary is the preferred destination for writes.
+	//
+	// This field is required.
+	Primary WriteSyncer
+
+	// Secondary receives writes while the primary is failing.
+	//
+	// This field is required.
+	Secondary WriteSyncer
+
+	// MaxFailures is the number of consecutive failed writes to the primary
+	// after which the FailoverWriteSyncer switches to the secondary. Writes
+	// that fail before then return the primary's error.
+	//
+	// Defaults to 3 if unspecified.
+	MaxFailures int
+
+	// RetryInterval is how long to wait between checks of the primary
+	// while failed over.
+	//
+	// Defaults to 30 seconds if unspecified.
+	RetryInterval time.Duration
+
+	// Probe, if specified, reports whether the primary has recovered. It's
+	// called at most once per RetryInterval while failed over.
+	Probe func() error
+
+	// ErrorOutput receives a message on each switch between the primary and
+	// the secondary.
+	//
+	// Defaults to standard error.
+	ErrorOutput WriteSyncer
+
+	// Clock, if specified, provides control of the source of time for the
+	// retry interval.
+	//
+	// Defaults to the system clock.
+	Clock Clock
+
+	mu        sync.Mutex
+	failures  int       // consecutive failed writes to the primary
+	failed    bool      // whether writes go to the secondary
+	nextProbe time.Time // when to next check the primary while failed
+}
+
+var _ WriteSyncer = (*FailoverWriteSyncer)(nil)
+
+// Write writes to the primary, or to the secondary while failed over.
+func (s *FailoverWriteSyncer) Write(bs []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed {
+		if !s.now().Before(s.nextProbe) {
+			if n, ok := s.recover(bs); ok {
+				return n, nil
+			}
+		}
+		return s.Secondary.Write(bs)
+	}
+
+	n, err := s.Primary.Write(bs)
+	if err == nil {
+		s.failures = 0
+		return n, nil
+	}
+
+	s.failures++
+	if s.failures < s.maxFailures() {
+		return n, err
+	}
+	s.failed = true
+	s.nextProbe = s.now().Add(s.retryInterval())
+	s.report("switching to secondary after %d consecutive write errors: %v", s.failures, err)
+	return s.Secondary.Write(bs)
+}
+
+// Sync syncs the WriteSyncer currently receiving writes.
+func (s *FailoverWriteSyncer) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed {
+		return s.Secondary.Sync()
+	}
+	return s.Primary.Sync()
+}
+
+// FailedOver reports whether writes are currently going to the secondary.
+func (s *FailoverWriteSyncer) FailedOver() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+// recover checks whether the primary has recovered, switching back to it if
+// so. Without a Probe, the check writes bs to the primary; ok reports
+// whether bs was written there. s.mu must be held.
+func (s *FailoverWriteSyncer) recover(bs []byte) (n int, ok bool) {
+	var err error
+	if s.Probe != nil {
+		err = s.Probe()
+	} else {
+		n, err = s.Primary.Write(bs)
+	}
+	if err != nil {
+		s.nextProbe = s.now().Add(s.retryInterval())
+		return 0, false
+	}
+
+	s.failed = false
+	s.failures = 0
+	s.report("primary recovered, switching back")
+	if s.Probe != nil {
+		n, err = s.Primary.Write(bs)
+		if err != nil {
+			s.failures++
+			return 0, false
+		}
+	}
+	return n, true
+}
+
+// report writes a message about a switch to ErrorOutput. s.mu must be held.
+func (s *FailoverWriteSyncer) report(format string, args ...interface{}) {
+	out := s.ErrorOutput
+	if out == nil {
+		out = Lock(os.Stderr)
+	}
+	fmt.Fprintf(out, "%v FailoverWriteSyncer: "+format+"\n", append([]interface{}{s.now().UTC()}, args...)...)
+	out.Sync()
+}
+
+func (s *FailoverWriteSyncer) maxFailures() int {
+	if s.MaxFailures > 0 {
+		return s.MaxFailures
+	}
+	return _defaultFailoverMaxFailures
+}
+
+func (s *FailoverWriteSyncer) retryInterval() time.Duration {
+	if s.RetryInterval > 0 {
+		return s.RetryInterval
+	}
+	return _defaultFailoverRetryInterval
+}
+
+func (s *FailoverWriteSyncer) now() time.Time {
+	if s.Clock == nil {
+		return DefaultClock.Now()
+	}
+	return s.Clock.Now()
+}
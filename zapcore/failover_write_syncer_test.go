@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+)
+
+// flakyWriter is a WriteSyncer that fails while err is set.
+type flakyWriter struct {
+	ztest.Buffer
+	err error
+}
+
+func (w *flakyWriter) Write(bs []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.Buffer.Write(bs)
+}
+
+func writeString(t testing.TB, ws WriteSyncer, s string) error {
+	n, err := ws.Write([]byte(s))
+	if err == nil {
+		assert.Equal(t, len(s), n, "Unexpected number of bytes written.")
+	}
+	return err
+}
+
+func TestFailoverWriteSyncer(t *testing.T) {
+	primary := &flakyWriter{}
+	secondary := &ztest.Buffer{}
+	events := &ztest.Buffer{}
+	clock := ztest.NewMockClock()
+	ws := &FailoverWriteSyncer{
+		Primary:       primary,
+		Secondary:     secondary,
+		MaxFailures:   2,
+		RetryInterval: time.Minute,
+		ErrorOutput:   events,
+		Clock:         clock,
+	}
+
+	require.NoError(t, writeString(t, ws, "a\n"))
+	require.NoError(t, ws.Sync())
+	assert.True(t, primary.Called(), "Expected primary to be synced.")
+
+	// Failures below the threshold return the primary's error, and a
+	// success resets the count.
+	primary.err = errors.New("down")
+	assert.EqualError(t, writeString(t, ws, "lost\n"), "down")
+	primary.err = nil
+	require.NoError(t, writeString(t, ws, "b\n"))
+	primary.err = errors.New("down")
+	assert.EqualError(t, writeString(t, ws, "lost\n"), "down")
+	assert.False(t, ws.FailedOver(), "Expected to stay on primary below the failure threshold.")
+
+	require.NoError(t, writeString(t, ws, "c\n"), "Expected failed write to be redirected to the secondary.")
+	assert.True(t, ws.FailedOver(), "Expected failover after consecutive errors.")
+	require.NoError(t, writeString(t, ws, "d\n"))
+	require.NoError(t, ws.Sync())
+	assert.True(t, secondary.Called(), "Expected secondary to be synced while failed over.")
+
+	// The primary isn't retried until the retry interval has passed.
+	primary.err = nil
+	require.NoError(t, writeString(t, ws, "e\n"))
+	clock.Add(time.Minute)
+	require.NoError(t, writeString(t, ws, "f\n"))
+	assert.False(t, ws.FailedOver(), "Expected primary to be used again once it recovers.")
+	require.NoError(t, writeString(t, ws, "g\n"))
+
+	assert.Equal(t, []string{"a", "b", "f", "g"}, primary.Lines(), "Unexpected writes to primary.")
+	assert.Equal(t, []string{"c", "d", "e"}, secondary.Lines(), "Unexpected writes to secondary.")
+
+	lines := events.Lines()
+	require.Len(t, lines, 2, "Expected an event for each switch.")
+	assert.Contains(t, lines[0], "FailoverWriteSyncer: switching to secondary after 2 consecutive write errors: down")
+	assert.Contains(t, lines[1], "FailoverWriteSyncer: primary recovered, switching back")
+}
+
+func TestFailoverWriteSyncerFailedRetry(t *testing.T) {
+	primary := &flakyWriter{err: errors.New("down")}
+	secondary := &ztest.Buffer{}
+	clock := ztest.NewMockClock()
+	ws := &FailoverWriteSyncer{
+		Primary:     primary,
+		Secondary:   secondary,
+		MaxFailures: 1,
+		ErrorOutput: &ztest.Buffer{},
+		Clock:       clock,
+	}
+
+	require.NoError(t, writeString(t, ws, "a\n"))
+	clock.Add(_defaultFailoverRetryInterval)
+	require.NoError(t, writeString(t, ws, "b\n"), "Expected failed retry to fall back to the secondary.")
+	assert.True(t, ws.FailedOver(), "Expected to stay failed over after a failed retry.")
+
+	// The failed retry schedules the next one.
+	primary.err = nil
+	require.NoError(t, writeString(t, ws, "c\n"))
+	assert.True(t, ws.FailedOver(), "Expected no retry before the interval has passed.")
+	clock.Add(_defaultFailoverRetryInterval)
+	require.NoError(t, writeString(t, ws, "d\n"))
+	assert.False(t, ws.FailedOver(), "Expected primary to recover.")
+
+	assert.Equal(t, []string{"d"}, primary.Lines(), "Unexpected writes to primary.")
+	assert.Equal(t, []string{"a", "b", "c"}, secondary.Lines(), "Unexpected writes to secondary.")
+}
+
+func TestFailoverWriteSyncerProbe(t *testing.T) {
+	primary := &flakyWriter{err: errors.New("down")}
+	secondary := &ztest.Buffer{}
+	clock := ztest.NewMockClock()
+	probeErr := errors.New("still down")
+	probes := 0
+	ws := &FailoverWriteSyncer{
+		Primary:     primary,
+		Secondary:   secondary,
+		MaxFailures: 1,
+		Probe: func() error {
+			probes++
+			return probeErr
+		},
+		ErrorOutput: &ztest.Buffer{},
+		Clock:       clock,
+	}
+
+	require.NoError(t, writeString(t, ws, "a\n"))
+	primary.err = nil
+	clock.Add(_defaultFailoverRetryInterval)
+	require.NoError(t, writeString(t, ws, "b\n"))
+	require.NoError(t, writeString(t, ws, "c\n"))
+	assert.Equal(t, 1, probes, "Expected a single probe per retry interval.")
+	assert.True(t, ws.FailedOver(), "Expected to stay failed over while the probe fails.")
+
+	probeErr = nil
+	clock.Add(_defaultFailoverRetryInterval)
+	require.NoError(t, writeString(t, ws, "d\n"))
+	assert.Equal(t, 2, probes, "Unexpected number of probes.")
+	assert.False(t, ws.FailedOver(), "Expected successful probe to switch back.")
+
+	assert.Equal(t, []string{"d"}, primary.Lines(), "Unexpected writes to primary.")
+	assert.Equal(t, []string{"a", "b", "c"}, secondary.Lines(), "Unexpected writes to secondary.")
+}
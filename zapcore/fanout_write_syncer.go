@@ -0,0 +1,337 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const _defaultFanoutTimeout = time.Second
+
+var (
+	// ErrFanoutTimeout is reported for a destination that didn't finish a
+	// write or sync within its timeout.
+	ErrFanoutTimeout = errors.New("timed out")
+
+	// ErrFanoutDegraded is reported for a destination that was skipped
+	// because an earlier write or sync to it still hasn't returned. It's
+	// reported once each time the destination becomes degraded, by the first
+	// call that skips it.
+	ErrFanoutDegraded = errors.New("skipped degraded destination")
+)
+
+// A FanoutDestination is one of the WriteSyncers written to by a
+// FanoutWriteSyncer.
+type FanoutDestination struct {
+	// Name identifies the destination in errors.
+	//
+	// Defaults to the destination's index if unspecified.
+	Name string
+
+	// WS is the WriteSyncer that receives writes.
+	//
+	// This field is required.
+	WS WriteSyncer
+
+	// Timeout is how long to wait for a write or sync to this destination.
+	//
+	// Defaults to the FanoutWriteSyncer's Timeout if unspecified.
+	Timeout time.Duration
+}
+
+// A FanoutFailure records a failed write or sync to one destination of a
+// FanoutWriteSyncer.
+type FanoutFailure struct {
+	// Destination is the Name of the destination.
+	Destination string
+	// Err is the error returned by the destination, ErrFanoutTimeout, or
+	// ErrFanoutDegraded.
+	Err error
+	// Late is set if Err was returned by an earlier call that had timed
+	// out, and so is reported by the first call after it returned.
+	Late bool
+}
+
+// A FanoutError is returned by a FanoutWriteSyncer when a write or sync
+// fails for some of its destinations.
+type FanoutError struct {
+	// Failures lists the failed destinations, in the order in which they
+	// were configured.
+	Failures []FanoutFailure
+}
+
+func (e *FanoutError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("fanout failed for ")
+	for i, f := range e.Failures {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(f.Destination)
+		if f.Late {
+			sb.WriteString(" (late)")
+		}
+		sb.WriteString(": ")
+		sb.WriteString(f.Err.Error())
+	}
+	return sb.String()
+}
+
+// A FanoutWriteSyncer is a WriteSyncer that duplicates its writes and sync
+// calls to several destinations, like NewMultiWriteSyncer, but writes to
+// them concurrently. A slow destination only holds up the caller for as
+// long as that destination's timeout.
+//
+// A destination that doesn't return within its timeout is marked degraded
+// and left to finish in the background. While degraded, it's skipped; it
+// rejoins the fan-out once the hanging call returns, so a destination that
+// blocks forever can't pile up goroutines. Only the first call that skips a
+// degraded destination reports it, so that an outage doesn't produce an
+// error for every entry. If the hanging call fails, its error is reported by
+// the next Write or Sync as a late FanoutFailure.
+//
+// Since a degraded destination may keep reading from a write after Write
+// returns, each write is copied before it's handed to the destinations.
+//
+// If any destination fails, Write and Sync return a *FanoutError listing
+// the failed destinations. Write reports the smallest number of bytes
+// written to any destination, counting failed destinations as zero and
+// leaving out degraded destinations that were skipped without an error.
+//
+// FanoutWriteSyncer is safe for concurrent use; calls to it are
+// serialized, and each destination receives at most one call at a time.
+type FanoutWriteSyncer struct {
+	// Destinations are the WriteSyncers that receive writes.
+	//
+	// This field is required.
+	Destinations []FanoutDestination
+
+	// Timeout is the default timeout for Destinations without their own.
+	//
+	// Defaults to one second if unspecified.
+	Timeout time.Duration
+
+	// unexported fields for state
+	mu          sync.Mutex
+	initialized bool
+	dests       []*fanoutDest
+}
+
+var _ WriteSyncer = (*FanoutWriteSyncer)(nil)
+
+// fanoutDest holds the state of a destination.
+type fanoutDest struct {
+	name    string
+	ws      WriteSyncer
+	timeout time.Duration
+
+	// pending is non-nil while the destination is degraded. It receives the
+	// result of the call that timed out once that call returns.
+	pending chan fanoutResult
+	// skipped is set once a call has reported skipping the destination
+	// since it became degraded.
+	skipped bool
+	// lateErr is the error returned by the call that timed out, waiting to
+	// be reported.
+	lateErr error
+}
+
+type fanoutResult struct {
+	n   int
+	err error
+}
+
+func (s *FanoutWriteSyncer) initialize() {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = _defaultFanoutTimeout
+	}
+
+	s.dests = make([]*fanoutDest, len(s.Destinations))
+	for i, d := range s.Destinations {
+		fd := &fanoutDest{name: d.Name, ws: d.WS, timeout: d.Timeout}
+		if fd.name == "" {
+			fd.name = strconv.Itoa(i)
+		}
+		if fd.timeout <= 0 {
+			fd.timeout = timeout
+		}
+		s.dests[i] = fd
+	}
+	s.initialized = true
+}
+
+// Write writes bs to every destination that isn't degraded.
+func (s *FanoutWriteSyncer) Write(bs []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		s.initialize()
+	}
+	if len(s.dests) == 0 {
+		return len(bs), nil
+	}
+
+	p := append([]byte(nil), bs...)
+	n, err := s.fanout(func(ws WriteSyncer) (int, error) {
+		return ws.Write(p)
+	})
+	if n < 0 || n > len(bs) {
+		// Either every destination was skipped silently, or one claims to
+		// have written more than it was given.
+		n = len(bs)
+	}
+	return n, err
+}
+
+// Sync syncs every destination that isn't degraded.
+func (s *FanoutWriteSyncer) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		s.initialize()
+	}
+
+	_, err := s.fanout(func(ws WriteSyncer) (int, error) {
+		return 0, ws.Sync()
+	})
+	return err
+}
+
+// Degraded returns the names of the destinations that are currently
+// degraded.
+func (s *FanoutWriteSyncer) Degraded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		s.initialize()
+	}
+
+	var names []string
+	for _, d := range s.dests {
+		if d.degraded() {
+			names = append(names, d.name)
+		}
+	}
+	return names
+}
+
+// fanout calls op concurrently for every healthy destination and waits for
+// each to return or time out. It returns the smallest count returned by op,
+// counting failures as zero, or -1 if no destination was counted. s.mu must
+// be held.
+func (s *FanoutWriteSyncer) fanout(op func(WriteSyncer) (int, error)) (int, error) {
+	start := time.Now()
+	results := make([]chan fanoutResult, len(s.dests))
+	for i, d := range s.dests {
+		if d.degraded() {
+			continue
+		}
+		// Buffered so that a call that times out can still deliver its
+		// result without blocking.
+		res := make(chan fanoutResult, 1)
+		results[i] = res
+		go func(ws WriteSyncer) {
+			n, err := op(ws)
+			res <- fanoutResult{n, err}
+		}(d.ws)
+	}
+
+	var failures []FanoutFailure
+	minN := -1
+	for i, d := range s.dests {
+		if d.lateErr != nil {
+			failures = append(failures, FanoutFailure{Destination: d.name, Err: d.lateErr, Late: true})
+			d.lateErr = nil
+		}
+		res := results[i]
+		if res == nil {
+			if !d.skipped {
+				d.skipped = true
+				failures = append(failures, FanoutFailure{Destination: d.name, Err: ErrFanoutDegraded})
+				minN = 0
+			}
+			continue
+		}
+
+		r, ok := d.wait(res, start)
+		if !ok {
+			d.pending = res
+			d.skipped = false
+			r.err = ErrFanoutTimeout
+		}
+		if r.err != nil {
+			failures = append(failures, FanoutFailure{Destination: d.name, Err: r.err})
+			r.n = 0
+		}
+		if minN < 0 || r.n < minN {
+			minN = r.n
+		}
+	}
+
+	if len(failures) > 0 {
+		return minN, &FanoutError{Failures: failures}
+	}
+	return minN, nil
+}
+
+// wait waits for the result of a call started at start, until the
+// destination's timeout expires.
+func (d *fanoutDest) wait(res <-chan fanoutResult, start time.Time) (fanoutResult, bool) {
+	select {
+	case r := <-res:
+		return r, true
+	default:
+	}
+
+	t := time.NewTimer(d.timeout - time.Since(start))
+	defer t.Stop()
+	select {
+	case r := <-res:
+		return r, true
+	case <-t.C:
+		return fanoutResult{}, false
+	}
+}
+
+// degraded reports whether a timed-out call to the destination is still
+// running, clearing the degraded state and keeping the call's error if it
+// has since returned.
+func (d *fanoutDest) degraded() bool {
+	if d.pending == nil {
+		return false
+	}
+	select {
+	case r := <-d.pending:
+		d.pending = nil
+		d.lateErr = r.err
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+)
+
+// blockingWriter is a WriteSyncer whose writes and syncs block until
+// released, then fail with err if it's set.
+type blockingWriter struct {
+	ztest.Buffer
+	release chan struct{}
+	started chan struct{}
+	err     error
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{
+		release: make(chan struct{}),
+		started: make(chan struct{}, 10),
+	}
+}
+
+func (w *blockingWriter) Write(bs []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.Buffer.Write(bs)
+}
+
+func (w *blockingWriter) Sync() error {
+	w.started <- struct{}{}
+	<-w.release
+	return w.Buffer.Sync()
+}
+
+func TestFanoutWriteSyncer(t *testing.T) {
+	a, b := &ztest.Buffer{}, &ztest.Buffer{}
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{{WS: a}, {WS: b}},
+	}
+
+	require.NoError(t, writeString(t, ws, "foo\n"), "Unexpected error writing.")
+	require.NoError(t, writeString(t, ws, "bar\n"), "Unexpected error writing.")
+	require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+
+	for _, buf := range []*ztest.Buffer{a, b} {
+		assert.Equal(t, "foo\nbar\n", buf.String(), "Unexpected destination contents.")
+		assert.True(t, buf.Called(), "Expected destination to be synced.")
+	}
+	assert.Empty(t, ws.Degraded(), "Expected no degraded destinations.")
+}
+
+func TestFanoutWriteSyncerNoDestinations(t *testing.T) {
+	ws := &FanoutWriteSyncer{}
+	require.NoError(t, writeString(t, ws, "foo\n"), "Unexpected error writing.")
+	assert.NoError(t, ws.Sync(), "Unexpected error syncing.")
+}
+
+func TestFanoutWriteSyncerErrors(t *testing.T) {
+	ok := &ztest.Buffer{}
+	failing := &flakyWriter{err: errors.New("down")}
+	short := &ztest.ShortWriter{}
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{
+			{Name: "ok", WS: ok},
+			{Name: "failing", WS: failing},
+			{Name: "short", WS: short},
+		},
+	}
+
+	n, err := ws.Write([]byte("foo\n"))
+	assert.Equal(t, 0, n, "Expected failed destinations to count as no bytes written.")
+	require.Error(t, err, "Expected an error writing.")
+	assert.Equal(t, "fanout failed for failing: down", err.Error(), "Unexpected error message.")
+
+	var fanoutErr *FanoutError
+	require.True(t, errors.As(err, &fanoutErr), "Expected a *FanoutError.")
+	assert.Equal(t, []FanoutFailure{{Destination: "failing", Err: failing.err}}, fanoutErr.Failures,
+		"Unexpected failures.")
+	assert.Equal(t, "foo\n", ok.String(), "Expected healthy destination to be written.")
+
+	failing.err = nil
+	n, err = ws.Write([]byte("bar\n"))
+	assert.NoError(t, err, "Unexpected error writing.")
+	assert.Equal(t, 3, n, "Expected the smallest count of bytes written.")
+}
+
+// failAfterWriter writes everything it's given, then fails anyway.
+type failAfterWriter struct {
+	ztest.Buffer
+	err error
+}
+
+func (w *failAfterWriter) Write(bs []byte) (int, error) {
+	n, _ := w.Buffer.Write(bs)
+	return n, w.err
+}
+
+func TestFanoutWriteSyncerFailureCount(t *testing.T) {
+	failing := &failAfterWriter{err: errors.New("down")}
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{{WS: &ztest.Buffer{}}, {WS: failing}},
+	}
+
+	n, err := ws.Write([]byte("foo\n"))
+	assert.Error(t, err, "Expected an error writing.")
+	assert.Equal(t, 0, n, "Expected a failed destination to count as zero bytes, whatever it returned.")
+}
+
+func TestFanoutWriteSyncerTimeout(t *testing.T) {
+	fast := &ztest.Buffer{}
+	slow := newBlockingWriter()
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{
+			{Name: "fast", WS: fast},
+			{Name: "slow", WS: slow, Timeout: 10 * time.Millisecond},
+		},
+		Timeout: time.Minute,
+	}
+
+	bs := []byte("foo\n")
+	start := time.Now()
+	_, err := ws.Write(bs)
+	assert.Less(t, int64(time.Since(start)), int64(time.Minute), "Expected the slow destination's timeout to apply.")
+	require.Error(t, err, "Expected an error writing to a hanging destination.")
+	assert.Equal(t, &FanoutError{Failures: []FanoutFailure{
+		{Destination: "slow", Err: ErrFanoutTimeout},
+	}}, err, "Unexpected error.")
+	assert.Equal(t, []string{"slow"}, ws.Degraded(), "Expected hanging destination to be degraded.")
+
+	// The caller may reuse its buffer while the degraded destination still
+	// holds the write.
+	copy(bs, "XXX\n")
+
+	// While degraded, the destination is skipped. Only the first call to
+	// skip it reports that.
+	n, err := ws.Write([]byte("bar\n"))
+	assert.Equal(t, 0, n, "Expected the reported skip to count as no bytes written.")
+	assert.Equal(t, &FanoutError{Failures: []FanoutFailure{
+		{Destination: "slow", Err: ErrFanoutDegraded},
+	}}, err, "Unexpected error.")
+	n, err = ws.Write([]byte("qux\n"))
+	assert.NoError(t, err, "Expected the degraded destination to be reported once.")
+	assert.Equal(t, 4, n, "Expected the healthy destination's count.")
+	assert.NoError(t, ws.Sync(), "Expected the degraded destination to be reported once.")
+	assert.Len(t, slow.started, 1, "Expected no further calls to the degraded destination.")
+	assert.Equal(t, "foo\nbar\nqux\n", fast.String(), "Expected healthy destination to keep receiving writes.")
+
+	// Once the hanging write returns, the destination rejoins the fan-out.
+	close(slow.release)
+	assert.Eventually(t, func() bool {
+		return len(ws.Degraded()) == 0
+	}, time.Second, time.Millisecond, "Expected destination to recover.")
+	require.NoError(t, writeString(t, ws, "baz\n"), "Unexpected error after recovery.")
+	assert.Equal(t, "foo\nbaz\n", slow.String(), "Unexpected contents of recovered destination.")
+	assert.Equal(t, "foo\nbar\nqux\nbaz\n", fast.String(), "Unexpected contents of healthy destination.")
+}
+
+func TestFanoutWriteSyncerLateError(t *testing.T) {
+	slow := newBlockingWriter()
+	slow.err = errors.New("disk full")
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{{Name: "slow", WS: slow}},
+		Timeout:      10 * time.Millisecond,
+	}
+
+	_, err := ws.Write([]byte("foo\n"))
+	assert.Equal(t, &FanoutError{Failures: []FanoutFailure{
+		{Destination: "slow", Err: ErrFanoutTimeout},
+	}}, err, "Unexpected error.")
+
+	close(slow.release)
+	assert.Eventually(t, func() bool {
+		return len(ws.Degraded()) == 0
+	}, time.Second, time.Millisecond, "Expected destination to recover.")
+
+	// The timed-out write's error is reported by the next call, which
+	// otherwise succeeds.
+	err = ws.Sync()
+	assert.Equal(t, &FanoutError{Failures: []FanoutFailure{
+		{Destination: "slow", Err: slow.err, Late: true},
+	}}, err, "Expected the late error to be reported.")
+	assert.Equal(t, "fanout failed for slow (late): disk full", err.Error(), "Unexpected error message.")
+	assert.NoError(t, ws.Sync(), "Expected the late error to be reported once.")
+}
+
+func TestFanoutWriteSyncerSyncTimeout(t *testing.T) {
+	slow := newBlockingWriter()
+	defer close(slow.release)
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{{WS: &ztest.Buffer{}}, {WS: slow}},
+		Timeout:      10 * time.Millisecond,
+	}
+
+	err := ws.Sync()
+	assert.Equal(t, &FanoutError{Failures: []FanoutFailure{
+		{Destination: "1", Err: ErrFanoutTimeout},
+	}}, err, "Expected destinations to be named by index.")
+	assert.Equal(t, []string{"1"}, ws.Degraded(), "Expected hanging destination to be degraded.")
+}
+
+func TestFanoutWriteSyncerConcurrent(t *testing.T) {
+	a, b := &ztest.Buffer{}, &ztest.Buffer{}
+	ws := &FanoutWriteSyncer{
+		Destinations: []FanoutDestination{{WS: a}, {WS: b}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, writeString(t, ws, "foo\n"), "Unexpected error writing.")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, a.Lines(), 100, "Unexpected number of writes.")
+	assert.Len(t, b.Lines(), 100, "Unexpected number of writes.")
+}
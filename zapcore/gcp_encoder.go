@@ -0,0 +1,181 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import "go.uber.org/zap/buffer"
+
+// GCPReportedErrorEventType is the @type that marks a Cloud Logging entry as
+// an error event for Error Reporting.
+const GCPReportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// GCPSeverityLevelEncoder serializes a Level to the name of a Google Cloud
+// Logging LogSeverity. DebugLevel, InfoLevel, WarnLevel, and ErrorLevel are
+// serialized to "DEBUG", "INFO", "WARNING", and "ERROR", while DPanicLevel,
+// PanicLevel, and FatalLevel are serialized to "CRITICAL", "ALERT", and
+// "EMERGENCY". Unknown levels are serialized to "DEFAULT".
+func GCPSeverityLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
+	switch l {
+	case DebugLevel:
+		enc.AppendString("DEBUG")
+	case InfoLevel:
+		enc.AppendString("INFO")
+	case WarnLevel:
+		enc.AppendString("WARNING")
+	case ErrorLevel:
+		enc.AppendString("ERROR")
+	case DPanicLevel:
+		enc.AppendString("CRITICAL")
+	case PanicLevel:
+		enc.AppendString("ALERT")
+	case FatalLevel:
+		enc.AppendString("EMERGENCY")
+	default:
+		enc.AppendString("DEFAULT")
+	}
+}
+
+// GCPSourceLocationCallerEncoder serializes a caller to a Google Cloud Logging
+// LogEntrySourceLocation object, with file, line, and function members. It's
+// meant to be used with the "logging.googleapis.com/sourceLocation" caller
+// key. The console encoder, and encoders that can't append objects, get the
+// caller in the same format as FullCallerEncoder.
+func GCPSourceLocationCallerEncoder(caller EntryCaller, enc PrimitiveArrayEncoder) {
+	type appendObjectEncoder interface {
+		AppendObject(ObjectMarshaler) error
+	}
+
+	if _, ok := enc.(*consoleArrayEncoder); ok {
+		FullCallerEncoder(caller, enc)
+		return
+	}
+	if enc, ok := enc.(appendObjectEncoder); ok {
+		enc.AppendObject(gcpSourceLocation(caller))
+		return
+	}
+	FullCallerEncoder(caller, enc)
+}
+
+type gcpSourceLocation EntryCaller
+
+func (c gcpSourceLocation) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("file", c.File)
+	enc.AddInt("line", c.Line)
+	if c.Function != "" {
+		enc.AddString("function", c.Function)
+	}
+	return nil
+}
+
+// gcpReportLocation is an Error Reporting ErrorContext, holding only the
+// location of the report.
+type gcpReportLocation EntryCaller
+
+func (c gcpReportLocation) MarshalLogObject(enc ObjectEncoder) error {
+	return enc.AddObject("reportLocation", ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+		enc.AddString("filePath", c.File)
+		enc.AddInt("lineNumber", c.Line)
+		if c.Function != "" {
+			enc.AddString("functionName", c.Function)
+		}
+		return nil
+	}))
+}
+
+type gcpEncoder struct {
+	*jsonEncoder
+}
+
+// NewGCPEncoder creates a JSON encoder for Google Cloud Logging's structured
+// logging, as read by the logging agents of GKE, Cloud Run, and other
+// platforms. It's meant to be used with the keys and encoders of
+// zap.NewGCPEncoderConfig.
+//
+// Besides encoding JSON, it marks entries at ErrorLevel and above that have a
+// caller as Error Reporting events: they get a top-level @type field of
+// GCPReportedErrorEventType, and a top-level context field holding the caller
+// as the report location, so that they show up in Error Reporting even
+// without a stack trace in the format it parses. If the entry already has a
+// top-level field named @type or context, that field is kept instead.
+func NewGCPEncoder(cfg EncoderConfig) Encoder {
+	return gcpEncoder{newJSONEncoder(cfg, false)}
+}
+
+func (enc gcpEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	return gcpEncoder{clone}
+}
+
+func (enc gcpEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	if ent.Level < ErrorLevel || !ent.Caller.Defined {
+		return enc.jsonEncoder.EncodeEntry(ent, fields)
+	}
+	return enc.encodeEntry(ent, fields, gcpReportLocation(ent.Caller).addErrorEvent)
+}
+
+// addErrorEvent adds the @type and context fields of an Error Reporting event
+// to the top-level object being encoded, skipping any already present.
+func (c gcpReportLocation) addErrorEvent(enc *jsonEncoder) {
+	hasType, hasContext := gcpTopLevelKeys(enc.buf.Bytes())
+	if !hasType {
+		enc.AddString("@type", GCPReportedErrorEventType)
+	}
+	if !hasContext {
+		enc.AddObject("context", c)
+	}
+}
+
+// gcpTopLevelKeys reports whether the JSON object in js, which may be missing
+// its closing brace, has top-level @type and context keys.
+func gcpTopLevelKeys(js []byte) (hasType, hasContext bool) {
+	depth := 0
+	wantKey := false
+	for i := 0; i < len(js); i++ {
+		switch js[i] {
+		case '{':
+			depth++
+			wantKey = depth == 1
+		case '[':
+			depth++
+		case '}', ']':
+			depth--
+		case ',':
+			wantKey = depth == 1
+		case '"':
+			start := i + 1
+			for i++; i < len(js) && js[i] != '"'; i++ {
+				if js[i] == '\\' {
+					i++
+				}
+			}
+			if wantKey {
+				switch string(js[start:i]) {
+				case "@type":
+					hasType = true
+				case "context":
+					hasContext = true
+				}
+				wantKey = false
+			}
+		}
+	}
+	return hasType, hasContext
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGCPSeverityLevelEncoder(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{zapcore.DebugLevel, "DEBUG"},
+		{zapcore.InfoLevel, "INFO"},
+		{zapcore.WarnLevel, "WARNING"},
+		{zapcore.ErrorLevel, "ERROR"},
+		{zapcore.DPanicLevel, "CRITICAL"},
+		{zapcore.PanicLevel, "ALERT"},
+		{zapcore.FatalLevel, "EMERGENCY"},
+		{zapcore.Level(42), "DEFAULT"},
+	}
+
+	for _, tt := range tests {
+		enc := zapcore.NewMapObjectEncoder()
+		enc.AddArray("k", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			zapcore.GCPSeverityLevelEncoder(tt.level, arr)
+			return nil
+		}))
+		assert.Equal(t, []interface{}{tt.want}, enc.Fields["k"], "Unexpected severity for %v.", tt.level)
+	}
+}
+
+func TestGCPEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891011000, time.UTC)
+	caller := zapcore.EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42, Function: "main.run"}
+	tests := []struct {
+		desc   string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc:   "info",
+			ent:    zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello", Caller: caller},
+			fields: []zapcore.Field{zap.String("k", "v")},
+			want: `{"severity":"INFO","time":"2022-03-04T05:06:07.891011Z",` +
+				`"logging.googleapis.com/sourceLocation":{"file":"/src/app/main.go","line":42,"function":"main.run"},` +
+				`"message":"hello","k":"v"}`,
+		},
+		{
+			desc:   "error",
+			ent:    zapcore.Entry{Level: zapcore.ErrorLevel, Time: ts, Message: "failed", Caller: caller},
+			fields: []zapcore.Field{zap.String("k", "v")},
+			want: `{"severity":"ERROR","time":"2022-03-04T05:06:07.891011Z",` +
+				`"logging.googleapis.com/sourceLocation":{"file":"/src/app/main.go","line":42,"function":"main.run"},` +
+				`"message":"failed","k":"v",` +
+				`"@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",` +
+				`"context":{"reportLocation":{"filePath":"/src/app/main.go","lineNumber":42,"functionName":"main.run"}}}`,
+		},
+		{
+			desc: "error with namespace",
+			ent:  zapcore.Entry{Level: zapcore.ErrorLevel, Time: ts, Message: "failed", Caller: caller},
+			fields: []zapcore.Field{
+				zap.Namespace("space"),
+				zap.String("k", "v"),
+				zap.String("context", "nested"),
+			},
+			want: `{"severity":"ERROR","time":"2022-03-04T05:06:07.891011Z",` +
+				`"logging.googleapis.com/sourceLocation":{"file":"/src/app/main.go","line":42,"function":"main.run"},` +
+				`"message":"failed","space":{"k":"v","context":"nested"},` +
+				`"@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",` +
+				`"context":{"reportLocation":{"filePath":"/src/app/main.go","lineNumber":42,"functionName":"main.run"}}}`,
+		},
+		{
+			desc: "error with user context",
+			ent:  zapcore.Entry{Level: zapcore.ErrorLevel, Time: ts, Message: "failed", Caller: caller},
+			fields: []zapcore.Field{
+				zap.Strings("tags", []string{"a,b", `"context"`}),
+				zap.Any("context", map[string]string{"user": "u1"}),
+			},
+			want: `{"severity":"ERROR","time":"2022-03-04T05:06:07.891011Z",` +
+				`"logging.googleapis.com/sourceLocation":{"file":"/src/app/main.go","line":42,"function":"main.run"},` +
+				`"message":"failed","tags":["a,b","\"context\""],"context":{"user":"u1"},` +
+				`"@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"}`,
+		},
+		{
+			desc: "error without caller",
+			ent:  zapcore.Entry{Level: zapcore.FatalLevel, Time: ts, Message: "failed"},
+			want: `{"severity":"EMERGENCY","time":"2022-03-04T05:06:07.891011Z","message":"failed"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewGCPEncoder(zap.NewGCPEncoderConfig())
+			fields := tt.fields[:len(tt.fields):len(tt.fields)]
+			buf, err := enc.EncodeEntry(tt.ent, fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want+"\n", buf.String(), "Unexpected structured log entry.")
+			assert.Len(t, fields, len(tt.fields), "Expected the fields to be unmodified.")
+			buf.Free()
+		})
+	}
+}
+
+func TestGCPEncoderClone(t *testing.T) {
+	enc := zapcore.NewGCPEncoder(zap.NewGCPEncoderConfig())
+	enc.AddString("parent", "p")
+
+	child := enc.Clone()
+	child.AddString("child", "c")
+
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Time:    time.Date(2022, time.March, 4, 5, 6, 7, 0, time.UTC),
+		Message: "m",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "f.go", Line: 1},
+	}
+	buf, err := child.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"severity":"ERROR","time":"2022-03-04T05:06:07Z","logging.googleapis.com/sourceLocation":{"file":"f.go","line":1},"message":"m","parent":"p","child":"c",`+
+			`"@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",`+
+			`"context":{"reportLocation":{"filePath":"f.go","lineNumber":1}}}`+"\n",
+		buf.String(), "Expected clone to keep the Error Reporting fields.")
+	buf.Free()
+}
+
+func TestGCPEncoderNamespaceContext(t *testing.T) {
+	enc := zapcore.NewGCPEncoder(zap.NewGCPEncoderConfig())
+	enc.OpenNamespace("request")
+	enc.AddString("id", "r1")
+
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Time:    time.Date(2022, time.March, 4, 5, 6, 7, 0, time.UTC),
+		Message: "m",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "f.go", Line: 1},
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.String("k", "v")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"severity":"ERROR","time":"2022-03-04T05:06:07Z","logging.googleapis.com/sourceLocation":{"file":"f.go","line":1},"message":"m","request":{"id":"r1","k":"v"},`+
+			`"@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",`+
+			`"context":{"reportLocation":{"filePath":"f.go","lineNumber":1}}}`+"\n",
+		buf.String(), "Expected the Error Reporting fields outside the namespace.")
+	buf.Free()
+}
+
+func TestGCPSourceLocationCallerEncoderConsole(t *testing.T) {
+	cfg := zap.NewGCPEncoderConfig()
+	cfg.TimeKey = ""
+	enc := zapcore.NewConsoleEncoder(cfg)
+	ent := zapcore.Entry{
+		Message: "m",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "/src/f.go", Line: 7},
+	}
+	buf, err := enc.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "INFO\t/src/f.go:7\tm\n", buf.String(), "Expected console encoder to fall back to a string caller.")
+	buf.Free()
+}
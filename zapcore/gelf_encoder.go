@@ -0,0 +1,396 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// _gelfVersion is the version of the GELF specification implemented by the
+// GELF encoder.
+const _gelfVersion = "1.1"
+
+// A GELFOption configures a GELF encoder.
+type GELFOption interface {
+	apply(*gelfOptions)
+}
+
+type gelfOptionFunc func(*gelfOptions)
+
+func (f gelfOptionFunc) apply(o *gelfOptions) {
+	f(o)
+}
+
+type gelfOptions struct {
+	host string
+}
+
+// WithGELFHost sets the host of each message. Defaults to the name reported
+// by os.Hostname.
+func WithGELFHost(host string) GELFOption {
+	return gelfOptionFunc(func(o *gelfOptions) {
+		o.host = host
+	})
+}
+
+var _gelfPool = sync.Pool{New: func() interface{} {
+	return &gelfEncoder{}
+}}
+
+func getGELFEncoder() *gelfEncoder {
+	return _gelfPool.Get().(*gelfEncoder)
+}
+
+func putGELFEncoder(enc *gelfEncoder) {
+	putJSONEncoder(enc.json)
+	enc.EncoderConfig = nil
+	enc.opts = nil
+	enc.buf = nil
+	enc.json = nil
+	enc.prefix = ""
+	_gelfPool.Put(enc)
+}
+
+type gelfEncoder struct {
+	*EncoderConfig
+	opts *gelfOptions
+
+	// buf holds the additional fields added so far, each preceded by a comma.
+	buf *buffer.Buffer
+	// json writes the values of additional fields to buf.
+	json *jsonEncoder
+	// prefix is prepended to the names of fields added in namespaces and
+	// nested objects.
+	prefix string
+}
+
+// NewGELFEncoder creates an encoder whose output is a GELF 1.1 message, as
+// ingested by Graylog. The message is used as the short_message, the stack
+// trace (if the stacktrace key is configured) as the full_message, and the
+// entry's level is mapped to a numeric syslog severity.
+//
+// Fields, along with the logger name, caller, and function (if their keys are
+// configured), are encoded as additional fields: their names are prefixed
+// with an underscore, and any characters other than letters, digits,
+// underscores, dashes and dots are replaced with underscores. Since GELF
+// reserves the "_id" field, a field named "id" is encoded as "__id". Fields of
+// nested objects and namespaces are flattened with dotted names. GELF only
+// permits string and numeric values, so booleans are encoded as strings,
+// arrays and structured reflected values as JSON strings, and reflected
+// values that encode to null are omitted. The EncodeTime, EncodeDuration,
+// EncodeCaller and EncodeName functions are honored for field values; the
+// timestamp is always encoded as seconds since the epoch.
+//
+// An empty time or level key omits the timestamp or level; the message key
+// is ignored, since GELF requires a short_message.
+func NewGELFEncoder(cfg EncoderConfig, opts ...GELFOption) Encoder {
+	if cfg.SkipLineEnding {
+		cfg.LineEnding = ""
+	} else if cfg.LineEnding == "" {
+		cfg.LineEnding = DefaultLineEnding
+	}
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+
+	o := &gelfOptions{host: "localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		o.host = hostname
+	}
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	enc := &gelfEncoder{
+		EncoderConfig: &cfg,
+		opts:          o,
+		buf:           bufferpool.Get(),
+	}
+	enc.json = enc.newJSONEncoder(enc.buf)
+	return enc
+}
+
+// newJSONEncoder returns a pooled JSON encoder that writes to buf.
+func (enc *gelfEncoder) newJSONEncoder(buf *buffer.Buffer) *jsonEncoder {
+	j := getJSONEncoder()
+	j.EncoderConfig = enc.EncoderConfig
+	j.buf = buf
+	return j
+}
+
+func (enc *gelfEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	return enc.addJSON(key, func(j *jsonEncoder) error {
+		return j.AppendArray(arr)
+	})
+}
+
+func (enc *gelfEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	old := enc.prefix
+	enc.prefix = old + key + "."
+	err := obj.MarshalLogObject(enc)
+	enc.prefix = old
+	return err
+}
+
+func (enc *gelfEncoder) AddReflected(key string, obj interface{}) error {
+	return enc.addJSON(key, func(j *jsonEncoder) error {
+		return j.AppendReflected(obj)
+	})
+}
+
+// addJSON adds a field whose value is JSON written by f. Strings and numbers
+// are added as is, nulls are left out, and other values are added as JSON
+// strings.
+func (enc *gelfEncoder) addJSON(key string, f func(*jsonEncoder) error) error {
+	j := enc.newJSONEncoder(bufferpool.Get())
+	defer func() {
+		j.buf.Free()
+		putJSONEncoder(j)
+	}()
+
+	if err := f(j); err != nil {
+		return err
+	}
+	bs := j.buf.Bytes()
+	if bytes.Equal(bs, nullLiteralBytes) {
+		return nil
+	}
+	enc.addKey(key)
+	if len(bs) == 0 {
+		// A custom ReflectedEncoder wrote nothing, so add an empty string.
+		enc.json.AppendString("")
+		return nil
+	}
+	switch c := bs[0]; {
+	case c == '"' || c == '-' || (c >= '0' && c <= '9'):
+		enc.buf.Write(bs)
+	default:
+		enc.json.AppendByteString(bs)
+	}
+	return nil
+}
+
+func (enc *gelfEncoder) OpenNamespace(key string) {
+	enc.prefix = enc.prefix + key + "."
+}
+
+func (enc *gelfEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *gelfEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.json.AppendByteString(val)
+}
+
+func (enc *gelfEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	if val {
+		enc.json.AppendString("true")
+	} else {
+		enc.json.AppendString("false")
+	}
+}
+
+func (enc *gelfEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.json.AppendComplex128(val)
+}
+
+func (enc *gelfEncoder) AddComplex64(key string, val complex64) {
+	enc.addKey(key)
+	enc.json.AppendComplex64(val)
+}
+
+func (enc *gelfEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.json.AppendDuration(val)
+}
+
+func (enc *gelfEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.json.AppendFloat64(val)
+}
+
+func (enc *gelfEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.json.AppendFloat32(val)
+}
+
+func (enc *gelfEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.json.AppendInt64(val)
+}
+
+func (enc *gelfEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.json.AppendString(val)
+}
+
+func (enc *gelfEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.json.AppendTime(val)
+}
+
+func (enc *gelfEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.json.AppendUint64(val)
+}
+
+func (enc *gelfEncoder) AddInt(k string, v int)         { enc.AddInt64(k, int64(v)) }
+func (enc *gelfEncoder) AddInt32(k string, v int32)     { enc.AddInt64(k, int64(v)) }
+func (enc *gelfEncoder) AddInt16(k string, v int16)     { enc.AddInt64(k, int64(v)) }
+func (enc *gelfEncoder) AddInt8(k string, v int8)       { enc.AddInt64(k, int64(v)) }
+func (enc *gelfEncoder) AddUint(k string, v uint)       { enc.AddUint64(k, uint64(v)) }
+func (enc *gelfEncoder) AddUint32(k string, v uint32)   { enc.AddUint64(k, uint64(v)) }
+func (enc *gelfEncoder) AddUint16(k string, v uint16)   { enc.AddUint64(k, uint64(v)) }
+func (enc *gelfEncoder) AddUint8(k string, v uint8)     { enc.AddUint64(k, uint64(v)) }
+func (enc *gelfEncoder) AddUintptr(k string, v uintptr) { enc.AddUint64(k, uint64(v)) }
+
+func (enc *gelfEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+func (enc *gelfEncoder) clone() *gelfEncoder {
+	clone := getGELFEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.opts = enc.opts
+	clone.prefix = enc.prefix
+	clone.buf = bufferpool.Get()
+	clone.json = clone.newJSONEncoder(clone.buf)
+	return clone
+}
+
+func (enc *gelfEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+	final.buf.Write(enc.buf.Bytes())
+
+	// Entry metadata goes first, outside of any namespaces.
+	final.prefix = ""
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		cur := final.buf.Len()
+		if e := final.EncodeName; e != nil {
+			e(ent.LoggerName, final.json)
+		}
+		if cur == final.buf.Len() {
+			// User-supplied EncodeName was a no-op. Fall back to strings to
+			// keep output JSON valid.
+			final.json.AppendString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" && final.EncodeCaller != nil {
+			final.addKey(final.CallerKey)
+			cur := final.buf.Len()
+			final.EncodeCaller(ent.Caller, final.json)
+			if cur == final.buf.Len() {
+				final.json.AppendString(ent.Caller.String())
+			}
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	final.prefix = enc.prefix
+	addFields(final, fields)
+
+	line := bufferpool.Get()
+	header := final.newJSONEncoder(line)
+	line.AppendString(`{"version":"` + _gelfVersion + `","host":`)
+	header.AppendString(final.opts.host)
+	line.AppendString(`,"short_message":`)
+	header.AppendString(ent.Message)
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		line.AppendString(`,"full_message":`)
+		header.AppendString(ent.Stack)
+	}
+	if final.TimeKey != "" && !ent.Time.IsZero() {
+		line.AppendString(`,"timestamp":`)
+		appendGELFTimestamp(line, ent.Time)
+	}
+	if final.LevelKey != "" {
+		line.AppendString(`,"level":`)
+		line.AppendInt(int64(syslogSeverity(ent.Level)))
+	}
+	line.Write(final.buf.Bytes())
+	line.AppendByte('}')
+	line.AppendString(final.LineEnding)
+	putJSONEncoder(header)
+
+	final.buf.Free()
+	putGELFEncoder(final)
+	return line, nil
+}
+
+// addKey starts an additional field with the given name, qualified by the
+// current prefix. Its value must be written with the JSON encoder.
+func (enc *gelfEncoder) addKey(key string) {
+	enc.buf.AppendString(`,"_`)
+	if enc.prefix == "" && key == "id" {
+		// GELF reserves "_id".
+		enc.buf.AppendByte('_')
+	}
+	appendGELFName(enc.buf, enc.prefix)
+	appendGELFName(enc.buf, key)
+	enc.buf.AppendString(`":`)
+}
+
+// appendGELFName appends s, replacing any characters not permitted in the
+// names of additional fields with underscores.
+func appendGELFName(buf *buffer.Buffer, s string) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			buf.AppendByte('_')
+			i += size
+			continue
+		}
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '_', c == '-', c == '.':
+			buf.AppendByte(c)
+		default:
+			buf.AppendByte('_')
+		}
+		i++
+	}
+}
+
+// appendGELFTimestamp appends t as seconds since the epoch, with millisecond
+// precision.
+func appendGELFTimestamp(buf *buffer.Buffer, t time.Time) {
+	var scratch [32]byte
+	sec := float64(t.UnixNano()/int64(time.Millisecond)) / 1000
+	buf.Write(strconv.AppendFloat(scratch[:0], sec, 'f', 3, 64))
+}
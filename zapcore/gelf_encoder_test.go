@@ -0,0 +1,239 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+func testGELFEncoder() zapcore.Encoder {
+	return zapcore.NewGELFEncoder(zap.NewProductionEncoderConfig(), zapcore.WithGELFHost("myhost"))
+}
+
+func TestGELFEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891011000, time.UTC)
+	tests := []struct {
+		desc   string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc: "minimal",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			want: `{"version":"1.1","host":"myhost","short_message":"hello","timestamp":1646370367.891,"level":6}` + "\n",
+		},
+		{
+			desc: "no time",
+			ent:  zapcore.Entry{Level: zapcore.ErrorLevel, Message: "oops"},
+			want: `{"version":"1.1","host":"myhost","short_message":"oops","level":3}` + "\n",
+		},
+		{
+			desc: "escaped message",
+			ent:  zapcore.Entry{Level: zapcore.WarnLevel, Message: "a \"quoted\"\nmessage"},
+			want: `{"version":"1.1","host":"myhost","short_message":"a \"quoted\"\nmessage","level":4}` + "\n",
+		},
+		{
+			desc: "fields",
+			ent:  zapcore.Entry{Level: zapcore.DebugLevel, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.String("str", "s"),
+				zap.Int("int", -42),
+				zap.Bool("bool", true),
+				zap.Float64("float", 1.5),
+				zap.Complex128("complex", 1+2i),
+				zap.Duration("dur", 1500*time.Millisecond),
+				zap.Time("ts", time.Unix(1, 0)),
+				zap.Strings("strs", []string{"a", "b"}),
+				zap.Binary("bin", []byte("hi")),
+				zap.ByteString("bs", []byte("bytes")),
+				zap.Error(errors.New("boom")),
+				zap.Reflect("reflected", map[string]int{"a": 1}),
+				zap.Reflect("reflectedNum", 3),
+				zap.Reflect("reflectedNil", nil),
+				zap.Reflect("reflectedNilMap", map[string]int(nil)),
+				zap.String("bad key=näme", "ok"),
+				zap.String("id", "reserved"),
+			},
+			want: `{"version":"1.1","host":"myhost","short_message":"hello","level":7` +
+				`,"_str":"s"` +
+				`,"_int":-42` +
+				`,"_bool":"true"` +
+				`,"_float":1.5` +
+				`,"_complex":"1+2i"` +
+				`,"_dur":1.5` +
+				`,"_ts":1` +
+				`,"_strs":"[\"a\",\"b\"]"` +
+				`,"_bin":"aGk="` +
+				`,"_bs":"bytes"` +
+				`,"_error":"boom"` +
+				`,"_reflected":"{\"a\":1}"` +
+				`,"_reflectedNum":3` +
+				`,"_bad_key_n_me":"ok"` +
+				`,"__id":"reserved"` +
+				"}\n",
+		},
+		{
+			desc: "nested objects and namespaces",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Object("obj", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("k", "v")
+					enc.OpenNamespace("ns")
+					enc.AddInt("id", 1)
+					return nil
+				})),
+				zap.Namespace("outer"),
+				zap.String("k", "v"),
+			},
+			want: `{"version":"1.1","host":"myhost","short_message":"hello","level":6` +
+				`,"_obj.k":"v","_obj.ns.id":1,"_outer.k":"v"}` + "\n",
+		},
+		{
+			desc: "name, caller and stack",
+			ent: zapcore.Entry{
+				Level:      zapcore.FatalLevel,
+				Message:    "hello",
+				LoggerName: "svc",
+				Caller:     zapcore.EntryCaller{Defined: true, File: "/src/foo/bar.go", Line: 42},
+				Stack:      "fake\nstack",
+			},
+			fields: []zapcore.Field{zap.Int("n", 1)},
+			want: `{"version":"1.1","host":"myhost","short_message":"hello","full_message":"fake\nstack","level":0` +
+				`,"_logger":"svc","_caller":"foo/bar.go:42","_n":1}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			buf, err := testGELFEncoder().EncodeEntry(tt.ent, tt.fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected GELF message.")
+			assert.True(t, json.Valid(buf.Bytes()), "Expected valid JSON.")
+			buf.Free()
+		})
+	}
+}
+
+func TestGELFEncoderConfig(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.LevelKey = ""
+	cfg.StacktraceKey = ""
+	cfg.SkipLineEnding = true
+	enc := zapcore.NewGELFEncoder(cfg, zapcore.WithGELFHost("h"))
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Time: time.Now(), Message: "m", Stack: "stack"}, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"m"}`, buf.String(), "Expected empty keys to omit elements.")
+	buf.Free()
+}
+
+func TestGELFEncoderClone(t *testing.T) {
+	enc := testGELFEncoder()
+	enc.AddString("parent", "p")
+
+	child := enc.Clone()
+	child.OpenNamespace("ns")
+	child.AddString("child", "c")
+
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "hi", LoggerName: "name"}
+	buf, err := child.EncodeEntry(ent, []zapcore.Field{zap.Int("n", 1)})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"version":"1.1","host":"myhost","short_message":"hi","level":6,"_parent":"p","_ns.child":"c","_logger":"name","_ns.n":1}`+"\n",
+		buf.String(), "Unexpected GELF message.")
+	buf.Free()
+
+	buf, err = enc.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t,
+		`{"version":"1.1","host":"myhost","short_message":"hi","level":6,"_parent":"p","_logger":"name"}`+"\n",
+		buf.String(), "Expected parent to be unaffected by child.")
+	buf.Free()
+}
+
+func TestGELFEncoderMarshalErrors(t *testing.T) {
+	enc := testGELFEncoder()
+	failing := zapcore.ArrayMarshalerFunc(func(zapcore.ArrayEncoder) error {
+		return errors.New("fail")
+	})
+	assert.Error(t, enc.AddArray("arr", failing), "Expected array marshaling error to propagate.")
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected reflection error to propagate.")
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `{"version":"1.1","host":"myhost","short_message":"","level":6}`+"\n", buf.String(), "Expected failed fields to be omitted.")
+	buf.Free()
+}
+
+type silentReflectedEncoder struct{}
+
+func (silentReflectedEncoder) Encode(interface{}) error { return nil }
+
+func TestGELFEncoderEmptyReflected(t *testing.T) {
+	cfg := zapcore.EncoderConfig{
+		MessageKey: "msg",
+		NewReflectedEncoder: func(io.Writer) zapcore.ReflectedEncoder {
+			return silentReflectedEncoder{}
+		},
+	}
+	enc := zapcore.NewGELFEncoder(cfg, zapcore.WithGELFHost("h"))
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "m"}, []zapcore.Field{zap.Reflect("r", struct{}{})})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"m","_r":""}`+"\n", buf.String(),
+		"Expected an empty string for a reflected value encoded as nothing.")
+	buf.Free()
+}
+
+func TestGELFEncoderAllocs(t *testing.T) {
+	if ztest.RaceEnabled {
+		t.Skip("Skipping allocation test under the race detector.")
+	}
+
+	enc := testGELFEncoder()
+	enc.AddString("service", "api")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "hello world"}
+	fields := []zapcore.Field{
+		zap.String("str", "foo bar"),
+		zap.Int("int", 42),
+		zap.Bool("bool", true),
+		zap.Float64("float", 1.5),
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	})
+	assert.Zero(t, allocs, "Expected encoding to be allocation-free.")
+}
@@ -361,6 +361,12 @@ func (enc *jsonEncoder) clone() *jsonEncoder {
 }
 
 func (enc *jsonEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	return enc.encodeEntry(ent, fields, nil)
+}
+
+// encodeEntry is EncodeEntry, except that addRoot, if it isn't nil, is called
+// to add fields to the top-level object once any open namespaces are closed.
+func (enc *jsonEncoder) encodeEntry(ent Entry, fields []Field, addRoot func(*jsonEncoder)) (*buffer.Buffer, error) {
 	final := enc.clone()
 	final.buf.AppendByte('{')
 
@@ -421,6 +427,9 @@ func (enc *jsonEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer,
 	}
 	addFields(final, fields)
 	final.closeOpenNamespaces()
+	if addRoot != nil {
+		addRoot(final)
+	}
 	if ent.Stack != "" && final.StacktraceKey != "" {
 		final.AddString(final.StacktraceKey, ent.Stack)
 	}
@@ -0,0 +1,660 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"encoding/base64"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+var _logfmtPool = sync.Pool{New: func() interface{} {
+	return &logfmtEncoder{}
+}}
+
+func getLogfmtEncoder() *logfmtEncoder {
+	return _logfmtPool.Get().(*logfmtEncoder)
+}
+
+func putLogfmtEncoder(enc *logfmtEncoder) {
+	if enc.reflectBuf != nil {
+		enc.reflectBuf.Free()
+	}
+	enc.EncoderConfig = nil
+	enc.buf = nil
+	enc.prefix = enc.prefix[:0]
+	enc.arrIndex = -1
+	enc.reflectBuf = nil
+	enc.reflectEnc = nil
+	_logfmtPool.Put(enc)
+}
+
+type logfmtEncoder struct {
+	*EncoderConfig
+	buf *buffer.Buffer
+
+	// prefix is prepended to keys added in namespaces, nested objects and
+	// arrays. Each element of the prefix is followed by a '.'.
+	prefix []byte
+	// arrIndex is the index of the next element when encoding an array.
+	// It's -1 when the Append methods write the value of a single key, as
+	// they do for the user-supplied time, duration and caller encoders.
+	arrIndex int
+
+	// for encoding generic values by reflection
+	reflectBuf *buffer.Buffer
+	reflectEnc ReflectedEncoder
+}
+
+// NewLogfmtEncoder creates a fast, low-allocation encoder whose output is
+// logfmt: a line of space-separated key=value pairs, such as
+//
+//   ts=1.6e+09 level=info msg="hello world" user.id=42 tags.0=a tags.1=b
+//
+// Values are quoted only if they're empty or contain spaces, '=', '"', or
+// non-printable characters; quoted values use Go-style escapes. Keys may not
+// contain such characters, so they're replaced with '_'.
+//
+// Fields of nested objects and namespaces are flattened into dotted keys.
+// Arrays are flattened the same way, using the index of each element as its
+// key, so an array of objects yields keys like users.0.name and
+// users.1.name. Empty objects and arrays are omitted. Reflected values are
+// encoded as JSON strings.
+//
+// Entry metadata is written first, using the keys and encoders from the
+// EncoderConfig, followed by the message, the fields, and the stack trace.
+func NewLogfmtEncoder(cfg EncoderConfig) Encoder {
+	if cfg.SkipLineEnding {
+		cfg.LineEnding = ""
+	} else if cfg.LineEnding == "" {
+		cfg.LineEnding = DefaultLineEnding
+	}
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+
+	return &logfmtEncoder{
+		EncoderConfig: &cfg,
+		buf:           bufferpool.Get(),
+		arrIndex:      -1,
+	}
+}
+
+func (enc *logfmtEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushKey(key)
+	err := enc.marshalArray(arr)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *logfmtEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushKey(key)
+	err := enc.marshalObject(obj)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *logfmtEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *logfmtEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.appendByteString(val)
+}
+
+func (enc *logfmtEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.buf.AppendBool(val)
+}
+
+func (enc *logfmtEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.appendComplex128(val)
+}
+
+func (enc *logfmtEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.appendDuration(val)
+}
+
+func (enc *logfmtEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.appendFloat(val, 64)
+}
+
+func (enc *logfmtEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.appendFloat(float64(val), 32)
+}
+
+func (enc *logfmtEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.buf.AppendInt(val)
+}
+
+func (enc *logfmtEncoder) AddReflected(key string, obj interface{}) error {
+	valueBytes, err := enc.encodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	enc.appendByteString(valueBytes)
+	return nil
+}
+
+func (enc *logfmtEncoder) OpenNamespace(key string) {
+	enc.pushKey(key)
+}
+
+func (enc *logfmtEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.appendString(val)
+}
+
+func (enc *logfmtEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.appendTime(val)
+}
+
+func (enc *logfmtEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.buf.AppendUint(val)
+}
+
+func (enc *logfmtEncoder) AppendArray(arr ArrayMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushElement()
+	err := enc.marshalArray(arr)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *logfmtEncoder) AppendObject(obj ObjectMarshaler) error {
+	n := len(enc.prefix)
+	enc.pushElement()
+	err := enc.marshalObject(obj)
+	enc.prefix = enc.prefix[:n]
+	return err
+}
+
+func (enc *logfmtEncoder) AppendBool(val bool) {
+	enc.addElementKey()
+	enc.buf.AppendBool(val)
+}
+
+func (enc *logfmtEncoder) AppendByteString(val []byte) {
+	enc.addElementKey()
+	enc.appendByteString(val)
+}
+
+func (enc *logfmtEncoder) AppendComplex128(val complex128) {
+	enc.addElementKey()
+	enc.appendComplex128(val)
+}
+
+func (enc *logfmtEncoder) AppendDuration(val time.Duration) {
+	enc.addElementKey()
+	enc.appendDuration(val)
+}
+
+func (enc *logfmtEncoder) AppendInt64(val int64) {
+	enc.addElementKey()
+	enc.buf.AppendInt(val)
+}
+
+func (enc *logfmtEncoder) AppendReflected(val interface{}) error {
+	valueBytes, err := enc.encodeReflected(val)
+	if err != nil {
+		return err
+	}
+	enc.addElementKey()
+	enc.appendByteString(valueBytes)
+	return nil
+}
+
+func (enc *logfmtEncoder) AppendString(val string) {
+	enc.addElementKey()
+	enc.appendString(val)
+}
+
+func (enc *logfmtEncoder) AppendTimeLayout(val time.Time, layout string) {
+	enc.addElementKey()
+	tmp := bufferpool.Get()
+	tmp.AppendTime(val, layout)
+	enc.appendByteString(tmp.Bytes())
+	tmp.Free()
+}
+
+func (enc *logfmtEncoder) AppendTime(val time.Time) {
+	enc.addElementKey()
+	enc.appendTime(val)
+}
+
+func (enc *logfmtEncoder) AppendUint64(val uint64) {
+	enc.addElementKey()
+	enc.buf.AppendUint(val)
+}
+
+func (enc *logfmtEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *logfmtEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *logfmtEncoder) AppendFloat64(v float64)            { enc.addElementKey(); enc.appendFloat(v, 64) }
+func (enc *logfmtEncoder) AppendFloat32(v float32) {
+	enc.addElementKey()
+	enc.appendFloat(float64(v), 32)
+}
+func (enc *logfmtEncoder) AppendInt(v int)         { enc.AppendInt64(int64(v)) }
+func (enc *logfmtEncoder) AppendInt32(v int32)     { enc.AppendInt64(int64(v)) }
+func (enc *logfmtEncoder) AppendInt16(v int16)     { enc.AppendInt64(int64(v)) }
+func (enc *logfmtEncoder) AppendInt8(v int8)       { enc.AppendInt64(int64(v)) }
+func (enc *logfmtEncoder) AppendUint(v uint)       { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtEncoder) AppendUint32(v uint32)   { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtEncoder) AppendUint16(v uint16)   { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtEncoder) AppendUint8(v uint8)     { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtEncoder) AppendUintptr(v uintptr) { enc.AppendUint64(uint64(v)) }
+
+func (enc *logfmtEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+func (enc *logfmtEncoder) clone() *logfmtEncoder {
+	clone := getLogfmtEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.prefix = append(clone.prefix[:0], enc.prefix...)
+	clone.arrIndex = -1
+	clone.buf = bufferpool.Get()
+	return clone
+}
+
+func (enc *logfmtEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+
+	// Entry metadata goes first, outside of any namespaces.
+	n := len(final.prefix)
+	final.prefix = final.prefix[:0]
+	if final.LevelKey != "" && final.EncodeLevel != nil {
+		final.addKey(final.LevelKey)
+		cur := final.buf.Len()
+		final.EncodeLevel(ent.Level, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to
+			// keep output valid.
+			final.appendString(ent.Level.String())
+		}
+	}
+	if final.TimeKey != "" {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		cur := final.buf.Len()
+		nameEncoder := final.EncodeName
+
+		// if no name encoder provided, fall back to FullNameEncoder for backwards
+		// compatibility
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+
+		nameEncoder(ent.LoggerName, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeName was a no-op. Fall back to strings to
+			// keep output valid.
+			final.appendString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" && final.EncodeCaller != nil {
+			final.addKey(final.CallerKey)
+			cur := final.buf.Len()
+			final.EncodeCaller(ent.Caller, final)
+			if cur == final.buf.Len() {
+				// User-supplied EncodeCaller was a no-op. Fall back to strings to
+				// keep output valid.
+				final.appendString(ent.Caller.String())
+			}
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	if final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+	if enc.buf.Len() > 0 {
+		if final.buf.Len() > 0 {
+			final.buf.AppendByte(' ')
+		}
+		final.buf.Write(enc.buf.Bytes())
+	}
+	final.prefix = final.prefix[:n]
+	addFields(final, fields)
+	final.prefix = final.prefix[:0]
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	final.buf.AppendString(final.LineEnding)
+
+	ret := final.buf
+	putLogfmtEncoder(final)
+	return ret, nil
+}
+
+// addKey starts a key=value pair with the given key, qualified by the
+// current prefix. The value must be written next.
+func (enc *logfmtEncoder) addKey(key string) {
+	if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+	enc.buf.Write(enc.prefix)
+	for i := 0; i < len(key); i++ {
+		enc.buf.AppendByte(logfmtKeyByte(key[i]))
+	}
+	if len(enc.prefix) == 0 && len(key) == 0 {
+		enc.buf.AppendByte('_')
+	}
+	enc.buf.AppendByte('=')
+}
+
+// addElementKey starts a key=value pair for the next array element. It does
+// nothing when the Append methods are writing the value of a single key.
+func (enc *logfmtEncoder) addElementKey() {
+	if enc.arrIndex < 0 {
+		return
+	}
+	if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+	enc.buf.Write(enc.prefix)
+	enc.buf.AppendInt(int64(enc.arrIndex))
+	enc.buf.AppendByte('=')
+	enc.arrIndex++
+}
+
+// pushKey adds a key to the prefix.
+func (enc *logfmtEncoder) pushKey(key string) {
+	for i := 0; i < len(key); i++ {
+		enc.prefix = append(enc.prefix, logfmtKeyByte(key[i]))
+	}
+	enc.prefix = append(enc.prefix, '.')
+}
+
+// pushElement adds the index of the next array element to the prefix.
+func (enc *logfmtEncoder) pushElement() {
+	if enc.arrIndex < 0 {
+		return
+	}
+	enc.prefix = strconv.AppendInt(enc.prefix, int64(enc.arrIndex), 10)
+	enc.prefix = append(enc.prefix, '.')
+	enc.arrIndex++
+}
+
+func (enc *logfmtEncoder) marshalArray(arr ArrayMarshaler) error {
+	old := enc.arrIndex
+	enc.arrIndex = 0
+	err := arr.MarshalLogArray(enc)
+	enc.arrIndex = old
+	return err
+}
+
+func (enc *logfmtEncoder) marshalObject(obj ObjectMarshaler) error {
+	old := enc.arrIndex
+	enc.arrIndex = -1
+	err := obj.MarshalLogObject(enc)
+	enc.arrIndex = old
+	return err
+}
+
+// appendTime writes a time with the user-supplied EncodeTime. The Append
+// methods called by EncodeTime write the value itself rather than array
+// elements.
+func (enc *logfmtEncoder) appendTime(val time.Time) {
+	old := enc.arrIndex
+	enc.arrIndex = -1
+	cur := enc.buf.Len()
+	if e := enc.EncodeTime; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		// User-supplied EncodeTime is a no-op. Fall back to nanos since epoch
+		// to keep output valid.
+		enc.buf.AppendInt(val.UnixNano())
+	}
+	enc.arrIndex = old
+}
+
+// appendDuration is the equivalent of appendTime for durations.
+func (enc *logfmtEncoder) appendDuration(val time.Duration) {
+	old := enc.arrIndex
+	enc.arrIndex = -1
+	cur := enc.buf.Len()
+	if e := enc.EncodeDuration; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		// User-supplied EncodeDuration is a no-op. Fall back to nanoseconds
+		// to keep output valid.
+		enc.buf.AppendInt(int64(val))
+	}
+	enc.arrIndex = old
+}
+
+func (enc *logfmtEncoder) appendComplex128(val complex128) {
+	// Cast to a platform-independent, fixed-size type.
+	r, i := float64(real(val)), float64(imag(val))
+	enc.buf.AppendFloat(r, 64)
+	// If imaginary part is less than 0, minus (-) sign is added by default
+	// by AppendFloat.
+	if i >= 0 {
+		enc.buf.AppendByte('+')
+	}
+	enc.buf.AppendFloat(i, 64)
+	enc.buf.AppendByte('i')
+}
+
+func (enc *logfmtEncoder) appendFloat(val float64, bitSize int) {
+	switch {
+	case math.IsNaN(val):
+		enc.buf.AppendString("NaN")
+	case math.IsInf(val, 1):
+		enc.buf.AppendString("+Inf")
+	case math.IsInf(val, -1):
+		enc.buf.AppendString("-Inf")
+	default:
+		enc.buf.AppendFloat(val, bitSize)
+	}
+}
+
+// Only invoke the standard JSON encoder if there is actually something to
+// encode; otherwise write JSON null literal directly.
+func (enc *logfmtEncoder) encodeReflected(obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nullLiteralBytes, nil
+	}
+	if enc.reflectBuf == nil {
+		enc.reflectBuf = bufferpool.Get()
+		enc.reflectEnc = enc.NewReflectedEncoder(enc.reflectBuf)
+	} else {
+		enc.reflectBuf.Reset()
+	}
+	if err := enc.reflectEnc.Encode(obj); err != nil {
+		return nil, err
+	}
+	enc.reflectBuf.TrimNewline()
+	return enc.reflectBuf.Bytes(), nil
+}
+
+// appendString writes a string value, quoting it if necessary.
+func (enc *logfmtEncoder) appendString(s string) {
+	if !logfmtNeedsQuotes(s) {
+		enc.buf.AppendString(s)
+		return
+	}
+	enc.buf.AppendByte('"')
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+			i++
+			continue
+		}
+		enc.buf.AppendString(s[i : i+size])
+		i += size
+	}
+	enc.buf.AppendByte('"')
+}
+
+// appendByteString is no-alloc equivalent of appendString(string(s)) for
+// s []byte.
+func (enc *logfmtEncoder) appendByteString(s []byte) {
+	if !logfmtNeedsQuotesBytes(s) {
+		enc.buf.Write(s)
+		return
+	}
+	enc.buf.AppendByte('"')
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+			i++
+			continue
+		}
+		enc.buf.Write(s[i : i+size])
+		i += size
+	}
+	enc.buf.AppendByte('"')
+}
+
+// tryAddRuneSelf appends b to a quoted value, escaping it if necessary, if
+// it's a single-byte rune.
+func (enc *logfmtEncoder) tryAddRuneSelf(b byte) bool {
+	if b >= utf8.RuneSelf {
+		return false
+	}
+	if 0x20 <= b && b != 0x7f && b != '\\' && b != '"' {
+		enc.buf.AppendByte(b)
+		return true
+	}
+	switch b {
+	case '\\', '"':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte(b)
+	case '\n':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte('n')
+	case '\r':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte('r')
+	case '\t':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte('t')
+	default:
+		// Encode bytes < 0x20 and DEL, except for the escape sequences above.
+		enc.buf.AppendString(`\u00`)
+		enc.buf.AppendByte(_hex[b>>4])
+		enc.buf.AppendByte(_hex[b&0xF])
+	}
+	return true
+}
+
+// logfmtNeedsQuotes reports whether a value must be quoted: if it's empty,
+// or contains spaces, '=', '"', or non-printable or invalid characters.
+func logfmtNeedsQuotes(s string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b <= ' ' || b == '=' || b == '"' || b == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || !unicode.IsPrint(r) {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// logfmtNeedsQuotesBytes is no-alloc equivalent of
+// logfmtNeedsQuotes(string(s)) for s []byte.
+func logfmtNeedsQuotesBytes(s []byte) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b <= ' ' || b == '=' || b == '"' || b == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if (r == utf8.RuneError && size == 1) || !unicode.IsPrint(r) {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// logfmtKeyByte replaces bytes that aren't allowed in keys with '_'.
+func logfmtKeyByte(b byte) byte {
+	if b <= ' ' || b == '=' || b == '"' || b == 0x7f {
+		return '_'
+	}
+	return b
+}
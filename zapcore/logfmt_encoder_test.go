@@ -0,0 +1,318 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+func testLogfmtEncoderConfig() zapcore.EncoderConfig {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	cfg.EncodeDuration = zapcore.StringDurationEncoder
+	return cfg
+}
+
+func TestLogfmtEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891000000, time.UTC)
+	tests := []struct {
+		desc   string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc: "minimal",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			want: "level=info ts=2022-03-04T05:06:07.891Z msg=hello\n",
+		},
+		{
+			desc: "metadata",
+			ent: zapcore.Entry{
+				Level:      zapcore.ErrorLevel,
+				Time:       ts,
+				LoggerName: "my svc",
+				Message:    "hello world",
+				Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+				Stack:      "goroutine 1\n\tmain.go:42",
+			},
+			fields: []zapcore.Field{zap.Int("n", 1)},
+			want: `level=error ts=2022-03-04T05:06:07.891Z logger="my svc" caller=app/main.go:42 ` +
+				`msg="hello world" n=1 stacktrace="goroutine 1\n\tmain.go:42"` + "\n",
+		},
+		{
+			desc: "primitives",
+			ent:  zapcore.Entry{Level: zapcore.DebugLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Bool("bool", true),
+				zap.Int("int", -42),
+				zap.Uint64("uint", 42),
+				zap.Float64("float", 1.5),
+				zap.Float32("float32", 0.1),
+				zap.Float64("nan", math.NaN()),
+				zap.Float64("inf", math.Inf(1)),
+				zap.Complex128("complex", 1-2i),
+				zap.Duration("dur", 1500*time.Millisecond),
+				zap.Time("time", ts),
+				zap.Binary("binary", []byte("foo")),
+				zap.ByteString("bytes", []byte("a b")),
+				zap.Error(errors.New("oh no")),
+			},
+			want: "level=debug ts=2022-03-04T05:06:07.891Z msg=hello bool=true int=-42 uint=42 " +
+				"float=1.5 float32=0.1 nan=NaN inf=+Inf complex=1-2i dur=1.5s " +
+				`time=2022-03-04T05:06:07.891Z binary=Zm9v bytes="a b" error="oh no"` + "\n",
+		},
+		{
+			desc: "quoting",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: ""},
+			fields: []zapcore.Field{
+				zap.String("plain", `a\b/c:d`),
+				zap.String("empty", ""),
+				zap.String("equals", "a=b"),
+				zap.String("quote", `say "hi"`),
+				zap.String("control", "a\x00\x7fb\r"),
+				zap.String("unicode", "héllo"),
+				zap.String("nbsp", "a b"),
+				zap.String("invalid", "a\xffb"),
+				zap.String("bad key=\"", "v"),
+				zap.String("", "v"),
+			},
+			want: `level=info ts=2022-03-04T05:06:07.891Z msg="" plain=a\b/c:d empty="" ` +
+				`equals="a=b" quote="say \"hi\"" control="a\u0000\u007fb\r" unicode=héllo ` +
+				"nbsp=\"a b\" invalid=\"a�b\" bad_key__=v _=v\n",
+		},
+		{
+			desc: "nested",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("name", "jane doe")
+					enc.AddInt("id", 42)
+					return enc.AddObject("address", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+						enc.AddString("city", "NYC")
+						return nil
+					}))
+				})),
+				zap.Object("empty", zapcore.ObjectMarshalerFunc(func(zapcore.ObjectEncoder) error { return nil })),
+				zap.Strings("tags", []string{"a", "b c"}),
+				zap.Ints("empty_list", nil),
+				zap.Durations("durs", []time.Duration{time.Second}),
+				zap.Array("nested", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+					enc.AppendBool(true)
+					if err := enc.AppendArray(zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+						enc.AppendInt(1)
+						enc.AppendInt(2)
+						return nil
+					})); err != nil {
+						return err
+					}
+					return enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+						enc.AddString("k", "v")
+						return enc.AddArray("list", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+							enc.AppendFloat64(1.5)
+							return nil
+						}))
+					}))
+				})),
+				zap.Reflect("reflect", map[string]string{"a": "b"}),
+				zap.Reflect("nil", nil),
+			},
+			want: `level=info ts=2022-03-04T05:06:07.891Z msg=hello user.name="jane doe" user.id=42 ` +
+				`user.address.city=NYC tags.0=a tags.1="b c" durs.0=1s nested.0=true nested.1.0=1 ` +
+				`nested.1.1=2 nested.2.k=v nested.2.list.0=1.5 reflect="{\"a\":\"b\"}" nil=null` + "\n",
+		},
+		{
+			desc: "namespace",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Int("outer", 1),
+				zap.Namespace("ns"),
+				zap.Int("inner", 2),
+				zap.Namespace("deeper"),
+				zap.Int("innermost", 3),
+			},
+			want: "level=info ts=2022-03-04T05:06:07.891Z msg=hello outer=1 ns.inner=2 ns.deeper.innermost=3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewLogfmtEncoder(testLogfmtEncoderConfig())
+			buf, err := enc.EncodeEntry(tt.ent, tt.fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected output.")
+			buf.Free()
+		})
+	}
+}
+
+func TestLogfmtEncoderClone(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 0, time.UTC)
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"}
+
+	enc := zapcore.NewLogfmtEncoder(testLogfmtEncoderConfig())
+	enc.AddString("a", "1")
+	enc.OpenNamespace("ns")
+	enc.AddString("b", "2")
+
+	clone := enc.Clone()
+	clone.AddString("c", "3")
+
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.String("d", "4")})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "level=info ts=2022-03-04T05:06:07.000Z msg=hello a=1 ns.b=2 ns.d=4\n", buf.String(),
+		"Unexpected output from original encoder.")
+	buf.Free()
+
+	buf, err = clone.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "level=info ts=2022-03-04T05:06:07.000Z msg=hello a=1 ns.b=2 ns.c=3\n", buf.String(),
+		"Unexpected output from clone.")
+	buf.Free()
+}
+
+func TestLogfmtEncoderConfig(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 0, time.UTC)
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       ts,
+		LoggerName: "svc",
+		Message:    "hello",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+	}
+
+	tests := []struct {
+		desc string
+		cfg  zapcore.EncoderConfig
+		want string
+	}{
+		{
+			desc: "no keys",
+			cfg:  zapcore.EncoderConfig{},
+			want: "dur=1000000000\n",
+		},
+		{
+			desc: "no-op encoders",
+			cfg: zapcore.EncoderConfig{
+				TimeKey:        "t",
+				LevelKey:       "l",
+				NameKey:        "n",
+				CallerKey:      "c",
+				FunctionKey:    "f",
+				MessageKey:     "m",
+				EncodeTime:     func(time.Time, zapcore.PrimitiveArrayEncoder) {},
+				EncodeLevel:    func(zapcore.Level, zapcore.PrimitiveArrayEncoder) {},
+				EncodeName:     func(string, zapcore.PrimitiveArrayEncoder) {},
+				EncodeCaller:   func(zapcore.EntryCaller, zapcore.PrimitiveArrayEncoder) {},
+				EncodeDuration: func(time.Duration, zapcore.PrimitiveArrayEncoder) {},
+			},
+			want: "l=warn t=1646370367000000000 n=svc c=/src/app/main.go:42 f=\"\" m=hello dur=1000000000\n",
+		},
+		{
+			desc: "custom encoders",
+			cfg: zapcore.EncoderConfig{
+				TimeKey:          "t",
+				LevelKey:         "l",
+				MessageKey:       "m",
+				EncodeTime:       zapcore.TimeEncoderOfLayout("2006-01-02 15:04"),
+				EncodeLevel:      zapcore.CapitalLevelEncoder,
+				EncodeDuration:   zapcore.MillisDurationEncoder,
+				LineEnding:       "\r\n",
+				ConsoleSeparator: "ignored",
+			},
+			want: "l=WARN t=\"2022-03-04 05:06\" m=hello dur=1000\r\n",
+		},
+		{
+			desc: "skip line ending",
+			cfg:  zapcore.EncoderConfig{MessageKey: "m", SkipLineEnding: true},
+			want: "m=hello dur=1000000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewLogfmtEncoder(tt.cfg)
+			buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.Duration("dur", time.Second)})
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected output.")
+		})
+	}
+}
+
+func TestLogfmtEncoderReflectedError(t *testing.T) {
+	enc := zapcore.NewLogfmtEncoder(testLogfmtEncoderConfig())
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error encoding a channel.")
+}
+
+func TestLogfmtEncoderAllocs(t *testing.T) {
+	if ztest.RaceEnabled {
+		t.Skip("Skipping allocation test under the race detector.")
+	}
+
+	enc := zapcore.NewLogfmtEncoder(testLogfmtEncoderConfig())
+	enc.AddString("service", "api")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "hello world"}
+	fields := []zapcore.Field{
+		zap.String("str", "foo bar"),
+		zap.Int("int", 42),
+		zap.Ints("ints", []int{1, 2, 3}),
+		zap.Namespace("ns"),
+		zap.Bool("bool", true),
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	})
+	assert.Zero(t, allocs, "Expected encoding to be allocation-free.")
+}
+
+func BenchmarkZapLogfmt(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			enc := zapcore.NewLogfmtEncoder(testLogfmtEncoderConfig())
+			enc.AddString("str", "foo")
+			enc.AddInt64("int64-1", 1)
+			enc.AddInt64("int64-2", 2)
+			enc.AddFloat64("float64", 1.0)
+			enc.AddString("string1", "\n")
+			enc.AddString("string2", "💩")
+			enc.AddString("string3", "🤔")
+			enc.AddString("string4", "🙊")
+			enc.AddBool("bool", true)
+			buf, _ := enc.EncodeEntry(zapcore.Entry{
+				Message: "fake",
+				Level:   zapcore.DebugLevel,
+			}, nil)
+			buf.Free()
+		}
+	})
+}
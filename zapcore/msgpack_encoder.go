@@ -0,0 +1,764 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// MessagePack format bytes used by the encoder. See
+// https://github.com/msgpack/msgpack/blob/master/spec.md.
+const (
+	_msgpackNil     = 0xc0
+	_msgpackFalse   = 0xc2
+	_msgpackTrue    = 0xc3
+	_msgpackBin8    = 0xc4
+	_msgpackBin16   = 0xc5
+	_msgpackBin32   = 0xc6
+	_msgpackExt8    = 0xc7
+	_msgpackFloat32 = 0xca
+	_msgpackFloat64 = 0xcb
+	_msgpackUint8   = 0xcc
+	_msgpackUint16  = 0xcd
+	_msgpackUint32  = 0xce
+	_msgpackUint64  = 0xcf
+	_msgpackInt8    = 0xd0
+	_msgpackInt16   = 0xd1
+	_msgpackInt32   = 0xd2
+	_msgpackInt64   = 0xd3
+	_msgpackFixExt4 = 0xd6
+	_msgpackFixExt8 = 0xd7
+	_msgpackStr8    = 0xd9
+	_msgpackStr16   = 0xda
+	_msgpackStr32   = 0xdb
+	_msgpackArray16 = 0xdc
+	_msgpackArray32 = 0xdd
+	_msgpackMap16   = 0xde
+	_msgpackMap32   = 0xdf
+
+	_msgpackFixStr   = 0xa0
+	_msgpackFixArray = 0x90
+	_msgpackFixMap   = 0x80
+
+	// _msgpackTimestamp is the extension type of timestamps.
+	_msgpackTimestamp = 0xff // -1
+)
+
+var _msgpackPool = sync.Pool{New: func() interface{} {
+	return &msgpackEncoder{}
+}}
+
+func getMsgpackEncoder() *msgpackEncoder {
+	return _msgpackPool.Get().(*msgpackEncoder)
+}
+
+func putMsgpackEncoder(enc *msgpackEncoder) {
+	if enc.reflectBuf != nil {
+		enc.reflectBuf.Free()
+	}
+	enc.EncoderConfig = nil
+	enc.buf = nil
+	enc.count = 0
+	enc.open = enc.open[:0]
+	enc.value = false
+	enc.reflectBuf = nil
+	enc.reflectEnc = nil
+	_msgpackPool.Put(enc)
+}
+
+type msgpackEncoder struct {
+	*EncoderConfig
+	buf *buffer.Buffer
+
+	// count is the number of fields added outside of any open maps.
+	count int
+	// open holds the offsets in buf of the headers of the open maps and
+	// arrays, innermost last. Since the number of entries in a map or array
+	// isn't known in advance, each open container has a 32-bit header that
+	// is incremented as entries are added, and shrunk to the smallest
+	// header that fits when the container is closed.
+	open []int
+	// value is set while a user-supplied encoder writes a single value, so
+	// that its Append calls aren't counted as array elements.
+	value bool
+
+	// for encoding generic values by reflection
+	reflectBuf *buffer.Buffer
+	reflectEnc ReflectedEncoder
+}
+
+// NewMsgpackEncoder creates a fast, low-allocation encoder whose output is
+// a stream of MessagePack maps, one per entry.
+//
+// Fields are encoded using native MessagePack types: integers use the
+// smallest representation that fits, times use the timestamp extension
+// type, durations are integer nanoseconds, binary fields use the bin type,
+// and nested objects and namespaces are nested maps. Complex numbers are
+// encoded as arrays of their real and imaginary parts. Reflected values are
+// encoded with the EncoderConfig's NewReflectedEncoder and converted from
+// JSON to the equivalent MessagePack types. Invalid UTF-8 in keys and
+// strings is replaced with U+FFFD, since MessagePack strings must be valid
+// UTF-8.
+//
+// The EncodeLevel, EncodeName and EncodeCaller functions of the
+// EncoderConfig are honored. EncodeTime, EncodeDuration and LineEnding are
+// ignored, since MessagePack has native timestamps and needs no delimiters.
+func NewMsgpackEncoder(cfg EncoderConfig) Encoder {
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+	return &msgpackEncoder{
+		EncoderConfig: &cfg,
+		buf:           bufferpool.Get(),
+	}
+}
+
+func (enc *msgpackEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.addKey(key)
+	return enc.appendArray(arr)
+}
+
+func (enc *msgpackEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.addKey(key)
+	return enc.appendObject(obj)
+}
+
+func (enc *msgpackEncoder) AddBinary(key string, val []byte) {
+	enc.addKey(key)
+	enc.appendBinary(val)
+}
+
+func (enc *msgpackEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.appendByteString(val)
+}
+
+func (enc *msgpackEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.appendBool(val)
+}
+
+func (enc *msgpackEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.appendComplex128(val)
+}
+
+func (enc *msgpackEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.appendInt(int64(val))
+}
+
+func (enc *msgpackEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.appendFloat64(val)
+}
+
+func (enc *msgpackEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.appendFloat32(val)
+}
+
+func (enc *msgpackEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.appendInt(val)
+}
+
+func (enc *msgpackEncoder) AddReflected(key string, obj interface{}) error {
+	valueBytes, err := enc.encodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	return enc.appendJSON(valueBytes)
+}
+
+func (enc *msgpackEncoder) OpenNamespace(key string) {
+	enc.addKey(key)
+	enc.openContainer(_msgpackMap32)
+}
+
+func (enc *msgpackEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.appendString(val)
+}
+
+func (enc *msgpackEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.appendTime(val)
+}
+
+func (enc *msgpackEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.appendUint(val)
+}
+
+func (enc *msgpackEncoder) AppendArray(arr ArrayMarshaler) error {
+	enc.addElement()
+	return enc.appendArray(arr)
+}
+
+func (enc *msgpackEncoder) AppendObject(obj ObjectMarshaler) error {
+	enc.addElement()
+	return enc.appendObject(obj)
+}
+
+func (enc *msgpackEncoder) AppendBool(val bool) {
+	enc.addElement()
+	enc.appendBool(val)
+}
+
+func (enc *msgpackEncoder) AppendByteString(val []byte) {
+	enc.addElement()
+	enc.appendByteString(val)
+}
+
+func (enc *msgpackEncoder) AppendComplex128(val complex128) {
+	enc.addElement()
+	enc.appendComplex128(val)
+}
+
+func (enc *msgpackEncoder) AppendDuration(val time.Duration) {
+	enc.addElement()
+	enc.appendInt(int64(val))
+}
+
+func (enc *msgpackEncoder) AppendInt64(val int64) {
+	enc.addElement()
+	enc.appendInt(val)
+}
+
+func (enc *msgpackEncoder) AppendReflected(val interface{}) error {
+	valueBytes, err := enc.encodeReflected(val)
+	if err != nil {
+		return err
+	}
+	enc.addElement()
+	return enc.appendJSON(valueBytes)
+}
+
+func (enc *msgpackEncoder) AppendString(val string) {
+	enc.addElement()
+	enc.appendString(val)
+}
+
+func (enc *msgpackEncoder) AppendTimeLayout(val time.Time, layout string) {
+	enc.addElement()
+	tmp := bufferpool.Get()
+	tmp.AppendTime(val, layout)
+	enc.appendByteString(tmp.Bytes())
+	tmp.Free()
+}
+
+func (enc *msgpackEncoder) AppendTime(val time.Time) {
+	enc.addElement()
+	enc.appendTime(val)
+}
+
+func (enc *msgpackEncoder) AppendUint64(val uint64) {
+	enc.addElement()
+	enc.appendUint(val)
+}
+
+func (enc *msgpackEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *msgpackEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *msgpackEncoder) AppendFloat64(v float64)            { enc.addElement(); enc.appendFloat64(v) }
+func (enc *msgpackEncoder) AppendFloat32(v float32)            { enc.addElement(); enc.appendFloat32(v) }
+func (enc *msgpackEncoder) AppendInt(v int)                    { enc.AppendInt64(int64(v)) }
+func (enc *msgpackEncoder) AppendInt32(v int32)                { enc.AppendInt64(int64(v)) }
+func (enc *msgpackEncoder) AppendInt16(v int16)                { enc.AppendInt64(int64(v)) }
+func (enc *msgpackEncoder) AppendInt8(v int8)                  { enc.AppendInt64(int64(v)) }
+func (enc *msgpackEncoder) AppendUint(v uint)                  { enc.AppendUint64(uint64(v)) }
+func (enc *msgpackEncoder) AppendUint32(v uint32)              { enc.AppendUint64(uint64(v)) }
+func (enc *msgpackEncoder) AppendUint16(v uint16)              { enc.AppendUint64(uint64(v)) }
+func (enc *msgpackEncoder) AppendUint8(v uint8)                { enc.AppendUint64(uint64(v)) }
+func (enc *msgpackEncoder) AppendUintptr(v uintptr)            { enc.AppendUint64(uint64(v)) }
+
+func (enc *msgpackEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	clone.count = enc.count
+	clone.open = append(clone.open, enc.open...)
+	return clone
+}
+
+func (enc *msgpackEncoder) clone() *msgpackEncoder {
+	clone := getMsgpackEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.buf = bufferpool.Get()
+	return clone
+}
+
+func (enc *msgpackEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+	final.openContainer(_msgpackMap32)
+
+	if final.LevelKey != "" && final.EncodeLevel != nil {
+		final.addKey(final.LevelKey)
+		final.encodeValue(func() { final.EncodeLevel(ent.Level, final) }, func() {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to
+			// keep output valid.
+			final.appendString(ent.Level.String())
+		})
+	}
+	if final.TimeKey != "" {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		nameEncoder := final.EncodeName
+
+		// if no name encoder provided, fall back to FullNameEncoder for backwards
+		// compatibility
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+
+		final.addKey(final.NameKey)
+		final.encodeValue(func() { nameEncoder(ent.LoggerName, final) }, func() {
+			final.appendString(ent.LoggerName)
+		})
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" && final.EncodeCaller != nil {
+			final.addKey(final.CallerKey)
+			final.encodeValue(func() { final.EncodeCaller(ent.Caller, final) }, func() {
+				final.appendString(ent.Caller.String())
+			})
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	if final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+
+	// Add the context, and reopen any namespaces it left open.
+	if enc.buf.Len() > 0 {
+		base := final.buf.Len()
+		final.buf.Write(enc.buf.Bytes())
+		final.addEntries(0, enc.count)
+		for _, off := range enc.open {
+			final.open = append(final.open, base+off)
+		}
+	}
+	addFields(final, fields)
+
+	final.closeContainers(1)
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	final.closeContainers(0)
+
+	ret := final.buf
+	putMsgpackEncoder(final)
+	return ret, nil
+}
+
+// encodeValue calls a user-supplied encoder to write a single value,
+// calling fallback if it writes nothing.
+func (enc *msgpackEncoder) encodeValue(encode, fallback func()) {
+	cur := enc.buf.Len()
+	enc.value = true
+	encode()
+	enc.value = false
+	if cur == enc.buf.Len() {
+		fallback()
+	}
+}
+
+// addKey writes the key of a map entry.
+func (enc *msgpackEncoder) addKey(key string) {
+	enc.addEntries(len(enc.open)-1, 1)
+	enc.appendString(key)
+}
+
+// addElement counts the next array element, unless a user-supplied encoder
+// is writing a single value.
+func (enc *msgpackEncoder) addElement() {
+	if !enc.value {
+		enc.addEntries(len(enc.open)-1, 1)
+	}
+}
+
+// addEntries adds n to the number of entries of the open container at
+// index i of enc.open, or to enc.count if i is negative.
+func (enc *msgpackEncoder) addEntries(i, n int) {
+	if i < 0 {
+		enc.count += n
+		return
+	}
+	b := enc.buf.Bytes()[enc.open[i]+1:]
+	c := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	c += uint32(n)
+	b[0], b[1], b[2], b[3] = byte(c>>24), byte(c>>16), byte(c>>8), byte(c)
+}
+
+// openContainer writes the header of a map or array with a 32-bit length
+// that's incremented as entries are added.
+func (enc *msgpackEncoder) openContainer(kind byte) {
+	enc.open = append(enc.open, enc.buf.Len())
+	enc.buf.AppendByte(kind)
+	enc.appendUint32(0)
+}
+
+// closeContainers closes the open containers at index n of enc.open and
+// above, innermost first, shrinking each header to the smallest one that
+// fits its number of entries. Shrinking a header moves only the bytes after
+// it, so the offsets of the remaining open containers stay valid.
+func (enc *msgpackEncoder) closeContainers(n int) {
+	for i := len(enc.open) - 1; i >= n; i-- {
+		enc.shrinkHeader(enc.open[i])
+	}
+	enc.open = enc.open[:n]
+}
+
+// shrinkHeader rewrites the 32-bit map or array header at off as a fixmap
+// or fixarray header, or a 16-bit header, if the length fits.
+func (enc *msgpackEncoder) shrinkHeader(off int) {
+	bs := enc.buf.Bytes()
+	isMap := bs[off] == _msgpackMap32
+	c := uint32(bs[off+1])<<24 | uint32(bs[off+2])<<16 | uint32(bs[off+3])<<8 | uint32(bs[off+4])
+
+	var cut int
+	switch {
+	case c < 16:
+		if isMap {
+			bs[off] = _msgpackFixMap | byte(c)
+		} else {
+			bs[off] = _msgpackFixArray | byte(c)
+		}
+		cut = 4
+	case c <= math.MaxUint16:
+		if isMap {
+			bs[off] = _msgpackMap16
+		} else {
+			bs[off] = _msgpackArray16
+		}
+		bs[off+1], bs[off+2] = byte(c>>8), byte(c)
+		cut = 2
+	default:
+		return
+	}
+
+	// Buffer has no way to truncate, so move the tail and rewrite the
+	// remaining bytes in place, which doesn't allocate.
+	start := off + 5 - cut
+	copy(bs[start:], bs[off+5:])
+	enc.buf.Reset()
+	enc.buf.Write(bs[:len(bs)-cut])
+}
+
+func (enc *msgpackEncoder) appendArray(arr ArrayMarshaler) error {
+	n := len(enc.open)
+	enc.openContainer(_msgpackArray32)
+	err := arr.MarshalLogArray(enc)
+	enc.closeContainers(n)
+	return err
+}
+
+func (enc *msgpackEncoder) appendObject(obj ObjectMarshaler) error {
+	// Close ONLY new namespaces that are opened during appendObject.
+	n := len(enc.open)
+	enc.openContainer(_msgpackMap32)
+	err := obj.MarshalLogObject(enc)
+	enc.closeContainers(n)
+	return err
+}
+
+func (enc *msgpackEncoder) appendBool(val bool) {
+	if val {
+		enc.buf.AppendByte(_msgpackTrue)
+	} else {
+		enc.buf.AppendByte(_msgpackFalse)
+	}
+}
+
+func (enc *msgpackEncoder) appendInt(val int64) {
+	switch {
+	case val >= 0:
+		enc.appendUint(uint64(val))
+	case val >= -32:
+		// negative fixint
+		enc.buf.AppendByte(byte(val))
+	case val >= math.MinInt8:
+		enc.buf.AppendByte(_msgpackInt8)
+		enc.buf.AppendByte(byte(val))
+	case val >= math.MinInt16:
+		enc.buf.AppendByte(_msgpackInt16)
+		enc.appendUint16(uint16(val))
+	case val >= math.MinInt32:
+		enc.buf.AppendByte(_msgpackInt32)
+		enc.appendUint32(uint32(val))
+	default:
+		enc.buf.AppendByte(_msgpackInt64)
+		enc.appendUint64(uint64(val))
+	}
+}
+
+func (enc *msgpackEncoder) appendUint(val uint64) {
+	switch {
+	case val <= 0x7f:
+		// positive fixint
+		enc.buf.AppendByte(byte(val))
+	case val <= math.MaxUint8:
+		enc.buf.AppendByte(_msgpackUint8)
+		enc.buf.AppendByte(byte(val))
+	case val <= math.MaxUint16:
+		enc.buf.AppendByte(_msgpackUint16)
+		enc.appendUint16(uint16(val))
+	case val <= math.MaxUint32:
+		enc.buf.AppendByte(_msgpackUint32)
+		enc.appendUint32(uint32(val))
+	default:
+		enc.buf.AppendByte(_msgpackUint64)
+		enc.appendUint64(val)
+	}
+}
+
+func (enc *msgpackEncoder) appendFloat32(val float32) {
+	enc.buf.AppendByte(_msgpackFloat32)
+	enc.appendUint32(math.Float32bits(val))
+}
+
+func (enc *msgpackEncoder) appendFloat64(val float64) {
+	enc.buf.AppendByte(_msgpackFloat64)
+	enc.appendUint64(math.Float64bits(val))
+}
+
+func (enc *msgpackEncoder) appendComplex128(val complex128) {
+	enc.buf.AppendByte(_msgpackFixArray | 2)
+	enc.appendFloat64(real(val))
+	enc.appendFloat64(imag(val))
+}
+
+// appendString writes a str, replacing invalid UTF-8 with U+FFFD.
+func (enc *msgpackEncoder) appendString(val string) {
+	if !utf8.ValidString(val) {
+		// Rare, so the conversion's allocation doesn't matter.
+		enc.appendByteString([]byte(val))
+		return
+	}
+	enc.appendStringHeader(len(val))
+	enc.buf.AppendString(val)
+}
+
+// appendByteString writes a str, replacing invalid UTF-8 with U+FFFD.
+func (enc *msgpackEncoder) appendByteString(val []byte) {
+	if utf8.Valid(val) {
+		enc.appendStringHeader(len(val))
+		enc.buf.Write(val)
+		return
+	}
+
+	// Invalid bytes are replaced with U+FFFD, which takes three bytes.
+	n := 0
+	for i := 0; i < len(val); {
+		r, size := utf8.DecodeRune(val[i:])
+		if r == utf8.RuneError && size == 1 {
+			n += utf8.RuneLen(utf8.RuneError)
+		} else {
+			n += size
+		}
+		i += size
+	}
+	enc.appendStringHeader(n)
+	for i := 0; i < len(val); {
+		r, size := utf8.DecodeRune(val[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+		} else {
+			enc.buf.Write(val[i : i+size])
+		}
+		i += size
+	}
+}
+
+func (enc *msgpackEncoder) appendStringHeader(n int) {
+	switch {
+	case n < 32:
+		enc.buf.AppendByte(_msgpackFixStr | byte(n))
+	case n <= math.MaxUint8:
+		enc.buf.AppendByte(_msgpackStr8)
+		enc.buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		enc.buf.AppendByte(_msgpackStr16)
+		enc.appendUint16(uint16(n))
+	default:
+		enc.buf.AppendByte(_msgpackStr32)
+		enc.appendUint32(uint32(n))
+	}
+}
+
+func (enc *msgpackEncoder) appendBinary(val []byte) {
+	n := len(val)
+	switch {
+	case n <= math.MaxUint8:
+		enc.buf.AppendByte(_msgpackBin8)
+		enc.buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		enc.buf.AppendByte(_msgpackBin16)
+		enc.appendUint16(uint16(n))
+	default:
+		enc.buf.AppendByte(_msgpackBin32)
+		enc.appendUint32(uint32(n))
+	}
+	enc.buf.Write(val)
+}
+
+// appendTime writes a time using the smallest of the timestamp extension's
+// three formats that can represent it.
+func (enc *msgpackEncoder) appendTime(val time.Time) {
+	sec, nsec := val.Unix(), uint32(val.Nanosecond())
+	switch {
+	case nsec == 0 && sec>>32 == 0:
+		enc.buf.AppendByte(_msgpackFixExt4)
+		enc.buf.AppendByte(_msgpackTimestamp)
+		enc.appendUint32(uint32(sec))
+	case sec>>34 == 0:
+		enc.buf.AppendByte(_msgpackFixExt8)
+		enc.buf.AppendByte(_msgpackTimestamp)
+		enc.appendUint64(uint64(nsec)<<34 | uint64(sec))
+	default:
+		enc.buf.AppendByte(_msgpackExt8)
+		enc.buf.AppendByte(12)
+		enc.buf.AppendByte(_msgpackTimestamp)
+		enc.appendUint32(nsec)
+		enc.appendUint64(uint64(sec))
+	}
+}
+
+func (enc *msgpackEncoder) appendUint16(v uint16) {
+	enc.buf.AppendByte(byte(v >> 8))
+	enc.buf.AppendByte(byte(v))
+}
+
+func (enc *msgpackEncoder) appendUint32(v uint32) {
+	enc.buf.AppendByte(byte(v >> 24))
+	enc.buf.AppendByte(byte(v >> 16))
+	enc.buf.AppendByte(byte(v >> 8))
+	enc.buf.AppendByte(byte(v))
+}
+
+func (enc *msgpackEncoder) appendUint64(v uint64) {
+	enc.appendUint32(uint32(v >> 32))
+	enc.appendUint32(uint32(v))
+}
+
+// Only invoke the standard JSON encoder if there is actually something to
+// encode; otherwise write JSON null literal directly.
+func (enc *msgpackEncoder) encodeReflected(obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nullLiteralBytes, nil
+	}
+	if enc.reflectBuf == nil {
+		enc.reflectBuf = bufferpool.Get()
+		enc.reflectEnc = enc.NewReflectedEncoder(enc.reflectBuf)
+	} else {
+		enc.reflectBuf.Reset()
+	}
+	if err := enc.reflectEnc.Encode(obj); err != nil {
+		return nil, err
+	}
+	enc.reflectBuf.TrimNewline()
+	return enc.reflectBuf.Bytes(), nil
+}
+
+// appendJSON converts a JSON value to MessagePack. Values that aren't valid
+// JSON, which a custom ReflectedEncoder may produce, are written as strings.
+func (enc *msgpackEncoder) appendJSON(data []byte) error {
+	if !json.Valid(data) {
+		enc.appendByteString(data)
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	n := len(enc.open)
+	err := enc.appendJSONValue(dec)
+	enc.closeContainers(n)
+	return err
+}
+
+func (enc *msgpackEncoder) appendJSONValue(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		kind := byte(_msgpackArray32)
+		if tok == '{' {
+			kind = _msgpackMap32
+		}
+		enc.openContainer(kind)
+		for dec.More() {
+			enc.addEntries(len(enc.open)-1, 1)
+			if kind == _msgpackMap32 {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				enc.appendString(key.(string))
+			}
+			if err := enc.appendJSONValue(dec); err != nil {
+				return err
+			}
+		}
+		enc.closeContainers(len(enc.open) - 1)
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		return err
+	case string:
+		enc.appendString(tok)
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			enc.appendInt(i)
+		} else if f, err := tok.Float64(); err == nil {
+			enc.appendFloat64(f)
+		} else {
+			enc.appendString(tok.String())
+		}
+	case bool:
+		enc.appendBool(tok)
+	case nil:
+		enc.buf.AppendByte(_msgpackNil)
+	default:
+		return fmt.Errorf("unexpected JSON token %v", tok)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+func decodeMsgpackEntry(t testing.TB, enc zapcore.Encoder, ent zapcore.Entry, fields ...zapcore.Field) map[string]interface{} {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+
+	entries, err := zaptest.DecodeMsgpack(buf.Bytes())
+	require.NoError(t, err, "Unexpected error decoding entry.")
+	require.Len(t, entries, 1, "Expected a single entry.")
+	return entries[0]
+}
+
+func TestMsgpackEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891000000, time.UTC)
+	enc := zapcore.NewMsgpackEncoder(zap.NewProductionEncoderConfig())
+	ent := zapcore.Entry{
+		Level:      zapcore.ErrorLevel,
+		Time:       ts,
+		LoggerName: "svc",
+		Message:    "hello",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+		Stack:      "goroutine 1",
+	}
+
+	got := decodeMsgpackEntry(t, enc, ent, zap.String("k", "v"))
+	assert.Equal(t, map[string]interface{}{
+		"level":      "error",
+		"ts":         ts,
+		"logger":     "svc",
+		"caller":     "app/main.go:42",
+		"msg":        "hello",
+		"k":          "v",
+		"stacktrace": "goroutine 1",
+	}, got, "Unexpected entry.")
+}
+
+func TestMsgpackEncoderFields(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 0, time.UTC)
+	enc := zapcore.NewMsgpackEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	got := decodeMsgpackEntry(t, enc, zapcore.Entry{Message: "hello"},
+		zap.Bool("bool", true),
+		zap.Int("fixint", 7),
+		zap.Int("negfixint", -7),
+		zap.Int8("int8", math.MinInt8),
+		zap.Int16("int16", math.MinInt16),
+		zap.Int32("int32", math.MinInt32),
+		zap.Int64("int64", math.MinInt64),
+		zap.Uint8("uint8", math.MaxUint8),
+		zap.Uint16("uint16", math.MaxUint16),
+		zap.Uint32("uint32", math.MaxUint32),
+		zap.Uint64("uint64", math.MaxUint64),
+		zap.Float32("float32", 1.5),
+		zap.Float64("float64", 2.5),
+		zap.Complex128("complex", 1-2i),
+		zap.Duration("dur", time.Second),
+		zap.Time("time", ts),
+		zap.Time("time64", ts.Add(time.Millisecond)),
+		zap.Time("time96", time.Date(3000, time.January, 1, 0, 0, 0, 1, time.UTC)),
+		zap.Time("pre-epoch", time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		zap.Binary("binary", []byte{0, 1, 2}),
+		zap.ByteString("bytes", []byte("foo")),
+		zap.String("long", string(make([]byte, 300))),
+		zap.Error(errors.New("oh no")),
+		zap.Reflect("reflect", map[string]interface{}{
+			"list": []interface{}{1, 1.5, "a", true, nil},
+			"big":  uint64(math.MaxUint64),
+		}),
+		zap.Reflect("nil", nil),
+	)
+
+	assert.Equal(t, map[string]interface{}{
+		"msg":       "hello",
+		"bool":      true,
+		"fixint":    int64(7),
+		"negfixint": int64(-7),
+		"int8":      int64(math.MinInt8),
+		"int16":     int64(math.MinInt16),
+		"int32":     int64(math.MinInt32),
+		"int64":     int64(math.MinInt64),
+		"uint8":     int64(math.MaxUint8),
+		"uint16":    int64(math.MaxUint16),
+		"uint32":    int64(math.MaxUint32),
+		"uint64":    uint64(math.MaxUint64),
+		"float32":   float32(1.5),
+		"float64":   2.5,
+		"complex":   []interface{}{1.0, -2.0},
+		"dur":       int64(time.Second),
+		"time":      ts,
+		"time64":    ts.Add(time.Millisecond),
+		"time96":    time.Date(3000, time.January, 1, 0, 0, 0, 1, time.UTC),
+		"pre-epoch": time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"binary":    []byte{0, 1, 2},
+		"bytes":     "foo",
+		"long":      string(make([]byte, 300)),
+		"error":     "oh no",
+		"reflect": map[string]interface{}{
+			"list": []interface{}{int64(1), 1.5, "a", true, nil},
+			"big":  float64(math.MaxUint64),
+		},
+		"nil": nil,
+	}, got, "Unexpected fields.")
+}
+
+func TestMsgpackEncoderInvalidUTF8(t *testing.T) {
+	enc := zapcore.NewMsgpackEncoder(zapcore.EncoderConfig{})
+	got := decodeMsgpackEntry(t, enc, zapcore.Entry{},
+		zap.String("s", "b\x00s\xff"),
+		zap.ByteString("b", []byte("\xc3")),
+		zap.Strings("strs", []string{"a\xffb"}),
+		zap.String("\xfe", "héllo"),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"s":      "b\x00s\ufffd",
+		"b":      "\ufffd",
+		"strs":   []interface{}{"a\ufffdb"},
+		"\ufffd": "héllo",
+	}, got, "Expected invalid UTF-8 to be replaced.")
+}
+
+func TestMsgpackEncoderNesting(t *testing.T) {
+	enc := zapcore.NewMsgpackEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	enc.AddString("ctx", "a")
+	enc.OpenNamespace("ns")
+	enc.AddString("inner", "b")
+
+	clone := enc.Clone()
+	clone.AddString("clone", "c")
+
+	got := decodeMsgpackEntry(t, enc, zapcore.Entry{Message: "hello"},
+		zap.Object("obj", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddInt("n", 1)
+			enc.OpenNamespace("deeper")
+			enc.AddInt("m", 2)
+			return nil
+		})),
+		zap.Array("arr", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			enc.AppendString("x")
+			enc.AppendDuration(time.Millisecond)
+			if err := enc.AppendArray(zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+				enc.AppendBool(false)
+				return nil
+			})); err != nil {
+				return err
+			}
+			return enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("k", "v")
+				return nil
+			}))
+		})),
+		zap.Ints("empty", nil),
+		zap.String("after", "d"),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"msg": "hello",
+		"ctx": "a",
+		"ns": map[string]interface{}{
+			"inner": "b",
+			"obj": map[string]interface{}{
+				"n":      int64(1),
+				"deeper": map[string]interface{}{"m": int64(2)},
+			},
+			"arr": []interface{}{
+				"x",
+				int64(time.Millisecond),
+				[]interface{}{false},
+				map[string]interface{}{"k": "v"},
+			},
+			"empty": []interface{}{},
+			"after": "d",
+		},
+	}, got, "Unexpected entry from original encoder.")
+
+	got = decodeMsgpackEntry(t, clone, zapcore.Entry{Message: "hello"})
+	assert.Equal(t, map[string]interface{}{
+		"msg": "hello",
+		"ctx": "a",
+		"ns":  map[string]interface{}{"inner": "b", "clone": "c"},
+	}, got, "Unexpected entry from clone.")
+}
+
+func TestMsgpackEncoderContainerHeaders(t *testing.T) {
+	tests := []struct {
+		desc  string
+		field zapcore.Field
+		want  []byte
+	}{
+		{
+			desc:  "fixarray",
+			field: zap.Ints("a", []int{1, 2}),
+			want:  []byte{0x81, 0xa1, 'a', 0x92, 0x01, 0x02},
+		},
+		{
+			desc: "fixmap",
+			field: zap.Object("o", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddBool("b", true)
+				return nil
+			})),
+			want: []byte{0x81, 0xa1, 'o', 0x81, 0xa1, 'b', 0xc3},
+		},
+		{
+			desc:  "array16",
+			field: zap.Bools("a", make([]bool, 16)),
+			want:  append([]byte{0x81, 0xa1, 'a', 0xdc, 0x00, 0x10}, bytes.Repeat([]byte{0xc2}, 16)...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewMsgpackEncoder(zapcore.EncoderConfig{})
+			buf, err := enc.EncodeEntry(zapcore.Entry{}, []zapcore.Field{tt.field})
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.Bytes(), "Unexpected encoding.")
+			buf.Free()
+		})
+	}
+}
+
+func TestMsgpackEncoderUserEncoders(t *testing.T) {
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		LoggerName: "svc",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+	}
+	tests := []struct {
+		desc string
+		cfg  zapcore.EncoderConfig
+		want map[string]interface{}
+	}{
+		{
+			desc: "custom",
+			cfg: zapcore.EncoderConfig{
+				LevelKey:     "L",
+				NameKey:      "N",
+				CallerKey:    "C",
+				FunctionKey:  "F",
+				EncodeLevel:  zapcore.CapitalLevelEncoder,
+				EncodeCaller: zapcore.FullCallerEncoder,
+				EncodeName: func(name string, enc zapcore.PrimitiveArrayEncoder) {
+					enc.AppendString("name:" + name)
+				},
+			},
+			want: map[string]interface{}{
+				"L": "WARN",
+				"N": "name:svc",
+				"C": "/src/app/main.go:42",
+				"F": "",
+			},
+		},
+		{
+			desc: "no-op",
+			cfg: zapcore.EncoderConfig{
+				LevelKey:     "L",
+				NameKey:      "N",
+				CallerKey:    "C",
+				EncodeLevel:  func(zapcore.Level, zapcore.PrimitiveArrayEncoder) {},
+				EncodeName:   func(string, zapcore.PrimitiveArrayEncoder) {},
+				EncodeCaller: func(zapcore.EntryCaller, zapcore.PrimitiveArrayEncoder) {},
+			},
+			want: map[string]interface{}{
+				"L": "warn",
+				"N": "svc",
+				"C": "/src/app/main.go:42",
+			},
+		},
+		{
+			desc: "default name encoder",
+			cfg:  zapcore.EncoderConfig{NameKey: "N"},
+			want: map[string]interface{}{"N": "svc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := zapcore.NewMsgpackEncoder(tt.cfg)
+			assert.Equal(t, tt.want, decodeMsgpackEntry(t, enc, ent), "Unexpected entry.")
+		})
+	}
+}
+
+func TestMsgpackEncoderReflectedError(t *testing.T) {
+	enc := zapcore.NewMsgpackEncoder(zapcore.EncoderConfig{})
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error encoding a channel.")
+}
+
+func TestMsgpackEncoderAllocs(t *testing.T) {
+	if ztest.RaceEnabled {
+		t.Skip("Skipping allocation test under the race detector.")
+	}
+
+	enc := zapcore.NewMsgpackEncoder(zap.NewProductionEncoderConfig())
+	enc.AddString("service", "api")
+	enc.OpenNamespace("ns")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "hello world"}
+	fields := []zapcore.Field{
+		zap.String("str", "foo bar"),
+		zap.Int("int", 42),
+		zap.Ints("ints", []int{1, 2, 3}),
+		zap.Time("time", time.Now()),
+		zap.Bool("bool", true),
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	})
+	assert.Zero(t, allocs, "Expected encoding to be allocation-free.")
+}
+
+func BenchmarkZapMsgpack(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			enc := zapcore.NewMsgpackEncoder(testEncoderConfig())
+			enc.AddString("str", "foo")
+			enc.AddInt64("int64-1", 1)
+			enc.AddInt64("int64-2", 2)
+			enc.AddFloat64("float64", 1.0)
+			enc.AddString("string1", "\n")
+			enc.AddString("string2", "💩")
+			enc.AddString("string3", "🤔")
+			enc.AddString("string4", "🙊")
+			enc.AddBool("bool", true)
+			buf, _ := enc.EncodeEntry(zapcore.Entry{
+				Message: "fake",
+				Level:   zapcore.DebugLevel,
+			}, nil)
+			buf.Free()
+		}
+	})
+}
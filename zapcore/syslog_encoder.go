@@ -0,0 +1,638 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"encoding/base64"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+)
+
+// _syslogTimeFormat is the RFC 5424 timestamp layout, with the maximum
+// permitted precision.
+const _syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Maximum lengths of the header fields and SD-NAMEs defined by RFC 5424.
+const (
+	_syslogMaxHostname = 255
+	_syslogMaxAppName  = 48
+	_syslogMaxProcID   = 128
+	_syslogMaxMsgID    = 32
+	_syslogMaxName     = 32
+)
+
+// _syslogDefaultSDID is the SD-ID used for fields if none is configured. 32473
+// is the Private Enterprise Number reserved for documentation by RFC 5612.
+const _syslogDefaultSDID = "zap@32473"
+
+// A SyslogFacility identifies the kind of program logging a syslog message.
+type SyslogFacility uint8
+
+// Facilities defined by RFC 5424.
+const (
+	SyslogKern SyslogFacility = iota
+	SyslogUser
+	SyslogMail
+	SyslogDaemon
+	SyslogAuth
+	SyslogSyslog
+	SyslogLPR
+	SyslogNews
+	SyslogUUCP
+	SyslogCron
+	SyslogAuthPriv
+	SyslogFTP
+	SyslogNTP
+	SyslogSecurity
+	SyslogConsole
+	SyslogSolarisCron
+	SyslogLocal0
+	SyslogLocal1
+	SyslogLocal2
+	SyslogLocal3
+	SyslogLocal4
+	SyslogLocal5
+	SyslogLocal6
+	SyslogLocal7
+)
+
+// syslogSeverity maps a Level to the corresponding RFC 5424 severity.
+func syslogSeverity(l Level) int {
+	switch l {
+	case DebugLevel:
+		return 7 // debug
+	case InfoLevel:
+		return 6 // informational
+	case WarnLevel:
+		return 4 // warning
+	case ErrorLevel:
+		return 3 // error
+	case DPanicLevel:
+		return 2 // critical
+	case PanicLevel:
+		return 1 // alert
+	case FatalLevel:
+		return 0 // emergency
+	default:
+		return 5 // notice
+	}
+}
+
+// A SyslogOption configures a syslog encoder.
+type SyslogOption interface {
+	apply(*syslogOptions)
+}
+
+type syslogOptionFunc func(*syslogOptions)
+
+func (f syslogOptionFunc) apply(o *syslogOptions) {
+	f(o)
+}
+
+type syslogOptions struct {
+	facility SyslogFacility
+	hostname string
+	appName  string
+	procID   string
+	msgID    string
+	sdID     string
+}
+
+// WithSyslogFacility sets the facility of each message. Defaults to
+// SyslogUser.
+func WithSyslogFacility(f SyslogFacility) SyslogOption {
+	return syslogOptionFunc(func(o *syslogOptions) {
+		o.facility = f
+	})
+}
+
+// WithSyslogHostname sets the HOSTNAME of each message. Defaults to the name
+// reported by os.Hostname.
+func WithSyslogHostname(hostname string) SyslogOption {
+	return syslogOptionFunc(func(o *syslogOptions) {
+		o.hostname = hostname
+	})
+}
+
+// WithSyslogAppName sets the APP-NAME of messages logged by unnamed loggers.
+// Messages from named loggers use the logger's name instead. Defaults to the
+// base name of the running program.
+func WithSyslogAppName(name string) SyslogOption {
+	return syslogOptionFunc(func(o *syslogOptions) {
+		o.appName = name
+	})
+}
+
+// WithSyslogProcID sets the PROCID of each message. Defaults to the process
+// ID.
+func WithSyslogProcID(procID string) SyslogOption {
+	return syslogOptionFunc(func(o *syslogOptions) {
+		o.procID = procID
+	})
+}
+
+// WithSyslogMsgID sets the MSGID of each message. Defaults to omitting it.
+func WithSyslogMsgID(msgID string) SyslogOption {
+	return syslogOptionFunc(func(o *syslogOptions) {
+		o.msgID = msgID
+	})
+}
+
+// WithSyslogSDID sets the SD-ID of the STRUCTURED-DATA element holding each
+// message's fields. Defaults to "zap@32473".
+func WithSyslogSDID(id string) SyslogOption {
+	return syslogOptionFunc(func(o *syslogOptions) {
+		o.sdID = id
+	})
+}
+
+var _syslogPool = sync.Pool{New: func() interface{} {
+	return &syslogEncoder{}
+}}
+
+func getSyslogEncoder() *syslogEncoder {
+	return _syslogPool.Get().(*syslogEncoder)
+}
+
+func putSyslogEncoder(enc *syslogEncoder) {
+	enc.EncoderConfig = nil
+	enc.opts = nil
+	enc.buf = nil
+	enc.prefix = ""
+	_syslogPool.Put(enc)
+}
+
+type syslogEncoder struct {
+	*EncoderConfig
+	opts *syslogOptions
+
+	// buf holds the SD-PARAMs added so far, each preceded by a space.
+	buf *buffer.Buffer
+	// prefix is prepended to the names of SD-PARAMs added in namespaces and
+	// nested objects.
+	prefix string
+}
+
+// NewSyslogEncoder creates an encoder whose output is an RFC 5424 syslog
+// message. The entry's level and the facility determine the PRI, the logger's
+// name is used as the APP-NAME, and the message is used as the MSG.
+//
+// Each message is a single line, so that it can be framed with a trailing
+// newline: line breaks in the MSG and in SD-PARAM values are written as the
+// two-character escapes \n and \r.
+//
+// Fields, along with the caller and stack trace (if their keys are
+// configured), are encoded as SD-PARAMs of a single STRUCTURED-DATA element.
+// Fields of nested objects and namespaces are flattened with dotted names,
+// while arrays and reflected values are encoded as JSON. The EncodeTime,
+// EncodeDuration and EncodeCaller functions are honored for field values;
+// the message timestamp is always encoded in the format required by RFC 5424.
+//
+// The level, time and name keys of the EncoderConfig are ignored, since those
+// elements are part of the message header.
+func NewSyslogEncoder(cfg EncoderConfig, opts ...SyslogOption) Encoder {
+	if cfg.SkipLineEnding {
+		cfg.LineEnding = ""
+	} else if cfg.LineEnding == "" {
+		cfg.LineEnding = DefaultLineEnding
+	}
+	if cfg.NewReflectedEncoder == nil {
+		cfg.NewReflectedEncoder = defaultReflectedEncoder
+	}
+
+	o := &syslogOptions{
+		facility: SyslogUser,
+		appName:  filepath.Base(os.Args[0]),
+		procID:   strconv.Itoa(os.Getpid()),
+		sdID:     _syslogDefaultSDID,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		o.hostname = hostname
+	}
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	return &syslogEncoder{
+		EncoderConfig: &cfg,
+		opts:          o,
+		buf:           bufferpool.Get(),
+	}
+}
+
+func (enc *syslogEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	return enc.addJSON(key, func(j *jsonEncoder) error {
+		return j.AppendArray(arr)
+	})
+}
+
+func (enc *syslogEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	old := enc.prefix
+	enc.prefix = old + key + "."
+	err := obj.MarshalLogObject(enc)
+	enc.prefix = old
+	return err
+}
+
+func (enc *syslogEncoder) AddReflected(key string, obj interface{}) error {
+	return enc.addJSON(key, func(j *jsonEncoder) error {
+		return j.AppendReflected(obj)
+	})
+}
+
+// addJSON adds a parameter whose value is JSON written by f.
+func (enc *syslogEncoder) addJSON(key string, f func(*jsonEncoder) error) error {
+	j := getJSONEncoder()
+	j.EncoderConfig = enc.EncoderConfig
+	j.buf = bufferpool.Get()
+	defer func() {
+		j.buf.Free()
+		putJSONEncoder(j)
+	}()
+
+	if err := f(j); err != nil {
+		return err
+	}
+	enc.beginParam(key)
+	enc.AppendByteString(j.buf.Bytes())
+	enc.endParam()
+	return nil
+}
+
+func (enc *syslogEncoder) OpenNamespace(key string) {
+	enc.prefix = enc.prefix + key + "."
+}
+
+func (enc *syslogEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *syslogEncoder) AddByteString(key string, val []byte) {
+	enc.beginParam(key)
+	enc.AppendByteString(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddBool(key string, val bool) {
+	enc.beginParam(key)
+	enc.AppendBool(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddComplex128(key string, val complex128) {
+	enc.beginParam(key)
+	enc.AppendComplex128(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddDuration(key string, val time.Duration) {
+	enc.beginParam(key)
+	cur := enc.buf.Len()
+	if e := enc.EncodeDuration; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		enc.AppendInt64(int64(val))
+	}
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddFloat64(key string, val float64) {
+	enc.beginParam(key)
+	enc.AppendFloat64(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddInt64(key string, val int64) {
+	enc.beginParam(key)
+	enc.AppendInt64(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddString(key, val string) {
+	enc.beginParam(key)
+	enc.AppendString(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddTime(key string, val time.Time) {
+	enc.beginParam(key)
+	cur := enc.buf.Len()
+	if e := enc.EncodeTime; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		enc.AppendInt64(val.UnixNano())
+	}
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddUint64(key string, val uint64) {
+	enc.beginParam(key)
+	enc.AppendUint64(val)
+	enc.endParam()
+}
+
+func (enc *syslogEncoder) AddComplex64(k string, v complex64)     { enc.AddComplex128(k, complex128(v)) }
+func (enc *syslogEncoder) AddFloat32(k string, v float32)         { enc.AddFloat64(k, float64(v)) }
+func (enc *syslogEncoder) AddInt(k string, v int)                 { enc.AddInt64(k, int64(v)) }
+func (enc *syslogEncoder) AddInt32(k string, v int32)             { enc.AddInt64(k, int64(v)) }
+func (enc *syslogEncoder) AddInt16(k string, v int16)             { enc.AddInt64(k, int64(v)) }
+func (enc *syslogEncoder) AddInt8(k string, v int8)               { enc.AddInt64(k, int64(v)) }
+func (enc *syslogEncoder) AddUint(k string, v uint)               { enc.AddUint64(k, uint64(v)) }
+func (enc *syslogEncoder) AddUint32(k string, v uint32)           { enc.AddUint64(k, uint64(v)) }
+func (enc *syslogEncoder) AddUint16(k string, v uint16)           { enc.AddUint64(k, uint64(v)) }
+func (enc *syslogEncoder) AddUint8(k string, v uint8)             { enc.AddUint64(k, uint64(v)) }
+func (enc *syslogEncoder) AddUintptr(k string, v uintptr)         { enc.AddUint64(k, uint64(v)) }
+func (enc *syslogEncoder) AppendComplex64(v complex64)            { enc.AppendComplex128(complex128(v)) }
+func (enc *syslogEncoder) AppendFloat32(v float32)                { enc.appendFloat(float64(v), 32) }
+func (enc *syslogEncoder) AppendFloat64(v float64)                { enc.appendFloat(v, 64) }
+func (enc *syslogEncoder) AppendInt(v int)                        { enc.AppendInt64(int64(v)) }
+func (enc *syslogEncoder) AppendInt32(v int32)                    { enc.AppendInt64(int64(v)) }
+func (enc *syslogEncoder) AppendInt16(v int16)                    { enc.AppendInt64(int64(v)) }
+func (enc *syslogEncoder) AppendInt8(v int8)                      { enc.AppendInt64(int64(v)) }
+func (enc *syslogEncoder) AppendUint(v uint)                      { enc.AppendUint64(uint64(v)) }
+func (enc *syslogEncoder) AppendUint32(v uint32)                  { enc.AppendUint64(uint64(v)) }
+func (enc *syslogEncoder) AppendUint16(v uint16)                  { enc.AppendUint64(uint64(v)) }
+func (enc *syslogEncoder) AppendUint8(v uint8)                    { enc.AppendUint64(uint64(v)) }
+func (enc *syslogEncoder) AppendUintptr(v uintptr)                { enc.AppendUint64(uint64(v)) }
+func (enc *syslogEncoder) AppendBool(v bool)                      { enc.buf.AppendBool(v) }
+func (enc *syslogEncoder) AppendInt64(v int64)                    { enc.buf.AppendInt(v) }
+func (enc *syslogEncoder) AppendUint64(v uint64)                  { enc.buf.AppendUint(v) }
+func (enc *syslogEncoder) AppendString(v string)                  { enc.safeAddString(v) }
+func (enc *syslogEncoder) AppendByteString(v []byte)              { enc.safeAddByteString(v) }
+func (enc *syslogEncoder) AppendTimeLayout(t time.Time, l string) { enc.buf.AppendTime(t, l) }
+
+// The syslog encoder is a PrimitiveArrayEncoder for the sake of the
+// user-supplied time, duration and caller encoders, which write the value of
+// a single SD-PARAM.
+var _ PrimitiveArrayEncoder = (*syslogEncoder)(nil)
+
+func (enc *syslogEncoder) AppendComplex128(val complex128) {
+	r, i := float64(real(val)), float64(imag(val))
+	enc.buf.AppendFloat(r, 64)
+	if i >= 0 {
+		enc.buf.AppendByte('+')
+	}
+	enc.buf.AppendFloat(i, 64)
+	enc.buf.AppendByte('i')
+}
+
+func (enc *syslogEncoder) appendFloat(val float64, bitSize int) {
+	switch {
+	case math.IsNaN(val):
+		enc.buf.AppendString("NaN")
+	case math.IsInf(val, 1):
+		enc.buf.AppendString("+Inf")
+	case math.IsInf(val, -1):
+		enc.buf.AppendString("-Inf")
+	default:
+		enc.buf.AppendFloat(val, bitSize)
+	}
+}
+
+func (enc *syslogEncoder) Clone() Encoder {
+	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+func (enc *syslogEncoder) clone() *syslogEncoder {
+	clone := getSyslogEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.opts = enc.opts
+	clone.prefix = enc.prefix
+	clone.buf = bufferpool.Get()
+	return clone
+}
+
+func (enc *syslogEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+	final.buf.Write(enc.buf.Bytes())
+
+	// Entry metadata that doesn't fit in the header goes first, outside of
+	// any namespaces.
+	final.prefix = ""
+	if ent.Caller.Defined {
+		if final.CallerKey != "" && final.EncodeCaller != nil {
+			final.beginParam(final.CallerKey)
+			cur := final.buf.Len()
+			final.EncodeCaller(ent.Caller, final)
+			if cur == final.buf.Len() {
+				final.AppendString(ent.Caller.String())
+			}
+			final.endParam()
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	final.prefix = enc.prefix
+	addFields(final, fields)
+	final.prefix = ""
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+
+	line := bufferpool.Get()
+	line.AppendByte('<')
+	line.AppendInt(int64(final.opts.facility)*8 + int64(syslogSeverity(ent.Level)))
+	line.AppendString(">1 ")
+	if ent.Time.IsZero() {
+		line.AppendByte('-')
+	} else {
+		line.AppendTime(ent.Time, _syslogTimeFormat)
+	}
+	line.AppendByte(' ')
+	appendSyslogHeaderField(line, final.opts.hostname, _syslogMaxHostname)
+	line.AppendByte(' ')
+	appName := ent.LoggerName
+	if appName == "" {
+		appName = final.opts.appName
+	}
+	appendSyslogHeaderField(line, appName, _syslogMaxAppName)
+	line.AppendByte(' ')
+	appendSyslogHeaderField(line, final.opts.procID, _syslogMaxProcID)
+	line.AppendByte(' ')
+	appendSyslogHeaderField(line, final.opts.msgID, _syslogMaxMsgID)
+	line.AppendByte(' ')
+
+	if final.buf.Len() == 0 {
+		line.AppendByte('-')
+	} else {
+		line.AppendByte('[')
+		appendSyslogName(line, final.opts.sdID, "", _syslogMaxName)
+		line.Write(final.buf.Bytes())
+		line.AppendByte(']')
+	}
+
+	if final.MessageKey != "" && ent.Message != "" {
+		line.AppendByte(' ')
+		appendSyslogMsg(line, ent.Message)
+	}
+	line.AppendString(final.LineEnding)
+
+	final.buf.Free()
+	putSyslogEncoder(final)
+	return line, nil
+}
+
+// beginParam starts an SD-PARAM with the given name, qualified by the current
+// prefix. Its value must be written with the Append methods and terminated
+// with endParam.
+func (enc *syslogEncoder) beginParam(key string) {
+	enc.buf.AppendByte(' ')
+	appendSyslogName(enc.buf, enc.prefix, key, _syslogMaxName)
+	enc.buf.AppendString(`="`)
+}
+
+func (enc *syslogEncoder) endParam() {
+	enc.buf.AppendByte('"')
+}
+
+// addRuneSelf appends a single-byte rune to an SD-PARAM value, escaping the
+// characters RFC 5424 requires and line breaks.
+func (enc *syslogEncoder) addRuneSelf(b byte) {
+	switch b {
+	case '"', '\\', ']':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte(b)
+	case '\n':
+		enc.buf.AppendString(`\n`)
+	case '\r':
+		enc.buf.AppendString(`\r`)
+	default:
+		enc.buf.AppendByte(b)
+	}
+}
+
+// safeAddString appends a string to an SD-PARAM value, escaping the
+// characters RFC 5424 requires and line breaks, and replacing invalid UTF-8.
+func (enc *syslogEncoder) safeAddString(s string) {
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			enc.addRuneSelf(b)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+		} else {
+			enc.buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+}
+
+// safeAddByteString is no-alloc equivalent of safeAddString(string(s)) for
+// s []byte.
+func (enc *syslogEncoder) safeAddByteString(s []byte) {
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			enc.addRuneSelf(b)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			enc.buf.AppendString("\ufffd")
+		} else {
+			enc.buf.Write(s[i : i+size])
+		}
+		i += size
+	}
+}
+
+// appendSyslogMsg appends the MSG, escaping line breaks so that the message
+// stays on one line, and replacing invalid UTF-8.
+func appendSyslogMsg(buf *buffer.Buffer, s string) {
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			switch b {
+			case '\n':
+				buf.AppendString(`\n`)
+			case '\r':
+				buf.AppendString(`\r`)
+			default:
+				buf.AppendByte(b)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.AppendString("\ufffd")
+		} else {
+			buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+}
+
+// appendSyslogHeaderField appends a header field, which must be printable
+// US-ASCII, truncated to max bytes. Empty fields are written as the NILVALUE.
+func appendSyslogHeaderField(buf *buffer.Buffer, s string, max int) {
+	if s == "" {
+		buf.AppendByte('-')
+		return
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 33 && c <= 126 {
+			buf.AppendByte(c)
+		} else {
+			buf.AppendByte('_')
+		}
+	}
+}
+
+// appendSyslogName appends prefix+key as an SD-NAME, which must be printable
+// US-ASCII other than '=', ' ', ']' and '"', truncated to max bytes.
+func appendSyslogName(buf *buffer.Buffer, prefix, key string, max int) {
+	n := 0
+	for _, s := range [2]string{prefix, key} {
+		for i := 0; i < len(s) && n < max; i++ {
+			c := s[i]
+			if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+				c = '_'
+			}
+			buf.AppendByte(c)
+			n++
+		}
+	}
+	if n == 0 {
+		buf.AppendByte('_')
+	}
+}
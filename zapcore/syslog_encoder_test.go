@@ -0,0 +1,184 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func testSyslogEncoder(opts ...zapcore.SyslogOption) zapcore.Encoder {
+	opts = append([]zapcore.SyslogOption{
+		zapcore.WithSyslogHostname("myhost"),
+		zapcore.WithSyslogAppName("myapp"),
+		zapcore.WithSyslogProcID("1234"),
+	}, opts...)
+	return zapcore.NewSyslogEncoder(zap.NewProductionEncoderConfig(), opts...)
+}
+
+func TestSyslogEncodeEntry(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 891011000, time.UTC)
+	tests := []struct {
+		desc   string
+		opts   []zapcore.SyslogOption
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			desc: "minimal",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			want: "<14>1 2022-03-04T05:06:07.891011Z myhost myapp 1234 - - hello\n",
+		},
+		{
+			desc: "no time or message",
+			ent:  zapcore.Entry{Level: zapcore.ErrorLevel},
+			want: "<11>1 - myhost myapp 1234 - -\n",
+		},
+		{
+			desc: "logger name and options",
+			opts: []zapcore.SyslogOption{
+				zapcore.WithSyslogFacility(zapcore.SyslogLocal3),
+				zapcore.WithSyslogMsgID("REQ"),
+				zapcore.WithSyslogSDID("meta@12345"),
+			},
+			ent:  zapcore.Entry{Level: zapcore.WarnLevel, Time: ts, LoggerName: "my svc", Message: "hi"},
+			want: "<156>1 2022-03-04T05:06:07.891011Z myhost my_svc 1234 REQ - hi\n",
+		},
+		{
+			desc: "fields",
+			ent:  zapcore.Entry{Level: zapcore.DebugLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.String("str", `a "quoted" \ [bracketed]`),
+				zap.Int("int", 42),
+				zap.Bool("bool", true),
+				zap.Float64("float", 1.5),
+				zap.Duration("dur", 1500*time.Millisecond),
+				zap.Time("ts", time.Unix(1, 0)),
+				zap.Strings("strs", []string{"a", "b"}),
+				zap.Binary("bin", []byte("hi")),
+				zap.Error(errors.New("boom")),
+				zap.String("bad key=name", "ok"),
+				zap.String("a-very-long-key-that-exceeds-the-limit", "ok"),
+			},
+			want: `<15>1 2022-03-04T05:06:07.891011Z myhost myapp 1234 - [zap@32473` +
+				` str="a \"quoted\" \\ [bracketed\]"` +
+				` int="42"` +
+				` bool="true"` +
+				` float="1.5"` +
+				` dur="1.5"` +
+				` ts="1"` +
+				` strs="[\"a\",\"b\"\]"` +
+				` bin="aGk="` +
+				` error="boom"` +
+				` bad_key_name="ok"` +
+				` a-very-long-key-that-exceeds-the="ok"` +
+				"] hello\n",
+		},
+		{
+			desc: "nested objects and namespaces",
+			ent:  zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "hello"},
+			fields: []zapcore.Field{
+				zap.Object("obj", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("k", "v")
+					enc.OpenNamespace("ns")
+					enc.AddInt("n", 1)
+					return nil
+				})),
+				zap.Namespace("outer"),
+				zap.String("k", "v"),
+			},
+			want: `<14>1 2022-03-04T05:06:07.891011Z myhost myapp 1234 - [zap@32473` +
+				` obj.k="v" obj.ns.n="1" outer.k="v"] hello` + "\n",
+		},
+		{
+			desc: "caller and stack",
+			ent: zapcore.Entry{
+				Level:   zapcore.FatalLevel,
+				Time:    ts,
+				Message: "hello",
+				Caller:  zapcore.EntryCaller{Defined: true, File: "/src/foo/bar.go", Line: 42},
+				Stack:   "fake\nstack",
+			},
+			fields: []zapcore.Field{zap.Int("n", 1)},
+			want: `<8>1 2022-03-04T05:06:07.891011Z myhost myapp 1234 - [zap@32473` +
+				` caller="foo/bar.go:42" n="1" stacktrace="fake\nstack"] hello` + "\n",
+		},
+		{
+			desc:   "line breaks",
+			ent:    zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "a|b=c\\d\nline\r\xff"},
+			fields: []zapcore.Field{zap.String("s", "x\r\ny")},
+			want: `<14>1 2022-03-04T05:06:07.891011Z myhost myapp 1234 - [zap@32473 s="x\r\ny"]` +
+				` a|b=c\d\nline\r` + "\ufffd\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := testSyslogEncoder(tt.opts...)
+			buf, err := enc.EncodeEntry(tt.ent, tt.fields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected syslog message.")
+			buf.Free()
+		})
+	}
+}
+
+func TestSyslogEncoderClone(t *testing.T) {
+	enc := testSyslogEncoder()
+	enc.AddString("parent", "p")
+
+	child := enc.Clone()
+	child.OpenNamespace("ns")
+	child.AddString("child", "c")
+
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "hi"}
+	buf, err := child.EncodeEntry(ent, []zapcore.Field{zap.Int("n", 1)})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `<14>1 - myhost myapp 1234 - [zap@32473 parent="p" ns.child="c" ns.n="1"] hi`+"\n", buf.String())
+	buf.Free()
+
+	buf, err = enc.EncodeEntry(ent, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, `<14>1 - myhost myapp 1234 - [zap@32473 parent="p"] hi`+"\n", buf.String(), "Expected parent to be unaffected by child.")
+	buf.Free()
+}
+
+func TestSyslogEncoderMarshalErrors(t *testing.T) {
+	enc := testSyslogEncoder()
+	failing := zapcore.ArrayMarshalerFunc(func(zapcore.ArrayEncoder) error {
+		return errors.New("fail")
+	})
+	assert.Error(t, enc.AddArray("arr", failing), "Expected array marshaling error to propagate.")
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected reflection error to propagate.")
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "<14>1 - myhost myapp 1234 - -\n", buf.String(), "Expected failed fields to be omitted.")
+	buf.Free()
+}
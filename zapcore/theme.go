@@ -0,0 +1,289 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/buffer"
+)
+
+// A Color is a foreground color for console output: one of the 16 standard
+// ANSI colors, an entry in the 256-color palette, or a 24-bit RGB
+// ("truecolor") value. The zero value is NoColor.
+//
+// Colors marshal to and from text, so they can be configured from YAML or
+// JSON. The text form of a standard color is its name (e.g., "red" or
+// "brightRed"), a palette color is its index (e.g., "208"), and an RGB color
+// is written in hex (e.g., "#ff8800").
+type Color uint32
+
+const (
+	colorModeBasic Color = (iota + 1) << 24
+	colorMode256
+	colorModeRGB
+
+	_colorModeMask Color = 0xff << 24
+)
+
+// NoColor leaves text uncolored.
+const NoColor Color = 0
+
+// The standard ANSI colors, supported by nearly all terminals.
+const (
+	ColorBlack Color = colorModeBasic + iota
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorMagenta
+	ColorCyan
+	ColorWhite
+	ColorBrightBlack
+	ColorBrightRed
+	ColorBrightGreen
+	ColorBrightYellow
+	ColorBrightBlue
+	ColorBrightMagenta
+	ColorBrightCyan
+	ColorBrightWhite
+)
+
+const _colorReset = "\x1b[0m"
+
+var _basicColorNames = [...]string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"brightBlack", "brightRed", "brightGreen", "brightYellow",
+	"brightBlue", "brightMagenta", "brightCyan", "brightWhite",
+}
+
+// Color256 returns the color at index n of the 256-color palette supported by
+// most modern terminals.
+func Color256(n uint8) Color {
+	return colorMode256 | Color(n)
+}
+
+// RGBColor returns a 24-bit color. Only some terminals support these colors;
+// others approximate them or ignore them.
+func RGBColor(r, g, b uint8) Color {
+	return colorModeRGB | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// String returns the text form of the color, or the empty string for
+// NoColor.
+func (c Color) String() string {
+	switch c & _colorModeMask {
+	case colorModeBasic:
+		if n := int(c &^ _colorModeMask); n < len(_basicColorNames) {
+			return _basicColorNames[n]
+		}
+	case colorMode256:
+		return strconv.Itoa(int(c &^ _colorModeMask))
+	case colorModeRGB:
+		return fmt.Sprintf("#%06x", uint32(c&^_colorModeMask))
+	}
+	if c == NoColor {
+		return ""
+	}
+	return fmt.Sprintf("Color(%d)", uint32(c))
+}
+
+// MarshalText marshals the Color to its text form.
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText unmarshals a color name (case-insensitively), a palette index,
+// or a "#rrggbb" hex value into a Color. The empty string unmarshals to
+// NoColor.
+func (c *Color) UnmarshalText(text []byte) error {
+	s := string(text)
+	if s == "" {
+		*c = NoColor
+		return nil
+	}
+	for i, name := range _basicColorNames {
+		if strings.EqualFold(s, name) {
+			*c = colorModeBasic | Color(i)
+			return nil
+		}
+	}
+	if len(s) == 7 && s[0] == '#' {
+		if rgb, err := strconv.ParseUint(s[1:], 16, 32); err == nil {
+			*c = colorModeRGB | Color(rgb)
+			return nil
+		}
+	}
+	if n, err := strconv.ParseUint(s, 10, 8); err == nil {
+		*c = Color256(uint8(n))
+		return nil
+	}
+	return fmt.Errorf("unrecognized color: %q", text)
+}
+
+// appendStart appends the escape sequence that switches to the color.
+func (c Color) appendStart(b *buffer.Buffer) {
+	v := uint64(c &^ _colorModeMask)
+	switch c & _colorModeMask {
+	case colorModeBasic:
+		b.AppendString("\x1b[")
+		if v < 8 {
+			b.AppendUint(30 + v)
+		} else {
+			b.AppendUint(90 + v - 8)
+		}
+	case colorMode256:
+		b.AppendString("\x1b[38;5;")
+		b.AppendUint(v)
+	case colorModeRGB:
+		b.AppendString("\x1b[38;2;")
+		b.AppendUint(v >> 16)
+		b.AppendByte(';')
+		b.AppendUint(v >> 8 & 0xff)
+		b.AppendByte(';')
+		b.AppendUint(v & 0xff)
+	default:
+		return
+	}
+	b.AppendByte('m')
+}
+
+// A Theme colors the parts of each line written by the console encoder. Any
+// part whose Color is NoColor is left uncolored.
+//
+// The level colors wrap whatever the configured LevelEncoder writes, so
+// themes are best paired with a plain LevelEncoder (e.g.,
+// CapitalLevelEncoder) rather than one that adds its own colors.
+type Theme struct {
+	Debug  Color `json:"debug" yaml:"debug"`
+	Info   Color `json:"info" yaml:"info"`
+	Warn   Color `json:"warn" yaml:"warn"`
+	Error  Color `json:"error" yaml:"error"`
+	DPanic Color `json:"dpanic" yaml:"dpanic"`
+	Panic  Color `json:"panic" yaml:"panic"`
+	Fatal  Color `json:"fatal" yaml:"fatal"`
+
+	// Name colors the logger name and Caller colors the caller (and the
+	// function name, if it's included).
+	Name   Color `json:"name" yaml:"name"`
+	Caller Color `json:"caller" yaml:"caller"`
+
+	// FieldKey and FieldValue color the keys and values of the structured
+	// context.
+	FieldKey   Color `json:"fieldKey" yaml:"fieldKey"`
+	FieldValue Color `json:"fieldValue" yaml:"fieldValue"`
+}
+
+// DefaultTheme returns a Theme that colors levels like
+// CapitalColorLevelEncoder, dims the caller, and highlights the logger name
+// and field keys.
+func DefaultTheme() *Theme {
+	return &Theme{
+		Debug:    ColorMagenta,
+		Info:     ColorBlue,
+		Warn:     ColorYellow,
+		Error:    ColorRed,
+		DPanic:   ColorRed,
+		Panic:    ColorRed,
+		Fatal:    ColorRed,
+		Name:     ColorGreen,
+		Caller:   ColorBrightBlack,
+		FieldKey: ColorCyan,
+	}
+}
+
+func (t *Theme) levelColor(l Level) Color {
+	switch l {
+	case DebugLevel:
+		return t.Debug
+	case InfoLevel:
+		return t.Info
+	case WarnLevel:
+		return t.Warn
+	case ErrorLevel:
+		return t.Error
+	case DPanicLevel:
+		return t.DPanic
+	case PanicLevel:
+		return t.Panic
+	case FatalLevel:
+		return t.Fatal
+	}
+	return t.Error
+}
+
+// ColorSupported reports whether colored output should be written to w. It
+// returns false if the NO_COLOR environment variable is set to a non-empty
+// value (see https://no-color.org) or if w isn't a terminal. WriteSyncers
+// returned by AddSync, Lock, and NewMultiWriteSyncer are unwrapped; a
+// WriteSyncer writing to several destinations supports color only if all of
+// them do.
+//
+// zap.Config sets EncoderConfig.DisableColor for outputs that don't support
+// color. Code that builds its own cores can do the same:
+//
+//   cfg.DisableColor = !zapcore.ColorSupported(os.Stderr)
+func ColorSupported(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+func isTerminal(w io.Writer) bool {
+	switch w := w.(type) {
+	case *lockedWriteSyncer:
+		return isTerminal(w.ws)
+	case writerWrapper:
+		return isTerminal(w.Writer)
+	case multiWriteSyncer:
+		for _, ws := range w {
+			if !isTerminal(ws) {
+				return false
+			}
+		}
+		return len(w) > 0
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := w.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	return false
+}
+
+// colorFilter is implemented by encoders that know whether color has been
+// disabled for their output. Color-aware encoding functions, like
+// CapitalColorLevelEncoder, check for it before writing escape sequences.
+type colorFilter interface {
+	colorDisabled() bool
+}
+
+func (cfg *EncoderConfig) colorDisabled() bool {
+	return cfg.DisableColor
+}
+
+func colorEnabled(enc PrimitiveArrayEncoder) bool {
+	f, ok := enc.(colorFilter)
+	return !ok || !f.colorDisabled()
+}
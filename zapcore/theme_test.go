@@ -0,0 +1,212 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "go.uber.org/zap/zapcore"
+)
+
+func TestColorText(t *testing.T) {
+	tests := []struct {
+		color Color
+		text  string
+	}{
+		{NoColor, ""},
+		{ColorBlack, "black"},
+		{ColorRed, "red"},
+		{ColorWhite, "white"},
+		{ColorBrightBlack, "brightBlack"},
+		{ColorBrightWhite, "brightWhite"},
+		{Color256(0), "0"},
+		{Color256(208), "208"},
+		{Color256(255), "255"},
+		{RGBColor(0xff, 0x88, 0x00), "#ff8800"},
+		{RGBColor(0, 0, 0), "#000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.text, tt.color.String(), "Unexpected string.")
+
+			text, err := tt.color.MarshalText()
+			assert.NoError(t, err, "Unexpected error marshaling color.")
+			assert.Equal(t, tt.text, string(text), "Unexpected marshaled text.")
+
+			var c Color
+			assert.NoError(t, c.UnmarshalText(text), "Unexpected error unmarshaling color.")
+			assert.Equal(t, tt.color, c, "Unexpected color after round trip.")
+		})
+	}
+}
+
+func TestColorUnmarshalText(t *testing.T) {
+	var c Color
+	assert.NoError(t, c.UnmarshalText([]byte("BrightRED")), "Unexpected error unmarshaling mixed-case name.")
+	assert.Equal(t, ColorBrightRed, c, "Expected color names to be case-insensitive.")
+
+	for _, text := range []string{"purple", "256", "-1", "#ff88", "#gg8800", "ff8800"} {
+		assert.Error(t, c.UnmarshalText([]byte(text)), "Expected an error unmarshaling %q.", text)
+	}
+}
+
+func TestConsoleEncoderTheme(t *testing.T) {
+	theme := &Theme{
+		Info:       ColorBlue,
+		Name:       ColorBrightGreen,
+		Caller:     Color256(244),
+		FieldKey:   RGBColor(1, 2, 3),
+		FieldValue: ColorYellow,
+	}
+	cfg := encoderTestEncoderConfig(" ")
+	cfg.TimeKey = ""
+	cfg.Theme = theme
+
+	tests := []struct {
+		desc   string
+		modify func(*EncoderConfig)
+		want   string
+	}{
+		{
+			desc: "theme",
+			want: "\x1b[34minfo\x1b[0m \x1b[92mmain\x1b[0m \x1b[38;5;244mfoo.go:42\x1b[0m \x1b[38;5;244mfoo.Foo\x1b[0m hello " +
+				`{` +
+				"\x1b[38;2;1;2;3m\"str\"\x1b[0m: \x1b[33m\"a \\\"b\\\": c\"\x1b[0m, " +
+				"\x1b[38;2;1;2;3m\"obj\"\x1b[0m: {\x1b[38;2;1;2;3m\"n\"\x1b[0m: \x1b[33m-1.5\x1b[0m, " +
+				"\x1b[38;2;1;2;3m\"list\"\x1b[0m: [\x1b[33mtrue\x1b[0m, \x1b[33mnull\x1b[0m]}" +
+				"}\nfake-stack\n",
+		},
+		{
+			desc:   "color disabled",
+			modify: func(cfg *EncoderConfig) { cfg.DisableColor = true },
+			want:   `info main foo.go:42 foo.Foo hello {"str": "a \"b\": c", "obj": {"n": -1.5, "list": [true, null]}}` + "\nfake-stack\n",
+		},
+		{
+			desc:   "no theme",
+			modify: func(cfg *EncoderConfig) { cfg.Theme = nil },
+			want:   `info main foo.go:42 foo.Foo hello {"str": "a \"b\": c", "obj": {"n": -1.5, "list": [true, null]}}` + "\nfake-stack\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := cfg
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			enc := NewConsoleEncoder(cfg)
+			buf, err := enc.EncodeEntry(testEntry, []Field{
+				{Key: "str", Type: StringType, String: `a "b": c`},
+				{Key: "obj", Type: ObjectMarshalerType, Interface: ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+					enc.AddFloat64("n", -1.5)
+					return enc.AddArray("list", ArrayMarshalerFunc(func(enc ArrayEncoder) error {
+						enc.AppendBool(true)
+						return enc.AppendReflected(nil)
+					}))
+				})},
+			})
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Unexpected console output.")
+			buf.Free()
+		})
+	}
+}
+
+func TestColorLevelEncodersDisableColor(t *testing.T) {
+	tests := []struct {
+		desc        string
+		newEncoder  func(EncoderConfig) Encoder
+		encodeLevel LevelEncoder
+		want        string
+	}{
+		{"console capital", NewConsoleEncoder, CapitalColorLevelEncoder, "WARN\thello\n"},
+		{"console lowercase", NewConsoleEncoder, LowercaseColorLevelEncoder, "warn\thello\n"},
+		{"json capital", NewJSONEncoder, CapitalColorLevelEncoder, `{"level":"WARN","msg":"hello"}` + "\n"},
+		{"json lowercase", NewJSONEncoder, LowercaseColorLevelEncoder, `{"level":"warn","msg":"hello"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := testEncoderConfig()
+			cfg.TimeKey = ""
+			cfg.EncodeLevel = tt.encodeLevel
+			cfg.DisableColor = true
+
+			buf, err := tt.newEncoder(cfg).EncodeEntry(Entry{Level: WarnLevel, Message: "hello"}, nil)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			assert.Equal(t, tt.want, buf.String(), "Expected no color escapes.")
+			buf.Free()
+		})
+	}
+}
+
+func TestDefaultTheme(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.CallerKey = ""
+	cfg.Theme = DefaultTheme()
+
+	for _, tt := range []struct {
+		level Level
+		want  string
+	}{
+		{DebugLevel, "\x1b[35mdebug\x1b[0m"},
+		{InfoLevel, "\x1b[34minfo\x1b[0m"},
+		{WarnLevel, "\x1b[33mwarn\x1b[0m"},
+		{ErrorLevel, "\x1b[31merror\x1b[0m"},
+		{FatalLevel, "\x1b[31mfatal\x1b[0m"},
+		{Level(42), "\x1b[31mLevel(42)\x1b[0m"},
+	} {
+		buf, err := NewConsoleEncoder(cfg).EncodeEntry(Entry{Level: tt.level, Message: "m"}, nil)
+		require.NoError(t, err, "Unexpected error encoding entry.")
+		assert.Equal(t, tt.want+"\tm\n", buf.String(), "Unexpected level color for %v.", tt.level)
+		buf.Free()
+	}
+}
+
+func TestColorSupported(t *testing.T) {
+	// os.DevNull is a character device, so it passes for a terminal.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(t, err, "Failed to open %v.", os.DevNull)
+	defer devNull.Close()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err, "Failed to create pipe.")
+	defer r.Close()
+	defer w.Close()
+
+	t.Setenv("NO_COLOR", "")
+	assert.True(t, ColorSupported(devNull), "Expected a character device to support color.")
+	assert.True(t, ColorSupported(Lock(devNull)), "Expected locked WriteSyncers to be unwrapped.")
+	assert.True(t, ColorSupported(AddSync(devNull)), "Expected AddSync to be unwrapped.")
+	assert.True(t, ColorSupported(NewMultiWriteSyncer(devNull, Lock(devNull))), "Expected multi-WriteSyncers to be unwrapped.")
+	assert.False(t, ColorSupported(NewMultiWriteSyncer(devNull, w)), "Expected color only if every destination supports it.")
+	assert.False(t, ColorSupported(w), "Expected a pipe not to support color.")
+	assert.False(t, ColorSupported(&bytes.Buffer{}), "Expected a buffer not to support color.")
+
+	t.Setenv("NO_COLOR", "1")
+	assert.False(t, ColorSupported(devNull), "Expected NO_COLOR to disable color.")
+}
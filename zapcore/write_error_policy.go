@@ -0,0 +1,201 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+const (
+	_defaultWriteRetryBackoff    = 10 * time.Millisecond
+	_defaultWriteRetryMaxBackoff = time.Second
+)
+
+// A WriteErrorPolicy determines how a Core built by NewCoreWithErrorPolicy
+// handles encoded entries that it fails to write.
+//
+// Without a policy, a Core returns write errors to the CheckedEntry, which
+// reports them to the logger's error output, and silently accepts short
+// writes. With a policy, short writes are treated as errors, and failed
+// writes may be completed, retried, or redirected before being reported.
+type WriteErrorPolicy struct {
+	// RetryShortWrites specifies whether to keep writing the remainder of an
+	// entry after the WriteSyncer accepts only part of it without an error.
+	// Otherwise, short writes fail with io.ErrShortWrite.
+	RetryShortWrites bool
+
+	// Retries is the number of times to retry a failed write. Retries write
+	// only the part of the entry that hasn't been written yet.
+	Retries int
+
+	// Backoff is the delay before the first retry. The delay doubles with
+	// each subsequent retry, up to MaxBackoff. Retries block the logging
+	// call.
+	//
+	// Default to 10 milliseconds and 1 second if unspecified.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Fallback, if specified, receives entries that couldn't be written
+	// after all retries. Entries written to the fallback aren't reported as
+	// errors, but are still passed to Hook.
+	Fallback WriteSyncer
+
+	// ReportInterval, if specified, limits how often write errors are
+	// reported: after an error is reported, errors are suppressed until the
+	// interval has passed. The next error reported includes the number of
+	// errors suppressed.
+	ReportInterval time.Duration
+
+	// Hook, if specified, is called with every entry that couldn't be written
+	// after all retries, whether or not it was written to the fallback and
+	// whether or not the error is reported. Use it to count failures for
+	// metrics.
+	Hook func(Entry, error)
+
+	// Clock, if specified, provides control of the source of time for
+	// ReportInterval and the retry backoff.
+	//
+	// Defaults to the system clock.
+	Clock Clock
+}
+
+// writeErrorHandler applies a WriteErrorPolicy. It's shared by a Core and
+// all of its clones.
+type writeErrorHandler struct {
+	WriteErrorPolicy
+
+	mu         sync.Mutex
+	nextReport time.Time // errors are suppressed until this time
+	suppressed int       // errors suppressed since the last report
+}
+
+func newWriteErrorHandler(p WriteErrorPolicy) *writeErrorHandler {
+	if p.Backoff <= 0 {
+		p.Backoff = _defaultWriteRetryBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = _defaultWriteRetryMaxBackoff
+	}
+	if p.Clock == nil {
+		p.Clock = DefaultClock
+	}
+	return &writeErrorHandler{WriteErrorPolicy: p}
+}
+
+// write writes an encoded entry to ws according to the policy, returning
+// the error to report, if any.
+func (h *writeErrorHandler) write(ws WriteSyncer, ent Entry, bs []byte) error {
+	written, err := h.writeFrom(ws, ent.Level, bs, 0)
+	backoff := h.Backoff
+	for i := 0; err != nil && i < h.Retries; i++ {
+		h.sleep(backoff)
+		if backoff *= 2; backoff > h.MaxBackoff {
+			backoff = h.MaxBackoff
+		}
+		written, err = h.writeFrom(ws, ent.Level, bs, written)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if h.Fallback != nil {
+		if _, ferr := h.writeFrom(h.Fallback, ent.Level, bs, 0); ferr != nil {
+			err = multierr.Append(err, fmt.Errorf("fallback write error: %v", ferr))
+		} else {
+			if h.Hook != nil {
+				h.Hook(ent, err)
+			}
+			return nil
+		}
+	}
+
+	if h.Hook != nil {
+		h.Hook(ent, err)
+	}
+	return h.report(err)
+}
+
+// writeFrom writes bs[offset:] to ws, returning the offset reached. A write
+// that fails partway through is resumed from where it stopped.
+func (h *writeErrorHandler) writeFrom(ws WriteSyncer, lvl Level, bs []byte, offset int) (int, error) {
+	for offset < len(bs) {
+		var (
+			n   int
+			err error
+		)
+		if lw, ok := ws.(levelWriter); ok {
+			n, err = lw.writeLevel(lvl, bs[offset:])
+		} else {
+			n, err = ws.Write(bs[offset:])
+		}
+		if n > 0 {
+			// Whatever was written mustn't be written again, even if the
+			// write also failed.
+			if offset += n; offset > len(bs) {
+				offset = len(bs)
+			}
+		}
+		if err != nil {
+			return offset, err
+		}
+		if (n <= 0 || !h.RetryShortWrites) && offset < len(bs) {
+			return offset, io.ErrShortWrite
+		}
+	}
+	return offset, nil
+}
+
+// sleep waits out a retry backoff on the policy's clock.
+func (h *writeErrorHandler) sleep(d time.Duration) {
+	ticker := h.Clock.NewTicker(d)
+	<-ticker.C
+	ticker.Stop()
+}
+
+// report applies ReportInterval to an error.
+func (h *writeErrorHandler) report(err error) error {
+	if h.ReportInterval <= 0 {
+		return err
+	}
+
+	h.mu.Lock()
+	now := h.Clock.Now()
+	if now.Before(h.nextReport) {
+		h.suppressed++
+		h.mu.Unlock()
+		return nil
+	}
+	suppressed := h.suppressed
+	h.suppressed = 0
+	h.nextReport = now.Add(h.ReportInterval)
+	h.mu.Unlock()
+
+	if suppressed > 0 {
+		return fmt.Errorf("%v (%d more write errors suppressed)", err, suppressed)
+	}
+	return err
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapcore_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/internal/ztest"
+	. "go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeResult scripts the outcome of a single write: at most n bytes are
+// accepted, and err is returned.
+type writeResult struct {
+	n   int
+	err error
+}
+
+// scriptedWriter is a WriteSyncer whose writes follow a script, then
+// succeed.
+type scriptedWriter struct {
+	ztest.Buffer
+	script []writeResult
+	calls  int
+}
+
+func (w *scriptedWriter) Write(bs []byte) (int, error) {
+	w.calls++
+	if len(w.script) == 0 {
+		return w.Buffer.Write(bs)
+	}
+	r := w.script[0]
+	w.script = w.script[1:]
+	if r.n > len(bs) {
+		r.n = len(bs)
+	}
+	w.Buffer.Write(bs[:r.n])
+	return r.n, r.err
+}
+
+func messageEncoder() Encoder {
+	return NewConsoleEncoder(EncoderConfig{MessageKey: "M", LineEnding: DefaultLineEnding})
+}
+
+func writeMessage(core Core, msg string) error {
+	return core.Write(Entry{Level: InfoLevel, Message: msg}, nil)
+}
+
+func TestWriteErrorPolicyShortWrites(t *testing.T) {
+	tests := []struct {
+		desc   string
+		policy WriteErrorPolicy
+		want   string
+		err    error
+	}{
+		{
+			desc: "reported",
+			want: "hel",
+			err:  io.ErrShortWrite,
+		},
+		{
+			desc:   "completed",
+			policy: WriteErrorPolicy{RetryShortWrites: true},
+			want:   "hello\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ws := &scriptedWriter{script: []writeResult{{n: 3}, {n: 1}}}
+			core := NewCoreWithErrorPolicy(messageEncoder(), ws, DebugLevel, tt.policy)
+			assert.Equal(t, tt.err, writeMessage(core, "hello"), "Unexpected error.")
+			assert.Equal(t, tt.want, ws.String(), "Unexpected output.")
+		})
+	}
+
+	ws := &scriptedWriter{script: []writeResult{{n: 0}}}
+	core := NewCoreWithErrorPolicy(messageEncoder(), ws, DebugLevel, WriteErrorPolicy{RetryShortWrites: true})
+	assert.Equal(t, io.ErrShortWrite, writeMessage(core, "hello"), "Expected writes that make no progress to fail.")
+}
+
+func TestWriteErrorPolicyRetries(t *testing.T) {
+	fail := errors.New("fail")
+	ws := &scriptedWriter{script: []writeResult{{n: 0, err: fail}, {n: 2, err: fail}, {n: 0, err: fail}}}
+	core := NewCoreWithErrorPolicy(messageEncoder(), ws, DebugLevel, WriteErrorPolicy{
+		Retries: 3,
+		Backoff: time.Millisecond,
+	})
+
+	require.NoError(t, writeMessage(core, "hello"), "Expected write to succeed after retries.")
+	assert.Equal(t, 4, ws.calls, "Unexpected number of writes.")
+	assert.Equal(t, "hello\n", ws.String(), "Expected retries to resume partial writes.")
+
+	ws.script = []writeResult{{err: fail}, {err: fail}, {err: fail}, {err: fail}}
+	ws.Reset()
+	assert.Equal(t, fail, writeMessage(core, "hello"), "Expected error once retries are exhausted.")
+	assert.Empty(t, ws.String(), "Unexpected output.")
+}
+
+func TestWriteErrorPolicyRetryAfterCompleteWrite(t *testing.T) {
+	fail := errors.New("fail")
+	ws := &scriptedWriter{script: []writeResult{{n: 100, err: fail}}}
+	core := NewCoreWithErrorPolicy(messageEncoder(), ws, DebugLevel, WriteErrorPolicy{
+		Retries: 1,
+		Backoff: time.Millisecond,
+	})
+
+	require.NoError(t, writeMessage(core, "hello"), "Expected retry to find nothing left to write.")
+	assert.Equal(t, 1, ws.calls, "Expected the written entry not to be written again.")
+	assert.Equal(t, "hello\n", ws.String(), "Unexpected output.")
+}
+
+func TestWriteErrorPolicyBackoffClock(t *testing.T) {
+	clock := ztest.NewMockClock()
+	ws := &scriptedWriter{script: []writeResult{{err: errors.New("fail")}}}
+	core := NewCoreWithErrorPolicy(messageEncoder(), ws, DebugLevel, WriteErrorPolicy{
+		Retries: 1,
+		Backoff: time.Hour,
+		Clock:   clock,
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- writeMessage(core, "hello") }()
+
+	// The backoff may not have started yet, so advance the clock until the
+	// retry happens.
+	for i := 0; i < 100; i++ {
+		clock.Add(time.Hour)
+		select {
+		case err := <-done:
+			require.NoError(t, err, "Expected write to succeed after a retry.")
+			assert.Equal(t, "hello\n", ws.String(), "Unexpected output.")
+			return
+		case <-time.After(ztest.Timeout(10 * time.Millisecond)):
+		}
+	}
+	t.Fatal("Expected the retry backoff to follow the policy's clock.")
+}
+
+func TestWriteErrorPolicyFallback(t *testing.T) {
+	var hooked []error
+	primary := &scriptedWriter{script: []writeResult{{err: errors.New("primary failed")}}}
+	fallback := &scriptedWriter{}
+	core := NewCoreWithErrorPolicy(messageEncoder(), primary, DebugLevel, WriteErrorPolicy{
+		Fallback: fallback,
+		Hook: func(ent Entry, err error) {
+			assert.Equal(t, "hello", ent.Message, "Unexpected entry passed to hook.")
+			hooked = append(hooked, err)
+		},
+	})
+
+	require.NoError(t, writeMessage(core, "hello"), "Expected entries written to the fallback not to be reported.")
+	assert.Equal(t, "hello\n", fallback.String(), "Expected entry to be written to the fallback.")
+
+	primary.script = []writeResult{{err: errors.New("primary failed")}}
+	fallback.script = []writeResult{{err: errors.New("fallback failed")}}
+	err := writeMessage(core, "hello")
+	assert.EqualError(t, err, "primary failed; fallback write error: fallback failed", "Unexpected error.")
+
+	require.Len(t, hooked, 2, "Expected hook to be called for each failure.")
+	assert.EqualError(t, hooked[0], "primary failed", "Unexpected error passed to hook.")
+	assert.Equal(t, err, hooked[1], "Unexpected error passed to hook.")
+}
+
+func TestWriteErrorPolicyReportInterval(t *testing.T) {
+	clock := ztest.NewMockClock()
+	failures := 0
+	core := NewCoreWithErrorPolicy(messageEncoder(), &ztest.FailWriter{}, DebugLevel, WriteErrorPolicy{
+		ReportInterval: time.Minute,
+		Hook:           func(Entry, error) { failures++ },
+		Clock:          clock,
+	}).With([]Field{makeInt64Field("k", 1)})
+
+	assert.EqualError(t, writeMessage(core, "a"), "failed", "Expected first error to be reported.")
+	assert.NoError(t, writeMessage(core, "b"), "Expected error to be suppressed.")
+	assert.NoError(t, writeMessage(core.With(nil), "c"), "Expected clones to share the report interval.")
+
+	clock.Add(time.Minute)
+	assert.EqualError(t, writeMessage(core, "d"), "failed (2 more write errors suppressed)",
+		"Expected next error to report the number suppressed.")
+	assert.NoError(t, writeMessage(core, "e"), "Expected error to be suppressed.")
+	assert.Equal(t, 5, failures, "Expected hook to see every failure.")
+}
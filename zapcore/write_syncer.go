@@ -46,6 +46,13 @@ func AddSync(w io.Writer) WriteSyncer {
 	}
 }
 
+// levelWriter is implemented by WriteSyncers that treat writes differently
+// depending on the level of the entry being written, like AsyncWriteSyncer.
+// ioCore passes the level of each entry to such WriteSyncers.
+type levelWriter interface {
+	writeLevel(Level, []byte) (int, error)
+}
+
 type lockedWriteSyncer struct {
 	sync.Mutex
 	ws WriteSyncer
@@ -0,0 +1,376 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package zapjournald provides a zapcore.Core that writes structured entries
+// to systemd-journald using its native protocol.
+//
+// Unlike a WriteSyncer writing formatted lines to stderr, the Core sends each
+// entry as a set of journal fields: the message, the syslog priority of the
+// entry's level, the caller, the logger name, the stack trace, and every
+// field attached to the entry. For example,
+//
+//   core := zapjournald.NewCore(zap.InfoLevel, zapjournald.WithIdentifier("myapp"))
+//   logger := zap.New(core, zap.AddCaller())
+//   logger.Info("started", zap.Int("port", 8080))
+//
+// is recorded by the journal with the fields MESSAGE=started, PRIORITY=6,
+// SYSLOG_IDENTIFIER=myapp, CODE_FILE, CODE_LINE, CODE_FUNC, and PORT=8080.
+package zapjournald // import "go.uber.org/zap/zapjournald"
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultSocket is the path of the socket on which systemd-journald accepts
+// entries in its native protocol.
+const DefaultSocket = "/run/systemd/journal/socket"
+
+// maxFieldNameLength is the longest field name accepted by journald.
+const maxFieldNameLength = 64
+
+// reservedPrefix is prepended to the names of fields that would otherwise
+// collide with a reserved journal field.
+const reservedPrefix = "FIELD_"
+
+// reservedFields are the journal fields with a special meaning, either
+// written by the Core from the entry itself or interpreted by journald. See
+// systemd.journal-fields(7).
+var reservedFields = map[string]struct{}{
+	"MESSAGE":            {},
+	"MESSAGE_ID":         {},
+	"PRIORITY":           {},
+	"CODE_FILE":          {},
+	"CODE_LINE":          {},
+	"CODE_FUNC":          {},
+	"ERRNO":              {},
+	"INVOCATION_ID":      {},
+	"USER_INVOCATION_ID": {},
+	"SYSLOG_FACILITY":    {},
+	"SYSLOG_IDENTIFIER":  {},
+	"SYSLOG_PID":         {},
+	"SYSLOG_TIMESTAMP":   {},
+	"SYSLOG_RAW":         {},
+	"DOCUMENTATION":      {},
+	"TID":                {},
+	"UNIT":               {},
+	"USER_UNIT":          {},
+	"LOGGER":             {},
+	"STACKTRACE":         {},
+}
+
+// An Option configures a Core.
+type Option interface {
+	apply(*journal)
+}
+
+type optionFunc func(*journal)
+
+func (f optionFunc) apply(j *journal) {
+	f(j)
+}
+
+// WithSocket configures the Core to send entries to the Unix datagram socket
+// at the given path rather than DefaultSocket.
+func WithSocket(path string) Option {
+	return optionFunc(func(j *journal) {
+		j.socket = path
+	})
+}
+
+// WithIdentifier sets the SYSLOG_IDENTIFIER field of every entry. It defaults
+// to the base name of the running program.
+func WithIdentifier(id string) Option {
+	return optionFunc(func(j *journal) {
+		j.identifier = id
+	})
+}
+
+// journal holds the connection to journald, shared by a Core and all of its
+// children.
+type journal struct {
+	socket     string
+	identifier string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+type core struct {
+	zapcore.LevelEnabler
+
+	j      *journal
+	fields []zapcore.Field
+}
+
+// NewCore creates a Core that sends entries at or above the given level to
+// journald. The socket is dialed lazily on the first write, and redialed after
+// a failed write, so the Core may be built before journald is available.
+//
+// Field keys are converted to valid journal field names: they're upper-cased,
+// characters other than letters, digits, and underscores are replaced with
+// underscores, and leading underscores and digits are removed. Fields of
+// nested objects are named by joining the keys with underscores, and arrays
+// and reflected values are rendered as JSON. Fields whose names are empty
+// after conversion are dropped, and fields whose names would collide with a
+// reserved journal field, such as MESSAGE, PRIORITY, or CODE_FILE, are
+// prefixed with FIELD_, so that they can't override the entry's own metadata.
+//
+// Entries that are too large to send as a single datagram are written to a
+// sealed memory file whose descriptor is passed to journald instead.
+func NewCore(enab zapcore.LevelEnabler, opts ...Option) zapcore.Core {
+	j := &journal{
+		socket:     DefaultSocket,
+		identifier: filepath.Base(os.Args[0]),
+	}
+	for _, opt := range opts {
+		opt.apply(j)
+	}
+	return &core{LevelEnabler: enab, j: j}
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{
+		LevelEnabler: c.LevelEnabler,
+		j:            c.j,
+		fields:       append(c.fields[:len(c.fields):len(c.fields)], fields...),
+	}
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf := bufferpool.Get()
+	defer buf.Free()
+
+	appendField(buf, "MESSAGE", ent.Message)
+	appendField(buf, "PRIORITY", strconv.Itoa(priority(ent.Level)))
+	if c.j.identifier != "" {
+		appendField(buf, "SYSLOG_IDENTIFIER", c.j.identifier)
+	}
+	if ent.LoggerName != "" {
+		appendField(buf, "LOGGER", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		appendField(buf, "CODE_FILE", ent.Caller.File)
+		appendField(buf, "CODE_LINE", strconv.Itoa(ent.Caller.Line))
+		if ent.Caller.Function != "" {
+			appendField(buf, "CODE_FUNC", ent.Caller.Function)
+		}
+	}
+	if ent.Stack != "" {
+		appendField(buf, "STACKTRACE", ent.Stack)
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range c.fields {
+		c.fields[i].AddTo(enc)
+	}
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	appendObject(buf, "", enc.Fields)
+
+	if err := c.j.send(buf.Bytes()); err != nil {
+		return fmt.Errorf("zapjournald: %v", err)
+	}
+	return nil
+}
+
+func (c *core) Sync() error {
+	// Entries are handed to journald as soon as they're written.
+	return nil
+}
+
+// send writes a single entry to journald, falling back to passing a file
+// descriptor if the entry doesn't fit in a datagram.
+func (j *journal) send(payload []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: j.socket, Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		j.conn = conn
+	}
+
+	_, err := j.conn.Write(payload)
+	if err != nil && isTooLarge(err) {
+		err = sendFile(j.conn, payload)
+	}
+	if err != nil {
+		// The socket may have gone away (for example, because journald
+		// restarted), so dial again on the next write.
+		j.conn.Close()
+		j.conn = nil
+	}
+	return err
+}
+
+// appendField appends a field to buf in the journal's native format. Values
+// containing newlines use the length-prefixed binary form.
+func appendField(buf *buffer.Buffer, name, value string) {
+	buf.AppendString(name)
+	if !containsNewline(value) {
+		buf.AppendByte('=')
+		buf.AppendString(value)
+		buf.AppendByte('\n')
+		return
+	}
+
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.AppendByte('\n')
+	buf.Write(size[:])
+	buf.AppendString(value)
+	buf.AppendByte('\n')
+}
+
+// appendObject appends the fields collected by a MapObjectEncoder, flattening
+// nested objects into underscore-separated names.
+func appendObject(buf *buffer.Buffer, prefix string, m map[string]interface{}) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := fieldName(k)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if v, ok := m[k].(map[string]interface{}); ok {
+			appendObject(buf, name, v)
+			continue
+		}
+		if name = trimFieldName(name); name == "" {
+			continue
+		}
+		if _, ok := reservedFields[name]; ok {
+			name = reservedPrefix + name
+		}
+		appendField(buf, name, formatValue(m[k]))
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32,
+		uint64, uintptr, float32, float64, complex64, complex128:
+		return fmt.Sprint(v)
+	}
+	// Arrays and reflected values.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// fieldName converts a zap field key into the characters allowed in journal
+// field names.
+func fieldName(key string) string {
+	b := make([]byte, len(key))
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			b[i] = c
+		case 'a' <= c && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// trimFieldName removes the leading underscores and digits that journald
+// reserves or rejects, and truncates the name to the maximum length.
+func trimFieldName(name string) string {
+	i := 0
+	for i < len(name) && (name[i] == '_' || ('0' <= name[i] && name[i] <= '9')) {
+		i++
+	}
+	name = name[i:]
+	if len(name) > maxFieldNameLength {
+		name = name[:maxFieldNameLength]
+	}
+	return name
+}
+
+func containsNewline(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+// priority maps zap levels to syslog severities, which journald uses as
+// priorities.
+func priority(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	case zapcore.FatalLevel:
+		return 0
+	default:
+		return 6
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapjournald
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+// listen starts a unixgram listener standing in for journald.
+func listen(t *testing.T) (*net.UnixConn, string) {
+	path := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err, "Failed to listen on Unix datagram socket.")
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+// parseEntry decodes an entry in the journal's native format.
+func parseEntry(t *testing.T, b []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(b) > 0 {
+		i := strings.IndexAny(string(b), "=\n")
+		require.True(t, i > 0, "Malformed entry: %q", b)
+		name := string(b[:i])
+		_, dup := fields[name]
+		require.False(t, dup, "Duplicate field %v.", name)
+		if b[i] == '=' {
+			b = b[i+1:]
+			end := strings.IndexByte(string(b), '\n')
+			require.True(t, end >= 0, "Unterminated field %v.", name)
+			fields[name] = string(b[:end])
+			b = b[end+1:]
+			continue
+		}
+		b = b[i+1:]
+		require.True(t, len(b) >= 8, "Missing length of field %v.", name)
+		n := int(binary.LittleEndian.Uint64(b))
+		b = b[8:]
+		require.True(t, len(b) > n && b[n] == '\n', "Malformed binary field %v.", name)
+		fields[name] = string(b[:n])
+		b = b[n+1:]
+	}
+	return fields
+}
+
+func readEntry(t *testing.T, conn *net.UnixConn) map[string]string {
+	buf := make([]byte, 64*1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err, "Failed to read entry.")
+	return parseEntry(t, buf[:n])
+}
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func (u user) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", u.Name)
+	enc.AddInt("age", u.Age)
+	return nil
+}
+
+func TestCoreWrite(t *testing.T) {
+	conn, path := listen(t)
+	core := NewCore(zapcore.InfoLevel, WithSocket(path), WithIdentifier("myapp")).
+		With([]zapcore.Field{{Key: "request-id", Type: zapcore.StringType, String: "abc"}})
+
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		LoggerName: "server",
+		Message:    "slow request",
+		Caller: zapcore.EntryCaller{
+			Defined:  true,
+			File:     "/src/server.go",
+			Line:     42,
+			Function: "main.serve",
+		},
+		Stack: "main.serve\n\t/src/server.go:42",
+	}
+	fields := []zapcore.Field{
+		{Key: "latency", Type: zapcore.DurationType, Integer: int64(1500 * time.Millisecond)},
+		{Key: "user", Type: zapcore.ObjectMarshalerType, Interface: user{Name: "jane", Age: 42}},
+		{Key: "_private", Type: zapcore.BoolType, Integer: 1},
+		{Key: "2xx", Type: zapcore.Int64Type, Integer: 3},
+		{Key: "body", Type: zapcore.StringType, String: "line one\nline two"},
+		{Key: "tags", Type: zapcore.ReflectType, Interface: []string{"a", "b"}},
+		{Key: "___", Type: zapcore.StringType, String: "dropped"},
+	}
+
+	ce := core.Check(ent, nil)
+	require.NotNil(t, ce, "Expected warn entry to be enabled.")
+	ce.Write(fields...)
+
+	assert.Equal(t, map[string]string{
+		"MESSAGE":           "slow request",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "myapp",
+		"LOGGER":            "server",
+		"CODE_FILE":         "/src/server.go",
+		"CODE_LINE":         "42",
+		"CODE_FUNC":         "main.serve",
+		"STACKTRACE":        "main.serve\n\t/src/server.go:42",
+		"REQUEST_ID":        "abc",
+		"LATENCY":           "1.5s",
+		"USER_NAME":         "jane",
+		"USER_AGE":          "42",
+		"PRIVATE":           "true",
+		"XX":                "3",
+		"BODY":              "line one\nline two",
+		"TAGS":              `["a","b"]`,
+	}, readEntry(t, conn), "Unexpected journal fields.")
+}
+
+func TestCoreReservedFields(t *testing.T) {
+	conn, path := listen(t)
+	core := NewCore(zapcore.InfoLevel, WithSocket(path), WithIdentifier("myapp")).
+		With([]zapcore.Field{{Key: "syslog_identifier", Type: zapcore.StringType, String: "other"}})
+
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Message: "failed",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "/src/server.go", Line: 42},
+	}
+	fields := []zapcore.Field{
+		{Key: "message", Type: zapcore.StringType, String: "user message"},
+		{Key: "priority", Type: zapcore.Int64Type, Integer: 7},
+		{Key: "_code_line", Type: zapcore.Int64Type, Integer: 1},
+		{Key: "code", Type: zapcore.ObjectMarshalerType, Interface: zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("file", "other.go")
+			return nil
+		})},
+		{Key: "message_text", Type: zapcore.StringType, String: "kept"},
+	}
+	require.NoError(t, core.Write(ent, fields), "Unexpected error writing entry.")
+
+	assert.Equal(t, map[string]string{
+		"MESSAGE":                 "failed",
+		"PRIORITY":                "3",
+		"SYSLOG_IDENTIFIER":       "myapp",
+		"CODE_FILE":               "/src/server.go",
+		"CODE_LINE":               "42",
+		"FIELD_SYSLOG_IDENTIFIER": "other",
+		"FIELD_MESSAGE":           "user message",
+		"FIELD_PRIORITY":          "7",
+		"FIELD_CODE_LINE":         "1",
+		"FIELD_CODE_FILE":         "other.go",
+		"MESSAGE_TEXT":            "kept",
+	}, readEntry(t, conn), "Expected fields colliding with reserved names to be prefixed.")
+}
+
+func TestCoreLevels(t *testing.T) {
+	conn, path := listen(t)
+	core := NewCore(zapcore.DebugLevel, WithSocket(path))
+
+	tests := []struct {
+		level    zapcore.Level
+		priority string
+	}{
+		{zapcore.DebugLevel, "7"},
+		{zapcore.InfoLevel, "6"},
+		{zapcore.WarnLevel, "4"},
+		{zapcore.ErrorLevel, "3"},
+		{zapcore.DPanicLevel, "2"},
+		{zapcore.PanicLevel, "1"},
+		{zapcore.FatalLevel, "0"},
+	}
+	for _, tt := range tests {
+		require.NoError(t, core.Write(zapcore.Entry{Level: tt.level, Message: "msg"}, nil))
+		fields := readEntry(t, conn)
+		assert.Equal(t, tt.priority, fields["PRIORITY"], "Unexpected priority for %v.", tt.level)
+		assert.NotEmpty(t, fields["SYSLOG_IDENTIFIER"], "Expected default identifier.")
+	}
+
+	assert.Nil(t, NewCore(zapcore.InfoLevel, WithSocket(path)).Check(zapcore.Entry{Level: zapcore.DebugLevel}, nil),
+		"Expected disabled entry to be skipped.")
+}
+
+func TestCoreRedial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	core := NewCore(zapcore.InfoLevel, WithSocket(path))
+
+	err := core.Write(zapcore.Entry{Message: "lost"}, nil)
+	require.Error(t, err, "Expected error writing without journald.")
+	assert.True(t, strings.HasPrefix(err.Error(), "zapjournald: "), "Unexpected error: %v", err)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err, "Failed to listen on Unix datagram socket.")
+	defer conn.Close()
+
+	require.NoError(t, core.Write(zapcore.Entry{Message: "found"}, nil), "Expected write to succeed once journald is up.")
+	assert.Equal(t, "found", readEntry(t, conn)["MESSAGE"], "Unexpected message.")
+}
+
+func TestFieldNames(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"simple", "SIMPLE"},
+		{"camelCase", "CAMELCASE"},
+		{"with.dots-and spaces", "WITH_DOTS_AND_SPACES"},
+		{"_leading", "LEADING"},
+		{"1st", "ST"},
+		{"ünïcode", "N__CODE"},
+		{strings.Repeat("a", 70), strings.Repeat("A", 64)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, trimFieldName(fieldName(tt.key)), "Unexpected field name for %q.", tt.key)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Equal(t, "2022-01-02T03:04:05.000000006Z", formatValue(time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC)))
+	assert.Equal(t, "raw", formatValue([]byte("raw")))
+	assert.Equal(t, "-12", formatValue(int64(-12)))
+	assert.Equal(t, "1.5", formatValue(1.5))
+	assert.Equal(t, `{"k":"v"}`, formatValue(map[string]string{"k": "v"}))
+	assert.Equal(t, "(1+2i)", formatValue(complex(1, 2)))
+
+	bad := errors.New("unmarshalable")
+	assert.Equal(t, "unmarshalable", formatValue(failMarshal{bad}), "Expected fallback to fmt for values that can't be marshaled.")
+}
+
+type failMarshal struct{ err error }
+
+func (f failMarshal) MarshalJSON() ([]byte, error) { return nil, f.err }
+func (f failMarshal) String() string               { return f.err.Error() }
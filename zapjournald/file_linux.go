@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package zapjournald
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Constants for memfd_create(2) and fcntl(2) file sealing, which aren't
+// available in the syscall package.
+const (
+	_mfdCloexec      = 0x1
+	_mfdAllowSealing = 0x2
+
+	_fAddSeals   = 1033
+	_fSealSeal   = 0x1
+	_fSealShrink = 0x2
+	_fSealGrow   = 0x4
+	_fSealWrite  = 0x8
+)
+
+func isTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// sendFile writes payload to a memory file and passes its descriptor to
+// journald in an otherwise empty datagram.
+func sendFile(conn *net.UnixConn, payload []byte) error {
+	f, err := tempFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(payload); err != nil {
+		return err
+	}
+	seal(f)
+
+	// WriteMsgUnix refuses connected datagram sockets, so call sendmsg(2)
+	// directly.
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(f.Fd()))
+	var sendErr error
+	if err := raw.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return sendErr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// tempFile creates an anonymous file to hold a large entry. It prefers a
+// memfd, which journald accepts from any sender once sealed, and otherwise
+// falls back to an unlinked file in /dev/shm.
+func tempFile() (*os.File, error) {
+	if _sysMemfdCreate != 0 {
+		name, err := syscall.BytePtrFromString("zapjournald")
+		if err != nil {
+			return nil, err
+		}
+		fd, _, errno := syscall.Syscall(_sysMemfdCreate, uintptr(unsafe.Pointer(name)), _mfdCloexec|_mfdAllowSealing, 0)
+		if errno == 0 {
+			return os.NewFile(fd, "zapjournald"), nil
+		}
+	}
+
+	f, err := os.CreateTemp("/dev/shm", "zapjournald-")
+	if err != nil {
+		return nil, fmt.Errorf("can't create file for large entry: %v", err)
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// seal prevents further modification of a memfd. Errors are ignored, since
+// unsealed files are still accepted from privileged senders.
+func seal(f *os.File) {
+	syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), _fAddSeals, _fSealSeal|_fSealShrink|_fSealGrow|_fSealWrite)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package zapjournald
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCoreLargeEntry(t *testing.T) {
+	conn, path := listen(t)
+	core := NewCore(zapcore.InfoLevel, WithSocket(path))
+
+	msg := strings.Repeat("x", 4<<20)
+	require.NoError(t, core.Write(zapcore.Entry{Message: msg}, nil), "Unexpected error writing large entry.")
+
+	buf := make([]byte, 16)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	require.NoError(t, err, "Failed to read datagram.")
+	assert.Zero(t, n, "Expected empty datagram carrying a file descriptor.")
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err, "Failed to parse control message.")
+	require.Len(t, msgs, 1, "Expected a single control message.")
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	require.NoError(t, err, "Failed to parse file descriptors.")
+	require.Len(t, fds, 1, "Expected a single file descriptor.")
+
+	f := os.NewFile(uintptr(fds[0]), "entry")
+	defer f.Close()
+	payload, err := io.ReadAll(io.NewSectionReader(f, 0, 8<<20))
+	require.NoError(t, err, "Failed to read passed file.")
+
+	fields := parseEntry(t, payload)
+	assert.Equal(t, msg, fields["MESSAGE"], "Unexpected message in passed file.")
+	assert.Equal(t, "6", fields["PRIORITY"], "Unexpected priority in passed file.")
+}
+
+func TestTempFile(t *testing.T) {
+	f, err := tempFile()
+	require.NoError(t, err, "Failed to create temporary file.")
+	defer f.Close()
+
+	_, err = f.Write([]byte("sealed"))
+	require.NoError(t, err, "Failed to write temporary file.")
+	seal(f)
+
+	if _sysMemfdCreate != 0 {
+		_, err = f.Write([]byte("more"))
+		assert.Error(t, err, "Expected sealed memfd to reject writes.")
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapotlp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const _hex = "0123456789abcdef"
+
+// attrEncoder is an ObjectEncoder and ArrayEncoder that writes fields as the
+// OTLP/JSON encoding of a list of KeyValues, and array elements as AnyValues.
+// Nested objects and namespaces become kvlistValues.
+type attrEncoder struct {
+	buf            *buffer.Buffer
+	openNamespaces int
+}
+
+var (
+	_ zapcore.ObjectEncoder = (*attrEncoder)(nil)
+	_ zapcore.ArrayEncoder  = (*attrEncoder)(nil)
+)
+
+// addSeparator adds a comma unless the next element is the first of a list or
+// the value of a KeyValue, or the buffer is empty.
+func (enc *attrEncoder) addSeparator() {
+	n := enc.buf.Len()
+	if n == 0 {
+		return
+	}
+	switch enc.buf.Bytes()[n-1] {
+	case '[', ':':
+	default:
+		enc.buf.AppendByte(',')
+	}
+}
+
+// beginValue starts a KeyValue, leaving the AnyValue to be written.
+func (enc *attrEncoder) beginValue(key string) {
+	enc.addSeparator()
+	enc.buf.AppendString(`{"key":`)
+	appendString(enc.buf, key)
+	enc.buf.AppendString(`,"value":`)
+}
+
+// closeNamespaces ends the kvlistValues of any open namespaces.
+func (enc *attrEncoder) closeNamespaces() {
+	for i := 0; i < enc.openNamespaces; i++ {
+		enc.buf.AppendString("]}}}")
+	}
+	enc.openNamespaces = 0
+}
+
+func (enc *attrEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	enc.beginValue(key)
+	err := enc.AppendArray(arr)
+	enc.buf.AppendByte('}')
+	return err
+}
+
+func (enc *attrEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	enc.beginValue(key)
+	err := enc.AppendObject(obj)
+	enc.buf.AppendByte('}')
+	return err
+}
+
+func (enc *attrEncoder) AddBinary(key string, val []byte) {
+	enc.beginValue(key)
+	enc.appendBinary(val)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddByteString(key string, val []byte) {
+	enc.AddString(key, string(val))
+}
+
+func (enc *attrEncoder) AddBool(key string, val bool) {
+	enc.beginValue(key)
+	enc.appendBool(val)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddComplex128(key string, val complex128) {
+	enc.AddString(key, strconv.FormatComplex(val, 'g', -1, 128))
+}
+
+func (enc *attrEncoder) AddComplex64(key string, val complex64) {
+	enc.AddString(key, strconv.FormatComplex(complex128(val), 'g', -1, 64))
+}
+
+func (enc *attrEncoder) AddDuration(key string, val time.Duration) {
+	enc.AddInt64(key, int64(val))
+}
+
+func (enc *attrEncoder) AddFloat64(key string, val float64) {
+	enc.beginValue(key)
+	enc.appendFloat(val, 64)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddFloat32(key string, val float32) {
+	enc.beginValue(key)
+	enc.appendFloat(float64(val), 32)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddInt64(key string, val int64) {
+	enc.beginValue(key)
+	enc.appendInt(val)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddString(key, val string) {
+	enc.beginValue(key)
+	enc.appendString(val)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddTime(key string, val time.Time) {
+	enc.AddString(key, val.Format(time.RFC3339Nano))
+}
+
+func (enc *attrEncoder) AddUint64(key string, val uint64) {
+	enc.beginValue(key)
+	enc.appendUint(val)
+	enc.buf.AppendByte('}')
+}
+
+func (enc *attrEncoder) AddReflected(key string, obj interface{}) error {
+	v, err := decodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.beginValue(key)
+	enc.appendJSONValue(v)
+	enc.buf.AppendByte('}')
+	return nil
+}
+
+func (enc *attrEncoder) OpenNamespace(key string) {
+	enc.beginValue(key)
+	enc.buf.AppendString(`{"kvlistValue":{"values":[`)
+	enc.openNamespaces++
+}
+
+func (enc *attrEncoder) AddInt(k string, v int)         { enc.AddInt64(k, int64(v)) }
+func (enc *attrEncoder) AddInt32(k string, v int32)     { enc.AddInt64(k, int64(v)) }
+func (enc *attrEncoder) AddInt16(k string, v int16)     { enc.AddInt64(k, int64(v)) }
+func (enc *attrEncoder) AddInt8(k string, v int8)       { enc.AddInt64(k, int64(v)) }
+func (enc *attrEncoder) AddUint(k string, v uint)       { enc.AddUint64(k, uint64(v)) }
+func (enc *attrEncoder) AddUint32(k string, v uint32)   { enc.AddUint64(k, uint64(v)) }
+func (enc *attrEncoder) AddUint16(k string, v uint16)   { enc.AddUint64(k, uint64(v)) }
+func (enc *attrEncoder) AddUint8(k string, v uint8)     { enc.AddUint64(k, uint64(v)) }
+func (enc *attrEncoder) AddUintptr(k string, v uintptr) { enc.AddUint64(k, uint64(v)) }
+
+func (enc *attrEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	enc.addSeparator()
+	enc.buf.AppendString(`{"arrayValue":{"values":[`)
+	err := arr.MarshalLogArray(enc)
+	enc.buf.AppendString("]}}")
+	return err
+}
+
+func (enc *attrEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	// Namespaces opened by the object are closed with it.
+	old := enc.openNamespaces
+	enc.openNamespaces = 0
+	enc.addSeparator()
+	enc.buf.AppendString(`{"kvlistValue":{"values":[`)
+	err := obj.MarshalLogObject(enc)
+	enc.closeNamespaces()
+	enc.buf.AppendString("]}}")
+	enc.openNamespaces = old
+	return err
+}
+
+func (enc *attrEncoder) AppendBool(val bool) {
+	enc.addSeparator()
+	enc.appendBool(val)
+}
+
+func (enc *attrEncoder) AppendByteString(val []byte) {
+	enc.AppendString(string(val))
+}
+
+func (enc *attrEncoder) AppendComplex128(val complex128) {
+	enc.AppendString(strconv.FormatComplex(val, 'g', -1, 128))
+}
+
+func (enc *attrEncoder) AppendComplex64(val complex64) {
+	enc.AppendString(strconv.FormatComplex(complex128(val), 'g', -1, 64))
+}
+
+func (enc *attrEncoder) AppendDuration(val time.Duration) {
+	enc.AppendInt64(int64(val))
+}
+
+func (enc *attrEncoder) AppendFloat64(val float64) {
+	enc.addSeparator()
+	enc.appendFloat(val, 64)
+}
+
+func (enc *attrEncoder) AppendFloat32(val float32) {
+	enc.addSeparator()
+	enc.appendFloat(float64(val), 32)
+}
+
+func (enc *attrEncoder) AppendInt64(val int64) {
+	enc.addSeparator()
+	enc.appendInt(val)
+}
+
+func (enc *attrEncoder) AppendString(val string) {
+	enc.addSeparator()
+	enc.appendString(val)
+}
+
+func (enc *attrEncoder) AppendTime(val time.Time) {
+	enc.AppendString(val.Format(time.RFC3339Nano))
+}
+
+func (enc *attrEncoder) AppendUint64(val uint64) {
+	enc.addSeparator()
+	enc.appendUint(val)
+}
+
+func (enc *attrEncoder) AppendReflected(obj interface{}) error {
+	v, err := decodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.addSeparator()
+	enc.appendJSONValue(v)
+	return nil
+}
+
+func (enc *attrEncoder) AppendInt(v int)         { enc.AppendInt64(int64(v)) }
+func (enc *attrEncoder) AppendInt32(v int32)     { enc.AppendInt64(int64(v)) }
+func (enc *attrEncoder) AppendInt16(v int16)     { enc.AppendInt64(int64(v)) }
+func (enc *attrEncoder) AppendInt8(v int8)       { enc.AppendInt64(int64(v)) }
+func (enc *attrEncoder) AppendUint(v uint)       { enc.AppendUint64(uint64(v)) }
+func (enc *attrEncoder) AppendUint32(v uint32)   { enc.AppendUint64(uint64(v)) }
+func (enc *attrEncoder) AppendUint16(v uint16)   { enc.AppendUint64(uint64(v)) }
+func (enc *attrEncoder) AppendUint8(v uint8)     { enc.AppendUint64(uint64(v)) }
+func (enc *attrEncoder) AppendUintptr(v uintptr) { enc.AppendUint64(uint64(v)) }
+
+// The append* methods below write a bare AnyValue.
+
+func (enc *attrEncoder) appendBinary(val []byte) {
+	enc.buf.AppendString(`{"bytesValue":"`)
+	enc.buf.AppendString(base64.StdEncoding.EncodeToString(val))
+	enc.buf.AppendString(`"}`)
+}
+
+func (enc *attrEncoder) appendBool(val bool) {
+	enc.buf.AppendString(`{"boolValue":`)
+	enc.buf.AppendBool(val)
+	enc.buf.AppendByte('}')
+}
+
+// appendFloat writes a doubleValue, using the names that the protobuf JSON
+// mapping gives to NaN and infinities.
+func (enc *attrEncoder) appendFloat(val float64, bitSize int) {
+	enc.buf.AppendString(`{"doubleValue":`)
+	switch {
+	case math.IsNaN(val):
+		enc.buf.AppendString(`"NaN"`)
+	case math.IsInf(val, 1):
+		enc.buf.AppendString(`"Infinity"`)
+	case math.IsInf(val, -1):
+		enc.buf.AppendString(`"-Infinity"`)
+	default:
+		enc.buf.AppendFloat(val, bitSize)
+	}
+	enc.buf.AppendByte('}')
+}
+
+// appendInt writes an intValue, which the protobuf JSON mapping represents as
+// a string, since it's a 64-bit integer.
+func (enc *attrEncoder) appendInt(val int64) {
+	enc.buf.AppendString(`{"intValue":"`)
+	enc.buf.AppendInt(val)
+	enc.buf.AppendString(`"}`)
+}
+
+// appendUint writes an intValue, or a stringValue if the value overflows a
+// signed 64-bit integer.
+func (enc *attrEncoder) appendUint(val uint64) {
+	if val > math.MaxInt64 {
+		enc.buf.AppendString(`{"stringValue":"`)
+		enc.buf.AppendUint(val)
+		enc.buf.AppendString(`"}`)
+		return
+	}
+	enc.appendInt(int64(val))
+}
+
+func (enc *attrEncoder) appendString(val string) {
+	enc.buf.AppendString(`{"stringValue":`)
+	appendString(enc.buf, val)
+	enc.buf.AppendByte('}')
+}
+
+// decodeReflected encodes a value with encoding/json and decodes the result,
+// so that it can be converted to an AnyValue.
+func decodeReflected(obj interface{}) (interface{}, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	return v, err
+}
+
+// appendJSONValue writes a value decoded by encoding/json. Objects become
+// kvlistValues with sorted keys, and null becomes an empty AnyValue.
+func (enc *attrEncoder) appendJSONValue(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		enc.buf.AppendString("{}")
+	case bool:
+		enc.appendBool(v)
+	case string:
+		enc.appendString(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			enc.appendInt(i)
+		} else if f, err := v.Float64(); err == nil {
+			enc.appendFloat(f, 64)
+		} else {
+			enc.appendString(v.String())
+		}
+	case []interface{}:
+		enc.buf.AppendString(`{"arrayValue":{"values":[`)
+		for i, elem := range v {
+			if i > 0 {
+				enc.buf.AppendByte(',')
+			}
+			enc.appendJSONValue(elem)
+		}
+		enc.buf.AppendString("]}}")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		enc.buf.AppendString(`{"kvlistValue":{"values":[`)
+		for i, k := range keys {
+			if i > 0 {
+				enc.buf.AppendByte(',')
+			}
+			enc.buf.AppendString(`{"key":`)
+			appendString(enc.buf, k)
+			enc.buf.AppendString(`,"value":`)
+			enc.appendJSONValue(v[k])
+			enc.buf.AppendByte('}')
+		}
+		enc.buf.AppendString("]}}")
+	}
+}
+
+// appendString appends s to buf as a quoted JSON string, replacing invalid
+// UTF-8 with the replacement character.
+func appendString(buf *buffer.Buffer, s string) {
+	buf.AppendByte('"')
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			switch {
+			case b == '"' || b == '\\':
+				buf.AppendByte('\\')
+				buf.AppendByte(b)
+			case b == '\n':
+				buf.AppendString(`\n`)
+			case b == '\r':
+				buf.AppendString(`\r`)
+			case b == '\t':
+				buf.AppendString(`\t`)
+			case b < 0x20:
+				buf.AppendString(`\u00`)
+				buf.AppendByte(_hex[b>>4])
+				buf.AppendByte(_hex[b&0xF])
+			default:
+				buf.AppendByte(b)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.AppendString(`\ufffd`)
+		} else {
+			buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.AppendByte('"')
+}
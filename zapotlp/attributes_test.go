@@ -0,0 +1,183 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapotlp
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/internal/bufferpool"
+	"go.uber.org/zap/zapcore"
+)
+
+type testObject struct {
+	name  string
+	count int
+}
+
+func (o testObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", o.name)
+	enc.AddInt("count", o.count)
+	return nil
+}
+
+// encodeAttrs encodes the fields as a list of KeyValues.
+func encodeAttrs(fields ...zapcore.Field) string {
+	buf := bufferpool.Get()
+	defer buf.Free()
+
+	buf.AppendByte('[')
+	enc := &attrEncoder{buf: buf}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	enc.closeNamespaces()
+	buf.AppendByte(']')
+	return buf.String()
+}
+
+func TestAttrEncoder(t *testing.T) {
+	tests := []struct {
+		desc  string
+		field zapcore.Field
+		want  string
+	}{
+		{
+			desc:  "string",
+			field: zapcore.Field{Key: "k", Type: zapcore.StringType, String: "a \"quoted\"\n\x01string \xff"},
+			want:  `{"key":"k","value":{"stringValue":"a \"quoted\"\n\u0001string \ufffd"}}`,
+		},
+		{
+			desc:  "bool",
+			field: zapcore.Field{Key: "k", Type: zapcore.BoolType, Integer: 1},
+			want:  `{"key":"k","value":{"boolValue":true}}`,
+		},
+		{
+			desc:  "int",
+			field: zapcore.Field{Key: "k", Type: zapcore.Int64Type, Integer: -42},
+			want:  `{"key":"k","value":{"intValue":"-42"}}`,
+		},
+		{
+			desc:  "large uint",
+			field: zapcore.Field{Key: "k", Type: zapcore.Uint64Type, Integer: -1},
+			want:  `{"key":"k","value":{"stringValue":"18446744073709551615"}}`,
+		},
+		{
+			desc:  "float",
+			field: zapcore.Field{Key: "k", Type: zapcore.Float64Type, Integer: int64(math.Float64bits(1.5))},
+			want:  `{"key":"k","value":{"doubleValue":1.5}}`,
+		},
+		{
+			desc:  "NaN",
+			field: zapcore.Field{Key: "k", Type: zapcore.Float64Type, Integer: int64(math.Float64bits(math.NaN()))},
+			want:  `{"key":"k","value":{"doubleValue":"NaN"}}`,
+		},
+		{
+			desc:  "binary",
+			field: zapcore.Field{Key: "k", Type: zapcore.BinaryType, Interface: []byte("hi")},
+			want:  `{"key":"k","value":{"bytesValue":"aGk="}}`,
+		},
+		{
+			desc:  "duration",
+			field: zapcore.Field{Key: "k", Type: zapcore.DurationType, Integer: int64(time.Second)},
+			want:  `{"key":"k","value":{"intValue":"1000000000"}}`,
+		},
+		{
+			desc:  "time",
+			field: zapcore.Field{Key: "k", Type: zapcore.TimeFullType, Interface: time.Date(2022, 3, 4, 5, 6, 7, 8, time.UTC)},
+			want:  `{"key":"k","value":{"stringValue":"2022-03-04T05:06:07.000000008Z"}}`,
+		},
+		{
+			desc:  "complex",
+			field: zapcore.Field{Key: "k", Type: zapcore.Complex128Type, Interface: complex(1, -2)},
+			want:  `{"key":"k","value":{"stringValue":"(1-2i)"}}`,
+		},
+		{
+			desc:  "object",
+			field: zapcore.Field{Key: "k", Type: zapcore.ObjectMarshalerType, Interface: testObject{"a", 2}},
+			want: `{"key":"k","value":{"kvlistValue":{"values":[` +
+				`{"key":"name","value":{"stringValue":"a"}},{"key":"count","value":{"intValue":"2"}}]}}}`,
+		},
+		{
+			desc: "array",
+			field: zapcore.Field{Key: "k", Type: zapcore.ArrayMarshalerType, Interface: zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+				arr.AppendString("a")
+				arr.AppendBool(false)
+				arr.AppendObject(testObject{"b", 1})
+				return arr.AppendArray(zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+					arr.AppendFloat64(2)
+					return nil
+				}))
+			})},
+			want: `{"key":"k","value":{"arrayValue":{"values":[{"stringValue":"a"},{"boolValue":false},` +
+				`{"kvlistValue":{"values":[{"key":"name","value":{"stringValue":"b"}},{"key":"count","value":{"intValue":"1"}}]}},` +
+				`{"arrayValue":{"values":[{"doubleValue":2}]}}]}}}`,
+		},
+		{
+			desc:  "reflected",
+			field: zapcore.Field{Key: "k", Type: zapcore.ReflectType, Interface: map[string]interface{}{"b": []int{1}, "a": 1.5, "c": nil}},
+			want: `{"key":"k","value":{"kvlistValue":{"values":[{"key":"a","value":{"doubleValue":1.5}},` +
+				`{"key":"b","value":{"arrayValue":{"values":[{"intValue":"1"}]}}},{"key":"c","value":{}}]}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := encodeAttrs(tt.field)
+			assert.Equal(t, "["+tt.want+"]", got, "Unexpected attributes.")
+			assert.True(t, json.Valid([]byte(got)), "Expected valid JSON.")
+		})
+	}
+}
+
+func TestAttrEncoderNamespaces(t *testing.T) {
+	got := encodeAttrs(
+		zapcore.Field{Key: "a", Type: zapcore.StringType, String: "1"},
+		zapcore.Field{Key: "ns", Type: zapcore.NamespaceType},
+		zapcore.Field{Key: "b", Type: zapcore.StringType, String: "2"},
+		zapcore.Field{Key: "obj", Type: zapcore.ObjectMarshalerType, Interface: zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.OpenNamespace("inner")
+			enc.AddString("c", "3")
+			return nil
+		})},
+		zapcore.Field{Key: "d", Type: zapcore.StringType, String: "4"},
+	)
+	assert.Equal(t, `[{"key":"a","value":{"stringValue":"1"}},{"key":"ns","value":{"kvlistValue":{"values":[`+
+		`{"key":"b","value":{"stringValue":"2"}},`+
+		`{"key":"obj","value":{"kvlistValue":{"values":[{"key":"inner","value":{"kvlistValue":{"values":[{"key":"c","value":{"stringValue":"3"}}]}}}]}}},`+
+		`{"key":"d","value":{"stringValue":"4"}}]}}}]`, got, "Unexpected attributes.")
+}
+
+func TestAttrEncoderErrors(t *testing.T) {
+	got := encodeAttrs(
+		zapcore.Field{Key: "ch", Type: zapcore.ReflectType, Interface: make(chan int)},
+		zapcore.Field{Key: "arr", Type: zapcore.ArrayMarshalerType, Interface: zapcore.ArrayMarshalerFunc(func(zapcore.ArrayEncoder) error {
+			return errors.New("fail")
+		})},
+	)
+	assert.True(t, json.Valid([]byte(got)), "Expected valid JSON despite marshaling errors: %s", got)
+	assert.Contains(t, got, `{"key":"chError","value":{"stringValue":"json: unsupported type: chan int"}}`, "Expected reflection error.")
+	assert.Contains(t, got, `{"key":"arrError","value":{"stringValue":"fail"}}`, "Expected array marshaling error.")
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapotlp
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/internal/bufferpool"
+	"go.uber.org/zap/zapcore"
+)
+
+// TraceContext constructs a field that sets the trace and span IDs of the
+// records it's written with, for correlation with OpenTelemetry traces. The
+// IDs of an OpenTelemetry SpanContext can be passed directly:
+//
+//   sc := trace.SpanContextFromContext(ctx)
+//   logger.Info("handled", zapotlp.TraceContext(sc.TraceID(), sc.SpanID()))
+//
+// Cores other than this package's encode the field as an object holding the
+// hex-encoded trace_id and span_id.
+func TraceContext(traceID [16]byte, spanID [8]byte) zapcore.Field {
+	return zapcore.Field{
+		Key:       "trace",
+		Type:      zapcore.ObjectMarshalerType,
+		Interface: traceContext{traceID: traceID, spanID: spanID},
+	}
+}
+
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (tc traceContext) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	var buf [32]byte
+	enc.AddString("trace_id", string(appendHex(buf[:0], tc.traceID[:])))
+	enc.AddString("span_id", string(appendHex(buf[:0], tc.spanID[:])))
+	return nil
+}
+
+func appendHex(dst, src []byte) []byte {
+	for _, b := range src {
+		dst = append(dst, _hex[b>>4], _hex[b&0xF])
+	}
+	return dst
+}
+
+func isZero(id []byte) bool {
+	for _, b := range id {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type core struct {
+	zapcore.LevelEnabler
+
+	exp *Exporter
+
+	// attrs holds the attributes added by With, and openNamespaces the
+	// number of namespaces they leave open.
+	attrs          []byte
+	openNamespaces int
+	trace          *traceContext
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	buf := bufferpool.Get()
+	defer buf.Free()
+
+	buf.Write(c.attrs)
+	enc := &attrEncoder{buf: buf, openNamespaces: c.openNamespaces}
+	trace := c.trace
+	for i := range fields {
+		if tc, ok := fields[i].Interface.(traceContext); ok {
+			trace = &tc
+			continue
+		}
+		addField(enc, fields[i])
+	}
+
+	return &core{
+		LevelEnabler:   c.LevelEnabler,
+		exp:            c.exp,
+		attrs:          append([]byte(nil), buf.Bytes()...),
+		openNamespaces: enc.openNamespaces,
+		trace:          trace,
+	}
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write encodes the entry as an OTLP/JSON LogRecord and adds it to the
+// Exporter's current batch. Entries above ErrorLevel also sync the Exporter,
+// since the process may be about to exit.
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf := bufferpool.Get()
+	defer buf.Free()
+
+	buf.AppendByte('{')
+	if !ent.Time.IsZero() {
+		buf.AppendString(`"timeUnixNano":"`)
+		buf.AppendInt(ent.Time.UnixNano())
+		buf.AppendString(`",`)
+	}
+	buf.AppendString(`"observedTimeUnixNano":"`)
+	buf.AppendInt(c.exp.clock.Now().UnixNano())
+	buf.AppendString(`","severityNumber":`)
+	buf.AppendInt(int64(severity(ent.Level)))
+	buf.AppendString(`,"severityText":`)
+	appendString(buf, ent.Level.CapitalString())
+	buf.AppendString(`,"body":{"stringValue":`)
+	appendString(buf, ent.Message)
+	buf.AppendString(`},"attributes":[`)
+
+	enc := &attrEncoder{buf: buf}
+	if ent.LoggerName != "" {
+		enc.AddString("logger.name", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		enc.AddString("code.filepath", ent.Caller.File)
+		enc.AddInt("code.lineno", ent.Caller.Line)
+		if ent.Caller.Function != "" {
+			enc.AddString("code.function", ent.Caller.Function)
+		}
+	}
+	if ent.Stack != "" {
+		enc.AddString("code.stacktrace", ent.Stack)
+	}
+	if len(c.attrs) > 0 {
+		enc.addSeparator()
+		buf.Write(c.attrs)
+		enc.openNamespaces = c.openNamespaces
+	}
+	trace := c.trace
+	for i := range fields {
+		if tc, ok := fields[i].Interface.(traceContext); ok {
+			trace = &tc
+			continue
+		}
+		addField(enc, fields[i])
+	}
+	enc.closeNamespaces()
+	buf.AppendByte(']')
+
+	if trace != nil {
+		appendID(buf, "traceId", trace.traceID[:])
+		appendID(buf, "spanId", trace.spanID[:])
+	}
+	buf.AppendByte('}')
+
+	if err := c.exp.add(buf.Bytes()); err != nil {
+		return err
+	}
+	if ent.Level > zapcore.ErrorLevel {
+		return c.exp.Sync()
+	}
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.exp.Sync()
+}
+
+// appendID appends a hex-encoded trace or span ID, unless it's invalid.
+func appendID(buf *buffer.Buffer, key string, id []byte) {
+	if isZero(id) {
+		return
+	}
+	var hex [32]byte
+	buf.AppendString(`,"`)
+	buf.AppendString(key)
+	buf.AppendString(`":"`)
+	buf.Write(appendHex(hex[:0], id))
+	buf.AppendByte('"')
+}
+
+// addField adds a field to enc. Errors added with zap.Error are recorded with
+// the exception attributes of the OpenTelemetry semantic conventions.
+func addField(enc *attrEncoder, f zapcore.Field) {
+	if f.Type == zapcore.ErrorType && f.Key == "error" {
+		if err, ok := f.Interface.(error); ok && err != nil {
+			addException(enc, err)
+			return
+		}
+	}
+	f.AddTo(enc)
+}
+
+func addException(enc *attrEncoder, err error) {
+	// Like zap's usual error encoding, guard against errors that panic,
+	// usually because they're nil pointers.
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			msg := fmt.Sprintf("PANIC=%v", rerr)
+			if v := reflect.ValueOf(err); v.Kind() == reflect.Ptr && v.IsNil() {
+				msg = "<nil>"
+			}
+			enc.AddString("exception.message", msg)
+		}
+	}()
+
+	basic := err.Error()
+	enc.AddString("exception.message", basic)
+	enc.AddString("exception.type", reflect.TypeOf(err).String())
+	if f, ok := err.(fmt.Formatter); ok {
+		if verbose := fmt.Sprintf("%+v", f); verbose != basic {
+			enc.AddString("exception.stacktrace", verbose)
+		}
+	}
+}
+
+// severity maps zap levels to OpenTelemetry severity numbers.
+func severity(l zapcore.Level) int {
+	switch {
+	case l < zapcore.DebugLevel:
+		return 1 // TRACE
+	case l == zapcore.DebugLevel:
+		return 5 // DEBUG
+	case l == zapcore.InfoLevel:
+		return 9 // INFO
+	case l == zapcore.WarnLevel:
+		return 13 // WARN
+	case l == zapcore.ErrorLevel:
+		return 17 // ERROR
+	case l == zapcore.DPanicLevel:
+		return 18 // ERROR2
+	case l == zapcore.PanicLevel:
+		return 21 // FATAL
+	default:
+		return 24 // FATAL4
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapotlp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	_testTraceID = [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	_testSpanID  = [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+)
+
+func stringAttr(key, val string) map[string]interface{} {
+	return map[string]interface{}{"key": key, "value": map[string]interface{}{"stringValue": val}}
+}
+
+func intAttr(key, val string) map[string]interface{} {
+	return map[string]interface{}{"key": key, "value": map[string]interface{}{"intValue": val}}
+}
+
+// export writes entries to a new Exporter's Core, after applying With to the
+// Core, and returns the records received by the collector.
+func export(t *testing.T, with []zapcore.Field, ents ...zapcore.Entry) []map[string]interface{} {
+	c := newCollector(t)
+	clock := ztest.NewMockClock()
+	exp := NewExporter(WithEndpoint(c.URL), WithClock(clock))
+	defer exp.Close()
+
+	core := exp.Core(zapcore.InfoLevel)
+	if with != nil {
+		core = core.With(with)
+	}
+	for _, ent := range ents {
+		if ce := core.Check(ent, nil); ce != nil {
+			ce.Write(zapcore.Field{Key: "n", Type: zapcore.Int64Type, Integer: 1})
+		}
+	}
+	require.NoError(t, core.Sync(), "Unexpected error syncing core.")
+	return c.Records()
+}
+
+func TestCoreRecord(t *testing.T) {
+	ts := time.Date(2022, time.March, 4, 5, 6, 7, 8, time.UTC)
+	records := export(t, nil, zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       ts,
+		LoggerName: "app.db",
+		Message:    "slow query",
+		Caller:     zapcore.EntryCaller{Defined: true, File: "/src/db.go", Line: 12, Function: "app/db.Query"},
+		Stack:      "goroutine 1",
+	})
+
+	require.Len(t, records, 1, "Expected one record.")
+	assert.Equal(t, map[string]interface{}{
+		"timeUnixNano":         "1646370367000000008",
+		"observedTimeUnixNano": "0",
+		"severityNumber":       float64(13),
+		"severityText":         "WARN",
+		"body":                 map[string]interface{}{"stringValue": "slow query"},
+		"attributes": []interface{}{
+			stringAttr("logger.name", "app.db"),
+			stringAttr("code.filepath", "/src/db.go"),
+			intAttr("code.lineno", "12"),
+			stringAttr("code.function", "app/db.Query"),
+			stringAttr("code.stacktrace", "goroutine 1"),
+			intAttr("n", "1"),
+		},
+	}, records[0], "Unexpected record.")
+}
+
+func TestCoreLevels(t *testing.T) {
+	records := export(t, nil,
+		zapcore.Entry{Level: zapcore.DebugLevel, Message: "disabled"},
+		zapcore.Entry{Level: zapcore.InfoLevel},
+		zapcore.Entry{Level: zapcore.ErrorLevel},
+		zapcore.Entry{Level: zapcore.DPanicLevel},
+	)
+
+	require.Len(t, records, 3, "Expected disabled levels to be skipped.")
+	for i, want := range []struct {
+		number float64
+		text   string
+	}{
+		{9, "INFO"},
+		{17, "ERROR"},
+		{18, "DPANIC"},
+	} {
+		assert.Equal(t, want.number, records[i]["severityNumber"], "Unexpected severity number.")
+		assert.Equal(t, want.text, records[i]["severityText"], "Unexpected severity text.")
+		assert.NotContains(t, records[i], "timeUnixNano", "Expected zero time to be omitted.")
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  int
+	}{
+		{zapcore.DebugLevel - 1, 1},
+		{zapcore.DebugLevel, 5},
+		{zapcore.InfoLevel, 9},
+		{zapcore.WarnLevel, 13},
+		{zapcore.ErrorLevel, 17},
+		{zapcore.DPanicLevel, 18},
+		{zapcore.PanicLevel, 21},
+		{zapcore.FatalLevel, 24},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, severity(tt.level), "Unexpected severity for %v.", tt.level)
+	}
+}
+
+func TestCoreWith(t *testing.T) {
+	with := []zapcore.Field{
+		{Key: "a", Type: zapcore.StringType, String: "1"},
+		TraceContext(_testTraceID, _testSpanID),
+		{Key: "ns", Type: zapcore.NamespaceType},
+	}
+	records := export(t, with, zapcore.Entry{Level: zapcore.InfoLevel, Message: "m"})
+
+	require.Len(t, records, 1, "Expected one record.")
+	assert.Equal(t, []interface{}{
+		stringAttr("a", "1"),
+		map[string]interface{}{"key": "ns", "value": map[string]interface{}{"kvlistValue": map[string]interface{}{
+			"values": []interface{}{intAttr("n", "1")},
+		}}},
+	}, records[0]["attributes"], "Expected entry fields to be added to the open namespace.")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", records[0]["traceId"], "Unexpected trace ID.")
+	assert.Equal(t, "00f067aa0ba902b7", records[0]["spanId"], "Unexpected span ID.")
+}
+
+func TestCoreWithDoesNotAffectParent(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(WithEndpoint(c.URL))
+	defer exp.Close()
+
+	parent := exp.Core(zapcore.InfoLevel).With([]zapcore.Field{{Key: "p", Type: zapcore.StringType, String: "1"}})
+	child := parent.With([]zapcore.Field{{Key: "c", Type: zapcore.StringType, String: "2"}, TraceContext(_testTraceID, [8]byte{})})
+	require.NoError(t, child.Write(zapcore.Entry{}, nil), "Unexpected error writing entry.")
+	require.NoError(t, parent.Write(zapcore.Entry{}, nil), "Unexpected error writing entry.")
+	require.NoError(t, exp.Sync(), "Unexpected error syncing exporter.")
+
+	records := c.Records()
+	require.Len(t, records, 2, "Expected two records.")
+	assert.Equal(t, []interface{}{stringAttr("p", "1"), stringAttr("c", "2")}, records[0]["attributes"], "Unexpected child attributes.")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", records[0]["traceId"], "Unexpected trace ID.")
+	assert.NotContains(t, records[0], "spanId", "Expected zero span ID to be omitted.")
+	assert.Equal(t, []interface{}{stringAttr("p", "1")}, records[1]["attributes"], "Unexpected parent attributes.")
+	assert.NotContains(t, records[1], "traceId", "Expected parent to have no trace.")
+}
+
+type nilPointerError struct{}
+
+func (*nilPointerError) Error() string { panic("nil") }
+
+func TestCoreErrors(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(WithEndpoint(c.URL))
+	defer exp.Close()
+
+	core := exp.Core(zapcore.InfoLevel)
+	var nilErr *nilPointerError
+	for _, err := range []error{errors.New("eof"), pkgerrors.New("boom"), nilErr} {
+		require.NoError(t, core.Write(zapcore.Entry{}, []zapcore.Field{{Key: "error", Type: zapcore.ErrorType, Interface: err}}), "Unexpected error writing entry.")
+	}
+	require.NoError(t, core.Write(zapcore.Entry{}, []zapcore.Field{{Key: "cause", Type: zapcore.ErrorType, Interface: errors.New("named")}}), "Unexpected error writing entry.")
+	require.NoError(t, exp.Sync(), "Unexpected error syncing exporter.")
+
+	records := c.Records()
+	require.Len(t, records, 4, "Expected four records.")
+	assert.Equal(t, []interface{}{
+		stringAttr("exception.message", "eof"),
+		stringAttr("exception.type", "*errors.errorString"),
+	}, records[0]["attributes"], "Unexpected attributes for error.")
+
+	attrs := records[1]["attributes"].([]interface{})
+	require.Len(t, attrs, 3, "Expected a stack trace for an error from github.com/pkg/errors.")
+	assert.Equal(t, stringAttr("exception.type", "*errors.fundamental"), attrs[1], "Unexpected exception type.")
+	stack := attrs[2].(map[string]interface{})
+	assert.Equal(t, "exception.stacktrace", stack["key"], "Unexpected attribute.")
+	assert.Contains(t, stack["value"].(map[string]interface{})["stringValue"], "TestCoreErrors", "Expected the error's stack trace.")
+
+	assert.Equal(t, []interface{}{stringAttr("exception.message", "<nil>")}, records[2]["attributes"], "Unexpected attributes for nil pointer error.")
+	assert.Equal(t, []interface{}{stringAttr("cause", "named")}, records[3]["attributes"], "Expected named errors to keep their keys.")
+}
+
+func TestCoreSyncsSevereEntries(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(WithEndpoint(c.URL), WithFlushInterval(time.Minute))
+	defer exp.Close()
+
+	core := exp.Core(zapcore.InfoLevel)
+	require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.ErrorLevel}, nil), "Unexpected error writing entry.")
+	assert.Empty(t, c.Requests(), "Expected ErrorLevel entries to be batched.")
+	require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.DPanicLevel}, nil), "Unexpected error writing entry.")
+	assert.Len(t, c.Records(), 2, "Expected entries above ErrorLevel to be delivered before Write returns.")
+}
+
+func TestTraceContextWithOtherCores(t *testing.T) {
+	enc := zapcore.NewMapObjectEncoder()
+	TraceContext(_testTraceID, _testSpanID).AddTo(enc)
+	assert.Equal(t, map[string]interface{}{
+		"trace": map[string]interface{}{
+			"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span_id":  "00f067aa0ba902b7",
+		},
+	}, enc.Fields, "Unexpected encoding of trace context.")
+}
@@ -0,0 +1,384 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package zapotlp provides a zapcore.Core that exports entries as
+// OpenTelemetry log records, using the OTLP/HTTP protocol with JSON encoding.
+//
+// Each entry becomes a LogRecord: the message is the body, the level sets the
+// severity number and text, and the entry's fields become attributes with
+// typed values, so that nested objects and arrays keep their structure. The
+// logger name, caller, and stack trace are recorded with the attributes
+// logger.name, code.filepath, code.lineno, code.function, and
+// code.stacktrace, and an error added with zap.Error is recorded with the
+// exception.message, exception.type, and exception.stacktrace attributes of
+// the OpenTelemetry semantic conventions. Fields created with TraceContext
+// set the record's trace and span IDs. For example,
+//
+//   exp := zapotlp.NewExporter(
+//     zapotlp.WithEndpoint("http://collector:4318/v1/logs"),
+//     zapotlp.WithResource(zap.String("service.name", "checkout")),
+//   )
+//   defer exp.Close()
+//   logger := zap.New(exp.Core(zap.InfoLevel), zap.AddCaller())
+//   logger.Info("paid", zap.Int("cents", 1299), zapotlp.TraceContext(traceID, spanID))
+//
+// Records are collected into batches, which a background goroutine POSTs to
+// the collector.
+package zapotlp // import "go.uber.org/zap/zapotlp"
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/internal/bufferpool"
+	"go.uber.org/zap/internal/httpbatch"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultEndpoint is the default URL of the OTLP/HTTP logs endpoint: a
+	// collector listening on the standard port of the local host.
+	DefaultEndpoint = "http://localhost:4318/v1/logs"
+
+	// DefaultScopeName is the default name of the instrumentation scope of
+	// the exported records.
+	DefaultScopeName = "go.uber.org/zap"
+)
+
+const (
+	_defaultBatchSize     = 512
+	_defaultFlushInterval = time.Second
+	_defaultMaxRetries    = 5
+	_defaultMinBackoff    = 100 * time.Millisecond
+	_defaultMaxBackoff    = 5 * time.Second
+	_defaultMaxPending    = 16
+	_defaultTimeout       = 10 * time.Second
+)
+
+var (
+	_defaultClient = &http.Client{Timeout: _defaultTimeout}
+
+	errExporterClosed = errors.New("zapotlp: exporter is closed")
+)
+
+// An Option configures an Exporter.
+type Option interface {
+	apply(*Exporter)
+}
+
+type optionFunc func(*Exporter)
+
+func (f optionFunc) apply(e *Exporter) {
+	f(e)
+}
+
+// WithEndpoint sets the URL to which batches are POSTed, including the path
+// (usually /v1/logs). It defaults to the value of the
+// OTEL_EXPORTER_OTLP_LOGS_ENDPOINT environment variable, then to
+// OTEL_EXPORTER_OTLP_ENDPOINT with /v1/logs appended, and then to
+// DefaultEndpoint.
+func WithEndpoint(url string) Option {
+	return optionFunc(func(e *Exporter) {
+		e.endpoint = url
+	})
+}
+
+// WithHeader adds a header to each request, such as authorization.
+func WithHeader(key, value string) Option {
+	return optionFunc(func(e *Exporter) {
+		e.header.Add(key, value)
+	})
+}
+
+// WithHTTPClient sets the client that sends the requests. It defaults to a
+// client with a 10 second timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(e *Exporter) {
+		e.client = client
+	})
+}
+
+// WithGzip enables gzip compression of request bodies.
+func WithGzip() Option {
+	return optionFunc(func(e *Exporter) {
+		e.compress = true
+	})
+}
+
+// WithResource adds attributes describing the entity producing the logs,
+// such as service.name and deployment.environment. Unless the fields include
+// service.name, it's set to the value of the OTEL_SERVICE_NAME environment
+// variable or, failing that, to "unknown_service:" followed by the name of
+// the program.
+func WithResource(fields ...zapcore.Field) Option {
+	return optionFunc(func(e *Exporter) {
+		e.resource = append(e.resource, fields...)
+	})
+}
+
+// WithScope sets the name and version of the instrumentation scope of the
+// exported records. The name defaults to DefaultScopeName.
+func WithScope(name, version string) Option {
+	return optionFunc(func(e *Exporter) {
+		e.scopeName = name
+		e.scopeVersion = version
+	})
+}
+
+// WithBatchSize sets the largest number of records sent in a single request.
+// It defaults to 512.
+func WithBatchSize(n int) Option {
+	return optionFunc(func(e *Exporter) {
+		e.batchSize = n
+	})
+}
+
+// WithFlushInterval sets the longest a record waits for its batch to fill
+// before the batch is sent anyway. It defaults to 1 second.
+func WithFlushInterval(d time.Duration) Option {
+	return optionFunc(func(e *Exporter) {
+		e.flushInterval = d
+	})
+}
+
+// WithMaxRetries sets the number of times a failed request is repeated
+// before the batch is abandoned. It defaults to 5; negative values disable
+// retries.
+func WithMaxRetries(n int) Option {
+	return optionFunc(func(e *Exporter) {
+		e.maxRetries = n
+	})
+}
+
+// WithClock sets the source of the records' observed timestamps, the flush
+// interval, and the delay between retries. It defaults to the system clock.
+func WithClock(clock zapcore.Clock) Option {
+	return optionFunc(func(e *Exporter) {
+		e.clock = clock
+	})
+}
+
+// ExporterStats reports the activity of an Exporter.
+type ExporterStats struct {
+	// Batches and Records count the batches and records accepted by the
+	// collector.
+	Batches int64
+	Records int64
+	// Retries is the number of requests repeated after a failure.
+	Retries int64
+	// FailedBatches and FailedRecords count the batches and records that
+	// were abandoned after exhausting their retries or being rejected by the
+	// collector.
+	FailedBatches int64
+	FailedRecords int64
+	// DroppedRecords is the number of records discarded because too many
+	// batches were already awaiting delivery.
+	DroppedRecords int64
+}
+
+// An Exporter collects log records into batches and exports them to an
+// OpenTelemetry collector, or any other OTLP/HTTP endpoint accepting JSON.
+// Use Core to create Cores that write to it.
+//
+// A batch is sent once it's full or once the flush interval has passed,
+// whichever comes first. Requests that fail with a network error or with one
+// of the statuses that OTLP designates as retryable (429, 502, 503, and 504)
+// are retried with exponential backoff, waiting as long as the collector asks
+// in a Retry-After header, up to 5 seconds. Other responses outside the 2xx
+// range abandon the batch. Errors encountered during delivery are reported by
+// the next call to Sync. They name the endpoint without its user info and
+// query string, which may hold credentials.
+//
+// An Exporter is safe for concurrent use. It must be closed when finished to
+// deliver any remaining records.
+type Exporter struct {
+	endpoint      string
+	header        http.Header
+	client        *http.Client
+	compress      bool
+	resource      []zapcore.Field
+	scopeName     string
+	scopeVersion  string
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	clock         zapcore.Clock
+
+	sender *httpbatch.Sender
+}
+
+// NewExporter creates an Exporter and starts the goroutines that deliver its
+// batches.
+func NewExporter(opts ...Option) *Exporter {
+	e := &Exporter{
+		endpoint:      defaultEndpoint(),
+		header:        make(http.Header),
+		client:        _defaultClient,
+		scopeName:     DefaultScopeName,
+		batchSize:     _defaultBatchSize,
+		flushInterval: _defaultFlushInterval,
+		maxRetries:    _defaultMaxRetries,
+		clock:         zapcore.DefaultClock,
+	}
+	for _, opt := range opts {
+		opt.apply(e)
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = _defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = _defaultFlushInterval
+	}
+	prefix, suffix := e.envelope()
+	e.sender = httpbatch.New(httpbatch.Config{
+		URL:           e.endpoint,
+		Client:        e.client,
+		Header:        e.header,
+		ContentType:   "application/json",
+		Compress:      e.compress,
+		Prefix:        prefix,
+		Suffix:        suffix,
+		Separator:     []byte{','},
+		BatchEntries:  e.batchSize,
+		FlushInterval: e.flushInterval,
+		MaxRetries:    e.maxRetries,
+		MinBackoff:    _defaultMinBackoff,
+		MaxBackoff:    _defaultMaxBackoff,
+		MaxPending:    _defaultMaxPending,
+		Retryable:     retryable,
+		Clock:         e.clock,
+	})
+	return e
+}
+
+// retryable reports whether OTLP designates a response status as retryable.
+func retryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// defaultEndpoint returns the endpoint configured by the environment
+// variables of the OpenTelemetry SDKs, or DefaultEndpoint.
+func defaultEndpoint() string {
+	if url := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT"); url != "" {
+		return url
+	}
+	if url := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); url != "" {
+		return strings.TrimSuffix(url, "/") + "/v1/logs"
+	}
+	return DefaultEndpoint
+}
+
+// envelope returns the JSON that precedes and follows the records of a batch,
+// which describes the resource and instrumentation scope.
+func (e *Exporter) envelope() (prefix, suffix []byte) {
+	buf := bufferpool.Get()
+	defer buf.Free()
+
+	buf.AppendString(`{"resourceLogs":[{"resource":{"attributes":[`)
+	enc := &attrEncoder{buf: buf}
+	hasServiceName := false
+	for _, f := range e.resource {
+		if f.Key == "service.name" {
+			hasServiceName = true
+		}
+	}
+	if !hasServiceName {
+		name := os.Getenv("OTEL_SERVICE_NAME")
+		if name == "" {
+			name = "unknown_service:" + filepath.Base(os.Args[0])
+		}
+		enc.AddString("service.name", name)
+	}
+	for _, f := range e.resource {
+		f.AddTo(enc)
+	}
+	enc.closeNamespaces()
+	buf.AppendString(`]},"scopeLogs":[{"scope":{"name":`)
+	appendString(buf, e.scopeName)
+	if e.scopeVersion != "" {
+		buf.AppendString(`,"version":`)
+		appendString(buf, e.scopeVersion)
+	}
+	buf.AppendString(`},"logRecords":[`)
+
+	prefix = append([]byte(nil), buf.Bytes()...)
+	return prefix, []byte("]}]}]}")
+}
+
+// Core returns a Core that writes entries at or above the given level to the
+// Exporter. Syncing the Core syncs the Exporter.
+func (e *Exporter) Core(enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, exp: e}
+}
+
+// add appends an encoded LogRecord to the current batch.
+func (e *Exporter) add(record []byte) error {
+	if err := e.sender.Add(record); err != nil {
+		return errExporterClosed
+	}
+	return nil
+}
+
+// Sync sends the current batch and waits until every batch so far has been
+// delivered or abandoned. It returns the first delivery error encountered
+// since the previous call to Sync.
+func (e *Exporter) Sync() error {
+	return wrapError(e.sender.Sync())
+}
+
+// Close delivers any remaining records and stops the background goroutines.
+// Once it's called, failed requests are no longer retried, so that Close
+// doesn't wait out the backoff. It returns the first delivery error
+// encountered since the previous call to Sync. Records written after Close
+// are rejected.
+func (e *Exporter) Close() error {
+	return wrapError(e.sender.Close())
+}
+
+// Stats reports the delivery statistics of the Exporter so far.
+func (e *Exporter) Stats() ExporterStats {
+	stats := e.sender.Stats()
+	return ExporterStats{
+		Batches:        stats.Batches,
+		Records:        stats.Entries,
+		Retries:        stats.Retries,
+		FailedBatches:  stats.FailedBatches,
+		FailedRecords:  stats.FailedEntries,
+		DroppedRecords: stats.DroppedEntries,
+	}
+}
+
+// wrapError prefixes a delivery error with the package name.
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("zapotlp: %v", err)
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zapotlp
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/internal/ztest"
+	"go.uber.org/zap/zapcore"
+)
+
+// exportRequest is the part of an ExportLogsServiceRequest that the tests
+// inspect.
+type exportRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []map[string]interface{} `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			Scope struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"scope"`
+			LogRecords []map[string]interface{} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+// collector is a fake OTLP/HTTP collector that records the requests it
+// receives and responds with the statuses it's given, then with 200 OK.
+type collector struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []exportRequest
+	headers  []http.Header
+	statuses []int
+	received chan struct{}
+}
+
+func newCollector(t *testing.T, statuses ...int) *collector {
+	c := &collector{statuses: statuses, received: make(chan struct{}, 100)}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.handle))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) handle(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req exportRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+	status := http.StatusOK
+	if len(c.statuses) > 0 {
+		status = c.statuses[0]
+		c.statuses = c.statuses[1:]
+	}
+	c.mu.Unlock()
+
+	w.WriteHeader(status)
+	io.WriteString(w, "{}")
+	c.received <- struct{}{}
+}
+
+func (c *collector) Requests() []exportRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]exportRequest(nil), c.requests...)
+}
+
+func (c *collector) Headers() []http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]http.Header(nil), c.headers...)
+}
+
+// Records returns the records of every request received.
+func (c *collector) Records() []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, req := range c.Requests() {
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				records = append(records, sl.LogRecords...)
+			}
+		}
+	}
+	return records
+}
+
+func writeMessages(t *testing.T, exp *Exporter, msgs ...string) {
+	core := exp.Core(zapcore.DebugLevel)
+	for _, msg := range msgs {
+		require.NoError(t, core.Write(zapcore.Entry{Message: msg}, nil), "Unexpected error writing entry.")
+	}
+}
+
+func TestExporterEnvelope(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(
+		WithEndpoint(c.URL+"/v1/logs"),
+		WithResource(
+			zapcore.Field{Key: "service.name", Type: zapcore.StringType, String: "checkout"},
+			zapcore.Field{Key: "replica", Type: zapcore.Int64Type, Integer: 3},
+		),
+		WithScope("myapp/logging", "1.2.3"),
+		WithHeader("Authorization", "Bearer token"),
+	)
+	defer exp.Close()
+
+	writeMessages(t, exp, "hello")
+	require.NoError(t, exp.Sync(), "Unexpected error syncing exporter.")
+
+	reqs := c.Requests()
+	require.Len(t, reqs, 1, "Expected one request.")
+	require.Len(t, reqs[0].ResourceLogs, 1, "Expected one ResourceLogs.")
+	rl := reqs[0].ResourceLogs[0]
+	assert.Equal(t, []map[string]interface{}{
+		{"key": "service.name", "value": map[string]interface{}{"stringValue": "checkout"}},
+		{"key": "replica", "value": map[string]interface{}{"intValue": "3"}},
+	}, rl.Resource.Attributes, "Unexpected resource attributes.")
+	require.Len(t, rl.ScopeLogs, 1, "Expected one ScopeLogs.")
+	assert.Equal(t, "myapp/logging", rl.ScopeLogs[0].Scope.Name, "Unexpected scope name.")
+	assert.Equal(t, "1.2.3", rl.ScopeLogs[0].Scope.Version, "Unexpected scope version.")
+	assert.Len(t, rl.ScopeLogs[0].LogRecords, 1, "Expected one record.")
+	assert.Equal(t, "Bearer token", c.Headers()[0].Get("Authorization"), "Expected configured header.")
+}
+
+func TestExporterDefaultServiceName(t *testing.T) {
+	c := newCollector(t)
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", c.URL+"/")
+	exp := NewExporter()
+	defer exp.Close()
+
+	writeMessages(t, exp, "hello")
+	require.NoError(t, exp.Sync(), "Expected endpoint to be read from the environment.")
+
+	reqs := c.Requests()
+	require.Len(t, reqs, 1, "Expected one request.")
+	attrs := reqs[0].ResourceLogs[0].Resource.Attributes
+	require.Len(t, attrs, 1, "Expected only the default service name.")
+	assert.Equal(t, "service.name", attrs[0]["key"], "Unexpected resource attribute.")
+	assert.Regexp(t, `^unknown_service:`, attrs[0]["value"].(map[string]interface{})["stringValue"], "Unexpected default service name.")
+	assert.Equal(t, DefaultScopeName, reqs[0].ResourceLogs[0].ScopeLogs[0].Scope.Name, "Unexpected default scope name.")
+}
+
+func TestExporterEndpointFromEnvironment(t *testing.T) {
+	tests := []struct {
+		logs, base string
+		want       string
+	}{
+		{"", "", DefaultEndpoint},
+		{"", "http://collector:4318", "http://collector:4318/v1/logs"},
+		{"http://logs:4318/custom", "http://collector:4318", "http://logs:4318/custom"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", tt.logs)
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", tt.base)
+		assert.Equal(t, tt.want, defaultEndpoint(), "Unexpected endpoint for %+v.", tt)
+	}
+}
+
+func TestExporterBatchSize(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(WithEndpoint(c.URL), WithBatchSize(2), WithFlushInterval(time.Minute))
+	defer exp.Close()
+
+	writeMessages(t, exp, "a", "b", "c")
+	select {
+	case <-c.received:
+	case <-time.After(ztest.Timeout(time.Second)):
+		t.Fatal("Expected full batch to be sent.")
+	}
+	require.NoError(t, exp.Sync(), "Unexpected error syncing exporter.")
+
+	reqs := c.Requests()
+	require.Len(t, reqs, 2, "Expected two batches.")
+	assert.Len(t, reqs[0].ResourceLogs[0].ScopeLogs[0].LogRecords, 2, "Expected a full first batch.")
+	assert.Len(t, reqs[1].ResourceLogs[0].ScopeLogs[0].LogRecords, 1, "Expected the rest in the second batch.")
+	assert.Equal(t, ExporterStats{Batches: 2, Records: 3}, exp.Stats(), "Unexpected stats.")
+}
+
+func TestExporterFlushInterval(t *testing.T) {
+	c := newCollector(t)
+	clock := ztest.NewMockClock()
+	exp := NewExporter(WithEndpoint(c.URL), WithFlushInterval(time.Minute), WithClock(clock))
+	defer exp.Close()
+
+	writeMessages(t, exp, "a")
+	assert.Empty(t, c.Requests(), "Expected partial batch to wait for the flush interval.")
+
+	clock.Add(time.Minute)
+	select {
+	case <-c.received:
+	case <-time.After(ztest.Timeout(time.Second)):
+		t.Fatal("Expected partial batch to be sent after the flush interval.")
+	}
+	assert.Len(t, c.Records(), 1, "Unexpected records.")
+}
+
+func TestExporterRetries(t *testing.T) {
+	c := newCollector(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	exp := NewExporter(WithEndpoint(c.URL))
+	defer exp.Close()
+
+	writeMessages(t, exp, "a")
+	require.NoError(t, exp.Sync(), "Expected batch to be delivered after retries.")
+	assert.Len(t, c.Requests(), 3, "Unexpected number of requests.")
+	assert.Equal(t, ExporterStats{Batches: 1, Records: 1, Retries: 2}, exp.Stats(), "Unexpected stats.")
+}
+
+func TestExporterFailures(t *testing.T) {
+	tests := []struct {
+		desc     string
+		statuses []int
+		opts     []Option
+		requests int
+		retries  int64
+	}{
+		{
+			desc:     "not retryable",
+			statuses: []int{http.StatusBadRequest},
+			requests: 1,
+		},
+		{
+			desc:     "retries exhausted",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			opts:     []Option{WithMaxRetries(2)},
+			requests: 3,
+			retries:  2,
+		},
+		{
+			desc:     "retries disabled",
+			statuses: []int{http.StatusServiceUnavailable},
+			opts:     []Option{WithMaxRetries(-1)},
+			requests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := newCollector(t, tt.statuses...)
+			exp := NewExporter(append([]Option{WithEndpoint(c.URL)}, tt.opts...)...)
+			defer exp.Close()
+
+			writeMessages(t, exp, "a", "b")
+			assert.Error(t, exp.Sync(), "Expected delivery error.")
+			assert.NoError(t, exp.Sync(), "Expected Sync to reset the delivery error.")
+			assert.Len(t, c.Requests(), tt.requests, "Unexpected number of requests.")
+			assert.Equal(t, ExporterStats{FailedBatches: 1, FailedRecords: 2, Retries: tt.retries}, exp.Stats(), "Unexpected stats.")
+		})
+	}
+}
+
+func TestExporterRedactsEndpoint(t *testing.T) {
+	c := newCollector(t, http.StatusBadRequest)
+	u, err := url.Parse(c.URL)
+	require.NoError(t, err, "Unexpected error parsing collector URL.")
+	u.User = url.UserPassword("user", "secret-password")
+	u.Path = "/v1/logs"
+	u.RawQuery = "token=secret-token"
+
+	exp := NewExporter(WithEndpoint(u.String()), WithMaxRetries(-1))
+	defer exp.Close()
+	writeMessages(t, exp, "a")
+	err = exp.Sync()
+	require.Error(t, err, "Expected delivery error.")
+	assert.Contains(t, err.Error(), "/v1/logs: 400 Bad Request", "Unexpected error.")
+	assert.NotContains(t, err.Error(), "secret", "Expected credentials to be redacted.")
+
+	// Client errors, like failing to connect, also name the endpoint.
+	c.Close()
+	writeMessages(t, exp, "b")
+	err = exp.Sync()
+	require.Error(t, err, "Expected delivery to a closed collector to fail.")
+	assert.Contains(t, err.Error(), "/v1/logs", "Expected the error to name the endpoint.")
+	assert.NotContains(t, err.Error(), "secret", "Expected credentials to be redacted.")
+}
+
+func TestExporterCloseStopsRetries(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	exp := NewExporter(WithEndpoint(srv.URL), WithBatchSize(1))
+	writeMessages(t, exp, "a")
+	// Wait for the first request, so that the exporter is backing off.
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- exp.Close() }()
+	select {
+	case err := <-done:
+		assert.Error(t, err, "Expected Close to report the failed delivery.")
+	case <-time.After(ztest.Timeout(time.Second)):
+		t.Fatal("Expected Close to interrupt the retry backoff.")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "Expected no retries after Close.")
+}
+
+func TestExporterGzip(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(WithEndpoint(c.URL), WithGzip())
+	defer exp.Close()
+
+	writeMessages(t, exp, "compressed")
+	require.NoError(t, exp.Sync(), "Unexpected error syncing exporter.")
+	require.Len(t, c.Records(), 1, "Expected one record.")
+	assert.Equal(t, "gzip", c.Headers()[0].Get("Content-Encoding"), "Expected gzip content encoding.")
+	assert.Equal(t, map[string]interface{}{"stringValue": "compressed"}, c.Records()[0]["body"], "Unexpected body.")
+}
+
+func TestExporterClose(t *testing.T) {
+	c := newCollector(t)
+	exp := NewExporter(WithEndpoint(c.URL), WithFlushInterval(time.Minute))
+
+	writeMessages(t, exp, "a", "b")
+	require.NoError(t, exp.Close(), "Unexpected error closing exporter.")
+	assert.Len(t, c.Records(), 2, "Expected Close to deliver remaining records.")
+
+	err := exp.Core(zapcore.DebugLevel).Write(zapcore.Entry{Message: "late"}, nil)
+	assert.Equal(t, errExporterClosed, err, "Expected writes after Close to fail.")
+	assert.NoError(t, exp.Close(), "Expected closing twice to succeed.")
+}
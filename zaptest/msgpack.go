@@ -0,0 +1,246 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zaptest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var errMsgpackTruncated = errors.New("truncated MessagePack data")
+
+// DecodeMsgpack decodes a stream of MessagePack maps, like the output of the
+// "msgpack" encoder, into one map per log entry.
+//
+// Values are decoded as nil, bool, int64 (or uint64, for integers too large
+// for an int64), float32, float64, string, []byte, time.Time (for the
+// timestamp extension type, in UTC), []interface{}, or
+// map[string]interface{}.
+func DecodeMsgpack(data []byte) ([]map[string]interface{}, error) {
+	d := msgpackDecoder{data: data}
+	var entries []map[string]interface{}
+	for d.off < len(d.data) {
+		v, err := d.decode()
+		if err != nil {
+			return entries, err
+		}
+		entry, ok := v.(map[string]interface{})
+		if !ok {
+			return entries, fmt.Errorf("expected a MessagePack map, got %T", v)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := b[0]; {
+	case c <= 0x7f: // positive fixint
+		return int64(c), nil
+	case c >= 0xe0: // negative fixint
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80: // fixmap
+		return d.decodeMap(int(c & 0x0f))
+	case c&0xf0 == 0x90: // fixarray
+		return d.decodeArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0: // fixstr
+		return d.decodeString(int(c & 0x1f))
+	}
+
+	switch b[0] {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		n, err := d.readLength(b[0] - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), bs...), nil
+	case 0xc7, 0xc8, 0xc9: // ext 8, 16, 32
+		n, err := d.readLength(b[0] - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xca:
+		v, err := d.readUint(4)
+		return math.Float32frombits(uint32(v)), err
+	case 0xcb:
+		v, err := d.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8, 16, 32, 64
+		v, err := d.readUint(1 << (b[0] - 0xcc))
+		if v > math.MaxInt64 {
+			return v, err
+		}
+		return int64(v), err
+	case 0xd0:
+		v, err := d.readUint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.readUint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.readUint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.readUint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return d.decodeExt(1 << (b[0] - 0xd4))
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		n, err := d.readLength(b[0] - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xdc, 0xdd: // array 16, 32
+		n, err := d.readLength(b[0] - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf: // map 16, 32
+		n, err := d.readLength(b[0] - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	default:
+		return nil, fmt.Errorf("unsupported MessagePack format 0x%02x at offset %d", b[0], d.off-1)
+	}
+}
+
+func (d *msgpackDecoder) decodeString(n int) (interface{}, error) {
+	bs, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int) (interface{}, error) {
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string map key, got %T", k)
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// decodeExt decodes an extension type with n bytes of data. Only the
+// timestamp extension type is supported.
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	typ, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != -1 {
+		return nil, fmt.Errorf("unsupported MessagePack extension type %d", int8(typ[0]))
+	}
+	switch n {
+	case 4:
+		sec, err := d.readUint(4)
+		return time.Unix(int64(sec), 0).UTC(), err
+	case 8:
+		v, err := d.readUint(8)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), err
+	case 12:
+		nsec, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		sec, err := d.readUint(8)
+		return time.Unix(int64(sec), int64(nsec)).UTC(), err
+	default:
+		return nil, fmt.Errorf("invalid MessagePack timestamp length %d", n)
+	}
+}
+
+// readLength reads a big-endian length of 1, 2, or 4 bytes, depending on
+// whether size is 0, 1, or 2.
+func (d *msgpackDecoder) readLength(size byte) (int, error) {
+	v, err := d.readUint(1 << size)
+	return int(v), err
+}
+
+func (d *msgpackDecoder) readUint(n int) (uint64, error) {
+	bs, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, b := range bs {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, errMsgpackTruncated
+	}
+	bs := d.data[d.off : d.off+n]
+	d.off += n
+	return bs, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zaptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMsgpack(t *testing.T) {
+	data := []byte{
+		// {"a": 1, "b": [nil, "x"]}, using fixmap, fixarray and fixstr
+		0x82,
+		0xa1, 'a', 0x01,
+		0xa1, 'b', 0x92, 0xc0, 0xa1, 'x',
+		// {"m": {}, "s": "yz", "bin": [0xff], "n": -300, "t": 1s}, using map16,
+		// map32, str16, bin16, int16 and fixext4
+		0xde, 0x00, 0x05,
+		0xa1, 'm', 0xdf, 0x00, 0x00, 0x00, 0x00,
+		0xa1, 's', 0xda, 0x00, 0x02, 'y', 'z',
+		0xa3, 'b', 'i', 'n', 0xc5, 0x00, 0x01, 0xff,
+		0xa1, 'n', 0xd1, 0xfe, 0xd4,
+		0xa1, 't', 0xd6, 0xff, 0x00, 0x00, 0x00, 0x01,
+		// {"arr": [0.5], "big": 2^63}, using array16, float64 and uint64
+		0x82,
+		0xa3, 'a', 'r', 'r', 0xdc, 0x00, 0x01, 0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0,
+		0xa3, 'b', 'i', 'g', 0xcf, 0x80, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	entries, err := DecodeMsgpack(data)
+	require.NoError(t, err, "Unexpected error decoding.")
+	assert.Equal(t, []map[string]interface{}{
+		{"a": int64(1), "b": []interface{}{nil, "x"}},
+		{
+			"m":   map[string]interface{}{},
+			"s":   "yz",
+			"bin": []byte{0xff},
+			"n":   int64(-300),
+			"t":   time.Unix(1, 0).UTC(),
+		},
+		{"arr": []interface{}{0.5}, "big": uint64(1 << 63)},
+	}, entries, "Unexpected entries.")
+}
+
+func TestDecodeMsgpackErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		data []byte
+		want string
+	}{
+		{"truncated", []byte{0x81, 0xa1, 'a'}, "truncated MessagePack data"},
+		{"truncated string", []byte{0x81, 0xa3, 'a'}, "truncated MessagePack data"},
+		{"not a map", []byte{0x01}, "expected a MessagePack map, got int64"},
+		{"non-string key", []byte{0x81, 0x01, 0x01}, "expected a string map key, got int64"},
+		{"unsupported format", []byte{0x81, 0xa1, 'a', 0xc1}, "unsupported MessagePack format 0xc1 at offset 3"},
+		{"unsupported extension", []byte{0x81, 0xa1, 'a', 0xd4, 0x01, 0x00}, "unsupported MessagePack extension type 1"},
+		{"bad timestamp", []byte{0x81, 0xa1, 'a', 0xd4, 0xff, 0x00}, "invalid MessagePack timestamp length 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := DecodeMsgpack(tt.data)
+			assert.EqualError(t, err, tt.want, "Unexpected error.")
+		})
+	}
+}